@@ -0,0 +1,176 @@
+package robots
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultTTL は robots.txt をキャッシュする既定の期間 (RFC 9309 では 24 時間を超えてキャッシュすべきでないとしている)
+const DefaultTTL = 24 * time.Hour
+
+type cacheEntry struct {
+	ready     chan struct{}
+	robots    *Robots
+	expiresAt time.Time
+
+	// err は取得した呼び出し元のコンテキストがキャンセルされた場合のエラー。
+	// 取得を待っていた呼び出し元は robots を使用せずに取得をやり直す。
+	err error
+}
+
+// Cache はホストごとに robots.txt を取得してキャッシュする
+type Cache struct {
+	client    *http.Client
+	userAgent string
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// NewCache は新しい Cache を作成する。client が nil の場合は http.DefaultClient が、ttl が 0 以下の場合は DefaultTTL が使用される。
+func NewCache(client *http.Client, userAgent string, ttl time.Duration) *Cache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{
+		client:    client,
+		userAgent: userAgent,
+		ttl:       ttl,
+		now:       time.Now,
+		entries:   make(map[string]*cacheEntry),
+	}
+}
+
+// Allowed は rawURL をクロールしてもよいかどうかを返す
+func (c *Cache) Allowed(ctx context.Context, rawURL string) (bool, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, xerrors.Errorf("robots: %w", err)
+	}
+
+	rb, err := c.get(ctx, u)
+	if err != nil {
+		return false, err
+	}
+
+	return rb.Allowed(c.userAgent, u.RequestURI()), nil
+}
+
+// CrawlDelay は rawURL のホストに対して指定された Crawl-delay を返す
+func (c *Cache) CrawlDelay(ctx context.Context, rawURL string) (time.Duration, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, xerrors.Errorf("robots: %w", err)
+	}
+
+	rb, err := c.get(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+
+	return rb.CrawlDelay(c.userAgent), nil
+}
+
+// Get は rawURL のホストの robots.txt を返す。キャッシュが有効期限切れの場合は再取得する。
+func (c *Cache) Get(ctx context.Context, rawURL string) (*Robots, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, xerrors.Errorf("robots: %w", err)
+	}
+
+	return c.get(ctx, u)
+}
+
+func (c *Cache) get(ctx context.Context, u *url.URL) (*Robots, error) {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, xerrors.Errorf("robots: unsupported URL %q", u.String())
+	}
+	key := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if found {
+		select {
+		case <-entry.ready:
+			if c.now().After(entry.expiresAt) {
+				found = false
+			}
+		default:
+			// 別の goroutine が取得中
+		}
+	}
+	if !found {
+		entry = &cacheEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+		c.mu.Unlock()
+
+		// 同じホストへの同時リクエストは 1 回の取得を共有する
+		entry.robots = c.fetch(ctx, key+"/robots.txt")
+		entry.expiresAt = c.now().Add(c.ttl)
+		if entry.err = ctx.Err(); entry.err != nil {
+			// 呼び出し元のキャンセルによる失敗はキャッシュしない
+			entry.robots, entry.expiresAt = nil, time.Time{}
+		}
+		close(entry.ready)
+
+		if entry.err != nil {
+			return nil, xerrors.Errorf("robots: %w", entry.err)
+		}
+		return entry.robots, nil
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return nil, xerrors.Errorf("robots: %w", ctx.Err())
+	}
+	if entry.err != nil {
+		// 取得した呼び出し元がキャンセルしたため、自身のコンテキストで取得し直す
+		if err := ctx.Err(); err != nil {
+			return nil, xerrors.Errorf("robots: %w", err)
+		}
+		return c.get(ctx, u)
+	}
+	return entry.robots, nil
+}
+
+// fetch は robots.txt を取得する。RFC 9309 に従い、4xx の場合はすべて許可、5xx やネットワークエラーの場合はすべて禁止として扱う。
+func (c *Cache) fetch(ctx context.Context, robotsURL string) *Robots {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return DisallowAll()
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return DisallowAll()
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		rb, err := Parse(res.Body)
+		if err != nil {
+			return DisallowAll()
+		}
+		return rb
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return AllowAll()
+	default:
+		return DisallowAll()
+	}
+}
@@ -0,0 +1,62 @@
+package robots
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+)
+
+var _ graph.LinkIterator = (*linkFilter)(nil)
+
+// linkFilter は robots.txt によってクロールが禁止されているリンクを読み飛ばす graph.LinkIterator
+type linkFilter struct {
+	ctx   context.Context
+	it    graph.LinkIterator
+	cache *Cache
+
+	latchedLink *graph.Link
+	lastErr     error
+}
+
+// FilterLinks は it から取得したリンクのうち、robots.txt でクロールが許可されているものだけを返すイテレータを作成する。
+// 禁止されたリンクは呼び出し元に渡されないため、その RetrievedAt が更新されることはない。
+func FilterLinks(ctx context.Context, it graph.LinkIterator, cache *Cache) graph.LinkIterator {
+	return &linkFilter{ctx: ctx, it: it, cache: cache}
+}
+
+func (f *linkFilter) Next() bool {
+	for f.lastErr == nil && f.it.Next() {
+		link := f.it.Link()
+		allowed, err := f.cache.Allowed(f.ctx, link.URL)
+		if err != nil {
+			if ctxErr := f.ctx.Err(); ctxErr != nil {
+				f.lastErr = ctxErr
+				return false
+			}
+			// 不正な URL などで判定できないリンクはクロールしない
+			continue
+		}
+		if !allowed {
+			continue
+		}
+
+		f.latchedLink = link
+		return true
+	}
+
+	return false
+}
+
+func (f *linkFilter) Error() error {
+	if f.lastErr != nil {
+		return f.lastErr
+	}
+	return f.it.Error()
+}
+
+func (f *linkFilter) Close() error {
+	return f.it.Close()
+}
+
+func (f *linkFilter) Link() *graph.Link {
+	return f.latchedLink
+}
@@ -0,0 +1,210 @@
+package robots
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRobotsSize は解析対象とする robots.txt の最大サイズ (RFC 9309 では最低 500KiB の解析を求めている)
+const maxRobotsSize = 500 << 10
+
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+// Robots は解析済みの robots.txt を表す
+type Robots struct {
+	groups   []*group
+	Sitemaps []string
+}
+
+// AllowAll はすべてのパスのクロールを許可する Robots を返す
+func AllowAll() *Robots {
+	return &Robots{}
+}
+
+// DisallowAll はすべてのパスのクロールを禁止する Robots を返す
+func DisallowAll() *Robots {
+	return &Robots{
+		groups: []*group{{agents: []string{"*"}, rules: []rule{{allow: false, pattern: "/"}}}},
+	}
+}
+
+// Parse は r から robots.txt を読み込み解析する。解釈できない行は無視される。
+func Parse(r io.Reader) (*Robots, error) {
+	var (
+		rb          = new(Robots)
+		cur         *group
+		lastWasRule = true
+	)
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxRobotsSize))
+	scanner.Buffer(make([]byte, 0, 4096), maxRobotsSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+
+		sep := strings.IndexByte(line, ':')
+		if sep < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:sep]))
+		val := strings.TrimSpace(line[sep+1:])
+
+		switch key {
+		case "user-agent":
+			// 連続する user-agent 行は同じグループを共有する
+			if lastWasRule || cur == nil {
+				cur = new(group)
+				rb.groups = append(rb.groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+			lastWasRule = false
+		case "allow", "disallow":
+			lastWasRule = true
+			// 空の Disallow は「すべて許可」を意味するのでルールとしては追加しない
+			if cur == nil || val == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, rule{allow: key == "allow", pattern: val})
+		case "crawl-delay":
+			lastWasRule = true
+			if cur == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(val, 64); err == nil && secs >= 0 {
+				cur.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		case "sitemap":
+			// sitemap 行はグループに属さない
+			if val != "" {
+				rb.Sitemaps = append(rb.Sitemaps, val)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rb, nil
+}
+
+// Allowed は指定されたユーザーエージェントが path (クエリ文字列を含んでもよい) をクロールできるかどうかを返す
+func (r *Robots) Allowed(userAgent, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	var (
+		matchLen = -1
+		allowed  = true
+	)
+	for _, g := range r.groupsFor(userAgent) {
+		for _, rl := range g.rules {
+			if !matchPattern(rl.pattern, path) {
+				continue
+			}
+
+			// 最も長い(具体的な)パターンが優先される。長さが同じ場合は Allow が優先される。
+			if l := len(rl.pattern); l > matchLen || (l == matchLen && rl.allow) {
+				matchLen, allowed = l, rl.allow
+			}
+		}
+	}
+
+	return allowed
+}
+
+// CrawlDelay は指定されたユーザーエージェントに適用される Crawl-delay を返す。指定がない場合は 0 を返す。
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	var delay time.Duration
+	for _, g := range r.groupsFor(userAgent) {
+		if g.crawlDelay > delay {
+			delay = g.crawlDelay
+		}
+	}
+	return delay
+}
+
+// groupsFor はユーザーエージェントに一致するグループを返す。
+// 一致するグループがない場合は "*" のグループが使用される。
+func (r *Robots) groupsFor(userAgent string) []*group {
+	token := productToken(userAgent)
+
+	var specific, wildcard []*group
+	for _, g := range r.groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				wildcard = append(wildcard, g)
+				break
+			}
+			if token != "" && agent == token {
+				specific = append(specific, g)
+				break
+			}
+		}
+	}
+
+	if len(specific) != 0 {
+		return specific
+	}
+	return wildcard
+}
+
+// productToken はユーザーエージェント文字列 ("MyBot/1.0 (+https://...)") からプロダクトトークン ("mybot") を取り出す
+func productToken(userAgent string) string {
+	token := strings.TrimSpace(userAgent)
+	if idx := strings.IndexAny(token, "/ "); idx >= 0 {
+		token = token[:idx]
+	}
+	return strings.ToLower(token)
+}
+
+// matchPattern はワイルドカード "*" と終端アンカー "$" を含むパターンが path の先頭に一致するかどうかを返す
+func matchPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	parts := strings.Split(pattern, "*")
+
+	// 最初の部分はパスの先頭に一致しなければならない
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+
+	// 中間の部分はできるだけ手前で一致させる (貪欲でない一致で十分)
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
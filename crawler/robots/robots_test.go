@@ -0,0 +1,253 @@
+package robots
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	_ = gc.Suite(new(RobotsTestSuite))
+
+	minUUID = uuid.Nil
+	maxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+)
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type RobotsTestSuite struct{}
+
+const testRobots = `
+# comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public*
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: FooBot
+User-agent: BarBot
+Disallow: /
+Allow: /$
+Crawl-delay: 0.5
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func (s *RobotsTestSuite) TestAllowed(c *gc.C) {
+	rb, err := Parse(strings.NewReader(testRobots))
+	c.Assert(err, gc.IsNil)
+
+	specs := []struct {
+		agent string
+		path  string
+		exp   bool
+	}{
+		{"AnyBot/1.0", "/", true},
+		{"AnyBot/1.0", "/private/secret", false},
+		{"AnyBot/1.0", "/private/public/page", true},
+		{"AnyBot/1.0", "/docs/file.pdf", false},
+		{"AnyBot/1.0", "/docs/file.pdf?x=1", true},
+		{"FooBot/2.1", "/", true},
+		{"foobot", "/index.html", false},
+		{"BarBot", "/private/public", false},
+		{"BarBot", "/robots.txt", true},
+	}
+
+	for i, spec := range specs {
+		c.Check(rb.Allowed(spec.agent, spec.path), gc.Equals, spec.exp, gc.Commentf("spec %d: %s %s", i, spec.agent, spec.path))
+	}
+}
+
+func (s *RobotsTestSuite) TestCrawlDelayAndSitemaps(c *gc.C) {
+	rb, err := Parse(strings.NewReader(testRobots))
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(rb.CrawlDelay("AnyBot"), gc.Equals, 2*time.Second)
+	c.Assert(rb.CrawlDelay("FooBot"), gc.Equals, 500*time.Millisecond)
+	c.Assert(rb.Sitemaps, gc.DeepEquals, []string{"https://example.com/sitemap.xml"})
+}
+
+func (s *RobotsTestSuite) TestMatchPattern(c *gc.C) {
+	specs := []struct {
+		pattern string
+		path    string
+		exp     bool
+	}{
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.asp", false},
+		{"/fish*", "/fishheads/yummy.html", true},
+		{"/*.php", "/folder/filename.php?parameters", true},
+		{"/*.php$", "/filename.php?parameters", false},
+		{"/*.php$", "/folder/filename.php", true},
+		{"/fish*.php", "/fishheads/catfish.php?parameters", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"/a*b*c$", "/axxbyyc", true},
+		{"/a*b*c$", "/axxbyycd", false},
+	}
+
+	for i, spec := range specs {
+		c.Check(matchPattern(spec.pattern, spec.path), gc.Equals, spec.exp, gc.Commentf("spec %d: %s %s", i, spec.pattern, spec.path))
+	}
+}
+
+func (s *RobotsTestSuite) TestCacheStatusHandling(c *gc.C) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		c.Check(r.Header.Get("User-Agent"), gc.Equals, "TestBot/1.0")
+		_, _ = fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	cache := NewCache(srv.Client(), "TestBot/1.0", time.Minute)
+	ctx := context.Background()
+
+	allowed, err := cache.Allowed(ctx, srv.URL+"/public")
+	c.Assert(err, gc.IsNil)
+	c.Assert(allowed, gc.Equals, true)
+
+	allowed, err = cache.Allowed(ctx, srv.URL+"/private/page")
+	c.Assert(err, gc.IsNil)
+	c.Assert(allowed, gc.Equals, false)
+	c.Assert(atomic.LoadInt32(&fetches), gc.Equals, int32(1), gc.Commentf("expected robots.txt to be cached"))
+}
+
+func (s *RobotsTestSuite) TestCacheUnavailableAndUnreachable(c *gc.C) {
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	cache := NewCache(nil, "TestBot", time.Minute)
+	ctx := context.Background()
+
+	allowed, err := cache.Allowed(ctx, notFound.URL+"/page")
+	c.Assert(err, gc.IsNil)
+	c.Assert(allowed, gc.Equals, true, gc.Commentf("4xx responses should allow crawling"))
+
+	allowed, err = cache.Allowed(ctx, unavailable.URL+"/page")
+	c.Assert(err, gc.IsNil)
+	c.Assert(allowed, gc.Equals, false, gc.Commentf("5xx responses should disallow crawling"))
+}
+
+func (s *RobotsTestSuite) TestCacheWaiterRetriesAfterCancellation(c *gc.C) {
+	var (
+		fetches int32
+		started = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			// 最初の取得は呼び出し元がキャンセルするまで応答しない
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		_, _ = fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	cache := NewCache(srv.Client(), "TestBot", time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	fetchErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, srv.URL)
+		fetchErr <- err
+	}()
+	<-started
+
+	// 取得中の robots.txt を待つ呼び出し元は、取得した呼び出し元のキャンセルの影響を受けない
+	waiterCtx := newWaitingContext()
+	waiterRes := make(chan bool, 1)
+	go func() {
+		allowed, err := cache.Allowed(waiterCtx, srv.URL+"/page")
+		c.Check(err, gc.IsNil)
+		waiterRes <- allowed
+	}()
+	<-waiterCtx.waiting
+	cancel()
+
+	c.Assert(xerrors.Is(<-fetchErr, context.Canceled), gc.Equals, true)
+	c.Assert(<-waiterRes, gc.Equals, true)
+	c.Assert(atomic.LoadInt32(&fetches), gc.Equals, int32(2))
+}
+
+func (s *RobotsTestSuite) TestCacheExpiry(c *gc.C) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	cache := NewCache(nil, "TestBot", time.Minute)
+	cache.now = func() time.Time { return now }
+	_, err := cache.Get(context.Background(), srv.URL)
+	c.Assert(err, gc.IsNil)
+	_, err = cache.Get(context.Background(), srv.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(atomic.LoadInt32(&fetches), gc.Equals, int32(1))
+
+	now = now.Add(time.Minute + time.Second)
+	_, err = cache.Get(context.Background(), srv.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(atomic.LoadInt32(&fetches), gc.Equals, int32(2))
+}
+
+func (s *RobotsTestSuite) TestFilterLinks(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	g := memory.NewInMemoryGraph()
+	for _, path := range []string{"/a", "/private/b", "/c"} {
+		c.Assert(g.UpsertLink(&graph.Link{URL: srv.URL + path}), gc.IsNil)
+	}
+
+	it, err := g.Links(minUUID, maxUUID, time.Now())
+	c.Assert(err, gc.IsNil)
+
+	filtered := FilterLinks(context.Background(), it, NewCache(nil, "TestBot", time.Minute))
+	var got []string
+	for filtered.Next() {
+		got = append(got, strings.TrimPrefix(filtered.Link().URL, srv.URL))
+	}
+	c.Assert(filtered.Error(), gc.IsNil)
+	c.Assert(filtered.Close(), gc.IsNil)
+	c.Assert(len(got), gc.Equals, 2)
+	for _, path := range got {
+		c.Assert(strings.HasPrefix(path, "/private"), gc.Equals, false)
+	}
+}
+
+// waitingContext は Done が最初に呼び出されたときに waiting を閉じるコンテキスト。
+// Cache は取得中の robots.txt を待つ前に Done を参照するため、呼び出し元が待機に入ったことを知るのに使用する。
+type waitingContext struct {
+	context.Context
+
+	once    sync.Once
+	waiting chan struct{}
+}
+
+func newWaitingContext() *waitingContext {
+	return &waitingContext{Context: context.Background(), waiting: make(chan struct{})}
+}
+
+func (ctx *waitingContext) Done() <-chan struct{} {
+	ctx.once.Do(func() { close(ctx.waiting) })
+	return ctx.Context.Done()
+}
@@ -0,0 +1,340 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed は Close 後に Enqueue が呼ばれた場合に返される
+var ErrClosed = xerrors.New("scheduler closed")
+
+// FetchFunc はスケジューラによってリンクの取得を依頼されたときに呼び出される
+type FetchFunc func(ctx context.Context, link *graph.Link)
+
+// CrawlDelayFunc はリンクのホストに適用される追加の遅延 (robots.txt の Crawl-delay など) を返す。
+// robots.Cache の CrawlDelay メソッドをそのまま指定できる。
+type CrawlDelayFunc func(ctx context.Context, rawURL string) (time.Duration, error)
+
+// Config はスケジューラの設定を保持する
+type Config struct {
+	// Fetch はリンクを取得する関数 (必須)
+	Fetch FetchFunc
+
+	// Workers は全ホスト合計での同時取得数の上限。既定値は 16。
+	Workers int
+
+	// MaxConcurrentPerHost はホストごとの同時取得数の上限。既定値は 1。
+	MaxConcurrentPerHost int
+
+	// MinDelay は同じホストへのリクエスト開始間隔の最小値
+	MinDelay time.Duration
+
+	// CrawlDelay が指定された場合、ホストごとの遅延は MinDelay との大きい方になる
+	CrawlDelay CrawlDelayFunc
+
+	// MaxQueued はキューに保持できるリンク数の上限。上限に達すると Enqueue はブロックする。既定値は 1024。
+	MaxQueued int
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Fetch == nil {
+		return xerrors.New("scheduler: fetch function not specified")
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 16
+	}
+	if cfg.MaxConcurrentPerHost <= 0 {
+		cfg.MaxConcurrentPerHost = 1
+	}
+	if cfg.MaxQueued <= 0 {
+		cfg.MaxQueued = 1024
+	}
+	if cfg.MinDelay < 0 {
+		return xerrors.New("scheduler: MinDelay must not be negative")
+	}
+	return nil
+}
+
+type hostState struct {
+	name        string
+	queue       []*graph.Link
+	inFlight    int
+	delay       time.Duration
+	nextAllowed time.Time
+}
+
+func (h *hostState) idle(now time.Time) bool {
+	return len(h.queue) == 0 && h.inFlight == 0 && !now.Before(h.nextAllowed)
+}
+
+// Scheduler はリンクをホストごとのキューに振り分け、ホストごとの同時実行数と遅延を守りながら
+// ホスト間でラウンドロビンにリンクの取得を割り当てる
+type Scheduler struct {
+	cfg Config
+
+	mu       sync.Mutex
+	hosts    map[string]*hostState
+	ring     []*hostState
+	rrPos    int
+	queued   int
+	inFlight int
+	closed   bool
+
+	// wakeCh はディスパッチループに状態の変化を知らせる
+	wakeCh chan struct{}
+	// spaceCh はキューに空きができたときに close される
+	spaceCh chan struct{}
+}
+
+// New は新しい Scheduler を作成する
+func New(cfg Config) (*Scheduler, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &Scheduler{
+		cfg:     cfg,
+		hosts:   make(map[string]*hostState),
+		wakeCh:  make(chan struct{}, 1),
+		spaceCh: make(chan struct{}),
+	}, nil
+}
+
+// Enqueue はリンクをホストのキューに追加する。キューが満杯の場合は空きができるか ctx がキャンセルされるまでブロックする。
+func (s *Scheduler) Enqueue(ctx context.Context, link *graph.Link) error {
+	host, err := hostOf(link.URL)
+	if err != nil {
+		return xerrors.Errorf("enqueue: %w", err)
+	}
+
+	var (
+		delay    = s.cfg.MinDelay
+		resolved = s.cfg.CrawlDelay == nil
+	)
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return xerrors.Errorf("enqueue: %w", ErrClosed)
+		}
+		if _, exists := s.hosts[host]; !exists && !resolved {
+			s.mu.Unlock()
+
+			// 新しいホストの Crawl-delay は、ホストが next から見えるようになる前に呼び出し元の goroutine で取得し、
+			// ディスパッチループをブロックしない
+			if d, err := s.cfg.CrawlDelay(ctx, link.URL); err == nil && d > delay {
+				delay = d
+			}
+			resolved = true
+			continue
+		}
+		if s.queued < s.cfg.MaxQueued {
+			break
+		}
+		spaceCh := s.spaceCh
+		s.mu.Unlock()
+
+		select {
+		case <-spaceCh:
+		case <-ctx.Done():
+			return xerrors.Errorf("enqueue: %w", ctx.Err())
+		}
+	}
+
+	// Crawl-delay の取得中に別の呼び出し元が同じホストを追加した場合は、大きい方の遅延を使用する
+	h, exists := s.hosts[host]
+	if !exists {
+		h = &hostState{name: host, delay: delay}
+		s.hosts[host] = h
+		s.ring = append(s.ring, h)
+	} else if delay > h.delay {
+		h.delay = delay
+	}
+	lCopy := new(graph.Link)
+	*lCopy = *link
+	h.queue = append(h.queue, lCopy)
+	s.queued++
+	s.mu.Unlock()
+
+	s.wake()
+	return nil
+}
+
+// Feed はイテレータからリンクを読み出してキューに追加する。キューが満杯の間は読み出しを停止するため、
+// 上流のイテレーションに背圧がかかる。追加したリンクの数を返す。
+func (s *Scheduler) Feed(ctx context.Context, it graph.LinkIterator) (int, error) {
+	var count int
+	for it.Next() {
+		if err := s.Enqueue(ctx, it.Link()); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if err := it.Error(); err != nil {
+		return count, xerrors.Errorf("feed: %w", err)
+	}
+	return count, nil
+}
+
+// Pending はキューに残っているリンクの数を返す
+func (s *Scheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queued
+}
+
+// Close は新たなリンクの受け付けを停止する。Run はキューに残ったリンクをすべて処理してから終了する。
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.spaceCh)
+	}
+	s.mu.Unlock()
+	s.wake()
+}
+
+// Run はキューからリンクを取り出して Fetch を呼び出す。ctx がキャンセルされるか、
+// Close された後にキューが空になり実行中の取得がすべて完了するまでブロックする。
+func (s *Scheduler) Run(ctx context.Context) error {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, s.cfg.Workers)
+	)
+	defer wg.Wait()
+
+	for {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		link, host, wait, done := s.next(time.Now())
+		if done {
+			<-sem
+			return nil
+		}
+		if link == nil {
+			<-sem
+			if err := s.sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.cfg.Fetch(ctx, link)
+			s.release(host)
+			<-sem
+		}()
+	}
+}
+
+// next はラウンドロビンで次に取得可能なリンクを選択する。取得可能なリンクがない場合は、
+// 次に遅延が明けるまでの時間 (0 の場合は状態の変化を待つ) を返す。
+func (s *Scheduler) next(now time.Time) (link *graph.Link, host *hostState, wait time.Duration, done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed && s.queued == 0 && s.inFlight == 0 {
+		return nil, nil, 0, true
+	}
+
+	for n := len(s.ring); n > 0; n-- {
+		if s.rrPos >= len(s.ring) {
+			s.rrPos = 0
+		}
+		h := s.ring[s.rrPos]
+
+		// 使われなくなったホストの状態を破棄してメモリ使用量を抑える
+		if h.idle(now) {
+			s.removeHost(s.rrPos)
+			continue
+		}
+		s.rrPos++
+
+		if len(h.queue) == 0 || h.inFlight >= s.cfg.MaxConcurrentPerHost {
+			continue
+		}
+		if delta := h.nextAllowed.Sub(now); delta > 0 {
+			if wait == 0 || delta < wait {
+				wait = delta
+			}
+			continue
+		}
+
+		link = h.queue[0]
+		h.queue[0] = nil
+		h.queue = h.queue[1:]
+		h.inFlight++
+		h.nextAllowed = now.Add(h.delay)
+		s.queued--
+		s.inFlight++
+
+		// Enqueue で待機している呼び出し元に空きができたことを知らせる
+		if !s.closed {
+			close(s.spaceCh)
+			s.spaceCh = make(chan struct{})
+		}
+		return link, h, 0, false
+	}
+
+	return nil, nil, wait, false
+}
+
+func (s *Scheduler) removeHost(pos int) {
+	delete(s.hosts, s.ring[pos].name)
+	s.ring = append(s.ring[:pos], s.ring[pos+1:]...)
+}
+
+func (s *Scheduler) release(h *hostState) {
+	s.mu.Lock()
+	h.inFlight--
+	s.inFlight--
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *Scheduler) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) sleep(ctx context.Context, wait time.Duration) error {
+	var timerCh <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timerCh = timer.C
+	}
+
+	select {
+	case <-s.wakeCh:
+	case <-timerCh:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func hostOf(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", xerrors.Errorf("URL %q does not specify a host", rawURL)
+	}
+	return strings.ToLower(u.Host), nil
+}
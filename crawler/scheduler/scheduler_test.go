@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	gc "gopkg.in/check.v1"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(SchedulerTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type SchedulerTestSuite struct{}
+
+// fetchRecorder は取得の開始時刻とホストごとの同時実行数を記録する
+type fetchRecorder struct {
+	mu         sync.Mutex
+	order      []string
+	starts     map[string][]time.Time
+	active     map[string]int
+	maxActive  map[string]int
+	fetchDelay time.Duration
+}
+
+func newFetchRecorder(fetchDelay time.Duration) *fetchRecorder {
+	return &fetchRecorder{
+		starts:     make(map[string][]time.Time),
+		active:     make(map[string]int),
+		maxActive:  make(map[string]int),
+		fetchDelay: fetchDelay,
+	}
+}
+
+func (r *fetchRecorder) fetch(_ context.Context, link *graph.Link) {
+	host, _ := hostOf(link.URL)
+
+	r.mu.Lock()
+	r.order = append(r.order, host)
+	r.starts[host] = append(r.starts[host], time.Now())
+	r.active[host]++
+	if r.active[host] > r.maxActive[host] {
+		r.maxActive[host] = r.active[host]
+	}
+	r.mu.Unlock()
+
+	time.Sleep(r.fetchDelay)
+
+	r.mu.Lock()
+	r.active[host]--
+	r.mu.Unlock()
+}
+
+func (s *SchedulerTestSuite) TestPerHostConcurrency(c *gc.C) {
+	rec := newFetchRecorder(5 * time.Millisecond)
+	sched, err := New(Config{Fetch: rec.fetch, Workers: 8, MaxConcurrentPerHost: 2})
+	c.Assert(err, gc.IsNil)
+
+	ctx := context.Background()
+	for _, host := range []string{"a.com", "b.com"} {
+		for i := 0; i < 6; i++ {
+			c.Assert(sched.Enqueue(ctx, &graph.Link{URL: fmt.Sprintf("http://%s/%d", host, i)}), gc.IsNil)
+		}
+	}
+	sched.Close()
+	c.Assert(sched.Run(ctx), gc.IsNil)
+
+	c.Assert(rec.order, gc.HasLen, 12)
+	for host, max := range rec.maxActive {
+		c.Assert(max <= 2, gc.Equals, true, gc.Commentf("host %s had %d concurrent fetches", host, max))
+	}
+}
+
+func (s *SchedulerTestSuite) TestFairness(c *gc.C) {
+	rec := newFetchRecorder(0)
+	sched, err := New(Config{Fetch: rec.fetch, Workers: 1})
+	c.Assert(err, gc.IsNil)
+
+	// 同じホストのリンクが連続して投入されてもホスト間で交互に取得されることを確認する
+	ctx := context.Background()
+	for _, host := range []string{"a.com", "b.com", "c.com"} {
+		for i := 0; i < 2; i++ {
+			c.Assert(sched.Enqueue(ctx, &graph.Link{URL: fmt.Sprintf("http://%s/%d", host, i)}), gc.IsNil)
+		}
+	}
+	sched.Close()
+	c.Assert(sched.Run(ctx), gc.IsNil)
+
+	c.Assert(rec.order, gc.DeepEquals, []string{"a.com", "b.com", "c.com", "a.com", "b.com", "c.com"})
+}
+
+func (s *SchedulerTestSuite) TestMinDelayAndCrawlDelay(c *gc.C) {
+	rec := newFetchRecorder(0)
+	crawlDelay := func(_ context.Context, rawURL string) (time.Duration, error) {
+		if host, _ := hostOf(rawURL); host == "slow.com" {
+			return 40 * time.Millisecond, nil
+		}
+		return 0, nil
+	}
+	sched, err := New(Config{Fetch: rec.fetch, MinDelay: 10 * time.Millisecond, CrawlDelay: crawlDelay})
+	c.Assert(err, gc.IsNil)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		c.Assert(sched.Enqueue(ctx, &graph.Link{URL: fmt.Sprintf("http://fast.com/%d", i)}), gc.IsNil)
+		c.Assert(sched.Enqueue(ctx, &graph.Link{URL: fmt.Sprintf("http://slow.com/%d", i)}), gc.IsNil)
+	}
+	sched.Close()
+	c.Assert(sched.Run(ctx), gc.IsNil)
+
+	assertSpacing := func(host string, min time.Duration) {
+		starts := rec.starts[host]
+		c.Assert(starts, gc.HasLen, 3)
+		for i := 1; i < len(starts); i++ {
+			gap := starts[i].Sub(starts[i-1])
+			c.Assert(gap >= min, gc.Equals, true, gc.Commentf("host %s: gap %s < %s", host, gap, min))
+		}
+	}
+	assertSpacing("fast.com", 10*time.Millisecond)
+	assertSpacing("slow.com", 40*time.Millisecond)
+}
+
+func (s *SchedulerTestSuite) TestCrawlDelayResolvedBeforeDispatch(c *gc.C) {
+	var (
+		rec       = newFetchRecorder(0)
+		resolving = make(chan struct{})
+		release   = make(chan struct{})
+		once      sync.Once
+	)
+	crawlDelay := func(_ context.Context, _ string) (time.Duration, error) {
+		once.Do(func() { close(resolving) })
+		<-release
+		return 40 * time.Millisecond, nil
+	}
+	sched, err := New(Config{Fetch: rec.fetch, CrawlDelay: crawlDelay})
+	c.Assert(err, gc.IsNil)
+
+	ctx := context.Background()
+	runErr := make(chan error, 1)
+	go func() { runErr <- sched.Run(ctx) }()
+
+	enqueueErr := make(chan error, 1)
+	go func() { enqueueErr <- sched.Enqueue(ctx, &graph.Link{URL: "http://slow.com/0"}) }()
+	<-resolving
+
+	// Crawl-delay の取得が完了するまでリンクはキューに追加されず、取得されない
+	c.Assert(sched.Pending(), gc.Equals, 0)
+	rec.mu.Lock()
+	c.Assert(rec.order, gc.HasLen, 0)
+	rec.mu.Unlock()
+
+	close(release)
+	c.Assert(<-enqueueErr, gc.IsNil)
+	c.Assert(sched.Enqueue(ctx, &graph.Link{URL: "http://slow.com/1"}), gc.IsNil)
+	sched.Close()
+	c.Assert(<-runErr, gc.IsNil)
+
+	// 最初のリクエストの後も Crawl-delay が守られる
+	starts := rec.starts["slow.com"]
+	c.Assert(starts, gc.HasLen, 2)
+	c.Assert(starts[1].Sub(starts[0]) >= 40*time.Millisecond, gc.Equals, true, gc.Commentf("gap %s", starts[1].Sub(starts[0])))
+}
+
+func (s *SchedulerTestSuite) TestBackPressure(c *gc.C) {
+	rec := newFetchRecorder(0)
+	sched, err := New(Config{Fetch: rec.fetch, MaxQueued: 2})
+	c.Assert(err, gc.IsNil)
+
+	ctx := context.Background()
+	c.Assert(sched.Enqueue(ctx, &graph.Link{URL: "http://a.com/1"}), gc.IsNil)
+	c.Assert(sched.Enqueue(ctx, &graph.Link{URL: "http://a.com/2"}), gc.IsNil)
+
+	// キューが満杯の場合、Enqueue は空きができるまでブロックする
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = sched.Enqueue(tctx, &graph.Link{URL: "http://a.com/3"})
+	c.Assert(err, gc.ErrorMatches, ".*context deadline exceeded")
+	c.Assert(sched.Pending(), gc.Equals, 2)
+
+	runErrCh := make(chan error, 1)
+	go func() { runErrCh <- sched.Run(ctx) }()
+
+	c.Assert(sched.Enqueue(ctx, &graph.Link{URL: "http://a.com/3"}), gc.IsNil)
+	sched.Close()
+	c.Assert(<-runErrCh, gc.IsNil)
+	c.Assert(rec.order, gc.HasLen, 3)
+
+	err = sched.Enqueue(ctx, &graph.Link{URL: "http://a.com/4"})
+	c.Assert(err, gc.ErrorMatches, ".*scheduler closed")
+}
+
+func (s *SchedulerTestSuite) TestRunCancellation(c *gc.C) {
+	sched, err := New(Config{Fetch: func(context.Context, *graph.Link) {}})
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runCtx := newWaitingContext(ctx)
+	go func() {
+		<-runCtx.waiting
+		cancel()
+	}()
+	c.Assert(sched.Run(runCtx), gc.Equals, context.Canceled)
+}
+
+// waitingContext は Done が最初に呼び出されたときに waiting を閉じるコンテキスト。
+// Run がキャンセルを待ち始めたことを知るのに使用する。
+type waitingContext struct {
+	context.Context
+
+	once    sync.Once
+	waiting chan struct{}
+}
+
+func newWaitingContext(ctx context.Context) *waitingContext {
+	return &waitingContext{Context: ctx, waiting: make(chan struct{})}
+}
+
+func (ctx *waitingContext) Done() <-chan struct{} {
+	ctx.once.Do(func() { close(ctx.waiting) })
+	return ctx.Context.Done()
+}
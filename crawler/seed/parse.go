@@ -0,0 +1,261 @@
+package seed
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"golang.org/x/xerrors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxDocumentSize はサイトマップ仕様で定められた展開後の最大サイズ
+const maxDocumentSize = 50 << 20
+
+// ErrUnknownFormat はサイトマップとしてもフィードとしても解釈できない文書に対して返される
+var ErrUnknownFormat = xerrors.New("unknown sitemap or feed format")
+
+// Kind は解析された文書の種類を表す
+type Kind uint8
+
+const (
+	KindSitemap Kind = iota
+	KindSitemapIndex
+	KindRSS
+	KindAtom
+)
+
+// Entry はサイトマップまたはフィードから抽出された URL
+type Entry struct {
+	URL string
+
+	// LastMod は文書に記載された最終更新日時。記載がない場合はゼロ値。
+	LastMod time.Time
+}
+
+// Document はサイトマップまたはフィードの解析結果
+type Document struct {
+	Kind Kind
+
+	// Entries はページの URL。サイトマップインデックスの場合は子サイトマップの URL。
+	Entries []Entry
+}
+
+type sitemapURLSet struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+type sitemapIndex struct {
+	Sitemaps []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"sitemap"`
+}
+
+type rssItem struct {
+	// atom:link 要素も同じ名前で一致するため、空でない最初の値を使用する
+	Links   []string `xml:"link"`
+	GUID    rssGUID  `xml:"guid"`
+	PubDate string   `xml:"pubDate"`
+	DCDate  string   `xml:"date"`
+}
+
+// rssGUID は item の guid 要素。isPermaLink が "false" でない場合、値はその item のパーマリンクとなる。
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink string `xml:"isPermaLink,attr"`
+}
+
+// permalink は guid がパーマリンクであり、http(s) の絶対 URL の場合にその URL を返す。
+// tag: URI などの不透明な識別子は空文字列となる。
+func (g rssGUID) permalink() string {
+	if strings.TrimSpace(g.IsPermaLink) == "false" {
+		return ""
+	}
+	v := strings.TrimSpace(g.Value)
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return v
+}
+
+type rssFeed struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+// rdfFeed は RSS 1.0 の文書を表す。item 要素は channel の外側に置かれる。
+type rdfFeed struct {
+	Items []rssItem `xml:"item"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Updated   string `xml:"updated"`
+		Published string `xml:"published"`
+	} `xml:"entry"`
+}
+
+// Parse はサイトマップ (gzip 圧縮されたものを含む)、サイトマップインデックス、RSS または Atom フィードを解析する
+func Parse(r io.Reader) (*Document, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, xerrors.Errorf("parse: %w", err)
+		}
+		defer func() { _ = zr.Close() }()
+		r = zr
+	} else {
+		r = br
+	}
+
+	dec := xml.NewDecoder(io.LimitReader(r, maxDocumentSize))
+	dec.Strict = false
+	root, err := rootElement(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := new(Document)
+	switch strings.ToLower(root.Name.Local) {
+	case "urlset":
+		var v sitemapURLSet
+		if err := dec.DecodeElement(&v, &root); err != nil {
+			return nil, xerrors.Errorf("parse: %w", err)
+		}
+		doc.Kind = KindSitemap
+		for _, u := range v.URLs {
+			doc.add(u.Loc, u.LastMod)
+		}
+	case "sitemapindex":
+		var v sitemapIndex
+		if err := dec.DecodeElement(&v, &root); err != nil {
+			return nil, xerrors.Errorf("parse: %w", err)
+		}
+		doc.Kind = KindSitemapIndex
+		for _, sm := range v.Sitemaps {
+			doc.add(sm.Loc, sm.LastMod)
+		}
+	case "rss":
+		var v rssFeed
+		if err := dec.DecodeElement(&v, &root); err != nil {
+			return nil, xerrors.Errorf("parse: %w", err)
+		}
+		doc.Kind = KindRSS
+		doc.addRSSItems(v.Items)
+	case "rdf":
+		var v rdfFeed
+		if err := dec.DecodeElement(&v, &root); err != nil {
+			return nil, xerrors.Errorf("parse: %w", err)
+		}
+		doc.Kind = KindRSS
+		doc.addRSSItems(v.Items)
+	case "feed":
+		var v atomFeed
+		if err := dec.DecodeElement(&v, &root); err != nil {
+			return nil, xerrors.Errorf("parse: %w", err)
+		}
+		doc.Kind = KindAtom
+		for _, e := range v.Entries {
+			var href string
+			for _, l := range e.Links {
+				// rel 属性が省略されたリンクは alternate として扱われる
+				if l.Rel == "" || l.Rel == "alternate" {
+					href = l.Href
+					break
+				}
+			}
+			lastMod := e.Updated
+			if lastMod == "" {
+				lastMod = e.Published
+			}
+			doc.add(href, lastMod)
+		}
+	default:
+		return nil, xerrors.Errorf("parse: root element %q: %w", root.Name.Local, ErrUnknownFormat)
+	}
+
+	return doc, nil
+}
+
+func (d *Document) addRSSItems(items []rssItem) {
+	for _, item := range items {
+		var link string
+		for _, l := range item.Links {
+			if l = strings.TrimSpace(l); l != "" {
+				link = l
+				break
+			}
+		}
+		if link == "" {
+			link = item.GUID.permalink()
+		}
+		lastMod := item.PubDate
+		if lastMod == "" {
+			lastMod = item.DCDate
+		}
+		d.add(link, lastMod)
+	}
+}
+
+func (d *Document) add(loc, lastMod string) {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return
+	}
+	d.Entries = append(d.Entries, Entry{URL: loc, LastMod: parseDate(lastMod)})
+}
+
+func rootElement(dec *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return xml.StartElement{}, xerrors.Errorf("parse: %w", ErrUnknownFormat)
+			}
+			return xml.StartElement{}, xerrors.Errorf("parse: %w", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se, nil
+		}
+	}
+}
+
+// dateLayouts はサイトマップ (W3C Datetime) と RSS (RFC 822) で使われる日付の書式
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+// parseDate は日付を解析する。解析できない場合はゼロ値を返す。
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,241 @@
+package seed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/store/memory"
+	gc "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	_ = gc.Suite(new(SeedTestSuite))
+
+	minUUID = uuid.Nil
+	maxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+)
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type SeedTestSuite struct{}
+
+const (
+	testSitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/sitemap-1.xml.gz</loc><lastmod>2022-01-02</lastmod></sitemap>
+</sitemapindex>`
+
+	testSitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/a</loc><lastmod>2022-01-02T10:00:00+09:00</lastmod></url>
+  <url><loc>https://example.com/b</loc></url>
+  <url><loc>https://example.com/old</loc><lastmod>2001-01-01</lastmod></url>
+</urlset>`
+
+	testRSS = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+  <channel>
+    <title>test</title>
+    <item>
+      <atom:link href="https://example.com/feed" rel="self"/>
+      <link>https://example.com/post-1</link>
+      <pubDate>Mon, 03 Jan 2022 10:00:00 +0000</pubDate>
+    </item>
+    <item><guid>https://example.com/post-2</guid></item>
+  </channel>
+</rss>`
+
+	testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <link rel="edit" href="https://example.com/edit/1"/>
+    <link href="https://example.com/entry-1"/>
+    <updated>2022-01-04T00:00:00Z</updated>
+  </entry>
+</feed>`
+)
+
+func (s *SeedTestSuite) TestParseSitemap(c *gc.C) {
+	doc, err := Parse(strings.NewReader(testSitemap))
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.Kind, gc.Equals, KindSitemap)
+	c.Assert(doc.Entries, gc.HasLen, 3)
+	c.Assert(doc.Entries[0].LastMod.Equal(time.Date(2022, 1, 2, 1, 0, 0, 0, time.UTC)), gc.Equals, true)
+	c.Assert(doc.Entries[1].LastMod.IsZero(), gc.Equals, true)
+}
+
+func (s *SeedTestSuite) TestParseGzippedSitemap(c *gc.C) {
+	doc, err := Parse(bytes.NewReader(gzipped(c, testSitemap)))
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.Kind, gc.Equals, KindSitemap)
+	c.Assert(doc.Entries, gc.HasLen, 3)
+}
+
+func (s *SeedTestSuite) TestParseFeeds(c *gc.C) {
+	doc, err := Parse(strings.NewReader(testRSS))
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.Kind, gc.Equals, KindRSS)
+	c.Assert(doc.Entries, gc.DeepEquals, []Entry{
+		{URL: "https://example.com/post-1", LastMod: time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC)},
+		{URL: "https://example.com/post-2"},
+	})
+
+	doc, err = Parse(strings.NewReader(testAtom))
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.Kind, gc.Equals, KindAtom)
+	c.Assert(doc.Entries, gc.DeepEquals, []Entry{
+		{URL: "https://example.com/entry-1", LastMod: time.Date(2022, 1, 4, 0, 0, 0, 0, time.UTC)},
+	})
+}
+
+func (s *SeedTestSuite) TestParseRSSGUID(c *gc.C) {
+	rss := `<?xml version="1.0"?>
+<rss version="2.0">
+  <channel>
+    <item><guid>https://example.com/permalink</guid></item>
+    <item><guid isPermaLink="true">http://example.com/explicit</guid></item>
+    <item><guid isPermaLink="false">https://example.com/not-a-permalink</guid></item>
+    <item><guid>tag:example.com,2022:post-3</guid></item>
+    <item><guid>post-4</guid></item>
+  </channel>
+</rss>`
+
+	// パーマリンクでない guid や絶対 URL でない guid はエントリにならない
+	doc, err := Parse(strings.NewReader(rss))
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.Entries, gc.DeepEquals, []Entry{
+		{URL: "https://example.com/permalink"},
+		{URL: "http://example.com/explicit"},
+	})
+}
+
+func (s *SeedTestSuite) TestParseUnknownFormat(c *gc.C) {
+	_, err := Parse(strings.NewReader("<html><body></body></html>"))
+	c.Assert(err, gc.ErrorMatches, ".*unknown sitemap or feed format")
+}
+
+func (s *SeedTestSuite) TestSeedSitemapIndex(c *gc.C) {
+	gzSitemap := gzipped(c, testSitemap)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = w.Write([]byte(testSitemapIndex))
+		case "/sitemap-1.xml.gz":
+			_, _ = w.Write(gzSitemap)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	g := memory.NewInMemoryGraph()
+	seeder := NewSeeder(g, srv.Client())
+	seeder.Since = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res, err := seeder.Seed(context.Background(), srv.URL+"/sitemap.xml")
+	c.Assert(err, gc.IsNil)
+	c.Assert(*res, gc.DeepEquals, Result{Links: 2, Sources: 2, Skipped: 1})
+
+	urls := linkURLs(c, g)
+	c.Assert(urls, gc.DeepEquals, []string{
+		srv.URL + "/sitemap-1.xml.gz",
+		srv.URL + "/sitemap.xml",
+		"https://example.com/a",
+		"https://example.com/b",
+	})
+
+	// サイトマップから各エントリへのエッジが張られていることを確認する
+	it, err := g.Edges(minUUID, maxUUID, time.Now().Add(time.Minute))
+	c.Assert(err, gc.IsNil)
+	var edgeCount int
+	for it.Next() {
+		edgeCount++
+	}
+	c.Assert(edgeCount, gc.Equals, 3)
+}
+
+func (s *SeedTestSuite) TestSeedSitemapIndexWithFailingChild(c *gc.C) {
+	index := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/missing.xml</loc></sitemap>
+  <sitemap><loc>/broken.xml</loc></sitemap>
+  <sitemap><loc>/sitemap-1.xml</loc></sitemap>
+</sitemapindex>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = w.Write([]byte(index))
+		case "/broken.xml":
+			_, _ = w.Write([]byte("not a sitemap"))
+		case "/sitemap-1.xml":
+			_, _ = w.Write([]byte(testSitemap))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	g := memory.NewInMemoryGraph()
+	res, err := NewSeeder(g, srv.Client()).Seed(context.Background(), srv.URL+"/sitemap.xml")
+	c.Assert(err, gc.IsNil)
+
+	// 失敗した子サイトマップはエラーとして報告され、残りの子サイトマップは処理される
+	c.Assert(res.Errors, gc.HasLen, 2)
+	c.Assert(res.Errors[0], gc.ErrorMatches, ".*missing.xml.*unexpected status code 404")
+	c.Assert(res.Errors[1], gc.ErrorMatches, ".*broken.xml.*")
+	c.Assert(res.Links, gc.Equals, 3)
+	c.Assert(res.Sources, gc.Equals, 2)
+	c.Assert(linkURLs(c, g), gc.DeepEquals, []string{
+		srv.URL + "/sitemap-1.xml",
+		srv.URL + "/sitemap.xml",
+		"https://example.com/a",
+		"https://example.com/b",
+		"https://example.com/old",
+	})
+}
+
+func (s *SeedTestSuite) TestSeedReader(c *gc.C) {
+	g := memory.NewInMemoryGraph()
+	res, err := NewSeeder(g, nil).SeedReader(context.Background(), "https://example.com/feed.atom", strings.NewReader(testAtom))
+	c.Assert(err, gc.IsNil)
+	c.Assert(*res, gc.DeepEquals, Result{Links: 1, Sources: 1})
+	c.Assert(linkURLs(c, g), gc.DeepEquals, []string{"https://example.com/entry-1", "https://example.com/feed.atom"})
+}
+
+func (s *SeedTestSuite) TestSeedUnexpectedStatus(c *gc.C) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := NewSeeder(memory.NewInMemoryGraph(), nil).Seed(context.Background(), srv.URL+"/sitemap.xml")
+	c.Assert(err, gc.ErrorMatches, ".*unexpected status code 404")
+}
+
+func linkURLs(c *gc.C, g graph.Graph) []string {
+	it, err := g.Links(minUUID, maxUUID, time.Now().Add(time.Minute))
+	c.Assert(err, gc.IsNil)
+
+	var urls []string
+	for it.Next() {
+		urls = append(urls, it.Link().URL)
+	}
+	c.Assert(it.Close(), gc.IsNil)
+	sort.Strings(urls)
+	return urls
+}
+
+func gzipped(c *gc.C, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	c.Assert(err, gc.IsNil)
+	c.Assert(zw.Close(), gc.IsNil)
+	return buf.Bytes()
+}
@@ -0,0 +1,172 @@
+package seed
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultMaxDepth はサイトマップインデックスを辿る深さの既定値
+const defaultMaxDepth = 3
+
+// Result は Seed の処理結果を表す
+type Result struct {
+	// Links は追加または更新されたページのリンク数
+	Links int
+
+	// Sources は処理したサイトマップおよびフィードの数
+	Sources int
+
+	// Skipped は不正な URL や Since より古いために読み飛ばされたエントリの数
+	Skipped int
+
+	// Errors は取得または処理に失敗したサイトマップインデックスの子サイトマップのエラー。
+	// 失敗した子サイトマップは読み飛ばされ、他の子サイトマップの処理は続けられる。
+	Errors []error
+}
+
+// Seeder はサイトマップや RSS/Atom フィードから URL を抽出してリンクグラフに追加する
+type Seeder struct {
+	g      graph.Graph
+	client *http.Client
+
+	// Since がゼロ値でない場合、LastMod がこれより古いエントリは読み飛ばされる。LastMod のないエントリは常に追加される。
+	Since time.Time
+
+	// MaxDepth はサイトマップインデックスを辿る最大の深さ
+	MaxDepth int
+
+	// UserAgent が空でない場合、取得リクエストの User-Agent ヘッダとして送信される
+	UserAgent string
+}
+
+// NewSeeder は g にリンクを追加する Seeder を作成する。client が nil の場合は http.DefaultClient が使用される。
+func NewSeeder(g graph.Graph, client *http.Client) *Seeder {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Seeder{g: g, client: client, MaxDepth: defaultMaxDepth}
+}
+
+// Seed は sourceURL のサイトマップまたはフィードを取得し、含まれる URL をリンクとして追加する。
+// サイトマップ (フィード) の URL 自身もリンクとして追加され、各エントリへのエッジが張られる。
+func (s *Seeder) Seed(ctx context.Context, sourceURL string) (*Result, error) {
+	res := new(Result)
+	if err := s.seed(ctx, sourceURL, 0, res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// SeedReader は r から読み込んだサイトマップまたはフィードを sourceURL から取得したものとして処理する。
+// サイトマップインデックスの場合、子サイトマップは HTTP で取得される。
+func (s *Seeder) SeedReader(ctx context.Context, sourceURL string, r io.Reader) (*Result, error) {
+	res := new(Result)
+	if err := s.process(ctx, sourceURL, r, 0, res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func (s *Seeder) seed(ctx context.Context, sourceURL string, depth int, res *Result) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return xerrors.Errorf("seed: %w", err)
+	}
+	if s.UserAgent != "" {
+		req.Header.Set("User-Agent", s.UserAgent)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("seed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("seed: fetching %q: unexpected status code %d", sourceURL, resp.StatusCode)
+	}
+
+	return s.process(ctx, sourceURL, resp.Body, depth, res)
+}
+
+func (s *Seeder) process(ctx context.Context, sourceURL string, r io.Reader, depth int, res *Result) error {
+	base, err := url.Parse(sourceURL)
+	if err != nil {
+		return xerrors.Errorf("seed: %w", err)
+	}
+
+	doc, err := Parse(r)
+	if err != nil {
+		return xerrors.Errorf("seed: %q: %w", sourceURL, err)
+	}
+	res.Sources++
+
+	// サイトマップ自体は取得済みなので、クローラが再取得しないように RetrievedAt を設定する
+	src := &graph.Link{URL: base.String(), RetrievedAt: time.Now()}
+	if err := s.g.UpsertLink(src); err != nil {
+		return xerrors.Errorf("seed: %w", err)
+	}
+
+	for _, entry := range doc.Entries {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("seed: %w", err)
+		}
+
+		target, err := resolve(base, entry.URL)
+		if err != nil || (!s.Since.IsZero() && !entry.LastMod.IsZero() && entry.LastMod.Before(s.Since)) {
+			res.Skipped++
+			continue
+		}
+
+		if doc.Kind == KindSitemapIndex {
+			if depth+1 > s.MaxDepth {
+				res.Skipped++
+				continue
+			}
+			if err := s.seed(ctx, target, depth+1, res); err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return xerrors.Errorf("seed: %w", ctxErr)
+				}
+				res.Errors = append(res.Errors, err)
+				continue
+			}
+		}
+
+		dst := &graph.Link{URL: target}
+		if doc.Kind == KindSitemapIndex {
+			dst.RetrievedAt = time.Now()
+		}
+		if err := s.g.UpsertLink(dst); err != nil {
+			return xerrors.Errorf("seed: %w", err)
+		}
+		if err := s.g.UpsertEdge(&graph.Edge{Src: src.ID, Dst: dst.ID}); err != nil {
+			return xerrors.Errorf("seed: %w", err)
+		}
+
+		if doc.Kind != KindSitemapIndex {
+			res.Links++
+		}
+	}
+
+	return nil
+}
+
+// resolve は相対 URL を base に対して解決し、http(s) の絶対 URL のみを返す
+func resolve(base *url.URL, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", xerrors.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
@@ -0,0 +1,214 @@
+package ssrf
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxRedirects は Client が辿るリダイレクトの最大数
+const maxRedirects = 10
+
+var (
+	// ErrBlockedAddress はプライベート、ループバック、リンクローカル、マルチキャストなどのアドレスへの接続を拒否した場合に返される
+	ErrBlockedAddress = xerrors.New("address is not publicly routable")
+
+	// ErrUnsupportedScheme は http(s) 以外の URL に対して返される
+	ErrUnsupportedScheme = xerrors.New("unsupported URL scheme")
+
+	_ graph.LinkValidator = (*Guard)(nil)
+)
+
+// blockedNets は IsPrivate などの net.IP のメソッドでは判定できない、公開されていないアドレス範囲
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // キャリアグレード NAT
+	"192.0.0.0/24",    // IETF プロトコル割り当て
+	"192.0.2.0/24",    // TEST-NET-1
+	"198.18.0.0/15",   // ベンチマーク
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"240.0.0.0/4",     // 予約済み (255.255.255.255 を含む)
+	"64:ff9b::/96",    // NAT64 (任意の IPv4 アドレスに変換されうる)
+	"100::/64",        // 破棄専用
+	"2001:db8::/32",   // ドキュメント用
+)
+
+// Guard はクローラの HTTP クライアントが公開されていないアドレスに接続することを防ぐ
+type Guard struct {
+	// Allow は禁止対象のアドレスであっても接続を許可するネットワーク (テストや社内ミラーなど)
+	Allow []*net.IPNet
+
+	// Resolver はホスト名の解決に使用される。nil の場合は net.DefaultResolver が使用される。
+	Resolver *net.Resolver
+
+	// ResolveOnValidate が true の場合、ValidateLink はホスト名を解決して解決先のアドレスも検証する
+	ResolveOnValidate bool
+}
+
+// Blocked は ip への接続を拒否すべきかどうかを返す
+func (g *Guard) Blocked(ip net.IP) bool {
+	for _, n := range g.Allow {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialContext は接続先のアドレスを検証してから接続する。検証は名前解決後の実際の接続先に対して行われるため、
+// リダイレクトや DNS リバインディングによって禁止されたアドレスに接続することはない。
+func (g *Guard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  g.Resolver,
+		Control:   g.control,
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func (g *Guard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return xerrors.Errorf("ssrf: %w", err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || g.Blocked(ip) {
+		return xerrors.Errorf("ssrf: dial %s %s: %w", network, address, ErrBlockedAddress)
+	}
+	return nil
+}
+
+// Transport は DialContext を使用する http.Transport を返す。
+// プロキシ経由の接続は検証を迂回してしまうため、環境変数のプロキシ設定は使用しない。
+func (g *Guard) Transport() *http.Transport {
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           g.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// Client は Transport を使用し、リダイレクト先の URL も検証する http.Client を返す
+func (g *Guard) Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: g.Transport(),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return xerrors.Errorf("ssrf: stopped after %d redirects", maxRedirects)
+			}
+			// 名前解決は接続時に検証されるので、ここではスキームとリテラルの IP アドレスのみを確認する
+			return g.checkURL(req.URL)
+		},
+	}
+}
+
+// ValidateURL は rawURL のスキームを確認し、ホスト名を解決して禁止されたアドレスを指していないか検証する
+func (g *Guard) ValidateURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return xerrors.Errorf("ssrf: %w", err)
+	}
+	if err := g.checkURL(u); err != nil {
+		return err
+	}
+
+	return g.checkResolved(ctx, u.Hostname())
+}
+
+// ValidateLink は graph.LinkValidator を実装する。graph.WithLinkValidator と組み合わせることで、
+// 禁止されたアドレスを指すリンクをリンクグラフへの追加時に拒否できる。
+func (g *Guard) ValidateLink(link *graph.Link) error {
+	if !g.ResolveOnValidate {
+		u, err := url.Parse(link.URL)
+		if err != nil {
+			return xerrors.Errorf("ssrf: %w", err)
+		}
+		return g.checkURL(u)
+	}
+
+	return g.ValidateURL(context.Background(), link.URL)
+}
+
+// checkURL は名前解決を行わずに URL を検証する
+func (g *Guard) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return xerrors.Errorf("ssrf: %q: %w", u.Scheme, ErrUnsupportedScheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return xerrors.Errorf("ssrf: URL %q does not specify a host", u.String())
+	}
+	if lhost := strings.ToLower(host); lhost == "localhost" || strings.HasSuffix(lhost, ".localhost") {
+		return xerrors.Errorf("ssrf: %s: %w", host, ErrBlockedAddress)
+	}
+	if ip := net.ParseIP(host); ip != nil && g.Blocked(ip) {
+		return xerrors.Errorf("ssrf: %s: %w", host, ErrBlockedAddress)
+	}
+	return nil
+}
+
+func (g *Guard) checkResolved(ctx context.Context, host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	resolver := g.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return xerrors.Errorf("ssrf: %w", err)
+	}
+
+	// 1 つでも禁止されたアドレスに解決される場合は拒否する
+	for _, addr := range addrs {
+		if g.Blocked(addr.IP) {
+			return xerrors.Errorf("ssrf: %s resolves to %s: %w", host, addr.IP, ErrBlockedAddress)
+		}
+	}
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -0,0 +1,113 @@
+package ssrf
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(GuardTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type GuardTestSuite struct{}
+
+func (s *GuardTestSuite) TestBlocked(c *gc.C) {
+	specs := []struct {
+		ip  string
+		exp bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"100.64.0.1", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700:4700::1111", false},
+	}
+
+	g := new(Guard)
+	for _, spec := range specs {
+		c.Check(g.Blocked(net.ParseIP(spec.ip)), gc.Equals, spec.exp, gc.Commentf("ip %s", spec.ip))
+	}
+}
+
+func (s *GuardTestSuite) TestClientRefusesLoopback(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	client := new(Guard).Client(time.Second)
+	_, err := client.Get(srv.URL)
+	c.Assert(xerrors.Is(err, ErrBlockedAddress), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	// ホスト名で指定された場合も、解決後のアドレスで拒否される
+	_, err = client.Get(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1))
+	c.Assert(xerrors.Is(err, ErrBlockedAddress), gc.Equals, true, gc.Commentf("got error: %v", err))
+}
+
+func (s *GuardTestSuite) TestClientRefusesRedirectToBlockedAddress(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	client := (&Guard{Allow: []*net.IPNet{loopback}}).Client(time.Second)
+
+	res, err := client.Get(srv.URL + "/ok")
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(res.Body.Close(), gc.IsNil)
+
+	_, err = client.Get(srv.URL + "/redirect")
+	c.Assert(xerrors.Is(err, ErrBlockedAddress), gc.Equals, true, gc.Commentf("got error: %v", err))
+}
+
+func (s *GuardTestSuite) TestValidateURL(c *gc.C) {
+	g := new(Guard)
+	ctx := context.Background()
+
+	c.Assert(xerrors.Is(g.ValidateURL(ctx, "http://localhost:8080/"), ErrBlockedAddress), gc.Equals, true)
+	c.Assert(xerrors.Is(g.ValidateURL(ctx, "http://[::1]/"), ErrBlockedAddress), gc.Equals, true)
+	c.Assert(xerrors.Is(g.ValidateURL(ctx, "file:///etc/passwd"), ErrUnsupportedScheme), gc.Equals, true)
+	c.Assert(g.ValidateURL(ctx, "https://93.184.216.34/"), gc.IsNil)
+}
+
+func (s *GuardTestSuite) TestValidatingGraph(c *gc.C) {
+	g := graph.WithLinkValidator(memory.NewInMemoryGraph(), new(Guard))
+
+	err := g.UpsertLink(&graph.Link{URL: "http://169.254.169.254/latest/meta-data/"})
+	c.Assert(xerrors.Is(err, ErrBlockedAddress), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	err = g.UpsertLink(&graph.Link{URL: "http://192.168.0.1/admin"})
+	c.Assert(xerrors.Is(err, ErrBlockedAddress), gc.Equals, true, gc.Commentf("got error: %v", err))
+
+	link := &graph.Link{URL: "https://example.com/"}
+	c.Assert(g.UpsertLink(link), gc.IsNil)
+
+	found, err := g.FindLink(link.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.URL, gc.Equals, link.URL)
+}
@@ -0,0 +1,35 @@
+package graph
+
+import "golang.org/x/xerrors"
+
+// LinkValidator は UpsertLink の前にリンクを検証する
+type LinkValidator interface {
+	ValidateLink(link *Link) error
+}
+
+// LinkValidatorFunc は関数を LinkValidator として使用するためのアダプタ
+type LinkValidatorFunc func(link *Link) error
+
+// ValidateLink は f(link) を呼び出す
+func (f LinkValidatorFunc) ValidateLink(link *Link) error {
+	return f(link)
+}
+
+type validatingGraph struct {
+	Graph
+	v LinkValidator
+}
+
+// WithLinkValidator は g をラップし、UpsertLink の前に v でリンクを検証する Graph を返す。
+// 検証に失敗したリンクはストアに追加されない。
+func WithLinkValidator(g Graph, v LinkValidator) Graph {
+	return &validatingGraph{Graph: g, v: v}
+}
+
+func (g *validatingGraph) UpsertLink(link *Link) error {
+	if err := g.v.ValidateLink(link); err != nil {
+		return xerrors.Errorf("upsert link: %w", err)
+	}
+
+	return g.Graph.UpsertLink(link)
+}
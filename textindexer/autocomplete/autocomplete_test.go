@@ -0,0 +1,108 @@
+package autocomplete
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	gc "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = gc.Suite(new(AutocompleteTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type AutocompleteTestSuite struct{}
+
+func (s *AutocompleteTestSuite) TestCompleteRanksByPageRankAndFrequency(c *gc.C) {
+	cp := NewCompleter(Config{})
+	goTour := &index.Document{LinkID: uuid.New(), Title: "A Tour of Go", PageRank: 0.1}
+	gopher := &index.Document{LinkID: uuid.New(), Title: "Gopher academy", PageRank: 0.9}
+	goroutines := &index.Document{LinkID: uuid.New(), Title: "Goroutines in Go"}
+	for _, doc := range []*index.Document{goTour, gopher, goroutines} {
+		cp.Add(doc)
+	}
+
+	// "go" は 2 件の文書に現れるため、PageRank の高い "gopher" より先に並ぶ
+	c.Assert(texts(cp.Complete("go", 3)), gc.DeepEquals, []string{"go", "gopher", "gopher academy"})
+
+	// PageRank の更新は候補の順に反映される
+	cp.UpdateScore(goroutines.LinkID, 2)
+	c.Assert(texts(cp.Complete("gor", 5)), gc.DeepEquals, []string{"goroutines", "goroutines in", "goroutines in go"})
+	c.Assert(texts(cp.Complete("go", 1)), gc.DeepEquals, []string{"go"})
+	c.Assert(texts(cp.Complete("gop", 5)), gc.DeepEquals, []string{"gopher", "gopher academy"})
+
+	// 末尾の空白は次の語の入力として扱う
+	c.Assert(texts(cp.Complete("Tour  ", 5)), gc.DeepEquals, []string{"tour of", "tour of go"})
+
+	// 削除した文書の候補は返さない
+	cp.Remove(gopher.LinkID)
+	c.Assert(cp.Complete("gop", 5), gc.HasLen, 0)
+	c.Assert(cp.Complete("xyz", 5), gc.HasLen, 0)
+}
+
+func (s *AutocompleteTestSuite) TestCompleteBeyondCacheSize(c *gc.C) {
+	cp := NewCompleter(Config{CacheSize: 2, MaxPhraseWords: 1})
+	for i := 0; i < 5; i++ {
+		cp.Add(&index.Document{LinkID: uuid.New(), Title: fmt.Sprintf("term%d", i), PageRank: float64(i)})
+	}
+
+	exp := []string{"term4", "term3", "term2", "term1", "term0"}
+	c.Assert(texts(cp.Complete("term", 2)), gc.DeepEquals, exp[:2])
+	c.Assert(texts(cp.Complete("term", 10)), gc.DeepEquals, exp)
+}
+
+func (s *AutocompleteTestSuite) TestIndexerTracksDocumentsAndQueries(c *gc.C) {
+	bleveIdx, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = bleveIdx.Close() }()
+	idx := NewIndexer(bleveIdx, Config{QueryWeight: 10})
+
+	low := &index.Document{LinkID: uuid.New(), Title: "Distributed tracing", Content: "spans and traces"}
+	high := &index.Document{LinkID: uuid.New(), Title: "Distributed systems", Content: "consensus and replication"}
+	c.Assert(idx.Index(low), gc.IsNil)
+	c.Assert(idx.Index(high), gc.IsNil)
+	c.Assert(idx.UpdateScore(high.LinkID, 1), gc.IsNil)
+	c.Assert(texts(idx.Complete("distributed ", 2)), gc.DeepEquals, []string{"distributed systems", "distributed tracing"})
+
+	// 結果が得られた検索式は候補になる
+	it, err := idx.Search(index.Query{Expression: "Distributed  Tracing"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(texts(idx.Complete("distributed ", 2)), gc.DeepEquals, []string{"distributed tracing", "distributed systems"})
+
+	// 結果が得られない検索式は候補にならない
+	it, err = idx.Search(index.Query{Expression: "quantum locks"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(idx.Complete("quantum", 5), gc.HasLen, 0)
+}
+
+func (s *AutocompleteTestSuite) TestIndexerKeepsScoreSetBeforeContent(c *gc.C) {
+	bleveIdx, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = bleveIdx.Close() }()
+	idx := NewIndexer(bleveIdx, Config{})
+
+	// PageRank が内容より先に計算された文書
+	ranked := &index.Document{LinkID: uuid.New(), Title: "Graph processing", Content: "pregel"}
+	c.Assert(idx.UpdateScore(ranked.LinkID, 5), gc.IsNil)
+	c.Assert(idx.Index(ranked), gc.IsNil)
+
+	batched := &index.Document{LinkID: uuid.New(), Title: "Graph partitioning", Content: "shards"}
+	c.Assert(idx.UpdateScore(batched.LinkID, 2), gc.IsNil)
+	plain := &index.Document{LinkID: uuid.New(), Title: "Graph databases", Content: "nodes"}
+	_, err = idx.IndexBatch([]*index.Document{batched, plain})
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(texts(idx.Complete("graph ", 3)), gc.DeepEquals, []string{"graph processing", "graph partitioning", "graph databases"})
+}
+
+func texts(completions []Completion) []string {
+	out := make([]string, len(completions))
+	for i, cm := range completions {
+		out[i] = cm.Text
+	}
+	return out
+}
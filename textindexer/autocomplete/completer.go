@@ -0,0 +1,275 @@
+// Package autocomplete は入力途中の検索語の補完候補を返す。
+// 候補は文書のタイトルの語とフレーズ、および結果が得られた検索式から作成され、
+// 文書の PageRank と出現回数に基づくスコアの順に並ぶ。
+package autocomplete
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// DefaultMaxPhraseWords はタイトルから作成するフレーズの最大の語数
+	DefaultMaxPhraseWords = 4
+
+	// DefaultCacheSize はトライの各ノードが保持する上位の候補の数
+	DefaultCacheSize = 10
+)
+
+// Config は Completer の設定を保持する
+type Config struct {
+	// MaxPhraseWords はタイトルの各語から始まるフレーズの最大の語数。0 の場合は DefaultMaxPhraseWords が使用される。
+	MaxPhraseWords int
+
+	// PageRankWeight は候補を含む文書 1 件あたりのスコアに加える PageRank の重み。0 の場合は 1 が使用される。
+	PageRankWeight float64
+
+	// QueryWeight は検索式 1 回あたりのスコア。0 の場合は 1 が使用される。
+	QueryWeight float64
+
+	// CacheSize はトライの各ノードが保持する上位の候補の数。これより多くの候補を要求された場合は部分木をすべて走査する。
+	// 0 の場合は DefaultCacheSize が使用される。
+	CacheSize int
+}
+
+// Completion は補完候補
+type Completion struct {
+	Text string
+
+	// Score は候補を含む文書ごとの 1 + PageRankWeight * PageRank と QueryWeight * 検索回数の合計
+	Score float64
+
+	// Count は候補をタイトルに含む文書の数と検索回数の合計
+	Count int
+}
+
+// docInfo は文書ごとに登録した候補とスコアの計算に使用した PageRank
+type docInfo struct {
+	pageRank float64
+	keys     []string
+}
+
+// Completer はタイトルの語とフレーズ、および検索式をトライに保持し、前方一致する候補を返す。
+// 複数の goroutine から同時に使用できる。
+type Completer struct {
+	cfg Config
+
+	mu      sync.Mutex
+	root    *node
+	entries map[string]*entry
+	docs    map[uuid.UUID]docInfo
+}
+
+// NewCompleter は候補を持たない Completer を作成する
+func NewCompleter(cfg Config) *Completer {
+	if cfg.MaxPhraseWords <= 0 {
+		cfg.MaxPhraseWords = DefaultMaxPhraseWords
+	}
+	if cfg.PageRankWeight == 0 {
+		cfg.PageRankWeight = 1
+	}
+	if cfg.QueryWeight == 0 {
+		cfg.QueryWeight = 1
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = DefaultCacheSize
+	}
+
+	return &Completer{
+		cfg:     cfg,
+		root:    newNode(),
+		entries: make(map[string]*entry),
+		docs:    make(map[uuid.UUID]docInfo),
+	}
+}
+
+// Add は文書のタイトルの語とフレーズを候補に登録する。同じリンク ID の文書が既にある場合は置き換える。
+// 既に登録されている文書の PageRank は保持され、新しい文書には doc.PageRank が使用される。
+func (c *Completer) Add(doc *index.Document) {
+	keys := c.keys(doc.Title)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pageRank := doc.PageRank
+	if old, found := c.docs[doc.LinkID]; found {
+		pageRank = old.pageRank
+		c.remove(doc.LinkID)
+	}
+
+	weight := 1 + c.cfg.PageRankWeight*pageRank
+	for _, key := range keys {
+		e := c.entry(key)
+		e.docs[doc.LinkID] = struct{}{}
+		e.score += weight
+	}
+	c.docs[doc.LinkID] = docInfo{pageRank: pageRank, keys: keys}
+}
+
+// UpdateScore は文書の PageRank を更新し、その文書を含む候補のスコアを更新する。登録されていない文書は無視する。
+func (c *Completer) UpdateScore(linkID uuid.UUID, pageRank float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, found := c.docs[linkID]
+	if !found {
+		return
+	}
+	delta := c.cfg.PageRankWeight * (pageRank - info.pageRank)
+	for _, key := range info.keys {
+		c.entries[key].score += delta
+		c.touch(key)
+	}
+	info.pageRank = pageRank
+	c.docs[linkID] = info
+}
+
+// Remove は文書の語とフレーズを候補から取り除く
+func (c *Completer) Remove(linkID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(linkID)
+}
+
+func (c *Completer) remove(linkID uuid.UUID) {
+	info, found := c.docs[linkID]
+	if !found {
+		return
+	}
+
+	weight := 1 + c.cfg.PageRankWeight*info.pageRank
+	for _, key := range info.keys {
+		e := c.entries[key]
+		delete(e.docs, linkID)
+		e.score -= weight
+		c.release(key, e)
+	}
+	delete(c.docs, linkID)
+}
+
+// AddQuery は結果が得られた検索式を候補に登録する。同じ検索式が登録されるたびにスコアが増える。
+func (c *Completer) AddQuery(expr string) {
+	key := normalize(expr)
+	if key == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entry(key)
+	e.queries++
+	e.score += c.cfg.QueryWeight
+}
+
+// Complete は prefix で始まる候補をスコアの降順に最大 n 件返す。
+// 同じスコアの候補は出現回数の降順、テキストの順に並ぶ。
+func (c *Completer) Complete(prefix string, n int) []Completion {
+	if n <= 0 {
+		return nil
+	}
+	// 入力途中の末尾の空白は次の語の始まりとして扱う
+	key := normalize(prefix)
+	if key != "" && strings.TrimRightFunc(prefix, unicode.IsSpace) != prefix {
+		key += " "
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.root.path(key, false)
+	if path == nil {
+		return nil
+	}
+	start := path[len(path)-1]
+
+	var entries []*entry
+	if n <= c.cfg.CacheSize {
+		entries = start.topK(c.cfg.CacheSize)
+	} else {
+		entries = start.collect(nil)
+		sortEntries(entries)
+	}
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	out := make([]Completion, len(entries))
+	for i, e := range entries {
+		out[i] = Completion{Text: e.text, Score: e.score, Count: e.count()}
+	}
+	return out
+}
+
+// entry は key の候補を返す。存在しない場合は作成する。呼び出し後に候補のスコアが変更されることを前提に経路を無効化する。
+func (c *Completer) entry(key string) *entry {
+	e, found := c.entries[key]
+	if !found {
+		e = &entry{text: key, docs: make(map[uuid.UUID]struct{})}
+		c.entries[key] = e
+		path := c.root.path(key, true)
+		path[len(path)-1].entry = e
+	}
+	c.touch(key)
+	return e
+}
+
+// release は文書と検索式のいずれにも現れなくなった候補をトライから取り除く
+func (c *Completer) release(key string, e *entry) {
+	c.touch(key)
+	if e.count() > 0 {
+		return
+	}
+
+	delete(c.entries, key)
+	path := c.root.path(key, false)
+	path[len(path)-1].entry = nil
+
+	// 候補を持たなくなった末端のノードを刈り込む
+	runes := []rune(key)
+	for i := len(path) - 1; i > 0; i-- {
+		if n := path[i]; n.entry != nil || len(n.children) != 0 {
+			break
+		}
+		delete(path[i-1].children, runes[i-1])
+	}
+}
+
+// touch は key の候補を部分木に含むノードの上位の候補を無効化する
+func (c *Completer) touch(key string) {
+	for _, n := range c.root.path(key, false) {
+		n.dirty = true
+	}
+}
+
+// keys はタイトルから候補とする語と、各語から始まる最大 MaxPhraseWords 語のフレーズを作成する
+func (c *Completer) keys(title string) []string {
+	words := words(title)
+	seen := make(map[string]struct{})
+	var keys []string
+	for i := range words {
+		for j := i + 1; j <= len(words) && j-i <= c.cfg.MaxPhraseWords; j++ {
+			key := strings.Join(words[i:j], " ")
+			if _, dup := seen[key]; dup || len([]rune(key)) < 2 {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// words はテキストを小文字の語に分割する
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalize はテキストを小文字の語を空白 1 つで区切った形式に変換する
+func normalize(text string) string {
+	return strings.Join(words(text), " ")
+}
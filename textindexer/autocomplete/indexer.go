@@ -0,0 +1,149 @@
+package autocomplete
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+)
+
+var (
+	_ index.BatchIndexer = (*Indexer)(nil)
+	_ index.Scanner      = (*Indexer)(nil)
+)
+
+// Indexer は index.Indexer をラップし、文書の追加と PageRank の更新に合わせて Completer の候補を更新する。
+// 結果が得られた検索式も候補として登録される。
+type Indexer struct {
+	idx index.Indexer
+	c   *Completer
+}
+
+// NewIndexer は idx をラップする Indexer を作成する
+func NewIndexer(idx index.Indexer, cfg Config) *Indexer {
+	return &Indexer{idx: idx, c: NewCompleter(cfg)}
+}
+
+// Complete は prefix で始まる補完候補を最大 n 件返す
+func (i *Indexer) Complete(prefix string, n int) []Completion {
+	return i.c.Complete(prefix, n)
+}
+
+// Completer は候補を保持する Completer を返す
+func (i *Indexer) Completer() *Completer {
+	return i.c
+}
+
+// Index は文書をインデックスに追加し、タイトルの語とフレーズを候補に登録する
+func (i *Indexer) Index(doc *index.Document) error {
+	if err := i.idx.Index(doc); err != nil {
+		return err
+	}
+	i.add(doc)
+	return nil
+}
+
+// IndexBatch は文書をまとめてインデックスに追加し、追加に成功した文書を候補に登録する
+func (i *Indexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	res, err := index.IndexBatch(i.idx, docs)
+	if err != nil {
+		return res, err
+	}
+
+	failed := make(map[int]struct{}, len(res.Errors))
+	for _, docErr := range res.Errors {
+		failed[docErr.Pos] = struct{}{}
+	}
+	for pos, doc := range docs {
+		if _, ok := failed[pos]; !ok {
+			i.add(doc)
+		}
+	}
+	return res, nil
+}
+
+// add はインデックスに追加した doc を候補に登録する。内容より先に UpdateScore で PageRank が設定された場合など、
+// doc.PageRank はインデックスに保存された値と異なることがあるため、保存された文書の PageRank を使用する。
+func (i *Indexer) add(doc *index.Document) {
+	dcopy := *doc
+	if stored, err := i.idx.FindByID(doc.LinkID); err == nil {
+		dcopy.PageRank = stored.PageRank
+	}
+	i.c.Add(&dcopy)
+}
+
+// Track は既にインデックスに存在する文書を PageRank とともに候補に登録する。
+// プロセスの再起動後に既存の文書を読み込み直す場合に使用する。
+func (i *Indexer) Track(doc *index.Document) {
+	i.c.Add(doc)
+	i.c.UpdateScore(doc.LinkID, doc.PageRank)
+}
+
+func (i *Indexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.idx.FindByID(linkID)
+}
+
+// Search はラップしたインデクサで検索を行う。
+// 先頭のページの検索で結果が得られた場合、QueryTypeAdvanced 以外の検索式を候補に登録する。
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	it, err := i.idx.Search(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Type != index.QueryTypeAdvanced && q.Offset == 0 && q.Cursor == "" && it.TotalCount() > 0 {
+		i.c.AddQuery(q.Expression)
+	}
+	return it, nil
+}
+
+// UpdateScore は文書の PageRank スコアを更新し、その文書を含む候補のスコアを更新する
+func (i *Indexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	if err := i.idx.UpdateScore(linkID, score); err != nil {
+		return err
+	}
+	i.c.UpdateScore(linkID, score)
+	return nil
+}
+
+// UpdateScores は PageRank スコアをまとめて更新し、更新に成功した文書を含む候補のスコアを更新する
+func (i *Indexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	res, err := index.UpdateScores(i.idx, scores)
+	if err != nil {
+		return res, err
+	}
+
+	failed := make(map[uuid.UUID]struct{}, len(res.Errors))
+	for _, docErr := range res.Errors {
+		failed[docErr.LinkID] = struct{}{}
+	}
+	for linkID, score := range scores {
+		if _, ok := failed[linkID]; !ok {
+			i.c.UpdateScore(linkID, score)
+		}
+	}
+	return res, nil
+}
+
+// Delete は文書を削除し、その語とフレーズを候補から取り除く
+func (i *Indexer) Delete(linkID uuid.UUID) error {
+	if err := i.idx.Delete(linkID); err != nil {
+		return err
+	}
+	i.c.Remove(linkID)
+	return nil
+}
+
+// DeleteMatching は pred が true を返す文書を削除し、それらの語とフレーズを候補から取り除く
+func (i *Indexer) DeleteMatching(pred index.Predicate) (int, error) {
+	matched, count, err := index.CollectMatching(i.idx, pred)
+
+	for _, id := range matched {
+		i.c.Remove(id)
+	}
+	return count, err
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.idx, fn)
+}
@@ -0,0 +1,103 @@
+package autocomplete
+
+import (
+	"github.com/google/uuid"
+	"sort"
+)
+
+// entry は補完候補となる 1 つの語またはフレーズ
+type entry struct {
+	text string
+
+	// docs はタイトルにこの候補を含む文書、queries は候補と同じ検索式で結果が得られた回数
+	docs    map[uuid.UUID]struct{}
+	queries int
+
+	// score は docs の文書ごとの 1 + PageRankWeight * PageRank と QueryWeight * queries の合計
+	score float64
+}
+
+func (e *entry) count() int {
+	return len(e.docs) + e.queries
+}
+
+// less は a が b より先に並ぶかを返す
+func less(a, b *entry) bool {
+	switch {
+	case a.score != b.score:
+		return a.score > b.score
+	case a.count() != b.count():
+		return a.count() > b.count()
+	default:
+		return a.text < b.text
+	}
+}
+
+func sortEntries(entries []*entry) {
+	sort.Slice(entries, func(a, b int) bool { return less(entries[a], entries[b]) })
+}
+
+// node はトライのノード。top には部分木に含まれる上位の候補が保持され、
+// 部分木の候補が変化すると dirty が設定されて次の参照時に子の top から作り直される。
+type node struct {
+	children map[rune]*node
+	entry    *entry
+
+	top   []*entry
+	dirty bool
+}
+
+func newNode() *node {
+	return &node{children: make(map[rune]*node)}
+}
+
+// path は key に対応するノードまでの経路を返す。create が false の場合、存在しないノードがあれば nil を返す。
+func (n *node) path(key string, create bool) []*node {
+	path := []*node{n}
+	for _, r := range key {
+		child, ok := n.children[r]
+		if !ok {
+			if !create {
+				return nil
+			}
+			child = newNode()
+			n.children[r] = child
+		}
+		path = append(path, child)
+		n = child
+	}
+	return path
+}
+
+// topK は部分木に含まれる上位 k 件の候補を返す
+func (n *node) topK(k int) []*entry {
+	if !n.dirty && n.top != nil {
+		return n.top
+	}
+
+	var cands []*entry
+	if n.entry != nil {
+		cands = append(cands, n.entry)
+	}
+	for _, child := range n.children {
+		cands = append(cands, child.topK(k)...)
+	}
+	sortEntries(cands)
+	if len(cands) > k {
+		cands = cands[:k]
+	}
+
+	n.top, n.dirty = cands, false
+	return n.top
+}
+
+// collect は部分木に含まれるすべての候補を返す
+func (n *node) collect(out []*entry) []*entry {
+	if n.entry != nil {
+		out = append(out, n.entry)
+	}
+	for _, child := range n.children {
+		out = child.collect(out)
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	gc "gopkg.in/check.v1"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(CacheTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type CacheTestSuite struct {
+	backend *memory.InMemoryBleveIndexer
+	idx     *Indexer
+	now     time.Time
+}
+
+func (s *CacheTestSuite) SetUpTest(c *gc.C) {
+	backend, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	s.backend = backend
+	s.newIndexer(Config{})
+}
+
+func (s *CacheTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.backend.Close(), gc.IsNil)
+}
+
+func (s *CacheTestSuite) newIndexer(cfg Config) {
+	s.now = time.Now()
+	s.idx = NewIndexer(s.backend, cfg)
+	s.idx.now = func() time.Time { return s.now }
+}
+
+func (s *CacheTestSuite) TestCachedResults(c *gc.C) {
+	id := s.index(c, "cached result", 0.5)
+
+	q := index.Query{Expression: "cached", Limit: 10, Highlight: &index.HighlightOptions{}}
+	first := s.search(c, q)
+	c.Assert(first, gc.DeepEquals, []uuid.UUID{id})
+
+	// バックエンドを直接変更した場合はキャッシュした結果が返る
+	c.Assert(s.backend.Delete(id), gc.IsNil)
+	it, err := s.idx.Search(q)
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(1))
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Document().LinkID, gc.Equals, id)
+	c.Assert(it.(index.SnippetIterator).Snippet(), gc.Equals, "<mark>cached</mark> result")
+	c.Assert(it.(index.CursorIterator).Cursor(), gc.Not(gc.Equals), "")
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(it.Close(), gc.IsNil)
+
+	st := s.idx.Stats()
+	c.Assert(st.Hits, gc.Equals, uint64(1))
+	c.Assert(st.Misses, gc.Equals, uint64(1))
+	c.Assert(st.Entries, gc.Equals, 1)
+
+	// オフセットが異なるクエリは別にキャッシュされる
+	q.Offset = 1
+	c.Assert(s.search(c, q), gc.HasLen, 0)
+	c.Assert(s.idx.Stats().Entries, gc.Equals, 2)
+}
+
+func (s *CacheTestSuite) TestInvalidation(c *gc.C) {
+	id1 := s.index(c, "invalidate me", 0.9)
+	id2 := s.index(c, "invalidate me too", 0.1)
+	other := s.index(c, "unrelated", 0.5)
+
+	q := index.Query{Expression: "invalidate", Limit: 10}
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id1, id2})
+
+	// 結果に含まれない文書の変更では結果は破棄されない
+	c.Assert(s.idx.UpdateScore(other, 1), gc.IsNil)
+	c.Assert(s.idx.Stats().Entries, gc.Equals, 1)
+
+	c.Assert(s.idx.UpdateScore(id2, 1), gc.IsNil)
+	c.Assert(s.idx.Stats().Entries, gc.Equals, 0)
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id2, id1})
+
+	c.Assert(s.idx.Index(&index.Document{LinkID: id1, Content: "changed"}), gc.IsNil)
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id2})
+
+	n, err := s.idx.DeleteMatching(func(doc *index.Document) bool { return doc.LinkID == id2 })
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	c.Assert(s.search(c, q), gc.HasLen, 0)
+}
+
+func (s *CacheTestSuite) TestWritesDuringSearch(c *gc.C) {
+	id := s.index(c, "in flight", 0.5)
+	other := s.index(c, "unrelated", 0.5)
+
+	var during func()
+	s.idx = NewIndexer(&hookIndexer{Indexer: s.backend, onSearch: func() { during() }}, Config{})
+	q := index.Query{Expression: "flight", Limit: 10}
+
+	// 検索中に結果に含まれない文書が変更されても結果はキャッシュされる
+	during = func() { c.Assert(s.idx.UpdateScore(other, 0.1), gc.IsNil) }
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id})
+	c.Assert(s.idx.Stats().Entries, gc.Equals, 1)
+
+	// 検索中に結果の文書が変更された場合は結果をキャッシュしない
+	s.idx.Purge()
+	during = func() { c.Assert(s.idx.UpdateScore(id, 0.1), gc.IsNil) }
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id})
+	c.Assert(s.idx.Stats().Entries, gc.Equals, 0)
+
+	// 検索の終了後は変更の記録が残らない
+	during = func() {}
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id})
+	c.Assert(s.idx.Stats().Entries, gc.Equals, 1)
+	c.Assert(s.idx.changed, gc.HasLen, 0)
+}
+
+func (s *CacheTestSuite) TestTTL(c *gc.C) {
+	s.newIndexer(Config{TTL: time.Minute})
+	id := s.index(c, "expiring", 0)
+
+	q := index.Query{Expression: "expiring", Limit: 10}
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id})
+	c.Assert(s.backend.Delete(id), gc.IsNil)
+
+	s.now = s.now.Add(30 * time.Second)
+	c.Assert(s.search(c, q), gc.DeepEquals, []uuid.UUID{id})
+
+	s.now = s.now.Add(time.Minute)
+	c.Assert(s.search(c, q), gc.HasLen, 0)
+}
+
+func (s *CacheTestSuite) TestLRUEviction(c *gc.C) {
+	s.index(c, "alpha", 0)
+	s.index(c, "bravo", 0)
+	s.index(c, "delta", 0)
+
+	alpha := index.Query{Expression: "alpha", Limit: 1}
+	bravo := index.Query{Expression: "bravo", Limit: 1}
+	delta := index.Query{Expression: "delta", Limit: 1}
+
+	// 1 件の結果を 2 ページ分だけ保持できるようにする
+	s.search(c, alpha)
+	pageSize := s.idx.Stats().Bytes
+	s.newIndexer(Config{MaxBytes: 2*pageSize + pageSize/2})
+
+	s.search(c, alpha)
+	s.search(c, bravo)
+	s.search(c, alpha)
+	s.search(c, delta)
+
+	st := s.idx.Stats()
+	c.Assert(st.Entries, gc.Equals, 2)
+	c.Assert(st.Evictions, gc.Equals, uint64(1))
+
+	// 最も長く使われていない bravo の結果が破棄される
+	s.search(c, alpha)
+	s.search(c, bravo)
+	st = s.idx.Stats()
+	c.Assert(st.Hits, gc.Equals, uint64(2))
+	c.Assert(st.Misses, gc.Equals, uint64(4))
+}
+
+func (s *CacheTestSuite) TestUncachedQueries(c *gc.C) {
+	s.index(c, "uncached", 0)
+
+	for _, q := range []index.Query{
+		{Expression: "uncached"},
+		{Expression: "uncached", Limit: DefaultMaxPageSize + 1},
+		{Expression: "uncached", Limit: 10, Ranking: &index.DefaultRanking},
+	} {
+		c.Assert(s.search(c, q), gc.HasLen, 1)
+		c.Assert(s.search(c, q), gc.HasLen, 1)
+	}
+
+	st := s.idx.Stats()
+	c.Assert(st.Entries, gc.Equals, 0)
+	c.Assert(st.Hits+st.Misses, gc.Equals, uint64(0))
+}
+
+func (s *CacheTestSuite) index(c *gc.C, content string, score float64) uuid.UUID {
+	id := uuid.New()
+	c.Assert(s.idx.Index(&index.Document{LinkID: id, Content: content}), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(id, score), gc.IsNil)
+	return id
+}
+
+func (s *CacheTestSuite) search(c *gc.C, q index.Query) []uuid.UUID {
+	it, err := s.idx.Search(q)
+	c.Assert(err, gc.IsNil)
+
+	var ids []uuid.UUID
+	for it.Next() {
+		ids = append(ids, it.Document().LinkID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	return ids
+}
+
+// hookIndexer は検索の前に onSearch を呼び出す index.Indexer
+type hookIndexer struct {
+	index.Indexer
+	onSearch func()
+}
+
+func (h *hookIndexer) Search(q index.Query) (index.Iterator, error) {
+	h.onSearch()
+	return h.Indexer.Search(q)
+}
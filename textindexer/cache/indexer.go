@@ -0,0 +1,330 @@
+// Package cache は検索結果のページをメモリに保持し、同じクエリの検索をインデクサに問い合わせずに返す。
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes はキャッシュする結果の合計サイズの既定の上限
+	DefaultMaxBytes = 32 << 20
+
+	// DefaultTTL は結果をキャッシュする既定の期間
+	DefaultTTL = 5 * time.Minute
+
+	// DefaultMaxPageSize はキャッシュする結果のページの既定の最大件数
+	DefaultMaxPageSize = 100
+
+	// entryOverhead は文書 1 件あたりのテキスト以外のメモリ使用量の概算
+	entryOverhead = 256
+)
+
+var (
+	_ index.BatchIndexer = (*Indexer)(nil)
+	_ index.Scanner      = (*Indexer)(nil)
+)
+
+// Config は Indexer の設定を保持する
+type Config struct {
+	// MaxBytes はキャッシュする結果の合計サイズの上限。超えた場合は最も長く使われていない結果から破棄される。
+	// 0 の場合は DefaultMaxBytes が使用される。
+	MaxBytes int64
+
+	// TTL は結果をキャッシュする期間。0 の場合は DefaultTTL が使用される。
+	TTL time.Duration
+
+	// MaxPageSize はキャッシュする検索の Query.Limit の最大値。0 の場合は DefaultMaxPageSize が使用される。
+	MaxPageSize uint64
+}
+
+// Stats はキャッシュの利用状況
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+// Indexer は index.Indexer をラップし、Search の結果のページをキャッシュする。
+// Limit が 1 以上 MaxPageSize 以下で Ranking を指定しない検索のみがキャッシュされる。
+// Index、UpdateScore、Delete で変更された文書を含む結果は破棄されるが、新たにクエリに一致するようになった文書は
+// TTL が経過するまで結果に反映されない。
+type Indexer struct {
+	idx index.Indexer
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// byDoc はリンク ID から、その文書を含む結果のキーへの対応
+	byDoc map[uuid.UUID]map[string]struct{}
+	bytes int64
+	stats Stats
+
+	// gen は結果を破棄するたびに増える。検索中に変更された文書を含む結果をキャッシュしないために使用する。
+	gen uint64
+	// inFlight は実行中の検索の数、changed は検索の実行中に変更された文書と変更時の gen。
+	// changed は実行中の検索がなくなった時点で空にする。
+	inFlight int
+	changed  map[uuid.UUID]uint64
+	// purged は最後に Purge が呼ばれた時点の gen
+	purged uint64
+}
+
+// NewIndexer は idx の検索結果をキャッシュする Indexer を作成する
+func NewIndexer(idx index.Indexer, cfg Config) *Indexer {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.MaxPageSize == 0 {
+		cfg.MaxPageSize = DefaultMaxPageSize
+	}
+
+	return &Indexer{
+		idx:     idx,
+		cfg:     cfg,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		byDoc:   make(map[uuid.UUID]map[string]struct{}),
+		changed: make(map[uuid.UUID]uint64),
+	}
+}
+
+// Search はキャッシュに q の結果があればそれを返し、なければラップしたインデクサで検索して結果をキャッシュする
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	if q.Ranking != nil || q.Limit == 0 || q.Limit > i.cfg.MaxPageSize {
+		return i.idx.Search(q)
+	}
+
+	key := cacheKey(q)
+	if e := i.get(key); e != nil {
+		return &cachedIterator{page: e}, nil
+	}
+
+	i.mu.Lock()
+	gen := i.gen
+	i.inFlight++
+	i.mu.Unlock()
+
+	p, err := i.search(q)
+	i.put(key, p, gen)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedIterator{page: p}, nil
+}
+
+func (i *Indexer) search(q index.Query) (*page, error) {
+	it, err := i.idx.Search(q)
+	if err != nil {
+		return nil, err
+	}
+	return materialize(it)
+}
+
+// cacheKey は検索結果に影響するクエリのフィールドからキーを作成する
+func cacheKey(q index.Query) string {
+	key := fmt.Sprintf("%d\x00%q\x00%d\x00%d\x00%q\x00%q", q.Type, q.Expression, q.Offset, q.Limit, q.Cursor, q.Language)
+	if q.Highlight != nil {
+		key += fmt.Sprintf("\x00%+v", q.Highlight.WithDefaults())
+	}
+	return key
+}
+
+func (i *Indexer) get(key string) *page {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	elem, found := i.entries[key]
+	if !found {
+		i.stats.Misses++
+		return nil
+	}
+	e := elem.Value.(*entry)
+	if i.now().After(e.expires) {
+		i.remove(elem)
+		i.stats.Misses++
+		return nil
+	}
+
+	i.lru.MoveToFront(elem)
+	i.stats.Hits++
+	return e.page
+}
+
+// put は gen の時点で開始した検索の結果 p をキャッシュする。p が nil の場合は検索の終了のみを記録する。
+func (i *Indexer) put(key string, p *page, gen uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	stale := p == nil || p.size > i.cfg.MaxBytes || gen < i.purged || i.changedSince(p, gen)
+	if i.inFlight--; i.inFlight == 0 && len(i.changed) != 0 {
+		i.changed = make(map[uuid.UUID]uint64)
+	}
+	if stale {
+		return
+	}
+	if elem, found := i.entries[key]; found {
+		i.remove(elem)
+	}
+
+	e := &entry{key: key, page: p, expires: i.now().Add(i.cfg.TTL)}
+	i.entries[key] = i.lru.PushFront(e)
+	i.bytes += p.size
+	for _, doc := range p.docs {
+		keys := i.byDoc[doc.LinkID]
+		if keys == nil {
+			keys = make(map[string]struct{})
+			i.byDoc[doc.LinkID] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	for i.bytes > i.cfg.MaxBytes {
+		i.remove(i.lru.Back())
+		i.stats.Evictions++
+	}
+}
+
+// changedSince は gen の時点より後に p のいずれかの文書が変更されたかを返す
+func (i *Indexer) changedSince(p *page, gen uint64) bool {
+	for _, doc := range p.docs {
+		if i.changed[doc.LinkID] > gen {
+			return true
+		}
+	}
+	return false
+}
+
+// remove はキャッシュから結果を取り除く
+func (i *Indexer) remove(elem *list.Element) {
+	e := i.lru.Remove(elem).(*entry)
+	delete(i.entries, e.key)
+	i.bytes -= e.page.size
+	for _, doc := range e.page.docs {
+		if keys := i.byDoc[doc.LinkID]; keys != nil {
+			delete(keys, e.key)
+			if len(keys) == 0 {
+				delete(i.byDoc, doc.LinkID)
+			}
+		}
+	}
+}
+
+// invalidate は linkIDs のいずれかの文書を含む結果を破棄する
+func (i *Indexer) invalidate(linkIDs ...uuid.UUID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.gen++
+	for _, linkID := range linkIDs {
+		// 実行中の検索の結果にこの文書が含まれる場合は、その結果をキャッシュしない
+		if i.inFlight != 0 {
+			i.changed[linkID] = i.gen
+		}
+		for key := range i.byDoc[linkID] {
+			if elem, found := i.entries[key]; found {
+				i.remove(elem)
+			}
+		}
+	}
+}
+
+// Purge はキャッシュしたすべての結果を破棄する
+func (i *Indexer) Purge() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.gen++
+	i.purged = i.gen
+	i.lru.Init()
+	i.entries = make(map[string]*list.Element)
+	i.byDoc = make(map[uuid.UUID]map[string]struct{})
+	i.bytes = 0
+}
+
+// Stats はキャッシュの利用状況を返す
+func (i *Indexer) Stats() Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	st := i.stats
+	st.Entries = len(i.entries)
+	st.Bytes = i.bytes
+	return st
+}
+
+// Index は文書をインデックスに追加し、その文書を含む結果を破棄する
+func (i *Indexer) Index(doc *index.Document) error {
+	defer i.invalidate(doc.LinkID)
+	return i.idx.Index(doc)
+}
+
+// IndexBatch は文書をまとめてインデックスに追加し、それらの文書を含む結果を破棄する
+func (i *Indexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	linkIDs := make([]uuid.UUID, 0, len(docs))
+	for _, doc := range docs {
+		if doc != nil {
+			linkIDs = append(linkIDs, doc.LinkID)
+		}
+	}
+	defer i.invalidate(linkIDs...)
+	return index.IndexBatch(i.idx, docs)
+}
+
+func (i *Indexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.idx.FindByID(linkID)
+}
+
+// UpdateScore は文書の PageRank スコアを更新し、その文書を含む結果を破棄する
+func (i *Indexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	defer i.invalidate(linkID)
+	return i.idx.UpdateScore(linkID, score)
+}
+
+// UpdateScores は PageRank スコアをまとめて更新し、それらの文書を含む結果を破棄する
+func (i *Indexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	linkIDs := make([]uuid.UUID, 0, len(scores))
+	for linkID := range scores {
+		linkIDs = append(linkIDs, linkID)
+	}
+	defer i.invalidate(linkIDs...)
+	return index.UpdateScores(i.idx, scores)
+}
+
+// Delete は文書を削除し、その文書を含む結果を破棄する
+func (i *Indexer) Delete(linkID uuid.UUID) error {
+	defer i.invalidate(linkID)
+	return i.idx.Delete(linkID)
+}
+
+// DeleteMatching は pred が true を返す文書を削除し、それらの文書を含む結果を破棄する
+func (i *Indexer) DeleteMatching(pred index.Predicate) (int, error) {
+	matched, count, err := index.CollectMatching(i.idx, pred)
+	i.invalidate(matched...)
+	return count, err
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.idx, fn)
+}
+
+// entry はキャッシュした結果と有効期限
+type entry struct {
+	key     string
+	page    *page
+	expires time.Time
+}
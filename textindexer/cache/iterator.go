@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+)
+
+// page はイテレータから読み出した検索結果のページ。作成後は変更されない。
+type page struct {
+	docs       []*index.Document
+	snippets   []string
+	cursors    []string
+	relevance  []float64
+	suggestion string
+	total      uint64
+
+	// size はページのメモリ使用量の概算
+	size int64
+}
+
+// materialize はイテレータのすべての文書を読み出してページを作成し、イテレータを閉じる
+func materialize(it index.Iterator) (*page, error) {
+	p := &page{total: it.TotalCount()}
+	if si, ok := it.(index.SuggestionIterator); ok {
+		p.suggestion = si.Suggestion()
+	}
+
+	for it.Next() {
+		doc := copyDoc(it.Document())
+		var snippet, cursor string
+		var relevance float64
+		if si, ok := it.(index.SnippetIterator); ok {
+			snippet = si.Snippet()
+		}
+		if ci, ok := it.(index.CursorIterator); ok {
+			cursor = ci.Cursor()
+		}
+		if si, ok := it.(index.ScoredIterator); ok {
+			relevance = si.Relevance()
+		}
+
+		p.docs = append(p.docs, doc)
+		p.snippets = append(p.snippets, snippet)
+		p.cursors = append(p.cursors, cursor)
+		p.relevance = append(p.relevance, relevance)
+		p.size += int64(entryOverhead + len(doc.URL) + len(doc.Title) + len(doc.Content) + len(snippet) + len(cursor))
+	}
+	if err := it.Error(); err != nil {
+		_ = it.Close()
+		return nil, err
+	}
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func copyDoc(d *index.Document) *index.Document {
+	dcopy := new(index.Document)
+	*dcopy = *d
+	return dcopy
+}
+
+var (
+	_ index.CursorIterator     = (*cachedIterator)(nil)
+	_ index.SnippetIterator    = (*cachedIterator)(nil)
+	_ index.ScoredIterator     = (*cachedIterator)(nil)
+	_ index.SuggestionIterator = (*cachedIterator)(nil)
+)
+
+// cachedIterator はキャッシュしたページの文書を返す index.Iterator の実装
+type cachedIterator struct {
+	page *page
+	cur  int
+}
+
+func (it *cachedIterator) Close() error {
+	it.cur = len(it.page.docs) + 1
+	return nil
+}
+
+func (it *cachedIterator) Next() bool {
+	if it.cur >= len(it.page.docs) {
+		return false
+	}
+	it.cur++
+	return true
+}
+
+func (it *cachedIterator) Error() error {
+	return nil
+}
+
+// Document は現在の文書の複製を返す。キャッシュした文書は呼び出し元に変更されない。
+func (it *cachedIterator) Document() *index.Document {
+	if !it.latched() {
+		return nil
+	}
+	return copyDoc(it.page.docs[it.cur-1])
+}
+
+func (it *cachedIterator) Snippet() string {
+	if !it.latched() {
+		return ""
+	}
+	return it.page.snippets[it.cur-1]
+}
+
+func (it *cachedIterator) Cursor() string {
+	if !it.latched() {
+		return ""
+	}
+	return it.page.cursors[it.cur-1]
+}
+
+func (it *cachedIterator) Relevance() float64 {
+	if !it.latched() {
+		return 0
+	}
+	return it.page.relevance[it.cur-1]
+}
+
+func (it *cachedIterator) Suggestion() string {
+	return it.page.suggestion
+}
+
+func (it *cachedIterator) TotalCount() uint64 {
+	return it.page.total
+}
+
+func (it *cachedIterator) latched() bool {
+	return it.cur > 0 && it.cur <= len(it.page.docs)
+}
@@ -0,0 +1,241 @@
+// Package consistency はリンクグラフとテキストインデックスの間の不整合を検出し、必要に応じて修復する。
+package consistency
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/reindex"
+	"golang.org/x/xerrors"
+	"sort"
+	"time"
+)
+
+// maxUUID は走査するリンクの ID の上限。graph.Graph.Links の上限は排他的なため、この ID のリンクは FindLink で個別に照合する。
+var maxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+
+// Kind は不整合の種類
+type Kind uint8
+
+const (
+	// KindOrphanDocument はリンクグラフに存在しないリンクの文書。修復では文書を削除する。
+	KindOrphanDocument Kind = iota
+
+	// KindMissingDocument は取得済みのリンクのうち、インデックスに文書がないもの。修復では内容を取得して追加する。
+	KindMissingDocument
+
+	// KindURLMismatch は文書の URL がリンクの URL と異なるもの。修復ではリンクの URL の内容を取得して置き換える。
+	KindURLMismatch
+
+	// KindPlaceholder は UpdateScore によって作成され、内容が追加されていない文書。
+	// リンクが取得済みの場合のみ、修復で内容を取得して追加する。
+	KindPlaceholder
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindOrphanDocument:
+		return "orphan document"
+	case KindMissingDocument:
+		return "missing document"
+	case KindURLMismatch:
+		return "URL mismatch"
+	case KindPlaceholder:
+		return "placeholder document"
+	default:
+		return "unknown"
+	}
+}
+
+// Issue は検出された 1 件の不整合
+type Issue struct {
+	Kind   Kind
+	LinkID uuid.UUID
+
+	// LinkURL はリンクグラフのリンクの URL。KindOrphanDocument では空文字列。
+	LinkURL string
+
+	// DocURL はインデックスの文書の URL。KindMissingDocument では空文字列。
+	DocURL string
+
+	// Repaired は修復に成功したかを表す
+	Repaired bool
+
+	// RepairErr は修復に失敗した場合のエラー
+	RepairErr error
+}
+
+// Report は検査の結果
+type Report struct {
+	Links     int
+	Documents int
+
+	// Issues は検出された不整合。リンク ID の順に並ぶ。
+	Issues []Issue
+
+	Repaired int
+}
+
+// Count は kind の不整合の数を返す
+func (r *Report) Count(kind Kind) int {
+	var n int
+	for _, issue := range r.Issues {
+		if issue.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Config は Checker の設定を保持する
+type Config struct {
+	// Graph は検査するリンクグラフ (必須)
+	Graph graph.Graph
+
+	// Indexer は検査するインデックス (必須)
+	Indexer index.Indexer
+
+	// Repair が true の場合、検出した不整合を修復する
+	Repair bool
+
+	// Source は修復で文書を追加するための内容を取得する。nil の場合、文書の追加が必要な不整合は修復されない。
+	Source reindex.ContentSource
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Graph == nil {
+		return xerrors.New("consistency: graph not specified")
+	}
+	if cfg.Indexer == nil {
+		return xerrors.New("consistency: indexer not specified")
+	}
+	return nil
+}
+
+// Checker はリンクグラフとインデックスを走査して不整合を検出する
+type Checker struct {
+	cfg Config
+}
+
+// New は cfg の Checker を作成する
+func New(cfg Config) (*Checker, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Checker{cfg: cfg}, nil
+}
+
+// docInfo は照合に必要な文書の情報
+type docInfo struct {
+	url         string
+	placeholder bool
+}
+
+// Check はインデックスのすべての文書を走査してからリンクグラフのすべてのリンクと照合する。
+// 照合のためにすべての文書のリンク ID と URL をメモリに保持する。
+func (c *Checker) Check(ctx context.Context) (*Report, error) {
+	var (
+		report Report
+		docs   = make(map[uuid.UUID]docInfo)
+	)
+	err := index.Scan(ctx, c.cfg.Indexer, func(doc *index.Document) error {
+		docs[doc.LinkID] = docInfo{url: doc.URL, placeholder: doc.IndexedAt.IsZero()}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("check: scan index: %w", err)
+	}
+	report.Documents = len(docs)
+
+	var links []*graph.Link
+	match := func(link *graph.Link) {
+		report.Links++
+
+		doc, indexed := docs[link.ID]
+		delete(docs, link.ID)
+		switch {
+		case !indexed && !link.RetrievedAt.IsZero():
+			report.Issues = append(report.Issues, Issue{Kind: KindMissingDocument, LinkID: link.ID, LinkURL: link.URL})
+		case indexed && doc.placeholder:
+			report.Issues = append(report.Issues, Issue{Kind: KindPlaceholder, LinkID: link.ID, LinkURL: link.URL, DocURL: doc.url})
+		case indexed && doc.url != link.URL:
+			report.Issues = append(report.Issues, Issue{Kind: KindURLMismatch, LinkID: link.ID, LinkURL: link.URL, DocURL: doc.url})
+		default:
+			return
+		}
+		links = append(links, link)
+	}
+
+	it, err := c.cfg.Graph.Links(uuid.Nil, maxUUID, time.Now())
+	if err != nil {
+		return nil, xerrors.Errorf("check: scan graph: %w", err)
+	}
+	for it.Next() {
+		match(it.Link())
+	}
+	if err := it.Error(); err != nil {
+		_ = it.Close()
+		return nil, xerrors.Errorf("check: scan graph: %w", err)
+	}
+	if err := it.Close(); err != nil {
+		return nil, xerrors.Errorf("check: scan graph: %w", err)
+	}
+	if link, err := c.cfg.Graph.FindLink(maxUUID); err == nil {
+		match(link)
+	} else if !xerrors.Is(err, graph.ErrNotFound) {
+		return nil, xerrors.Errorf("check: scan graph: %w", err)
+	}
+
+	// 照合されずに残った文書にはリンクが存在しない
+	for linkID, doc := range docs {
+		report.Issues = append(report.Issues, Issue{Kind: KindOrphanDocument, LinkID: linkID, DocURL: doc.url})
+	}
+	sort.Slice(report.Issues, func(a, b int) bool {
+		return report.Issues[a].LinkID.String() < report.Issues[b].LinkID.String()
+	})
+
+	if c.cfg.Repair {
+		linksByID := make(map[uuid.UUID]*graph.Link, len(links))
+		for _, link := range links {
+			linksByID[link.ID] = link
+		}
+		for n := range report.Issues {
+			if err := ctx.Err(); err != nil {
+				return &report, xerrors.Errorf("check: %w", err)
+			}
+			issue := &report.Issues[n]
+			if issue.Repaired, issue.RepairErr = c.repair(ctx, issue, linksByID[issue.LinkID]); issue.Repaired {
+				report.Repaired++
+			}
+		}
+	}
+	return &report, nil
+}
+
+// repair は issue を修復し、修復したかを返す。修復の方法がない不整合は修復せずにエラーも返さない。
+func (c *Checker) repair(ctx context.Context, issue *Issue, link *graph.Link) (bool, error) {
+	switch {
+	case issue.Kind == KindOrphanDocument:
+		if err := c.cfg.Indexer.Delete(issue.LinkID); err != nil && !xerrors.Is(err, index.ErrNotFound) {
+			return false, err
+		}
+		return true, nil
+	case c.cfg.Source == nil:
+		return false, nil
+	case issue.Kind == KindPlaceholder && link.RetrievedAt.IsZero():
+		// まだ取得されていないリンクの PageRank スコアを保持する文書は、クロールされるまで内容がない
+		return false, nil
+	}
+
+	page, err := c.cfg.Source.Content(ctx, link)
+	if err != nil {
+		return false, err
+	}
+	// Index は既存の文書の PageRank スコアを保持する
+	doc := &index.Document{LinkID: link.ID, URL: link.URL, Title: page.Title, Content: page.Content}
+	if err := c.cfg.Indexer.Index(doc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,204 @@
+package consistency
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	graphmemory "github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/store/memory"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/reindex"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(ConsistencyTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type ConsistencyTestSuite struct {
+	graph *graphmemory.InMemoryGraph
+	idx   *memory.InMemoryBleveIndexer
+
+	consistent, missing, mismatched, uncrawled, placeholder *graph.Link
+	orphan, orphanPlaceholder                               uuid.UUID
+}
+
+func (s *ConsistencyTestSuite) SetUpTest(c *gc.C) {
+	s.graph = graphmemory.NewInMemoryGraph()
+	idx, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	s.idx = idx
+
+	retrieved := time.Now().Add(-time.Hour)
+	s.consistent = s.link(c, "http://example.com/consistent", retrieved)
+	s.missing = s.link(c, "http://example.com/missing", retrieved)
+	s.mismatched = s.link(c, "http://example.com/mismatched", retrieved)
+	s.uncrawled = s.link(c, "http://example.com/uncrawled", time.Time{})
+	s.placeholder = s.link(c, "http://example.com/placeholder", retrieved)
+	s.orphan, s.orphanPlaceholder = uuid.New(), uuid.New()
+
+	c.Assert(s.idx.Index(&index.Document{LinkID: s.consistent.ID, URL: s.consistent.URL, Content: "ok"}), gc.IsNil)
+	c.Assert(s.idx.Index(&index.Document{LinkID: s.mismatched.ID, URL: "http://example.com/old", Content: "old"}), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(s.mismatched.ID, 0.5), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(s.uncrawled.ID, 0.1), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(s.placeholder.ID, 0.2), gc.IsNil)
+	c.Assert(s.idx.Index(&index.Document{LinkID: s.orphan, URL: "http://example.com/orphan", Content: "orphan"}), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(s.orphanPlaceholder, 0.3), gc.IsNil)
+}
+
+func (s *ConsistencyTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.idx.Close(), gc.IsNil)
+}
+
+func (s *ConsistencyTestSuite) link(c *gc.C, url string, retrievedAt time.Time) *graph.Link {
+	link := &graph.Link{URL: url, RetrievedAt: retrievedAt}
+	c.Assert(s.graph.UpsertLink(link), gc.IsNil)
+	return link
+}
+
+func (s *ConsistencyTestSuite) TestCheck(c *gc.C) {
+	checker, err := New(Config{Graph: s.graph, Indexer: s.idx})
+	c.Assert(err, gc.IsNil)
+
+	report, err := checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Links, gc.Equals, 5)
+	c.Assert(report.Documents, gc.Equals, 6)
+	c.Assert(report.Repaired, gc.Equals, 0)
+
+	c.Assert(issuesByID(report), gc.DeepEquals, map[uuid.UUID]Kind{
+		s.missing.ID:        KindMissingDocument,
+		s.mismatched.ID:     KindURLMismatch,
+		s.uncrawled.ID:      KindPlaceholder,
+		s.placeholder.ID:    KindPlaceholder,
+		s.orphan:            KindOrphanDocument,
+		s.orphanPlaceholder: KindOrphanDocument,
+	})
+	c.Assert(report.Count(KindPlaceholder), gc.Equals, 2)
+
+	for _, issue := range report.Issues {
+		if issue.Kind == KindURLMismatch {
+			c.Assert(issue.LinkURL, gc.Equals, s.mismatched.URL)
+			c.Assert(issue.DocURL, gc.Equals, "http://example.com/old")
+		}
+	}
+
+	// 修復しない場合はインデックスを変更しない
+	_, err = s.idx.FindByID(s.orphan)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *ConsistencyTestSuite) TestRepair(c *gc.C) {
+	source := reindex.ContentSourceFunc(func(_ context.Context, link *graph.Link) (*reindex.Page, error) {
+		return &reindex.Page{Title: "refetched", Content: "content of " + link.URL}, nil
+	})
+	checker, err := New(Config{Graph: s.graph, Indexer: s.idx, Repair: true, Source: source})
+	c.Assert(err, gc.IsNil)
+
+	report, err := checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Issues, gc.HasLen, 6)
+
+	// 取得されていないリンクのプレースホルダ以外は修復される
+	c.Assert(report.Repaired, gc.Equals, 5)
+	for _, issue := range report.Issues {
+		c.Assert(issue.RepairErr, gc.IsNil)
+		c.Assert(issue.Repaired, gc.Equals, issue.LinkID != s.uncrawled.ID)
+	}
+
+	for _, id := range []uuid.UUID{s.orphan, s.orphanPlaceholder} {
+		_, err := s.idx.FindByID(id)
+		c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+	}
+	for _, link := range []*graph.Link{s.missing, s.mismatched, s.placeholder} {
+		doc, err := s.idx.FindByID(link.ID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(doc.URL, gc.Equals, link.URL)
+		c.Assert(doc.Content, gc.Equals, "content of "+link.URL)
+	}
+
+	// 修復した文書の PageRank スコアは保持される
+	doc, err := s.idx.FindByID(s.mismatched.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.PageRank, gc.Equals, 0.5)
+
+	report, err = checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(issuesByID(report), gc.DeepEquals, map[uuid.UUID]Kind{s.uncrawled.ID: KindPlaceholder})
+}
+
+func (s *ConsistencyTestSuite) TestRepairWithoutSource(c *gc.C) {
+	checker, err := New(Config{Graph: s.graph, Indexer: s.idx, Repair: true})
+	c.Assert(err, gc.IsNil)
+
+	report, err := checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+
+	// 内容を取得できないため、削除のみが行われる
+	c.Assert(report.Repaired, gc.Equals, 2)
+	report, err = checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Count(KindOrphanDocument), gc.Equals, 0)
+	c.Assert(report.Issues, gc.HasLen, 4)
+}
+
+func (s *ConsistencyTestSuite) TestRepairErrors(c *gc.C) {
+	source := reindex.ContentSourceFunc(func(_ context.Context, link *graph.Link) (*reindex.Page, error) {
+		return nil, xerrors.Errorf("gone: %w", reindex.ErrSkip)
+	})
+	checker, err := New(Config{Graph: s.graph, Indexer: s.idx, Repair: true, Source: source})
+	c.Assert(err, gc.IsNil)
+
+	report, err := checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+	for _, issue := range report.Issues {
+		if issue.Kind == KindMissingDocument {
+			c.Assert(issue.Repaired, gc.Equals, false)
+			c.Assert(xerrors.Is(issue.RepairErr, reindex.ErrSkip), gc.Equals, true)
+		}
+	}
+}
+
+func (s *ConsistencyTestSuite) TestMaxUUIDLink(c *gc.C) {
+	last := &graph.Link{ID: maxUUID, URL: "http://example.com/last", RetrievedAt: time.Now().Add(-time.Hour)}
+	source := reindex.ContentSourceFunc(func(_ context.Context, link *graph.Link) (*reindex.Page, error) {
+		return &reindex.Page{Content: "content of " + link.URL}, nil
+	})
+	checker, err := New(Config{Graph: &maxIDGraph{InMemoryGraph: s.graph, link: last}, Indexer: s.idx, Repair: true, Source: source})
+	c.Assert(err, gc.IsNil)
+
+	// Links の範囲に含まれない ID のリンクも照合される
+	report, err := checker.Check(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Links, gc.Equals, 6)
+	c.Assert(issuesByID(report)[maxUUID], gc.Equals, KindMissingDocument)
+
+	doc, err := s.idx.FindByID(maxUUID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.URL, gc.Equals, last.URL)
+}
+
+// maxIDGraph は ID が UUID の最大値のリンクを追加したリンクグラフ。InMemoryGraph はリンクの ID を無作為に割り当てる。
+type maxIDGraph struct {
+	*graphmemory.InMemoryGraph
+	link *graph.Link
+}
+
+func (g *maxIDGraph) FindLink(id uuid.UUID) (*graph.Link, error) {
+	if id == g.link.ID {
+		lCopy := *g.link
+		return &lCopy, nil
+	}
+	return g.InMemoryGraph.FindLink(id)
+}
+
+func issuesByID(report *Report) map[uuid.UUID]Kind {
+	issues := make(map[uuid.UUID]Kind)
+	for _, issue := range report.Issues {
+		issues[issue.LinkID] = issue.Kind
+	}
+	return issues
+}
@@ -0,0 +1,288 @@
+package dedup
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var _ = gc.Suite(new(DedupTestSuite))
+
+// testMaxDistance は短いテスト用の文書でもほぼ同一と判定できるように、既定値より大きく取ったハミング距離
+const testMaxDistance = 8
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type DedupTestSuite struct{}
+
+const (
+	article = `Go is an open source programming language supported by Google. It is easy to learn and
+get started with, has built-in concurrency and a robust standard library, and a growing ecosystem of
+partners, communities and tools. Go makes it simple to build secure, scalable systems. Companies of
+every size use Go to power their cloud services, command line tools and web applications. The language
+was designed at Google to address criticism of other languages while keeping their useful characteristics:
+static typing and run-time efficiency, readability and usability, and high-performance networking and
+multiprocessing. Its compiler produces statically linked native binaries without external dependencies,
+and its toolchain includes a formatter, a test runner, a race detector and a module system.`
+
+	// articlePrint は印刷用ページのように末尾に少しだけ文字列が追加された article
+	articlePrint = article + " Print this page."
+
+	unrelated = `The quick brown fox jumps over the lazy dog while the cat sleeps on the warm windowsill,
+ignoring the birds singing in the garden and the rain drumming on the roof of the old barn.`
+)
+
+func (s *DedupTestSuite) TestFingerprintDistance(c *gc.C) {
+	fp := Fingerprint(article)
+	c.Assert(Distance(fp, Fingerprint(article)), gc.Equals, 0)
+	c.Assert(Distance(fp, Fingerprint(strings.ToUpper(article))), gc.Equals, 0)
+	c.Assert(Distance(fp, Fingerprint(articlePrint)) <= testMaxDistance, gc.Equals, true)
+	c.Assert(Distance(fp, Fingerprint(unrelated)) > 2*testMaxDistance, gc.Equals, true)
+	c.Assert(Fingerprint(""), gc.Equals, uint64(0))
+}
+
+func (s *DedupTestSuite) TestFingerprintIndexLookup(c *gc.C) {
+	fi := NewFingerprintIndex(3)
+	id1, id2, id3 := uuid.New(), uuid.New(), uuid.New()
+
+	fi.Add(id1, 0xffff0000ffff0000)
+	fi.Add(id2, 0xffff0000ffff0007) // 距離 3
+	fi.Add(id3, 0x0000ffff0000ffff)
+
+	matches := fi.Lookup(0xffff0000ffff0000)
+	c.Assert(matches, gc.DeepEquals, []Match{
+		{LinkID: id1, Fingerprint: 0xffff0000ffff0000, Distance: 0},
+		{LinkID: id2, Fingerprint: 0xffff0000ffff0007, Distance: 3},
+	})
+
+	fi.Remove(id1)
+	c.Assert(fi.Lookup(0xffff0000ffff0000), gc.HasLen, 1)
+
+	// 同じ ID で再登録すると古いフィンガープリントは検索対象から外れる
+	fi.Add(id2, 0x0000ffff0000fff0)
+	c.Assert(fi.Lookup(0xffff0000ffff0000), gc.HasLen, 0)
+}
+
+func (s *DedupTestSuite) TestMarkDuplicates(c *gc.C) {
+	store := newStubIndexer()
+	idx := NewIndexer(store, Config{Mode: ModeMark, MaxDistance: testMaxDistance})
+
+	orig := &index.Document{LinkID: uuid.New(), URL: "https://example.com/", Content: article}
+	dup := &index.Document{LinkID: uuid.New(), URL: "https://example.com/?print=1", Content: articlePrint}
+	other := &index.Document{LinkID: uuid.New(), URL: "https://example.com/fox", Content: unrelated}
+	for _, doc := range []*index.Document{orig, dup, other} {
+		c.Assert(idx.Index(doc), gc.IsNil)
+	}
+
+	got, err := idx.FindByID(dup.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.DuplicateOf, gc.Equals, orig.LinkID)
+	c.Assert(got.Fingerprint, gc.Equals, Fingerprint(articlePrint))
+
+	got, err = idx.FindByID(other.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.DuplicateOf, gc.Equals, uuid.Nil)
+
+	// 再インデックスしても自分自身とは重複と判定されない
+	c.Assert(idx.Index(orig), gc.IsNil)
+	c.Assert(orig.DuplicateOf, gc.Equals, uuid.Nil)
+}
+
+func (s *DedupTestSuite) TestSkipDuplicates(c *gc.C) {
+	store := newStubIndexer()
+	idx := NewIndexer(store, Config{Mode: ModeSkip, MaxDistance: testMaxDistance})
+
+	c.Assert(idx.Index(&index.Document{LinkID: uuid.New(), Content: article}), gc.IsNil)
+
+	dupID := uuid.New()
+	err := idx.Index(&index.Document{LinkID: dupID, Content: articlePrint})
+	c.Assert(xerrors.Is(err, ErrDuplicate), gc.Equals, true)
+
+	_, err = idx.FindByID(dupID)
+	c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+}
+
+func (s *DedupTestSuite) TestConcurrentDuplicates(c *gc.C) {
+	store, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = store.Close() }()
+	idx := NewIndexer(store, Config{Mode: ModeSkip, MaxDistance: testMaxDistance})
+
+	const workers = 8
+	var (
+		wg      sync.WaitGroup
+		start   = make(chan struct{})
+		errCh   = make(chan error, workers)
+		content = []string{article, articlePrint}
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			<-start
+			errCh <- idx.Index(&index.Document{LinkID: uuid.New(), Content: content[w%2]})
+		}(w)
+	}
+	close(start)
+	wg.Wait()
+	close(errCh)
+
+	// 並行して追加されても、重複していないと判定される文書は 1 件のみ
+	var indexed int
+	for err := range errCh {
+		if err == nil {
+			indexed++
+			continue
+		}
+		c.Assert(xerrors.Is(err, ErrDuplicate), gc.Equals, true)
+	}
+	c.Assert(indexed, gc.Equals, 1)
+}
+
+func (s *DedupTestSuite) TestIndexBatchSkipsDuplicatesWithinBatch(c *gc.C) {
+	store := newStubIndexer()
+	idx := NewIndexer(store, Config{Mode: ModeSkip, MaxDistance: testMaxDistance})
+
+	docs := []*index.Document{
+		{LinkID: uuid.New(), Content: article},
+		{LinkID: uuid.New(), Content: unrelated},
+		{LinkID: uuid.New(), Content: articlePrint},
+		{Content: article},
+	}
+	res, err := idx.IndexBatch(docs)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Processed, gc.Equals, 2)
+	c.Assert(res.Errors, gc.HasLen, 2)
+	c.Assert(res.Errors[0].Pos, gc.Equals, 2)
+	c.Assert(xerrors.Is(res.Errors[0], ErrDuplicate), gc.Equals, true)
+	c.Assert(res.Errors[1].Pos, gc.Equals, 3)
+	c.Assert(xerrors.Is(res.Errors[1], index.ErrMissingLinkID), gc.Equals, true)
+
+	c.Assert(store.docs, gc.HasLen, 2)
+	c.Assert(idx.FindDuplicates(Fingerprint(articlePrint)), gc.HasLen, 1)
+}
+
+func (s *DedupTestSuite) TestDeleteForgetsFingerprint(c *gc.C) {
+	store := newStubIndexer()
+	idx := NewIndexer(store, Config{Mode: ModeSkip, MaxDistance: testMaxDistance})
+
+	origID := uuid.New()
+	c.Assert(idx.Index(&index.Document{LinkID: origID, Content: article}), gc.IsNil)
+	c.Assert(idx.Delete(origID), gc.IsNil)
+
+	// 削除された文書とほぼ同一の文書は重複とみなされない
+	printID := uuid.New()
+	c.Assert(idx.Index(&index.Document{LinkID: printID, Content: articlePrint}), gc.IsNil)
+
+	n, err := idx.DeleteMatching(func(doc *index.Document) bool { return doc.LinkID == printID })
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	c.Assert(idx.FindDuplicates(Fingerprint(article)), gc.HasLen, 0)
+
+	err = idx.Delete(origID)
+	c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+}
+
+func (s *DedupTestSuite) TestCollapseSearchResults(c *gc.C) {
+	store := newStubIndexer()
+	idx := NewIndexer(store, Config{Mode: ModeMark, MaxDistance: testMaxDistance, Collapse: true})
+
+	for _, content := range []string{article, articlePrint, unrelated} {
+		c.Assert(idx.Index(&index.Document{LinkID: uuid.New(), Content: content}), gc.IsNil)
+	}
+
+	it, err := idx.Search(index.Query{Expression: "and"})
+	c.Assert(err, gc.IsNil)
+
+	var count int
+	for it.Next() {
+		count++
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(count, gc.Equals, 2)
+}
+
+// stubIndexer はテスト用に文書をマップに保持し、式を含むすべての文書を返す index.Indexer
+type stubIndexer struct {
+	docs map[uuid.UUID]*index.Document
+}
+
+func newStubIndexer() *stubIndexer {
+	return &stubIndexer{docs: make(map[uuid.UUID]*index.Document)}
+}
+
+func (s *stubIndexer) Index(doc *index.Document) error {
+	dcopy := *doc
+	s.docs[doc.LinkID] = &dcopy
+	return nil
+}
+
+func (s *stubIndexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	doc, found := s.docs[linkID]
+	if !found {
+		return nil, xerrors.Errorf("find by ID: %w", index.ErrNotFound)
+	}
+	dcopy := *doc
+	return &dcopy, nil
+}
+
+func (s *stubIndexer) Search(q index.Query) (index.Iterator, error) {
+	var docs []*index.Document
+	for _, doc := range s.docs {
+		if strings.Contains(doc.Content, q.Expression) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].LinkID.String() < docs[j].LinkID.String() })
+	return &stubIterator{docs: docs}, nil
+}
+
+func (s *stubIndexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	if doc, found := s.docs[linkID]; found {
+		doc.PageRank = score
+	}
+	return nil
+}
+
+func (s *stubIndexer) Delete(linkID uuid.UUID) error {
+	if _, found := s.docs[linkID]; !found {
+		return xerrors.Errorf("delete: %w", index.ErrNotFound)
+	}
+	delete(s.docs, linkID)
+	return nil
+}
+
+func (s *stubIndexer) DeleteMatching(pred index.Predicate) (int, error) {
+	var count int
+	for id, doc := range s.docs {
+		if pred(doc) {
+			delete(s.docs, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+type stubIterator struct {
+	docs []*index.Document
+	cur  int
+}
+
+func (it *stubIterator) Close() error { return nil }
+func (it *stubIterator) Next() bool {
+	if it.cur >= len(it.docs) {
+		return false
+	}
+	it.cur++
+	return true
+}
+func (it *stubIterator) Error() error              { return nil }
+func (it *stubIterator) Document() *index.Document { return it.docs[it.cur-1] }
+func (it *stubIterator) TotalCount() uint64        { return uint64(len(it.docs)) }
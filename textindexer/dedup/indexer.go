@@ -0,0 +1,281 @@
+package dedup
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxDistance は 64 ビットの SimHash でほぼ同一とみなす一般的なハミング距離
+const DefaultMaxDistance = 3
+
+// ErrDuplicate は ModeSkip の Indexer が既存の文書とほぼ同一の文書を受け取った場合に返される
+var ErrDuplicate = xerrors.New("document is a near-duplicate of an indexed document")
+
+var (
+	_ index.BatchIndexer = (*Indexer)(nil)
+	_ index.Scanner      = (*Indexer)(nil)
+)
+
+// Mode はほぼ同一の文書を検出したときの Indexer の動作を指定する
+type Mode uint8
+
+const (
+	// ModeMark は文書の DuplicateOf に既存文書のリンク ID を設定したうえでインデックスに追加する
+	ModeMark Mode = iota
+
+	// ModeSkip は文書をインデックスに追加せずに ErrDuplicate を返す
+	ModeSkip
+)
+
+// Config は Indexer の設定を保持する
+type Config struct {
+	Mode Mode
+
+	// MaxDistance はほぼ同一とみなすハミング距離の最大値。0 の場合は DefaultMaxDistance が使用される。
+	MaxDistance int
+
+	// Collapse が true の場合、Search は既に返した文書とほぼ同一の文書を結果から除外する
+	Collapse bool
+}
+
+// Indexer は index.Indexer をラップし、インデックスに追加される文書のフィンガープリントを計算して
+// ほぼ同一の文書をマークまたは除外する。
+//
+// フィンガープリントの登録はメモリ上にのみ保持される。プロセスの再起動後は、既存の文書を Scan で
+// 読み込んで Track で登録し直すまで、それらの文書との重複は検出されない。
+type Indexer struct {
+	idx      index.Indexer
+	fi       *FingerprintIndex
+	mode     Mode
+	collapse bool
+
+	// mu は重複の判定から登録までを直列化し、並行して追加されたほぼ同一の文書が
+	// どちらも重複していないと判定されるのを防ぐ
+	mu sync.Mutex
+}
+
+// NewIndexer は idx をラップする Indexer を作成する
+func NewIndexer(idx index.Indexer, cfg Config) *Indexer {
+	if cfg.MaxDistance <= 0 {
+		cfg.MaxDistance = DefaultMaxDistance
+	}
+
+	return &Indexer{
+		idx:      idx,
+		fi:       NewFingerprintIndex(cfg.MaxDistance),
+		mode:     cfg.Mode,
+		collapse: cfg.Collapse,
+	}
+}
+
+// Index は文書のフィンガープリントを計算し、既存の文書とほぼ同一かどうかを判定してからインデックスに追加する
+func (i *Indexer) Index(doc *index.Document) error {
+	if doc.LinkID == uuid.Nil {
+		return xerrors.Errorf("index: %w", index.ErrMissingLinkID)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.classify(doc); err != nil {
+		return xerrors.Errorf("index: %w", err)
+	}
+
+	if err := i.idx.Index(doc); err != nil {
+		return err
+	}
+	i.track(doc)
+	return nil
+}
+
+// IndexBatch は各文書を Index と同様に判定したうえで、ラップしたインデクサにまとめて追加する。
+// 同じバッチ内の先行する文書とほぼ同一の文書も重複として扱われる。
+// ModeSkip で除外された文書は ErrDuplicate として BatchResult.Errors に報告される。
+func (i *Indexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	var (
+		res       index.BatchResult
+		start     = time.Now()
+		accepted  []*index.Document
+		positions []int
+	)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for pos, doc := range docs {
+		if doc == nil || doc.LinkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, Err: index.ErrMissingLinkID})
+			continue
+		}
+		if err := i.classify(doc); err != nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, LinkID: doc.LinkID, Err: err})
+			continue
+		}
+
+		// 後続の文書の判定に使用できるように、追加前にフィンガープリントを登録する
+		i.track(doc)
+		accepted = append(accepted, doc)
+		positions = append(positions, pos)
+	}
+
+	inner, err := index.IndexBatch(i.idx, accepted)
+	if err != nil {
+		for _, doc := range accepted {
+			i.fi.Remove(doc.LinkID)
+		}
+		return index.BatchResult{}, err
+	}
+
+	for _, docErr := range inner.Errors {
+		i.fi.Remove(docErr.LinkID)
+		docErr.Pos = positions[docErr.Pos]
+		res.Errors = append(res.Errors, docErr)
+	}
+	sort.Slice(res.Errors, func(a, b int) bool { return res.Errors[a].Pos < res.Errors[b].Pos })
+
+	res.Processed = inner.Processed
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// classify は文書のフィンガープリントを計算し、既存の文書とほぼ同一の場合は DuplicateOf を設定する。
+// ModeSkip の場合は ErrDuplicate を返す。
+func (i *Indexer) classify(doc *index.Document) error {
+	doc.Fingerprint = Fingerprint(doc.Content)
+	doc.DuplicateOf = uuid.Nil
+	if doc.Fingerprint == 0 {
+		return nil
+	}
+
+	if orig, found := i.original(doc.LinkID, doc.Fingerprint); found {
+		if i.mode == ModeSkip {
+			return xerrors.Errorf("%s: %w", doc.URL, ErrDuplicate)
+		}
+		doc.DuplicateOf = orig
+	}
+	return nil
+}
+
+// track は重複の検出に使用するフィンガープリントの登録を更新する。
+// 重複の検出には重複していない文書のフィンガープリントのみを使用する。
+func (i *Indexer) track(doc *index.Document) {
+	if doc.DuplicateOf == uuid.Nil && doc.Fingerprint != 0 {
+		i.fi.Add(doc.LinkID, doc.Fingerprint)
+	} else {
+		i.fi.Remove(doc.LinkID)
+	}
+}
+
+// original は fp に最も近い、linkID 以外の文書のリンク ID を返す
+func (i *Indexer) original(linkID uuid.UUID, fp uint64) (uuid.UUID, bool) {
+	for _, m := range i.fi.Lookup(fp) {
+		if m.LinkID != linkID {
+			return m.LinkID, true
+		}
+	}
+	return uuid.Nil, false
+}
+
+// Track は既にインデックスに存在する文書のフィンガープリントを重複の検出対象として登録する。
+// プロセスの再起動後に既存の文書を読み込み直す場合に使用する。
+func (i *Indexer) Track(doc *index.Document) {
+	if doc.DuplicateOf != uuid.Nil || doc.Fingerprint == 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fi.Add(doc.LinkID, doc.Fingerprint)
+}
+
+// FindDuplicates は fp とのハミング距離が MaxDistance 以内の文書を距離の近い順に返す
+func (i *Indexer) FindDuplicates(fp uint64) []Match {
+	return i.fi.Lookup(fp)
+}
+
+func (i *Indexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.idx.FindByID(linkID)
+}
+
+// Search はラップしたインデクサで検索を行う。Collapse が有効な場合、結果のイテレータは
+// 既に返した文書とほぼ同一の文書を読み飛ばす。その場合 TotalCount は重複を含む件数の上限となる。
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	it, err := i.idx.Search(q)
+	if err != nil || !i.collapse {
+		return it, err
+	}
+
+	return &collapsingIterator{IteratorWrapper: index.IteratorWrapper{Iterator: it}, maxDistance: i.fi.MaxDistance()}, nil
+}
+
+func (i *Indexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	return i.idx.UpdateScore(linkID, score)
+}
+
+func (i *Indexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	return index.UpdateScores(i.idx, scores)
+}
+
+// Delete は文書を削除し、そのフィンガープリントを重複の検出対象から除外する
+func (i *Indexer) Delete(linkID uuid.UUID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.idx.Delete(linkID); err != nil {
+		return err
+	}
+	i.fi.Remove(linkID)
+	return nil
+}
+
+// DeleteMatching は pred が true を返す文書を削除し、それらのフィンガープリントを重複の検出対象から除外する
+func (i *Indexer) DeleteMatching(pred index.Predicate) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	matched, count, err := index.CollectMatching(i.idx, pred)
+
+	// 削除に失敗した文書が残っていても、重複の検出から外れるだけで不整合は生じない
+	for _, id := range matched {
+		i.fi.Remove(id)
+	}
+	return count, err
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.idx, fn)
+}
+
+// collapsingIterator は既に返した文書とほぼ同一の文書を読み飛ばす index.Iterator。
+// カーソルで再開した次のページでは、既に返した文書との重複は判定されない。
+type collapsingIterator struct {
+	index.IteratorWrapper
+
+	maxDistance int
+	seen        []uint64
+}
+
+func (it *collapsingIterator) Next() bool {
+	for it.Iterator.Next() {
+		fp := it.Iterator.Document().Fingerprint
+		if fp != 0 && it.isDuplicate(fp) {
+			continue
+		}
+		if fp != 0 {
+			it.seen = append(it.seen, fp)
+		}
+		return true
+	}
+	return false
+}
+
+func (it *collapsingIterator) isDuplicate(fp uint64) bool {
+	for _, seen := range it.seen {
+		if Distance(fp, seen) <= it.maxDistance {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package dedup
+
+import (
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+)
+
+// Match は Lookup によって見つかった文書を表す
+type Match struct {
+	LinkID      uuid.UUID
+	Fingerprint uint64
+	Distance    int
+}
+
+type blockKey struct {
+	block int
+	value uint64
+}
+
+// FingerprintIndex はフィンガープリントからハミング距離が一定以内の文書を検索する。
+// 64 ビットを maxDistance+1 個のブロックに分割すると、距離が maxDistance 以内の 2 つの
+// フィンガープリントは少なくとも 1 つのブロックが完全に一致する (鳩の巣原理) ことを利用して候補を絞り込む。
+type FingerprintIndex struct {
+	maxDistance int
+	blockSizes  []uint
+
+	mu     sync.RWMutex
+	fps    map[uuid.UUID]uint64
+	tables map[blockKey][]uuid.UUID
+}
+
+// NewFingerprintIndex は距離が maxDistance 以内の文書を検索できる FingerprintIndex を作成する
+func NewFingerprintIndex(maxDistance int) *FingerprintIndex {
+	if maxDistance < 0 {
+		maxDistance = 0
+	}
+	if maxDistance > 63 {
+		maxDistance = 63
+	}
+
+	blocks := maxDistance + 1
+	sizes := make([]uint, blocks)
+	for i := range sizes {
+		sizes[i] = uint(64 / blocks)
+		if i < 64%blocks {
+			sizes[i]++
+		}
+	}
+
+	return &FingerprintIndex{
+		maxDistance: maxDistance,
+		blockSizes:  sizes,
+		fps:         make(map[uuid.UUID]uint64),
+		tables:      make(map[blockKey][]uuid.UUID),
+	}
+}
+
+// MaxDistance は Lookup が一致とみなす最大のハミング距離を返す
+func (fi *FingerprintIndex) MaxDistance() int {
+	return fi.maxDistance
+}
+
+// Add は文書のフィンガープリントを登録する。同じリンク ID が登録済みの場合は置き換える。
+func (fi *FingerprintIndex) Add(linkID uuid.UUID, fp uint64) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if old, exists := fi.fps[linkID]; exists {
+		if old == fp {
+			return
+		}
+		fi.remove(linkID, old)
+	}
+
+	fi.fps[linkID] = fp
+	for _, key := range fi.keys(fp) {
+		fi.tables[key] = append(fi.tables[key], linkID)
+	}
+}
+
+// Remove は文書のフィンガープリントの登録を解除する
+func (fi *FingerprintIndex) Remove(linkID uuid.UUID) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if old, exists := fi.fps[linkID]; exists {
+		fi.remove(linkID, old)
+	}
+}
+
+func (fi *FingerprintIndex) remove(linkID uuid.UUID, fp uint64) {
+	delete(fi.fps, linkID)
+	for _, key := range fi.keys(fp) {
+		ids := fi.tables[key]
+		for i, id := range ids {
+			if id == linkID {
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+		if len(ids) == 0 {
+			delete(fi.tables, key)
+		} else {
+			fi.tables[key] = ids
+		}
+	}
+}
+
+// Lookup は fp とのハミング距離が MaxDistance 以内の文書を距離の近い順に返す
+func (fi *FingerprintIndex) Lookup(fp uint64) []Match {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	var (
+		matches []Match
+		seen    = make(map[uuid.UUID]struct{})
+	)
+	for _, key := range fi.keys(fp) {
+		for _, id := range fi.tables[key] {
+			if _, dup := seen[id]; dup {
+				continue
+			}
+			seen[id] = struct{}{}
+
+			cand := fi.fps[id]
+			if d := Distance(fp, cand); d <= fi.maxDistance {
+				matches = append(matches, Match{LinkID: id, Fingerprint: cand, Distance: d})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Distance < matches[j].Distance })
+	return matches
+}
+
+func (fi *FingerprintIndex) keys(fp uint64) []blockKey {
+	keys := make([]blockKey, len(fi.blockSizes))
+
+	var shift uint
+	for i, size := range fi.blockSizes {
+		mask := uint64(1)<<size - 1
+		keys[i] = blockKey{block: i, value: (fp >> shift) & mask}
+		shift += size
+	}
+	return keys
+}
@@ -0,0 +1,95 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// shingleSize はフィンガープリントの計算に使用する連続した語 (シングル) の長さ
+const shingleSize = 2
+
+// Fingerprint は text の 64 ビット SimHash を計算する。
+// 語の並びが似ている文書ほどフィンガープリントのハミング距離が小さくなる。
+func Fingerprint(text string) uint64 {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return 0
+	}
+
+	n := shingleSize
+	if len(tokens) < n {
+		n = len(tokens)
+	}
+
+	var (
+		weights [64]int
+		h       = fnv.New64a()
+	)
+	for i := 0; i+n <= len(tokens); i++ {
+		h.Reset()
+		for j, tok := range tokens[i : i+n] {
+			if j > 0 {
+				_, _ = h.Write([]byte{0})
+			}
+			_, _ = h.Write([]byte(tok))
+		}
+
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fp uint64
+	for bit, w := range weights {
+		if w > 0 {
+			fp |= 1 << uint(bit)
+		}
+	}
+	return fp
+}
+
+// Distance は 2 つのフィンガープリントのハミング距離を返す
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// tokenize は text を小文字の語に分割する。空白で区切られない CJK の文字は 1 文字ずつ語として扱う。
+func tokenize(text string) []string {
+	var (
+		tokens []string
+		cur    strings.Builder
+	)
+	flush := func() {
+		if cur.Len() != 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			cur.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
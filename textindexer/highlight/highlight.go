@@ -0,0 +1,299 @@
+// Package highlight は検索結果の本文からクエリの語を強調した抜粋を作成する。
+// 独自の強調表示の仕組みを持たない index.Indexer の実装が共通して使用する。
+package highlight
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ellipsis は本文の途中で切った抜粋の前後に付加される
+	ellipsis = "…"
+
+	// maxSnap は抜粋の端を語の境界に合わせるために移動する最大の文字数
+	maxSnap = 15
+)
+
+// Highlighter はクエリに一致する語を強調した抜粋を作成する
+type Highlighter struct {
+	opts     index.HighlightOptions
+	terms    [][]rune
+	prefixes [][]rune
+}
+
+// New はクエリ q の語を強調する Highlighter を作成する。q.Highlight が nil の場合は既定のオプションが使用される。
+func New(q index.Query) *Highlighter {
+	var opts index.HighlightOptions
+	if q.Highlight != nil {
+		opts = *q.Highlight
+	}
+
+	h := &Highlighter{opts: opts.WithDefaults()}
+	switch q.Type {
+	case index.QueryTypeAdvanced:
+		if n, err := querylang.Parse(q.Expression); err == nil {
+			h.addNode(n)
+		}
+	default:
+		h.addText(q.Expression, false)
+	}
+	return h
+}
+
+// addNode は本文に一致し得る肯定的な語を構文木から収集する
+func (h *Highlighter) addNode(n querylang.Node) {
+	switch n := n.(type) {
+	case *querylang.And:
+		for _, child := range n.Nodes {
+			h.addNode(child)
+		}
+	case *querylang.Or:
+		for _, child := range n.Nodes {
+			h.addNode(child)
+		}
+	case *querylang.Term:
+		if n.Field == querylang.FieldAny || n.Field == querylang.FieldContent {
+			h.addText(n.Value, n.Prefix)
+		}
+	case *querylang.Phrase:
+		if n.Field == querylang.FieldAny || n.Field == querylang.FieldContent {
+			h.addText(n.Value, false)
+		}
+	}
+}
+
+func (h *Highlighter) addText(text string, prefix bool) {
+	lower := toLower([]rune(text))
+	for _, w := range words(lower) {
+		term := lower[w.start:w.end]
+		if prefix {
+			h.prefixes = append(h.prefixes, term)
+		} else {
+			h.terms = append(h.terms, term)
+		}
+	}
+}
+
+// Snippet は text のうちクエリの語を最も多く含む部分を抜粋する。
+// 一致した語はマーカーで囲まれ、それ以外のテキストは HTML エスケープされる。
+// 一致する語がない場合は text の先頭を返す。
+func (h *Highlighter) Snippet(text string) string {
+	runes := []rune(text)
+	matches := h.matches(runes)
+	start, end := h.window(runes, matches)
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString(ellipsis)
+	}
+	cur := start
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		sb.WriteString(html.EscapeString(string(runes[cur:m.start])))
+		sb.WriteString(h.opts.PreTag)
+		sb.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		sb.WriteString(h.opts.PostTag)
+		cur = m.end
+	}
+	sb.WriteString(html.EscapeString(string(runes[cur:end])))
+	if end < len(runes) {
+		sb.WriteString(ellipsis)
+	}
+	return sb.String()
+}
+
+type span struct {
+	start, end int
+	term       string
+}
+
+// matches はテキスト中でクエリの語に一致する範囲を出現順に返す
+func (h *Highlighter) matches(runes []rune) []span {
+	lower := toLower(runes)
+
+	var matches []span
+	for _, w := range words(lower) {
+		word := lower[w.start:w.end]
+		if term, ok := h.matchWord(word); ok {
+			matches = append(matches, span{start: w.start, end: w.end, term: term})
+			continue
+		}
+
+		// 空白で区切られない CJK の語は部分文字列として検索する
+		for off := 0; off < len(word); {
+			m, found := h.matchCJK(word[off:])
+			if !found {
+				off++
+				continue
+			}
+			matches = append(matches, span{start: w.start + off + m.start, end: w.start + off + m.end, term: m.term})
+			off += m.end
+		}
+	}
+	return matches
+}
+
+func (h *Highlighter) matchWord(word []rune) (string, bool) {
+	for _, term := range h.terms {
+		if string(word) == string(term) {
+			return string(term), true
+		}
+	}
+	for _, prefix := range h.prefixes {
+		if len(word) >= len(prefix) && string(word[:len(prefix)]) == string(prefix) {
+			return string(prefix) + "*", true
+		}
+	}
+	return "", false
+}
+
+// matchCJK は word の先頭に一致する CJK の語を返す
+func (h *Highlighter) matchCJK(word []rune) (span, bool) {
+	if !isCJK(word[0]) {
+		return span{}, false
+	}
+	for _, term := range h.terms {
+		if isCJK(term[0]) && len(word) >= len(term) && string(word[:len(term)]) == string(term) {
+			return span{start: 0, end: len(term), term: string(term)}, true
+		}
+	}
+	return span{}, false
+}
+
+// window は抜粋する範囲を返す。異なる語を多く含む範囲が優先される。
+func (h *Highlighter) window(runes []rune, matches []span) (int, int) {
+	size := h.opts.FragmentSize
+	if len(runes) <= size {
+		return 0, len(runes)
+	}
+
+	start, bestScore := 0, -1
+	for _, anchor := range matches {
+		// 一致した語の前にも文脈を残す
+		candidate := anchor.start - size/5
+		if candidate < 0 {
+			candidate = 0
+		}
+		if candidate+size > len(runes) {
+			candidate = len(runes) - size
+		}
+		if score := windowScore(matches, candidate, candidate+size); score > bestScore {
+			start, bestScore = candidate, score
+		}
+	}
+	end := start + size
+
+	// 語の途中で切らないように端を内側へ移動する。一致した語は切り捨てない。
+	firstMatch, lastMatch := end, start
+	for _, m := range matches {
+		if m.start >= start && m.end <= end {
+			if m.start < firstMatch {
+				firstMatch = m.start
+			}
+			if m.end > lastMatch {
+				lastMatch = m.end
+			}
+		}
+	}
+	if start > 0 && isWordRune(runes[start-1]) {
+		for s := start; s < firstMatch && s-start <= maxSnap; s++ {
+			if !isWordRune(runes[s]) {
+				start = s
+				break
+			}
+		}
+	}
+	if end < len(runes) && isWordRune(runes[end]) {
+		for e := end; e > lastMatch && end-e <= maxSnap; e-- {
+			if !isWordRune(runes[e-1]) {
+				end = e
+				break
+			}
+		}
+	}
+
+	for start < end && unicode.IsSpace(runes[start]) {
+		start++
+	}
+	for end > start && unicode.IsSpace(runes[end-1]) {
+		end--
+	}
+	return start, end
+}
+
+func windowScore(matches []span, start, end int) int {
+	var (
+		count int
+		terms = make(map[string]struct{})
+	)
+	for _, m := range matches {
+		if m.start >= start && m.end <= end {
+			count++
+			terms[m.term] = struct{}{}
+		}
+	}
+	return len(terms)*1000 + count
+}
+
+// words はテキストを文字と数字の連続に分割する
+func words(runes []rune) []span {
+	var (
+		spans []span
+		start = -1
+	)
+	for i, r := range runes {
+		if isWordRune(r) {
+			if start == -1 {
+				start = i
+			}
+		} else if start != -1 {
+			spans = append(spans, span{start: start, end: i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		spans = append(spans, span{start: start, end: len(runes)})
+	}
+	return spans
+}
+
+func toLower(runes []rune) []rune {
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	return lower
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// NewIterator は it が返す文書の本文から抜粋を作成する index.SnippetIterator を返す
+func NewIterator(it index.Iterator, q index.Query) index.SnippetIterator {
+	return &snippetIterator{IteratorWrapper: index.IteratorWrapper{Iterator: it}, h: New(q)}
+}
+
+type snippetIterator struct {
+	index.IteratorWrapper
+	h *Highlighter
+}
+
+func (it *snippetIterator) Snippet() string {
+	doc := it.Document()
+	if doc == nil {
+		return ""
+	}
+	return it.h.Snippet(doc.Content)
+}
@@ -0,0 +1,82 @@
+package highlight
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	gc "gopkg.in/check.v1"
+	"testing"
+	"unicode/utf8"
+)
+
+var _ = gc.Suite(new(HighlightTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type HighlightTestSuite struct{}
+
+func (s *HighlightTestSuite) TestShortText(c *gc.C) {
+	h := New(index.Query{Expression: "Poeta terra"})
+	c.Assert(h.Snippet("Ovidius poeta in <terra> pontica"), gc.Equals, "Ovidius <mark>poeta</mark> in &lt;<mark>terra</mark>&gt; pontica")
+}
+
+func (s *HighlightTestSuite) TestCustomMarkers(c *gc.C) {
+	h := New(index.Query{
+		Type:       index.QueryTypePhrase,
+		Expression: "hello world",
+		Highlight:  &index.HighlightOptions{PreTag: "[", PostTag: "]"},
+	})
+	c.Assert(h.Snippet("Hello, World & friends"), gc.Equals, "[Hello], [World] &amp; friends")
+}
+
+func (s *HighlightTestSuite) TestBestFragment(c *gc.C) {
+	text := "golang appears early in this text. " +
+		"Then there is a long stretch of filler words that do not matter at all for the query. " +
+		"Finally the part about golang concurrency with goroutines and channels appears here. " +
+		"And the text continues with even more unrelated filler until it ends."
+
+	h := New(index.Query{Expression: "golang goroutines", Highlight: &index.HighlightOptions{FragmentSize: 60}})
+	snippet := h.Snippet(text)
+	c.Assert(snippet, gc.Equals, "…part about <mark>golang</mark> concurrency with <mark>goroutines</mark> and channels…")
+}
+
+func (s *HighlightTestSuite) TestNoMatchReturnsLeadingText(c *gc.C) {
+	h := New(index.Query{Expression: "missing", Highlight: &index.HighlightOptions{FragmentSize: 20}})
+	snippet := h.Snippet("The quick brown fox jumps over the lazy dog")
+	c.Assert(snippet, gc.Equals, "The quick brown fox…")
+	c.Assert(utf8.RuneCountInString(snippet) <= 21, gc.Equals, true)
+}
+
+func (s *HighlightTestSuite) TestAdvancedQuery(c *gc.C) {
+	h := New(index.Query{Type: index.QueryTypeAdvanced, Expression: `gorout* title:golang -channels "data races"`})
+	c.Assert(h.Snippet("golang goroutines without data races or channels"), gc.Equals,
+		"golang <mark>goroutines</mark> without <mark>data</mark> <mark>races</mark> or channels")
+}
+
+func (s *HighlightTestSuite) TestCJK(c *gc.C) {
+	h := New(index.Query{Expression: "天気予報"})
+	c.Assert(h.Snippet("東京都の天気予報です"), gc.Equals, "東京都の<mark>天気予報</mark>です")
+}
+
+func (s *HighlightTestSuite) TestIterator(c *gc.C) {
+	docs := []*index.Document{{Content: "Ovidius poeta"}}
+	it := NewIterator(&sliceIterator{docs: docs}, index.Query{Expression: "poeta"})
+	c.Assert(it.Snippet(), gc.Equals, "")
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Snippet(), gc.Equals, "Ovidius <mark>poeta</mark>")
+}
+
+type sliceIterator struct {
+	docs []*index.Document
+	cur  *index.Document
+}
+
+func (it *sliceIterator) Close() error { return nil }
+func (it *sliceIterator) Next() bool {
+	if len(it.docs) == 0 {
+		return false
+	}
+	it.cur, it.docs = it.docs[0], it.docs[1:]
+	return true
+}
+func (it *sliceIterator) Error() error              { return nil }
+func (it *sliceIterator) Document() *index.Document { return it.cur }
+func (it *sliceIterator) TotalCount() uint64        { return 1 }
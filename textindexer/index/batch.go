@@ -0,0 +1,154 @@
+package index
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// BatchIndexer は複数の文書の追加と PageRank スコアの更新をまとめて行う Indexer。
+// 個々の文書の処理に失敗してもバッチ全体は中断されず、失敗は BatchResult.Errors に報告される。
+// バッチ全体を処理できなかった場合のみエラーを返す。
+type BatchIndexer interface {
+	Indexer
+
+	// IndexBatch は Index と同様に、既存の文書の PageRank スコアを保持したまま docs を追加または更新する
+	IndexBatch(docs []*Document) (BatchResult, error)
+
+	// UpdateScores は UpdateScore と同様に、scores のリンク ID を持つ文書の PageRank スコアを更新する
+	UpdateScores(scores map[uuid.UUID]float64) (BatchResult, error)
+}
+
+// BatchResult はバッチ処理の結果を保持する
+type BatchResult struct {
+	// Processed は正常に処理された文書の数
+	Processed int
+
+	// Errors は処理に失敗した文書のエラー
+	Errors []*DocumentError
+
+	// Elapsed はバッチの処理にかかった時間
+	Elapsed time.Duration
+}
+
+// Throughput は 1 秒あたりに処理された文書の数を返す
+func (r BatchResult) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Processed) / r.Elapsed.Seconds()
+}
+
+// DocumentError はバッチ内の 1 つの文書の処理に失敗したことを表す
+type DocumentError struct {
+	// Pos は IndexBatch に渡されたスライス内の文書の位置。UpdateScores では -1。
+	Pos    int
+	LinkID uuid.UUID
+	Err    error
+}
+
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("document %s: %v", e.LinkID, e.Err)
+}
+
+func (e *DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// IndexBatch は idx が BatchIndexer を実装する場合は IndexBatch を呼び出し、
+// そうでない場合は文書を 1 件ずつ Index に渡す
+func IndexBatch(idx Indexer, docs []*Document) (BatchResult, error) {
+	if bi, ok := idx.(BatchIndexer); ok {
+		return bi.IndexBatch(docs)
+	}
+
+	var (
+		res   BatchResult
+		start = time.Now()
+	)
+	for pos, doc := range docs {
+		if doc == nil {
+			res.Errors = append(res.Errors, &DocumentError{Pos: pos, Err: ErrMissingLinkID})
+			continue
+		}
+		if err := idx.Index(doc); err != nil {
+			res.Errors = append(res.Errors, &DocumentError{Pos: pos, LinkID: doc.LinkID, Err: err})
+			continue
+		}
+		res.Processed++
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// UpdateScores は idx が BatchIndexer を実装する場合は UpdateScores を呼び出し、
+// そうでない場合はスコアを 1 件ずつ UpdateScore に渡す
+func UpdateScores(idx Indexer, scores map[uuid.UUID]float64) (BatchResult, error) {
+	if bi, ok := idx.(BatchIndexer); ok {
+		return bi.UpdateScores(scores)
+	}
+
+	var (
+		res   BatchResult
+		start = time.Now()
+	)
+	for linkID, score := range scores {
+		if err := idx.UpdateScore(linkID, score); err != nil {
+			res.Errors = append(res.Errors, &DocumentError{Pos: -1, LinkID: linkID, Err: err})
+			continue
+		}
+		res.Processed++
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// Restorer は文書を IndexedAt と PageRank を含めてそのまま追加する Indexer。スナップショットからの復元に使用される。
+type Restorer interface {
+	Indexer
+
+	// Restore は IndexBatch と同様に docs を追加するが、文書の IndexedAt と PageRank を上書きしない
+	Restore(docs []*Document) (BatchResult, error)
+}
+
+// Restore は idx が Restorer を実装する場合は Restore を呼び出し、そうでない場合は IndexBatch で文書を追加してから
+// UpdateScores で PageRank スコアを設定する。後者の場合、IndexedAt は復元した時刻となる。
+func Restore(idx Indexer, docs []*Document) (BatchResult, error) {
+	if r, ok := idx.(Restorer); ok {
+		return r.Restore(docs)
+	}
+
+	start := time.Now()
+	res, err := IndexBatch(idx, docs)
+	if err != nil {
+		return BatchResult{}, err
+	}
+
+	failed := make(map[int]bool, len(res.Errors))
+	for _, docErr := range res.Errors {
+		failed[docErr.Pos] = true
+	}
+	scores := make(map[uuid.UUID]float64, len(docs))
+	for pos, doc := range docs {
+		if !failed[pos] {
+			scores[doc.LinkID] = doc.PageRank
+		}
+	}
+	scoreRes, err := UpdateScores(idx, scores)
+	if err != nil {
+		return BatchResult{}, err
+	}
+
+	// PageRank スコアを設定できなかった文書は失敗として報告する
+	for _, docErr := range scoreRes.Errors {
+		for pos, doc := range docs {
+			if !failed[pos] && doc.LinkID == docErr.LinkID {
+				failed[pos] = true
+				res.Processed--
+				res.Errors = append(res.Errors, &DocumentError{Pos: pos, LinkID: doc.LinkID, Err: docErr.Err})
+			}
+		}
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
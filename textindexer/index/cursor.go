@@ -0,0 +1,34 @@
+package index
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"golang.org/x/xerrors"
+)
+
+// EncodeCursor は実装ごとのソートキー keys を不透明なカーソル文字列に変換する
+func EncodeCursor(keys interface{}) string {
+	data, err := json.Marshal(keys)
+	if err != nil {
+		// ソートキーは常に JSON に変換できる値で構成される
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor は EncodeCursor で作成されたカーソルをソートキー keys に復元する。
+// q.Offset と同時に指定されている場合や、カーソルが不正な場合は ErrInvalidCursor をラップしたエラーを返す。
+func DecodeCursor(q Query, keys interface{}) error {
+	if q.Offset != 0 {
+		return xerrors.Errorf("cursor cannot be combined with an offset: %w", ErrInvalidCursor)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return xerrors.Errorf("decode cursor: %w", ErrInvalidCursor)
+	}
+	if err = json.Unmarshal(data, keys); err != nil {
+		return xerrors.Errorf("decode cursor: %w", ErrInvalidCursor)
+	}
+	return nil
+}
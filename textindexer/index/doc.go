@@ -12,4 +12,13 @@ type Document struct {
 	Content   string
 	IndexedAt time.Time
 	PageRank  float64
+
+	// Fingerprint はコンテンツの SimHash。ほぼ同一の文書の検出に使用される。
+	Fingerprint uint64
+
+	// DuplicateOf はこの文書がほぼ同一と判定された既存文書のリンク ID。重複していない場合は uuid.Nil。
+	DuplicateOf uuid.UUID
+
+	// Language は文書の ISO 639-1 の言語コード。空の場合はインデックスへの追加時にタイトルと本文から推定される。
+	Language string
 }
@@ -6,4 +6,8 @@ var (
 	ErrNotFound = xerrors.New("not found")
 
 	ErrMissingLinkID = xerrors.New("document does not provide a valid linkID")
+
+	ErrInvalidCursor = xerrors.New("invalid search cursor")
+
+	ErrScanUnsupported = xerrors.New("indexer does not support scanning")
 )
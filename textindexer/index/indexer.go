@@ -1,12 +1,47 @@
 package index
 
-import "github.com/google/uuid"
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
 
 type Indexer interface {
 	Index(doc *Document) error
 	FindByID(linkID uuid.UUID) (*Document, error)
 	Search(query Query) (Iterator, error)
 	UpdateScore(linkID uuid.UUID, score float64) error
+
+	// Delete は指定されたリンク ID を持つ文書を削除する。文書が存在しない場合は ErrNotFound を返す。
+	Delete(linkID uuid.UUID) error
+
+	// DeleteMatching は pred が true を返すすべての文書を削除し、削除した文書の数を返す
+	DeleteMatching(pred Predicate) (int, error)
+}
+
+// Predicate は DeleteMatching で削除する文書を選択する。
+// DeleteMatching は Predicate を並行して呼び出さないため、Predicate は排他制御なしに状態を更新してよい。
+type Predicate func(doc *Document) bool
+
+// IndexedBefore は cutoff より前にインデックスに追加された文書を選択する Predicate を返す。
+// UpdateScore によって作成され、まだ内容が追加されていないプレースホルダ文書は選択されない。
+func IndexedBefore(cutoff time.Time) Predicate {
+	return func(doc *Document) bool {
+		return !doc.IndexedAt.IsZero() && doc.IndexedAt.Before(cutoff)
+	}
+}
+
+// ContextIndexer は Indexer の各操作に context.Context を受け取る版を追加する。
+// コンテキストがキャンセルされると、操作および Search が返すイテレータによる結果の取得は中断される。
+type ContextIndexer interface {
+	Indexer
+
+	IndexContext(ctx context.Context, doc *Document) error
+	FindByIDContext(ctx context.Context, linkID uuid.UUID) (*Document, error)
+	SearchContext(ctx context.Context, query Query) (Iterator, error)
+	UpdateScoreContext(ctx context.Context, linkID uuid.UUID, score float64) error
+	DeleteContext(ctx context.Context, linkID uuid.UUID) error
+	DeleteMatchingContext(ctx context.Context, pred Predicate) (int, error)
 }
 
 type Iterator interface {
@@ -17,15 +52,100 @@ type Iterator interface {
 	TotalCount() uint64
 }
 
+// CursorIterator は検索を現在の文書の直後から再開するためのカーソルを返す Iterator
+type CursorIterator interface {
+	Iterator
+
+	// Cursor は現在の文書の次から結果を返すための Query.Cursor の値を返す。
+	// Next が true を返す前、またはカーソルに対応していない場合は空文字列を返す。
+	Cursor() string
+}
+
+// ScoredIterator は現在の文書と検索語との関連度を返す Iterator。Ranking の計算に使用される。
+type ScoredIterator interface {
+	Iterator
+
+	// Relevance は PageRank などを含まない、検索語のみに基づく現在の文書のスコアを返す
+	Relevance() float64
+}
+
+// SuggestionIterator は綴りを修正したクエリの候補を返す Iterator
+type SuggestionIterator interface {
+	Iterator
+
+	// Suggestion はインデックスの語彙に基づいて綴りを修正した検索式を返す。修正する語がない場合は空文字列を返す。
+	Suggestion() string
+}
+
+// SnippetIterator は現在の文書の抜粋を返す Iterator。Query.Highlight を指定した検索のイテレータが実装する。
+type SnippetIterator interface {
+	Iterator
+
+	// Snippet は現在の文書の本文のうちクエリに最もよく一致する部分を返す。
+	// 一致した語は HighlightOptions のマーカーで囲まれ、それ以外のテキストは HTML エスケープされる。
+	Snippet() string
+}
+
 type QueryType uint8
 
 const (
 	QueryTypeMatch QueryType = iota
 	QueryTypePhrase
+
+	// QueryTypeAdvanced は Expression を querylang パッケージの構文で解釈する。
+	// 構文に誤りがある場合、Search は *querylang.SyntaxError をラップしたエラーを返す。
+	QueryTypeAdvanced
 )
 
 type Query struct {
 	Type       QueryType
 	Expression string
 	Offset     uint64
+
+	// Limit はイテレータが返す文書の最大数。0 の場合は一致したすべての文書を返す。
+	Limit uint64
+
+	// Cursor は CursorIterator.Cursor が返した値。指定された場合、結果はカーソルの位置の文書の次から始まる。
+	// Offset と同時には指定できない。
+	Cursor string
+
+	// Highlight が nil でない場合、Search が返すイテレータは SnippetIterator を実装する
+	Highlight *HighlightOptions
+
+	// Language は Expression の解析に使用する言語の ISO 639-1 コード。空の場合は Expression から推定される。
+	Language string
+
+	// Ranking が nil でない場合、結果は PageRank と関連度の順ではなく Ranking のスコアの降順に並ぶ。
+	// スコアは PageRank と関連度の順で上位 ranking.MaxCandidates 件の文書について計算され、それらは検索時にまとめて取得される。
+	Ranking *Ranking
+}
+
+// QueryRewriter は検索の前にクエリを書き換える。同義語の展開やストップワードの除去に使用される。
+type QueryRewriter interface {
+	Rewrite(q Query) Query
+}
+
+// DefaultFragmentSize は HighlightOptions.FragmentSize が未設定の場合の抜粋の文字数
+const DefaultFragmentSize = 150
+
+// HighlightOptions は検索結果の抜粋の作成方法を指定する
+type HighlightOptions struct {
+	// FragmentSize は抜粋の最大文字数。0 の場合は DefaultFragmentSize が使用される。
+	FragmentSize int
+
+	// PreTag と PostTag は一致した語を囲むマーカー。エスケープされずにそのまま出力される。
+	// 空の場合は <mark> と </mark> が使用される。
+	PreTag  string
+	PostTag string
+}
+
+// WithDefaults は未設定のフィールドを既定値で補ったオプションを返す
+func (o HighlightOptions) WithDefaults() HighlightOptions {
+	if o.FragmentSize <= 0 {
+		o.FragmentSize = DefaultFragmentSize
+	}
+	if o.PreTag == "" && o.PostTag == "" {
+		o.PreTag, o.PostTag = "<mark>", "</mark>"
+	}
+	return o
 }
@@ -0,0 +1,806 @@
+package indextest
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type SuiteBase struct {
+	idx index.Indexer
+}
+
+func (s *SuiteBase) SetIndexer(idx index.Indexer) {
+	s.idx = idx
+}
+
+func (s *SuiteBase) TestIndexDocument(c *gc.C) {
+	// Create a new document
+	doc := &index.Document{
+		LinkID:  uuid.New(),
+		URL:     "http://example.com",
+		Title:   "Illustrious examples",
+		Content: "Lorem ipsum dolor",
+	}
+	err := s.idx.Index(doc)
+	c.Assert(err, gc.IsNil)
+
+	// 既存の文書を更新する
+	updatedDoc := &index.Document{
+		LinkID:  doc.LinkID,
+		URL:     "http://example.com",
+		Title:   "A more exciting title",
+		Content: "Ovidius poeta in terra pontica",
+	}
+	err = s.idx.Index(updatedDoc)
+	c.Assert(err, gc.IsNil)
+
+	// リンク ID のない文書はエラーになる
+	err = s.idx.Index(&index.Document{URL: "http://example.com"})
+	c.Assert(xerrors.Is(err, index.ErrMissingLinkID), gc.Equals, true)
+}
+
+func (s *SuiteBase) TestIndexDoesNotOverridePageRank(c *gc.C) {
+	doc := &index.Document{
+		LinkID:  uuid.New(),
+		URL:     "http://example.com",
+		Title:   "Illustrious examples",
+		Content: "Lorem ipsum dolor",
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	expScore := 0.5
+	c.Assert(s.idx.UpdateScore(doc.LinkID, expScore), gc.IsNil)
+
+	// 再インデックスしても PageRank スコアは保持される
+	c.Assert(s.idx.Index(&index.Document{LinkID: doc.LinkID, URL: doc.URL, Title: "Another title"}), gc.IsNil)
+
+	got, err := s.idx.FindByID(doc.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.PageRank, gc.Equals, expScore)
+	c.Assert(got.Title, gc.Equals, "Another title")
+}
+
+func (s *SuiteBase) TestIndexReplacesDuplicateOf(c *gc.C) {
+	doc := &index.Document{
+		LinkID:      uuid.New(),
+		URL:         "http://example.com/copy",
+		Content:     "Lorem ipsum dolor",
+		Fingerprint: 0xcafe,
+		DuplicateOf: uuid.New(),
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	// 重複でなくなった文書を追加し直すと、以前の DuplicateOf と Fingerprint は残らない
+	c.Assert(s.idx.Index(&index.Document{LinkID: doc.LinkID, Content: "Lorem ipsum dolor sit amet"}), gc.IsNil)
+	got, err := s.idx.FindByID(doc.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.DuplicateOf, gc.Equals, uuid.Nil)
+	c.Assert(got.Fingerprint, gc.Equals, uint64(0))
+	c.Assert(got.URL, gc.Equals, "")
+
+	it, err := s.idx.Search(index.Query{Type: index.QueryTypeAdvanced, Expression: "site:example.com"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.HasLen, 0)
+}
+
+func (s *SuiteBase) TestFindByID(c *gc.C) {
+	doc := &index.Document{
+		LinkID:  uuid.New(),
+		URL:     "http://example.com",
+		Title:   "Illustrious examples",
+		Content: "Lorem ipsum dolor",
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	got, err := s.idx.FindByID(doc.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.URL, gc.Equals, doc.URL)
+	c.Assert(got.Title, gc.Equals, doc.Title)
+	c.Assert(got.Content, gc.Equals, doc.Content)
+	c.Assert(got.IndexedAt.IsZero(), gc.Equals, false)
+
+	_, err = s.idx.FindByID(uuid.New())
+	c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+}
+
+func (s *SuiteBase) TestPhraseSearch(c *gc.C) {
+	var (
+		numDocs = 20
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: "Lorem Ipsum Dolor",
+		}
+		if i%5 == 0 {
+			doc.Content = "Lorem Dolor Ipsum"
+			expIDs = append(expIDs, id)
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(numDocs-i)), gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{
+		Type:       index.QueryTypePhrase,
+		Expression: "lorem dolor ipsum",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, expIDs)
+}
+
+func (s *SuiteBase) TestMatchSearch(c *gc.C) {
+	var (
+		numDocs = 20
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		expIDs = append(expIDs, id)
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: "Ovidius poeta in terra pontica",
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(numDocs-i)), gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{
+		Type:       index.QueryTypeMatch,
+		Expression: "poeta",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(numDocs))
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, expIDs)
+}
+
+func (s *SuiteBase) TestMatchSearchWithOffset(c *gc.C) {
+	var (
+		numDocs = 50
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		expIDs = append(expIDs, id)
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: "Ovidius poeta in terra pontica",
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(numDocs-i)), gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{
+		Type:       index.QueryTypeMatch,
+		Expression: "poeta",
+		Offset:     20,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(numDocs))
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, expIDs[20:])
+
+	// 結果の件数を超えるオフセット
+	it, err = s.idx.Search(index.Query{
+		Type:       index.QueryTypeMatch,
+		Expression: "poeta",
+		Offset:     uint64(numDocs) + 1,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.HasLen, 0)
+}
+
+func (s *SuiteBase) TestSearchSkipsDeletedDocuments(c *gc.C) {
+	var (
+		numDocs = 5
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		expIDs = append(expIDs, id)
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: "Ovidius poeta in terra pontica",
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(numDocs-i)), gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{
+		Type:       index.QueryTypeMatch,
+		Expression: "poeta",
+	})
+	c.Assert(err, gc.IsNil)
+
+	// 検索後に削除された文書はエラーにならずに読み飛ばされる
+	c.Assert(s.idx.Delete(expIDs[2]), gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, append(expIDs[:2:2], expIDs[3:]...))
+}
+
+func (s *SuiteBase) TestUpdateScore(c *gc.C) {
+	var (
+		numDocs = 100
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		expIDs = append(expIDs, id)
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: "Ovidius poeta in terra pontica",
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(numDocs-i)), gc.IsNil)
+	}
+
+	// スコアを逆転させると結果の順序も逆転する
+	for i, id := range expIDs {
+		c.Assert(s.idx.UpdateScore(id, float64(i)), gc.IsNil)
+	}
+	for i, j := 0, len(expIDs)-1; i < j; i, j = i+1, j-1 {
+		expIDs[i], expIDs[j] = expIDs[j], expIDs[i]
+	}
+
+	it, err := s.idx.Search(index.Query{
+		Type:       index.QueryTypeMatch,
+		Expression: "poeta",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, expIDs)
+}
+
+func (s *SuiteBase) TestUpdateScoreForUnknownDocument(c *gc.C) {
+	linkID := uuid.New()
+	c.Assert(s.idx.UpdateScore(linkID, 0.5), gc.IsNil)
+
+	doc, err := s.idx.FindByID(linkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.URL, gc.Equals, "")
+	c.Assert(doc.Title, gc.Equals, "")
+	c.Assert(doc.Content, gc.Equals, "")
+	c.Assert(doc.IndexedAt.IsZero(), gc.Equals, true)
+	c.Assert(doc.PageRank, gc.Equals, 0.5)
+}
+
+func (s *SuiteBase) TestSearchContextCancellation(c *gc.C) {
+	cidx, ok := s.idx.(index.ContextIndexer)
+	if !ok {
+		c.Skip("indexer does not implement index.ContextIndexer")
+	}
+
+	for i := 0; i < 30; i++ {
+		doc := &index.Document{
+			LinkID:  uuid.New(),
+			Content: "Ovidius poeta in terra pontica",
+		}
+		c.Assert(cidx.IndexContext(context.Background(), doc), gc.IsNil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it, err := cidx.SearchContext(ctx, index.Query{Type: index.QueryTypeMatch, Expression: "poeta"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+
+	// キャンセル後は新たなバッチを取得せずにイテレーションが終了する
+	cancel()
+	var count int
+	for it.Next() {
+		count++
+	}
+	c.Assert(count < 29, gc.Equals, true, gc.Commentf("expected iteration to stop early; got %d more documents", count))
+	c.Assert(xerrors.Is(it.Error(), context.Canceled), gc.Equals, true)
+	c.Assert(it.Close(), gc.IsNil)
+
+	// キャンセル済みのコンテキストでの操作はエラーになる
+	_, err = cidx.SearchContext(ctx, index.Query{Expression: "poeta"})
+	c.Assert(err, gc.NotNil)
+	err = cidx.IndexContext(ctx, &index.Document{LinkID: uuid.New()})
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true)
+	_, err = cidx.FindByIDContext(ctx, uuid.New())
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true)
+	err = cidx.UpdateScoreContext(ctx, uuid.New(), 1)
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true)
+}
+
+func (s *SuiteBase) TestAdvancedSearch(c *gc.C) {
+	docs := []*index.Document{
+		{LinkID: uuid.New(), URL: "https://blog.example.com/go", Title: "Golang concurrency patterns", Content: "goroutines and channels"},
+		{LinkID: uuid.New(), URL: "https://example.com/hello", Title: "Golang basics", Content: "hello world program"},
+		{LinkID: uuid.New(), URL: "https://other.org/go", Title: "Golang concurrency", Content: "goroutines everywhere"},
+		{LinkID: uuid.New(), URL: "https://example.com/rust", Title: "Rust", Content: "concurrency without data races"},
+	}
+	for i, doc := range docs {
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(doc.LinkID, float64(len(docs)-i)), gc.IsNil)
+	}
+
+	specs := []struct {
+		expr string
+		exp  []int
+	}{
+		{expr: `title:golang AND (concurrency OR goroutines) -"hello world" site:example.com`, exp: []int{0}},
+		{expr: `title:golang`, exp: []int{0, 1, 2}},
+		{expr: `content:golang`},
+		{expr: `concurrency -title:rust`, exp: []int{0, 2}},
+		{expr: `gorout*`, exp: []int{0, 2}},
+		{expr: `"hello world" OR rust`, exp: []int{1, 3}},
+		{expr: `NOT golang`, exp: []int{3}},
+		{expr: `site:example.com`, exp: []int{0, 1, 3}},
+		{expr: `site:blog.example.com`, exp: []int{0}},
+		{expr: `url:https://example.com/*`, exp: []int{1, 3}},
+		{expr: `url:https://other.org/go`, exp: []int{2}},
+	}
+	for i, spec := range specs {
+		c.Logf("[spec %d] %s", i, spec.expr)
+		it, err := s.idx.Search(index.Query{Type: index.QueryTypeAdvanced, Expression: spec.expr})
+		c.Assert(err, gc.IsNil)
+
+		var exp []string
+		for _, docIdx := range spec.exp {
+			exp = append(exp, docs[docIdx].LinkID.String())
+		}
+		var got []string
+		for _, id := range iterateDocs(c, it) {
+			got = append(got, id.String())
+		}
+		sort.Strings(exp)
+		sort.Strings(got)
+		c.Assert(got, gc.DeepEquals, exp)
+	}
+
+	// 構文の誤りは querylang.SyntaxError として返される
+	_, err := s.idx.Search(index.Query{Type: index.QueryTypeAdvanced, Expression: "title:(golang"})
+	var synErr *querylang.SyntaxError
+	c.Assert(xerrors.As(err, &synErr), gc.Equals, true, gc.Commentf("err: %v", err))
+}
+
+func (s *SuiteBase) TestSearchSnippets(c *gc.C) {
+	doc := &index.Document{
+		LinkID: uuid.New(),
+		Title:  "Tristia",
+		Content: "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt. " +
+			"Ovidius was a <b>poeta</b> in terra pontica. " +
+			"Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo.",
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	it, err := s.idx.Search(index.Query{
+		Expression: "poeta",
+		Highlight:  &index.HighlightOptions{FragmentSize: 60, PreTag: "[", PostTag: "]"},
+	})
+	c.Assert(err, gc.IsNil)
+	si, ok := it.(index.SnippetIterator)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("iterator does not implement index.SnippetIterator"))
+
+	c.Assert(si.Next(), gc.Equals, true)
+	snippet := si.Snippet()
+	c.Assert(strings.Contains(snippet, "&lt;b&gt;[poeta]&lt;/b&gt;"), gc.Equals, true, gc.Commentf("snippet: %s", snippet))
+	c.Assert(strings.Contains(snippet, "Lorem"), gc.Equals, false, gc.Commentf("snippet: %s", snippet))
+	// 抜粋の長さはエスケープ前の本文、マーカーおよび前後の省略記号の長さを超えない
+	c.Assert(utf8.RuneCountInString(html.UnescapeString(snippet)) <= 60+len("[]")+2, gc.Equals, true, gc.Commentf("snippet: %s", snippet))
+	c.Assert(si.Close(), gc.IsNil)
+
+	// タイトルのみに一致する場合は本文の先頭を返す
+	it, err = s.idx.Search(index.Query{Expression: "tristia", Highlight: &index.HighlightOptions{FragmentSize: 20}})
+	c.Assert(err, gc.IsNil)
+	si = it.(index.SnippetIterator)
+	c.Assert(si.Next(), gc.Equals, true)
+	c.Assert(strings.HasPrefix(si.Snippet(), "Lorem ipsum"), gc.Equals, true, gc.Commentf("snippet: %s", si.Snippet()))
+	c.Assert(si.Close(), gc.IsNil)
+}
+
+func (s *SuiteBase) TestCursorPagination(c *gc.C) {
+	var (
+		numDocs = 25
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		expIDs = append(expIDs, id)
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: "Ovidius poeta in terra pontica",
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(numDocs-i)), gc.IsNil)
+	}
+
+	// Limit ごとにカーソルで続きのページを取得する
+	var (
+		got    []uuid.UUID
+		cursor string
+		pages  int
+	)
+	for {
+		it, err := s.idx.Search(index.Query{Expression: "poeta", Limit: 10, Cursor: cursor})
+		c.Assert(err, gc.IsNil)
+		ci, ok := it.(index.CursorIterator)
+		c.Assert(ok, gc.Equals, true, gc.Commentf("iterator does not implement index.CursorIterator"))
+		c.Assert(ci.TotalCount(), gc.Equals, uint64(numDocs))
+
+		var page []uuid.UUID
+		for ci.Next() {
+			page = append(page, ci.Document().LinkID)
+			cursor = ci.Cursor()
+		}
+		c.Assert(ci.Error(), gc.IsNil)
+		c.Assert(ci.Close(), gc.IsNil)
+		if len(page) == 0 {
+			break
+		}
+		c.Assert(len(page) <= 10, gc.Equals, true)
+		got = append(got, page...)
+		pages++
+	}
+	c.Assert(pages, gc.Equals, 3)
+	c.Assert(got, gc.DeepEquals, expIDs)
+
+	// カーソルとオフセットは同時に指定できない
+	_, err := s.idx.Search(index.Query{Expression: "poeta", Cursor: cursor, Offset: 1})
+	c.Assert(xerrors.Is(err, index.ErrInvalidCursor), gc.Equals, true, gc.Commentf("err: %v", err))
+
+	// 不正なカーソル
+	_, err = s.idx.Search(index.Query{Expression: "poeta", Cursor: "not a cursor!"})
+	c.Assert(xerrors.Is(err, index.ErrInvalidCursor), gc.Equals, true, gc.Commentf("err: %v", err))
+}
+
+func (s *SuiteBase) TestDelete(c *gc.C) {
+	doc := &index.Document{
+		LinkID:  uuid.New(),
+		URL:     "http://example.com",
+		Title:   "Illustrious examples",
+		Content: "Lorem ipsum dolor",
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+	c.Assert(s.idx.Delete(doc.LinkID), gc.IsNil)
+
+	_, err := s.idx.FindByID(doc.LinkID)
+	c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+
+	it, err := s.idx.Search(index.Query{Expression: "lorem"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.HasLen, 0)
+
+	// 存在しない文書の削除
+	err = s.idx.Delete(doc.LinkID)
+	c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+}
+
+func (s *SuiteBase) TestDeleteMatching(c *gc.C) {
+	var staleIDs, freshIDs []uuid.UUID
+	for n := 0; n < 3; n++ {
+		doc := &index.Document{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica"}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		staleIDs = append(staleIDs, doc.LinkID)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	for n := 0; n < 2; n++ {
+		doc := &index.Document{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica"}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		freshIDs = append(freshIDs, doc.LinkID)
+	}
+
+	// PageRank のみを持つプレースホルダは IndexedBefore の対象外
+	placeholderID := uuid.New()
+	c.Assert(s.idx.UpdateScore(placeholderID, 0.5), gc.IsNil)
+
+	n, err := s.idx.DeleteMatching(index.IndexedBefore(cutoff))
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, len(staleIDs))
+
+	for _, id := range staleIDs {
+		_, err = s.idx.FindByID(id)
+		c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+	}
+	for _, id := range append(freshIDs, placeholderID) {
+		_, err = s.idx.FindByID(id)
+		c.Assert(err, gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{Expression: "poeta"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(len(freshIDs)))
+	c.Assert(iterateDocs(c, it), gc.HasLen, len(freshIDs))
+
+	// 一致する文書がない場合
+	n, err = s.idx.DeleteMatching(func(*index.Document) bool { return false })
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *SuiteBase) TestIndexBatch(c *gc.C) {
+	existing := &index.Document{LinkID: uuid.New(), Title: "Tristia", Content: "Lorem ipsum dolor"}
+	c.Assert(s.idx.Index(existing), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(existing.LinkID, 0.75), gc.IsNil)
+
+	docs := []*index.Document{
+		{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica"},
+		{Content: "document without a link ID"},
+		{LinkID: existing.LinkID, Title: "Tristia", Content: "Ovidius poeta"},
+		{LinkID: uuid.New(), Content: "Lorem ipsum"},
+	}
+	res, err := index.IndexBatch(s.idx, docs)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Processed, gc.Equals, 3)
+
+	// リンク ID のない文書のみが失敗し、残りの文書は追加される
+	c.Assert(res.Errors, gc.HasLen, 1)
+	c.Assert(res.Errors[0].Pos, gc.Equals, 1)
+	c.Assert(xerrors.Is(res.Errors[0], index.ErrMissingLinkID), gc.Equals, true)
+
+	for _, doc := range []*index.Document{docs[0], docs[2], docs[3]} {
+		got, err := s.idx.FindByID(doc.LinkID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got.Content, gc.Equals, doc.Content)
+		c.Assert(got.IndexedAt.IsZero(), gc.Equals, false)
+	}
+
+	// 既存の文書の PageRank は保持される
+	got, err := s.idx.FindByID(existing.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.PageRank, gc.Equals, 0.75)
+
+	it, err := s.idx.Search(index.Query{Expression: "poeta"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.HasLen, 2)
+}
+
+func (s *SuiteBase) TestUpdateScores(c *gc.C) {
+	var (
+		numDocs = 20
+		scores  = make(map[uuid.UUID]float64)
+		expIDs  []uuid.UUID
+	)
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		expIDs = append(expIDs, id)
+		c.Assert(s.idx.Index(&index.Document{LinkID: id, Content: "Ovidius poeta in terra pontica"}), gc.IsNil)
+		scores[id] = float64(numDocs - i)
+	}
+
+	// まだインデックスに存在しない文書のスコアも設定できる
+	placeholderID := uuid.New()
+	scores[placeholderID] = 0.5
+
+	res, err := index.UpdateScores(s.idx, scores)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Processed, gc.Equals, numDocs+1)
+	c.Assert(res.Errors, gc.HasLen, 0)
+
+	got, err := s.idx.FindByID(placeholderID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.PageRank, gc.Equals, 0.5)
+
+	it, err := s.idx.Search(index.Query{Expression: "poeta"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, expIDs)
+}
+
+func (s *SuiteBase) TestRestore(c *gc.C) {
+	r, ok := s.idx.(index.Restorer)
+	if !ok {
+		c.Skip("indexer does not implement index.Restorer")
+	}
+
+	existing := &index.Document{LinkID: uuid.New(), Content: "Lorem ipsum dolor"}
+	c.Assert(s.idx.Index(existing), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(existing.LinkID, 0.75), gc.IsNil)
+
+	indexedAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	docs := []*index.Document{
+		{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica", IndexedAt: indexedAt, PageRank: 0.5},
+		{Content: "document without a link ID"},
+		{LinkID: existing.LinkID, Content: "Ovidius poeta", IndexedAt: indexedAt, PageRank: 0.25},
+	}
+	res, err := r.Restore(docs)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Processed, gc.Equals, 2)
+	c.Assert(res.Errors, gc.HasLen, 1)
+	c.Assert(res.Errors[0].Pos, gc.Equals, 1)
+
+	// Index と異なり、IndexedAt と PageRank は文書の値がそのまま保存される
+	for _, doc := range []*index.Document{docs[0], docs[2]} {
+		got, err := s.idx.FindByID(doc.LinkID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got.Content, gc.Equals, doc.Content)
+		c.Assert(got.IndexedAt.Equal(indexedAt), gc.Equals, true, gc.Commentf("got %v", got.IndexedAt))
+		c.Assert(got.PageRank, gc.Equals, doc.PageRank)
+	}
+
+	it, err := s.idx.Search(index.Query{Expression: "poeta"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, []uuid.UUID{docs[0].LinkID, docs[2].LinkID})
+}
+
+func (s *SuiteBase) TestScan(c *gc.C) {
+	sc, ok := s.idx.(index.Scanner)
+	if !ok {
+		c.Skip("indexer does not implement index.Scanner")
+	}
+
+	expIDs := make(map[uuid.UUID]bool)
+	for n := 0; n < 5; n++ {
+		doc := &index.Document{LinkID: uuid.New(), Content: fmt.Sprintf("scanned document %d", n)}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		expIDs[doc.LinkID] = true
+	}
+	placeholder := uuid.New()
+	c.Assert(s.idx.UpdateScore(placeholder, 0.5), gc.IsNil)
+	expIDs[placeholder] = true
+
+	// fn からインデクサを読み書きしてもデッドロックしない
+	gotIDs := make(map[uuid.UUID]bool)
+	err := sc.Scan(context.Background(), func(doc *index.Document) error {
+		gotIDs[doc.LinkID] = true
+		if _, err := s.idx.FindByID(doc.LinkID); err != nil {
+			return err
+		}
+		return s.idx.UpdateScore(doc.LinkID, 0.25)
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotIDs, gc.DeepEquals, expIDs)
+
+	doc, err := s.idx.FindByID(placeholder)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.PageRank, gc.Equals, 0.25)
+
+	// fn が返したエラーで走査を中断する
+	var (
+		calls   int
+		errStop = xerrors.New("stop")
+	)
+	err = sc.Scan(context.Background(), func(*index.Document) error {
+		calls++
+		return errStop
+	})
+	c.Assert(xerrors.Is(err, errStop), gc.Equals, true)
+	c.Assert(calls, gc.Equals, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = sc.Scan(ctx, func(*index.Document) error { return nil })
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true)
+}
+
+func (s *SuiteBase) TestDocumentLanguage(c *gc.C) {
+	specs := []struct {
+		doc *index.Document
+		exp string
+	}{
+		{&index.Document{LinkID: uuid.New(), Title: "東京タワー", Content: "東京タワーは東京都港区にある電波塔です。"}, "ja"},
+		{&index.Document{LinkID: uuid.New(), Title: "Runners", Content: "The runners were running through the park"}, "en"},
+		// 明示的に指定された言語は推定で上書きされない
+		{&index.Document{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica", Language: "la"}, "la"},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.doc.Content)
+		c.Assert(s.idx.Index(spec.doc), gc.IsNil)
+		c.Assert(spec.doc.Language, gc.Equals, spec.exp)
+
+		got, err := s.idx.FindByID(spec.doc.LinkID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got.Language, gc.Equals, spec.exp)
+	}
+}
+
+func (s *SuiteBase) TestRankingBlendsSignals(c *gc.C) {
+	relevant := &index.Document{
+		LinkID:  uuid.New(),
+		Title:   "Bananas",
+		Content: "banana banana banana banana",
+	}
+	popular := &index.Document{
+		LinkID:  uuid.New(),
+		Title:   "Fruit market",
+		Content: "apples, oranges, pears and a banana are sold at the market every morning",
+	}
+	for _, doc := range []*index.Document{relevant, popular} {
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+	}
+	c.Assert(s.idx.UpdateScore(relevant.LinkID, 0.1), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(popular.LinkID, 0.9), gc.IsNil)
+
+	// Ranking を指定しない場合は PageRank の順
+	it, err := s.idx.Search(index.Query{Expression: "banana"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, []uuid.UUID{popular.LinkID, relevant.LinkID})
+
+	// 既定の重みでは関連度の高い文書が PageRank の高い文書より先に並ぶ
+	it, err = s.idx.Search(index.Query{Expression: "banana", Ranking: &index.Ranking{}})
+	c.Assert(err, gc.IsNil)
+	si, ok := it.(index.ScoredIterator)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("iterator does not implement index.ScoredIterator"))
+	c.Assert(si.TotalCount(), gc.Equals, uint64(2))
+	c.Assert(si.Next(), gc.Equals, true)
+	c.Assert(si.Document().LinkID, gc.Equals, relevant.LinkID)
+	c.Assert(si.Relevance() > 0, gc.Equals, true)
+	c.Assert(si.Next(), gc.Equals, true)
+	c.Assert(si.Document().LinkID, gc.Equals, popular.LinkID)
+	c.Assert(si.Next(), gc.Equals, false)
+	c.Assert(si.Close(), gc.IsNil)
+
+	// クエリごとに重みを変更できる
+	it, err = s.idx.Search(index.Query{Expression: "banana", Ranking: &index.Ranking{PageRankWeight: 1}})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, []uuid.UUID{popular.LinkID, relevant.LinkID})
+}
+
+func (s *SuiteBase) TestRankingCursorPagination(c *gc.C) {
+	numDocs := 7
+	for i := 0; i < numDocs; i++ {
+		id := uuid.New()
+		doc := &index.Document{
+			LinkID:  id,
+			Title:   fmt.Sprintf("doc with ID %s", id.String()),
+			Content: strings.Repeat("poeta ", i+1),
+		}
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(i%3)), gc.IsNil)
+	}
+
+	q := index.Query{Expression: "poeta", Ranking: &index.Ranking{}}
+	it, err := s.idx.Search(q)
+	c.Assert(err, gc.IsNil)
+	expIDs := iterateDocs(c, it)
+	c.Assert(expIDs, gc.HasLen, numDocs)
+
+	// カーソルで取得したページを連結すると、まとめて取得した結果と同じ順になる
+	var got []uuid.UUID
+	for q.Limit = 3; ; {
+		it, err := s.idx.Search(q)
+		c.Assert(err, gc.IsNil)
+		ci, ok := it.(index.CursorIterator)
+		c.Assert(ok, gc.Equals, true, gc.Commentf("iterator does not implement index.CursorIterator"))
+		c.Assert(ci.TotalCount(), gc.Equals, uint64(numDocs))
+
+		var page int
+		for ci.Next() {
+			got = append(got, ci.Document().LinkID)
+			q.Cursor = ci.Cursor()
+			page++
+		}
+		c.Assert(ci.Error(), gc.IsNil)
+		c.Assert(ci.Close(), gc.IsNil)
+		if page == 0 {
+			break
+		}
+	}
+	c.Assert(got, gc.DeepEquals, expIDs)
+
+	// オフセットも並べ替えた結果に適用される
+	it, err = s.idx.Search(index.Query{Expression: "poeta", Ranking: &index.Ranking{}, Offset: 5})
+	c.Assert(err, gc.IsNil)
+	c.Assert(iterateDocs(c, it), gc.DeepEquals, expIDs[5:])
+}
+
+// iterateDocs はイテレータが返すすべての文書のリンク ID を返す
+func iterateDocs(c *gc.C, it index.Iterator) []uuid.UUID {
+	var seen []uuid.UUID
+	for it.Next() {
+		seen = append(seen, it.Document().LinkID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	return seen
+}
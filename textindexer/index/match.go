@@ -0,0 +1,32 @@
+package index
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// CollectMatching は pred が true を返す文書を idx.DeleteMatching で削除し、削除対象となった文書のリンク ID を返す。
+// 削除した文書に対応する状態を保持するデコレータが使用する。
+// 削除に失敗した場合は、選択された文書のうちインデックスから削除されたもののリンク ID のみを返す。
+func CollectMatching(idx Indexer, pred Predicate) ([]uuid.UUID, int, error) {
+	// DeleteMatching は Predicate を並行して呼び出さないため、排他制御なしに収集できる
+	var matched []uuid.UUID
+	count, err := idx.DeleteMatching(func(doc *Document) bool {
+		if !pred(doc) {
+			return false
+		}
+		matched = append(matched, doc.LinkID)
+		return true
+	})
+	if err != nil {
+		// 削除に失敗したバッチの文書はインデックスに残っているため、デコレータの状態から取り除かない
+		deleted := matched[:0]
+		for _, id := range matched {
+			if _, findErr := idx.FindByID(id); xerrors.Is(findErr, ErrNotFound) {
+				deleted = append(deleted, id)
+			}
+		}
+		matched = deleted
+	}
+	return matched, count, err
+}
@@ -0,0 +1,157 @@
+package querylang
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Field は語の検索対象となる文書のフィールド
+type Field uint8
+
+const (
+	// FieldAny はタイトルと本文の両方を対象とする
+	FieldAny Field = iota
+	FieldTitle
+	FieldContent
+
+	// FieldURL は URL 全体と比較する。前方一致のワイルドカードを指定できる。
+	FieldURL
+
+	// FieldSite は URL のホスト名と比較する。サブドメインも一致する。
+	FieldSite
+)
+
+var fieldNames = map[string]Field{
+	"title":   FieldTitle,
+	"content": FieldContent,
+	"url":     FieldURL,
+	"site":    FieldSite,
+	"host":    FieldSite,
+}
+
+func (f Field) String() string {
+	switch f {
+	case FieldTitle:
+		return "title"
+	case FieldContent:
+		return "content"
+	case FieldURL:
+		return "url"
+	case FieldSite:
+		return "site"
+	default:
+		return ""
+	}
+}
+
+// Node は構文木のノード。And、Or、Not、Term、Phrase のいずれか。
+type Node interface {
+	String() string
+}
+
+// And はすべての子ノードに一致する文書に一致する
+type And struct {
+	Nodes []Node
+}
+
+// Or はいずれかの子ノードに一致する文書に一致する
+type Or struct {
+	Nodes []Node
+}
+
+// Not は子ノードに一致しない文書に一致する
+type Not struct {
+	Node Node
+}
+
+// Term は単語に一致する。Prefix が true の場合は Value で始まる語に一致する。
+type Term struct {
+	Field  Field
+	Value  string
+	Prefix bool
+}
+
+// Phrase は語の並びに一致する
+type Phrase struct {
+	Field Field
+	Value string
+}
+
+func (n *And) String() string { return joinNodes("AND", n.Nodes) }
+func (n *Or) String() string  { return joinNodes("OR", n.Nodes) }
+func (n *Not) String() string { return "(NOT " + n.Node.String() + ")" }
+
+func (n *Term) String() string {
+	s := fieldPrefix(n.Field) + n.Value
+	if n.Prefix {
+		s += "*"
+	}
+	return s
+}
+
+func (n *Phrase) String() string {
+	return fieldPrefix(n.Field) + strconv.Quote(n.Value)
+}
+
+// Format は Parse で同じ構文木に戻る検索式を返す。String と異なり、検索式の構文で記述される。
+func Format(n Node) string {
+	switch n := n.(type) {
+	case *And:
+		return formatNodes(" AND ", n.Nodes)
+	case *Or:
+		return formatNodes(" OR ", n.Nodes)
+	case *Not:
+		return "-" + Format(n.Node)
+	case *Phrase:
+		return fieldPrefix(n.Field) + `"` + n.Value + `"`
+	default:
+		return n.String()
+	}
+}
+
+func formatNodes(op string, nodes []Node) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = Format(n)
+	}
+	return "(" + strings.Join(parts, op) + ")"
+}
+
+func joinNodes(op string, nodes []Node) string {
+	var sb strings.Builder
+	sb.WriteString("(" + op)
+	for _, n := range nodes {
+		sb.WriteString(" " + n.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func fieldPrefix(f Field) string {
+	if f == FieldAny {
+		return ""
+	}
+	return f.String() + ":"
+}
+
+// Sites は URL に site: で一致するドメインの一覧を返す。
+// 例えば http://blog.example.com/ に対しては blog.example.com、example.com、com を返す。
+func Sites(rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return nil
+	}
+
+	sites := []string{host}
+	for i, r := range host {
+		if r == '.' && i+1 < len(host) {
+			sites = append(sites, host[i+1:])
+		}
+	}
+	return sites
+}
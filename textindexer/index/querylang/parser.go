@@ -0,0 +1,373 @@
+// Package querylang は、真偽演算子とフィールド指定を含む検索式を構文木に変換する。
+//
+// 構文の例:
+//
+//	title:golang AND (concurrency OR goroutines) -"hello world" site:example.com
+//
+// 空白で区切られた式は AND で結合される。演算子 AND、OR、NOT は大文字で記述し、
+// NOT の代わりに語の直前の - を使用できる。優先順位は NOT、AND、OR の順に高い。
+// フィールド title、content、url、site (別名 host) は語、フレーズ、括弧で囲まれた式に指定できる。
+// 語の末尾の * は前方一致を表す。
+package querylang
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError は検索式の構文の誤りを表す
+type SyntaxError struct {
+	// Offset は誤りのある位置の式の先頭からのバイト数
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// Parse は検索式を構文木に変換する。構文に誤りがある場合は *SyntaxError を返す。
+func Parse(expr string) (Node, error) {
+	p := &parser{lex: lexer{input: expr}}
+	p.advance()
+	if p.tok.kind == tokEOF {
+		return nil, &SyntaxError{Offset: 0, Msg: "empty query"}
+	}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) advance() {
+	p.tok = p.lex.next()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokError {
+		return &SyntaxError{Offset: p.tok.offset, Msg: p.tok.value}
+	}
+	if p.tok.kind == tokEOF {
+		return &SyntaxError{Offset: p.tok.offset, Msg: "unexpected end of query"}
+	}
+	return &SyntaxError{Offset: p.tok.offset, Msg: fmt.Sprintf("unexpected %s", p.tok)}
+}
+
+// parseOr: or = and { "OR" and }
+func (p *parser) parseOr() (Node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{first}
+	for p.tok.kind == tokOr {
+		p.advance()
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return &Or{Nodes: nodes}, nil
+}
+
+// parseAnd: and = unary { ["AND"] unary }
+func (p *parser) parseAnd() (Node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{first}
+	for {
+		switch p.tok.kind {
+		case tokAnd:
+			p.advance()
+		case tokWord, tokPhrase, tokField, tokNot, tokLParen:
+			// 暗黙の AND
+		default:
+			if len(nodes) == 1 {
+				return first, nil
+			}
+			return &And{Nodes: nodes}, nil
+		}
+
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+// parseUnary: unary = ("NOT" | "-") unary | primary
+func (p *parser) parseUnary() (Node, error) {
+	if p.tok.kind != tokNot {
+		return p.parsePrimary(FieldAny)
+	}
+
+	p.advance()
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &Not{Node: n}, nil
+}
+
+// parsePrimary: primary = [field ":"] ( word | phrase | "(" or ")" )
+func (p *parser) parsePrimary(field Field) (Node, error) {
+	switch p.tok.kind {
+	case tokField:
+		f, known := fieldNames[strings.ToLower(p.tok.value)]
+		if !known {
+			return nil, &SyntaxError{Offset: p.tok.offset, Msg: fmt.Sprintf("unknown field %q", p.tok.value)}
+		}
+		p.advance()
+		return p.parsePrimary(f)
+	case tokWord:
+		tok := p.tok
+		p.advance()
+		return makeTerm(field, tok)
+	case tokPhrase:
+		tok := p.tok
+		p.advance()
+		if strings.TrimSpace(tok.value) == "" {
+			return nil, &SyntaxError{Offset: tok.offset, Msg: "empty phrase"}
+		}
+		return &Phrase{Field: field, Value: tok.value}, nil
+	case tokLParen:
+		open := p.tok.offset
+		p.advance()
+		if p.tok.kind == tokRParen {
+			return nil, &SyntaxError{Offset: open, Msg: "empty group"}
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			if p.tok.kind == tokEOF {
+				return nil, &SyntaxError{Offset: open, Msg: "unclosed parenthesis"}
+			}
+			return nil, p.unexpected()
+		}
+		p.advance()
+		return scope(n, field), nil
+	default:
+		return nil, p.unexpected()
+	}
+}
+
+func makeTerm(field Field, tok token) (Node, error) {
+	value, prefix := tok.value, false
+	if strings.HasSuffix(value, "*") {
+		value, prefix = strings.TrimSuffix(value, "*"), true
+	}
+	if idx := strings.IndexByte(value, '*'); idx != -1 {
+		return nil, &SyntaxError{Offset: tok.offset + idx, Msg: "wildcards are only supported at the end of a term"}
+	}
+	if value == "" {
+		return nil, &SyntaxError{Offset: tok.offset, Msg: "wildcard without a prefix"}
+	}
+	if prefix && field == FieldSite {
+		return nil, &SyntaxError{Offset: tok.offset, Msg: "wildcards are not supported for site"}
+	}
+	return &Term{Field: field, Value: value, Prefix: prefix}, nil
+}
+
+// scope は括弧で囲まれた式のうちフィールドが指定されていない語とフレーズに field を設定する。
+// 括弧の中で明示的に指定されたフィールドが優先される。
+func scope(n Node, field Field) Node {
+	if field == FieldAny {
+		return n
+	}
+
+	switch n := n.(type) {
+	case *And:
+		for _, child := range n.Nodes {
+			scope(child, field)
+		}
+	case *Or:
+		for _, child := range n.Nodes {
+			scope(child, field)
+		}
+	case *Not:
+		scope(n.Node, field)
+	case *Term:
+		if n.Field == FieldAny {
+			n.Field = field
+		}
+	case *Phrase:
+		if n.Field == FieldAny {
+			n.Field = field
+		}
+	}
+	return n
+}
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokError
+	tokWord
+	tokPhrase
+	tokField
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	offset int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokPhrase:
+		return fmt.Sprintf("phrase %q", t.value)
+	case tokField:
+		return fmt.Sprintf("field %q", t.value+":")
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+type lexer struct {
+	input string
+	pos   int
+
+	// afterField はフィールド名の直後であることを表す。値に含まれる : はフィールドの区切りとして扱わない。
+	afterField bool
+}
+
+func (l *lexer) next() token {
+	for l.pos < len(l.input) {
+		r, size := l.peek()
+		if !isSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, offset: l.pos}
+	}
+
+	start := l.pos
+	afterField := l.afterField
+	l.afterField = false
+	switch c := l.input[l.pos]; c {
+	case '(':
+		l.pos++
+		return token{kind: tokLParen, value: "(", offset: start}
+	case ')':
+		l.pos++
+		return token{kind: tokRParen, value: ")", offset: start}
+	case '"':
+		return l.phrase()
+	case '-':
+		// 語、フレーズ、括弧の直前の - は NOT を表す
+		l.pos++
+		if l.pos < len(l.input) && !l.atSpace() && l.input[l.pos] != ')' {
+			return token{kind: tokNot, value: "-", offset: start}
+		}
+		return token{kind: tokError, value: "dangling '-'", offset: start}
+	}
+
+	for l.pos < len(l.input) {
+		r, size := l.peek()
+		if isDelimiter(r) {
+			break
+		}
+		if r == ':' && !afterField && isFieldName(l.input[start:l.pos]) {
+			tok := token{kind: tokField, value: l.input[start:l.pos], offset: start}
+			l.pos++
+			if l.pos >= len(l.input) || l.atSpace() || l.input[l.pos] == ')' {
+				return token{kind: tokError, value: fmt.Sprintf("missing value for field %q", tok.value), offset: start}
+			}
+			l.afterField = true
+			return tok
+		}
+		l.pos += size
+	}
+
+	word := l.input[start:l.pos]
+	switch word {
+	case "AND":
+		return token{kind: tokAnd, value: word, offset: start}
+	case "OR":
+		return token{kind: tokOr, value: word, offset: start}
+	case "NOT":
+		return token{kind: tokNot, value: word, offset: start}
+	}
+	return token{kind: tokWord, value: word, offset: start}
+}
+
+func (l *lexer) phrase() token {
+	start := l.pos
+	end := strings.IndexByte(l.input[start+1:], '"')
+	if end == -1 {
+		l.pos = len(l.input)
+		return token{kind: tokError, value: "unterminated phrase", offset: start}
+	}
+
+	l.pos = start + 1 + end + 1
+	return token{kind: tokPhrase, value: l.input[start+1 : start+1+end], offset: start}
+}
+
+// isFieldName は : の前の文字列がフィールド名として解釈されるかを返す。
+// 英字のみからなる場合にフィールド名とみなすため、10:30 のような語はそのまま検索される。
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// peek は現在位置の文字とそのバイト数を返す
+func (l *lexer) peek() (rune, int) {
+	return utf8.DecodeRuneInString(l.input[l.pos:])
+}
+
+// atSpace は現在位置の文字が空白かを返す。全角空白などの Unicode の空白も含む。
+func (l *lexer) atSpace() bool {
+	r, _ := l.peek()
+	return isSpace(r)
+}
+
+func isDelimiter(r rune) bool {
+	return isSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+func isSpace(r rune) bool {
+	return unicode.IsSpace(r)
+}
@@ -0,0 +1,114 @@
+package querylang
+
+import (
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = gc.Suite(new(ParserTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type ParserTestSuite struct{}
+
+func (s *ParserTestSuite) TestParse(c *gc.C) {
+	specs := []struct {
+		expr string
+		exp  string
+	}{
+		{expr: "golang", exp: "golang"},
+		{expr: "golang concurrency", exp: "(AND golang concurrency)"},
+		{expr: "a OR b c", exp: "(OR a (AND b c))"},
+		{expr: "a AND b OR c AND d", exp: "(OR (AND a b) (AND c d))"},
+		{expr: "NOT a -b", exp: "(AND (NOT a) (NOT b))"},
+		{expr: `-(a OR b) -"c d"`, exp: `(AND (NOT (OR a b)) (NOT "c d"))`},
+		{expr: `"hello world"`, exp: `"hello world"`},
+		{expr: "go*", exp: "go*"},
+		{expr: "foo-bar 10:30", exp: "(AND foo-bar 10:30)"},
+		{expr: "東京　大阪", exp: "(AND 東京 大阪)"},
+		{expr: "-東京　title:大阪　OR　京都", exp: "(OR (AND (NOT 東京) title:大阪) 京都)"},
+		{expr: "title:a:b", exp: "title:a:b"},
+		{expr: "TITLE:Golang url:https://example.com/blog/* host:Example.com", exp: "(AND title:Golang url:https://example.com/blog/* site:Example.com)"},
+		{expr: `content:(a OR title:b -"c d")`, exp: `(OR content:a (AND title:b (NOT content:"c d")))`},
+		{
+			expr: `title:golang AND (concurrency OR goroutines) -"hello world" site:example.com`,
+			exp:  `(AND title:golang (OR concurrency goroutines) (NOT "hello world") site:example.com)`,
+		},
+	}
+
+	for i, spec := range specs {
+		c.Logf("[spec %d] %s", i, spec.expr)
+		n, err := Parse(spec.expr)
+		c.Assert(err, gc.IsNil)
+		c.Assert(n.String(), gc.Equals, spec.exp)
+	}
+
+	// 前方一致の URL の末尾の * は Prefix として解析される
+	n, err := Parse("url:https://example.com/*")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.DeepEquals, &Term{Field: FieldURL, Value: "https://example.com/", Prefix: true})
+}
+
+func (s *ParserTestSuite) TestFormat(c *gc.C) {
+	specs := []struct {
+		expr string
+		exp  string
+	}{
+		{expr: "golang", exp: "golang"},
+		{expr: "a OR b c", exp: "(a OR (b AND c))"},
+		{expr: `-(a OR b) -"c d"`, exp: `(-(a OR b) AND -"c d")`},
+		{expr: `content:(a OR title:b -"c d") go*`, exp: `((content:a OR (title:b AND -content:"c d")) AND go*)`},
+		{expr: "url:https://example.com/* host:Example.com", exp: "(url:https://example.com/* AND site:Example.com)"},
+	}
+
+	for i, spec := range specs {
+		c.Logf("[spec %d] %s", i, spec.expr)
+		n, err := Parse(spec.expr)
+		c.Assert(err, gc.IsNil)
+		c.Assert(Format(n), gc.Equals, spec.exp)
+
+		// 整形した検索式は同じ構文木に解析される
+		again, err := Parse(Format(n))
+		c.Assert(err, gc.IsNil)
+		c.Assert(again, gc.DeepEquals, n)
+	}
+}
+
+func (s *ParserTestSuite) TestSyntaxErrors(c *gc.C) {
+	specs := []struct {
+		expr   string
+		offset int
+		msg    string
+	}{
+		{expr: "", offset: 0, msg: "empty query"},
+		{expr: "   ", offset: 0, msg: "empty query"},
+		{expr: `golang "hello`, offset: 7, msg: "unterminated phrase"},
+		{expr: "(a OR b", offset: 0, msg: "unclosed parenthesis"},
+		{expr: "a OR b)", offset: 6, msg: `unexpected ")"`},
+		{expr: "a OR", offset: 4, msg: "unexpected end of query"},
+		{expr: "AND a", offset: 0, msg: `unexpected "AND"`},
+		{expr: "a ()", offset: 2, msg: "empty group"},
+		{expr: "a - b", offset: 2, msg: "dangling '-'"},
+		{expr: "author:pike", offset: 0, msg: `unknown field "author"`},
+		{expr: "title: go", offset: 0, msg: `missing value for field "title"`},
+		{expr: "go*lang", offset: 2, msg: "wildcards are only supported at the end of a term"},
+		{expr: "a *", offset: 2, msg: "wildcard without a prefix"},
+		{expr: "site:example*", offset: 5, msg: "wildcards are not supported for site"},
+		{expr: `""`, offset: 0, msg: "empty phrase"},
+	}
+
+	for i, spec := range specs {
+		c.Logf("[spec %d] %q", i, spec.expr)
+		_, err := Parse(spec.expr)
+		var synErr *SyntaxError
+		c.Assert(xerrors.As(err, &synErr), gc.Equals, true, gc.Commentf("err: %v", err))
+		c.Assert(synErr.Msg, gc.Equals, spec.msg)
+		c.Assert(synErr.Offset, gc.Equals, spec.offset)
+	}
+}
+
+func (s *ParserTestSuite) TestSites(c *gc.C) {
+	c.Assert(Sites("https://Blog.Example.com:8080/a"), gc.DeepEquals, []string{"blog.example.com", "example.com", "com"})
+	c.Assert(Sites("/relative"), gc.HasLen, 0)
+}
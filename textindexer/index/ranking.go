@@ -0,0 +1,95 @@
+package index
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultFreshnessHalfLife は Ranking.Freshness が未設定の場合に、鮮度が半分になるまでの期間
+const DefaultFreshnessHalfLife = 30 * 24 * time.Hour
+
+// DefaultRanking は Ranking の重みがすべて 0 の場合に使用される重み
+var DefaultRanking = Ranking{
+	RelevanceWeight: 1,
+	PageRankWeight:  0.5,
+	FreshnessWeight: 0.2,
+}
+
+// Ranking は検索結果の並び順を決めるスコアの計算方法を指定する。
+// スコアは検索語との関連度、PageRank、IndexedAt からの経過時間による鮮度を重み付けして合計した値となる。
+// 関連度と PageRank はスコアを計算する候補のうち最大の値で割って [0, 1] に正規化してから関数に渡される。
+type Ranking struct {
+	RelevanceWeight float64
+	PageRankWeight  float64
+	FreshnessWeight float64
+
+	// Relevance と PageRank は正規化された値を変換する関数。nil の場合は値をそのまま使用する。
+	Relevance func(v float64) float64
+	PageRank  func(v float64) float64
+
+	// Freshness は文書の経過時間を [0, 1] の鮮度に変換する関数。
+	// nil の場合は DefaultFreshnessHalfLife の ExponentialDecay が使用される。
+	Freshness func(age time.Duration) float64
+}
+
+// WithDefaults は未設定のフィールドを既定値で補った Ranking を返す
+func (r Ranking) WithDefaults() Ranking {
+	if r.RelevanceWeight == 0 && r.PageRankWeight == 0 && r.FreshnessWeight == 0 {
+		r.RelevanceWeight = DefaultRanking.RelevanceWeight
+		r.PageRankWeight = DefaultRanking.PageRankWeight
+		r.FreshnessWeight = DefaultRanking.FreshnessWeight
+	}
+	if r.Relevance == nil {
+		r.Relevance = identity
+	}
+	if r.PageRank == nil {
+		r.PageRank = identity
+	}
+	if r.Freshness == nil {
+		r.Freshness = ExponentialDecay(DefaultFreshnessHalfLife)
+	}
+	return r
+}
+
+// Score は正規化された関連度 relevance と PageRank pageRank、および時刻 now における文書の経過時間からスコアを計算する。
+// r は WithDefaults で補われている必要がある。IndexedAt が未設定の文書の鮮度は 0 となる。
+func (r Ranking) Score(relevance, pageRank float64, indexedAt, now time.Time) float64 {
+	var freshness float64
+	if !indexedAt.IsZero() {
+		freshness = r.Freshness(now.Sub(indexedAt))
+	}
+	return r.RelevanceWeight*r.Relevance(relevance) +
+		r.PageRankWeight*r.PageRank(pageRank) +
+		r.FreshnessWeight*freshness
+}
+
+func identity(v float64) float64 { return v }
+
+// ExponentialDecay は経過時間が halfLife ごとに鮮度が半分になる関数を返す
+func ExponentialDecay(halfLife time.Duration) func(time.Duration) float64 {
+	return func(age time.Duration) float64 {
+		if age <= 0 {
+			return 1
+		}
+		return math.Exp2(-float64(age) / float64(halfLife))
+	}
+}
+
+// LinearDecay は経過時間が maxAge に達するまで鮮度が直線的に 0 まで減少する関数を返す
+func LinearDecay(maxAge time.Duration) func(time.Duration) float64 {
+	return func(age time.Duration) float64 {
+		switch {
+		case age <= 0:
+			return 1
+		case age >= maxAge:
+			return 0
+		default:
+			return 1 - float64(age)/float64(maxAge)
+		}
+	}
+}
+
+// LogScale は正規化された値の小さな差を強調する関数。log2(1+v) を返す。
+func LogScale(v float64) float64 {
+	return math.Log2(1 + v)
+}
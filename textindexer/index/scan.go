@@ -0,0 +1,26 @@
+package index
+
+import (
+	"context"
+	"golang.org/x/xerrors"
+)
+
+// Scanner は文書を読み取り専用で列挙できる Indexer
+type Scanner interface {
+	Indexer
+
+	// Scan はすべての文書について fn を呼び出す。fn の呼び出し中はインデックスのロックを保持しないため、
+	// fn から Indexer の操作を呼び出してもよい。fn がエラーを返した場合、走査を中断してそのエラーを返す。
+	// 走査中に追加、更新、削除された文書が fn に渡されるかどうかは実装に依存する。
+	Scan(ctx context.Context, fn func(doc *Document) error) error
+}
+
+// Scan は idx のすべての文書について fn を呼び出す。idx が Scanner を実装しない場合は ErrScanUnsupported を返す。
+// Indexer をラップするデコレータは Scan を実装し、ラップしたインデクサに転送する必要がある。
+func Scan(ctx context.Context, idx Indexer, fn func(doc *Document) error) error {
+	s, ok := idx.(Scanner)
+	if !ok {
+		return xerrors.Errorf("scan: %T: %w", idx, ErrScanUnsupported)
+	}
+	return s.Scan(ctx, fn)
+}
@@ -0,0 +1,47 @@
+package index
+
+var (
+	_ CursorIterator     = IteratorWrapper{}
+	_ ScoredIterator     = IteratorWrapper{}
+	_ SnippetIterator    = IteratorWrapper{}
+	_ SuggestionIterator = IteratorWrapper{}
+)
+
+// IteratorWrapper は Iterator をラップするイテレータに埋め込む型。
+// ラップした Iterator が CursorIterator、ScoredIterator、SnippetIterator、SuggestionIterator を実装する場合は
+// それぞれのメソッドを転送し、実装しない場合はゼロ値を返す。埋め込むイテレータは変更するメソッドのみを定義する。
+type IteratorWrapper struct {
+	Iterator
+}
+
+// Cursor はラップした Iterator のカーソルを返す
+func (w IteratorWrapper) Cursor() string {
+	if ci, ok := w.Iterator.(CursorIterator); ok {
+		return ci.Cursor()
+	}
+	return ""
+}
+
+// Relevance はラップした Iterator の現在の文書の関連度を返す
+func (w IteratorWrapper) Relevance() float64 {
+	if si, ok := w.Iterator.(ScoredIterator); ok {
+		return si.Relevance()
+	}
+	return 0
+}
+
+// Snippet はラップした Iterator の現在の文書の抜粋を返す
+func (w IteratorWrapper) Snippet() string {
+	if si, ok := w.Iterator.(SnippetIterator); ok {
+		return si.Snippet()
+	}
+	return ""
+}
+
+// Suggestion はラップした Iterator の修正候補を返す
+func (w IteratorWrapper) Suggestion() string {
+	if si, ok := w.Iterator.(SuggestionIterator); ok {
+		return si.Suggestion()
+	}
+	return ""
+}
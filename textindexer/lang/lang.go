@@ -0,0 +1,123 @@
+// Package lang はテキストの言語を推定する。
+// 文字の種類から CJK の各言語とキリル文字の言語を判定し、ラテン文字のテキストは
+// 機能語 (ストップワード) の出現数から言語を推定する。
+package lang
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"strings"
+	"unicode"
+)
+
+// ISO 639-1 の言語コード
+const (
+	Unknown  = ""
+	Japanese = "ja"
+	Chinese  = "zh"
+	Korean   = "ko"
+	English  = "en"
+	German   = "de"
+	French   = "fr"
+	Spanish  = "es"
+	Russian  = "ru"
+)
+
+// minHits はラテン文字の言語を確かに判定するために必要な機能語の出現数。英語以外の言語はこの数に満たない場合は選ばれない。
+const minHits = 2
+
+// functionWords はラテン文字の言語ごとの頻出する機能語。判定の優先順に並ぶ。
+var functionWords = []struct {
+	lang  string
+	words map[string]struct{}
+}{
+	{English, wordSet("the and of to is are was were that this with for from have has not you they it which")},
+	{German, wordSet("der die das und ist nicht ein eine mit auf dem den des sich auch ich sie wir für von zu")},
+	{French, wordSet("le la les et est une des du dans que qui pour pas sur au avec ce il elle nous vous")},
+	{Spanish, wordSet("el la los las y es una del que por para con no se su al lo como pero más")},
+}
+
+func wordSet(words string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, w := range strings.Fields(words) {
+		set[w] = struct{}{}
+	}
+	return set
+}
+
+// Detect はテキストの言語コードを返す。文字を含まない場合は Unknown を返す。
+// ラテン文字のテキストで他の言語の特徴が十分に見られない場合は English を返す。
+func Detect(text string) string {
+	l, _ := DetectWithConfidence(text)
+	return l
+}
+
+// DetectWithConfidence は Detect と同じ言語コードと、その判定が確かかどうかを返す。
+// 文字の種類で判定した場合と、ラテン文字のテキストに機能語が minHits 個以上含まれる場合に true となる。
+// 短い検索語のように特徴の少ないテキストでは false となる。
+func DetectWithConfidence(text string) (string, bool) {
+	var kana, hangul, han, cyrillic, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	// CJK の 1 文字はラテン文字の数文字に相当する情報を持つため、重みを付けて比較する
+	cjk := kana + hangul + han
+	switch {
+	case cjk == 0 && cyrillic == 0 && latin == 0:
+		return Unknown, false
+	case cjk*3 >= latin && cjk >= cyrillic:
+		switch {
+		case kana != 0:
+			// 漢字とかなが混在するテキストは日本語
+			return Japanese, true
+		case hangul >= han:
+			return Korean, true
+		default:
+			return Chinese, true
+		}
+	case cyrillic >= latin:
+		return Russian, true
+	default:
+		return detectLatin(text)
+	}
+}
+
+// OfDocument は文書の言語を返す。doc.Language が空の場合はタイトルと本文から推定する。
+func OfDocument(doc *index.Document) string {
+	if doc.Language != "" {
+		return doc.Language
+	}
+	return Detect(doc.Title + "\n" + doc.Content)
+}
+
+func detectLatin(text string) (string, bool) {
+	hits := make([]int, len(functionWords))
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for i, fw := range functionWords {
+			if _, ok := fw.words[w]; ok {
+				hits[i]++
+			}
+		}
+	}
+
+	best := 0
+	for i := range functionWords[1:] {
+		if n := hits[i+1]; n >= minHits && n > hits[best] {
+			best = i + 1
+		}
+	}
+	return functionWords[best].lang, hits[best] >= minHits
+}
@@ -0,0 +1,68 @@
+package lang
+
+import (
+	gc "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = gc.Suite(new(LangTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type LangTestSuite struct{}
+
+func (s *LangTestSuite) TestDetect(c *gc.C) {
+	specs := []struct {
+		text string
+		exp  string
+	}{
+		{"東京タワーは東京都港区にある電波塔です。", Japanese},
+		{"Go言語で並行処理を書く", Japanese},
+		{"北京是中华人民共和国的首都", Chinese},
+		{"서울은 대한민국의 수도이다", Korean},
+		{"Москва — столица России", Russian},
+		{"The quick brown fox jumps over the lazy dog and runs away from the farmer", English},
+		{"Der schnelle braune Fuchs springt über den faulen Hund und läuft davon", German},
+		{"Le renard brun rapide saute par-dessus le chien paresseux et il est parti", French},
+		{"El rápido zorro marrón salta sobre el perro perezoso y se va por la calle", Spanish},
+
+		// 特徴が少ないラテン文字のテキストは英語とみなす
+		{"Ovidius poeta in terra pontica", English},
+		{"golang", English},
+
+		// 日本語の文中の英単語は判定に影響しない
+		{"Kubernetes のクラスタに Go のアプリケーションをデプロイする方法", Japanese},
+
+		{"", Unknown},
+		{"12345 !?", Unknown},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %q", specIndex, spec.text)
+		c.Assert(Detect(spec.text), gc.Equals, spec.exp)
+	}
+}
+
+func (s *LangTestSuite) TestDetectWithConfidence(c *gc.C) {
+	specs := []struct {
+		text      string
+		exp       string
+		confident bool
+	}{
+		{"東京タワー", Japanese, true},
+		{"The quick brown fox jumps over the lazy dog and runs away from the farmer", English, true},
+		{"Der schnelle braune Fuchs springt über den faulen Hund und läuft davon", German, true},
+
+		// 機能語の少ない短い検索語の判定は確かではない
+		{"Läufer", English, false},
+		{"the park", English, false},
+		{"", Unknown, false},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %q", specIndex, spec.text)
+		l, confident := DetectWithConfidence(spec.text)
+		c.Assert(l, gc.Equals, spec.exp)
+		c.Assert(confident, gc.Equals, spec.confident)
+	}
+}
@@ -0,0 +1,110 @@
+package querylog
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"time"
+)
+
+// LoggedIterator は検索の記録の QueryID を返す Iterator。RecordClick に渡す ID の取得に使用する。
+type LoggedIterator interface {
+	index.Iterator
+
+	QueryID() uuid.UUID
+}
+
+var _ index.Scanner = (*Indexer)(nil)
+
+// Indexer は index.Indexer をラップし、Search の呼び出しを Writer に記録する
+type Indexer struct {
+	index.Indexer
+	w       *Writer
+	onError func(error)
+}
+
+// NewIndexer は idx の検索を w に記録する Indexer を作成する。
+// ログの書き込みに失敗しても検索は失敗せず、onError が nil でなければそのエラーが渡される。
+func NewIndexer(idx index.Indexer, w *Writer, onError func(error)) *Indexer {
+	return &Indexer{Indexer: idx, w: w, onError: onError}
+}
+
+// Search はラップしたインデクサで検索を行い、検索式、種類、オフセット、TotalCount、所要時間を記録する。
+// 返されるイテレータは LoggedIterator を実装する。
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	start := time.Now()
+	it, err := i.Indexer.Search(q)
+
+	r := Record{
+		Kind:       KindSearch,
+		Time:       start,
+		QueryID:    uuid.New(),
+		Expression: q.Expression,
+		Type:       typeName(q.Type),
+		Offset:     q.Offset,
+		Cursor:     q.Cursor != "",
+		Latency:    time.Since(start),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.TotalCount = it.TotalCount()
+	}
+	i.write(r)
+
+	if err != nil {
+		return nil, err
+	}
+	return &loggedIterator{IteratorWrapper: index.IteratorWrapper{Iterator: it}, queryID: r.QueryID}, nil
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.Indexer, fn)
+}
+
+// RecordClick は queryID の検索の結果のうち、position の位置の文書 linkID がクリックされたことを記録する
+func (i *Indexer) RecordClick(queryID, linkID uuid.UUID, position int) error {
+	return i.w.Write(Record{
+		Kind:     KindClick,
+		Time:     time.Now(),
+		QueryID:  queryID,
+		LinkID:   linkID,
+		Position: position,
+	})
+}
+
+func (i *Indexer) write(r Record) {
+	if err := i.w.Write(r); err != nil && i.onError != nil {
+		i.onError(err)
+	}
+}
+
+func typeName(t index.QueryType) string {
+	switch t {
+	case index.QueryTypePhrase:
+		return "phrase"
+	case index.QueryTypeAdvanced:
+		return "advanced"
+	default:
+		return "match"
+	}
+}
+
+var (
+	_ LoggedIterator           = (*loggedIterator)(nil)
+	_ index.CursorIterator     = (*loggedIterator)(nil)
+	_ index.SnippetIterator    = (*loggedIterator)(nil)
+	_ index.ScoredIterator     = (*loggedIterator)(nil)
+	_ index.SuggestionIterator = (*loggedIterator)(nil)
+)
+
+// loggedIterator は検索の記録の QueryID を返す index.Iterator
+type loggedIterator struct {
+	index.IteratorWrapper
+	queryID uuid.UUID
+}
+
+func (it *loggedIterator) QueryID() uuid.UUID {
+	return it.queryID
+}
@@ -0,0 +1,145 @@
+package querylog
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	gc "gopkg.in/check.v1"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(QueryLogTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type QueryLogTestSuite struct{}
+
+func (s *QueryLogTestSuite) TestLogSearchesAndClicks(c *gc.C) {
+	dir := c.MkDir()
+	w, err := NewWriter(Config{Dir: dir})
+	c.Assert(err, gc.IsNil)
+
+	bleveIdx, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = bleveIdx.Close() }()
+	idx := NewIndexer(bleveIdx, w, func(err error) { c.Errorf("unexpected log error: %v", err) })
+
+	doc := &index.Document{LinkID: uuid.New(), Title: "Concurrency in Go", Content: "goroutines and channels"}
+	c.Assert(idx.Index(doc), gc.IsNil)
+
+	search := func(q index.Query) LoggedIterator {
+		it, err := idx.Search(q)
+		c.Assert(err, gc.IsNil)
+		c.Assert(it.Close(), gc.IsNil)
+		li, ok := it.(LoggedIterator)
+		c.Assert(ok, gc.Equals, true)
+		return li
+	}
+	first := search(index.Query{Expression: "Goroutines"})
+	search(index.Query{Expression: "goroutines ", Offset: 1})
+	search(index.Query{Expression: "goroutines"})
+	search(index.Query{Expression: "rust"})
+	c.Assert(idx.RecordClick(first.QueryID(), doc.LinkID, 0), gc.IsNil)
+
+	_, err = idx.Search(index.Query{Type: index.QueryTypeAdvanced, Expression: "(unclosed"})
+	c.Assert(err, gc.NotNil)
+	c.Assert(w.Close(), gc.IsNil)
+
+	records, err := ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(records, gc.HasLen, 6)
+	c.Assert(records[0].Kind, gc.Equals, KindSearch)
+	c.Assert(records[0].QueryID, gc.Equals, first.QueryID())
+	c.Assert(records[0].Expression, gc.Equals, "Goroutines")
+	c.Assert(records[0].Type, gc.Equals, "match")
+	c.Assert(records[0].TotalCount, gc.Equals, uint64(1))
+	c.Assert(records[1].Offset, gc.Equals, uint64(1))
+	c.Assert(records[4].Kind, gc.Equals, KindClick)
+	c.Assert(records[4].LinkID, gc.Equals, doc.LinkID)
+	c.Assert(records[5].Type, gc.Equals, "advanced")
+	c.Assert(records[5].Error, gc.Not(gc.Equals), "")
+
+	r := NewReport(records)
+	top := r.TopQueries(10)
+	c.Assert(top, gc.HasLen, 2)
+	c.Assert(top[0].Query, gc.Equals, "goroutines")
+	c.Assert(top[0].Searches, gc.Equals, 2)
+	c.Assert(top[0].Clicks, gc.Equals, 1)
+	c.Assert(top[1].Query, gc.Equals, "rust")
+
+	zero := r.ZeroResultQueries(10)
+	c.Assert(zero, gc.HasLen, 1)
+	c.Assert(zero[0].Query, gc.Equals, "rust")
+	c.Assert(zero[0].ZeroResults, gc.Equals, 1)
+}
+
+func (s *QueryLogTestSuite) TestSlowestQueries(c *gc.C) {
+	records := []Record{
+		{Kind: KindSearch, QueryID: uuid.New(), Expression: "fast", Latency: time.Millisecond, TotalCount: 1},
+		{Kind: KindSearch, QueryID: uuid.New(), Expression: "slow", Latency: 30 * time.Millisecond, TotalCount: 1},
+		{Kind: KindSearch, QueryID: uuid.New(), Expression: "slow", Latency: 10 * time.Millisecond, TotalCount: 1},
+		{Kind: KindSearch, QueryID: uuid.New(), Expression: "medium", Latency: 5 * time.Millisecond, TotalCount: 1},
+	}
+
+	slowest := NewReport(records).SlowestQueries(2)
+	c.Assert(slowest, gc.HasLen, 2)
+	c.Assert(slowest[0].Query, gc.Equals, "slow")
+	c.Assert(slowest[0].MaxLatency, gc.Equals, 30*time.Millisecond)
+	c.Assert(slowest[0].AvgLatency, gc.Equals, 20*time.Millisecond)
+	c.Assert(slowest[1].Query, gc.Equals, "medium")
+}
+
+func (s *QueryLogTestSuite) TestLoadReport(c *gc.C) {
+	dir := c.MkDir()
+	w, err := NewWriter(Config{Dir: dir, MaxFileSize: 200, MaxFiles: 10})
+	c.Assert(err, gc.IsNil)
+
+	early, late := uuid.New(), uuid.New()
+	records := []Record{
+		{Kind: KindSearch, QueryID: early, Expression: "golang", TotalCount: 1},
+		{Kind: KindClick, QueryID: early},
+		// 検索より先に書き込まれたクリックも集計される
+		{Kind: KindClick, QueryID: late},
+		{Kind: KindSearch, QueryID: late, Expression: "Golang", TotalCount: 1},
+		{Kind: KindSearch, QueryID: uuid.New(), Expression: "rust"},
+	}
+	for _, rec := range records {
+		c.Assert(w.Write(rec), gc.IsNil)
+	}
+	c.Assert(w.Close(), gc.IsNil)
+
+	files, err := logFiles(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(files) > 1, gc.Equals, true)
+
+	r, err := LoadReport(dir)
+	c.Assert(err, gc.IsNil)
+	top := r.TopQueries(10)
+	c.Assert(top, gc.HasLen, 2)
+	c.Assert(top[0].Query, gc.Equals, "golang")
+	c.Assert(top[0].Searches, gc.Equals, 2)
+	c.Assert(top[0].Clicks, gc.Equals, 2)
+	c.Assert(r.ZeroResultQueries(10), gc.HasLen, 1)
+}
+
+func (s *QueryLogTestSuite) TestRotation(c *gc.C) {
+	dir := c.MkDir()
+	w, err := NewWriter(Config{Dir: dir, MaxFileSize: 200, MaxFiles: 3})
+	c.Assert(err, gc.IsNil)
+
+	for i := 0; i < 20; i++ {
+		c.Assert(w.Write(Record{Kind: KindSearch, QueryID: uuid.New(), Expression: "golang"}), gc.IsNil)
+	}
+	c.Assert(w.Close(), gc.IsNil)
+
+	files, err := logFiles(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 3)
+
+	// 古いファイルは削除され、残ったファイルの記録のみが読み込まれる
+	records, err := ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(records) < 20, gc.Equals, true)
+	c.Assert(len(records) > 0, gc.Equals, true)
+}
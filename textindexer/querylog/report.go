@@ -0,0 +1,162 @@
+package querylog
+
+import (
+	"github.com/google/uuid"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryStats は同じ検索式の検索の集計結果
+type QueryStats struct {
+	// Query は小文字にして空白を正規化した検索式
+	Query string
+
+	// Searches は先頭のページの検索回数、ZeroResults はそのうち結果が 0 件だった回数。
+	// 続きのページの検索は回数に含めない。
+	Searches    int
+	ZeroResults int
+
+	// Clicks は結果がクリックされた回数。続きのページのクリックも含む。
+	Clicks int
+
+	// AvgLatency と MaxLatency はすべてのページの検索の所要時間の平均と最大
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+
+	totalLatency time.Duration
+	pages        int
+}
+
+// Report は Record を検索式ごとに集計する
+type Report struct {
+	stats map[string]*QueryStats
+
+	// queries は検索 ID ごとの集計先、pendingClicks は検索より先に読み込まれたクリックの回数
+	queries       map[uuid.UUID]*QueryStats
+	pendingClicks map[uuid.UUID]int
+}
+
+// NewReport は records を集計する。エラーになった検索は集計に含めない。
+func NewReport(records []Record) *Report {
+	r := newReport()
+	for _, rec := range records {
+		r.Add(rec)
+	}
+	return r
+}
+
+func newReport() *Report {
+	return &Report{
+		stats:         make(map[string]*QueryStats),
+		queries:       make(map[uuid.UUID]*QueryStats),
+		pendingClicks: make(map[uuid.UUID]int),
+	}
+}
+
+// Add は rec を集計に加える。エラーになった検索は集計に含めない。
+func (r *Report) Add(rec Record) {
+	switch {
+	case rec.Kind == KindClick:
+		if st, found := r.queries[rec.QueryID]; found {
+			st.Clicks++
+		} else {
+			r.pendingClicks[rec.QueryID]++
+		}
+		return
+	case rec.Kind != KindSearch || rec.Error != "":
+		return
+	}
+
+	key := normalize(rec.Expression)
+	st, found := r.stats[key]
+	if !found {
+		st = &QueryStats{Query: key}
+		r.stats[key] = st
+	}
+	r.queries[rec.QueryID] = st
+	if clicks, found := r.pendingClicks[rec.QueryID]; found {
+		st.Clicks += clicks
+		delete(r.pendingClicks, rec.QueryID)
+	}
+
+	st.pages++
+	st.totalLatency += rec.Latency
+	st.AvgLatency = st.totalLatency / time.Duration(st.pages)
+	if rec.Latency > st.MaxLatency {
+		st.MaxLatency = rec.Latency
+	}
+	if rec.Offset == 0 && !rec.Cursor {
+		st.Searches++
+		if rec.TotalCount == 0 {
+			st.ZeroResults++
+		}
+	}
+}
+
+// LoadReport は dir のすべてのログファイルを 1 行ずつ読み込んで集計する
+func LoadReport(dir string) (*Report, error) {
+	r := newReport()
+	err := WalkDir(dir, func(rec Record) error {
+		r.Add(rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TopQueries は検索回数の多い順に最大 n 件の検索式を返す
+func (r *Report) TopQueries(n int) []QueryStats {
+	return r.top(n, func(st *QueryStats) bool { return st.Searches > 0 }, func(a, b *QueryStats) bool {
+		return a.Searches > b.Searches
+	})
+}
+
+// ZeroResultQueries は結果が 0 件だった回数の多い順に最大 n 件の検索式を返す
+func (r *Report) ZeroResultQueries(n int) []QueryStats {
+	return r.top(n, func(st *QueryStats) bool { return st.ZeroResults > 0 }, func(a, b *QueryStats) bool {
+		return a.ZeroResults > b.ZeroResults
+	})
+}
+
+// SlowestQueries は検索の所要時間の最大値が大きい順に最大 n 件の検索式を返す
+func (r *Report) SlowestQueries(n int) []QueryStats {
+	return r.top(n, func(*QueryStats) bool { return true }, func(a, b *QueryStats) bool {
+		return a.MaxLatency > b.MaxLatency
+	})
+}
+
+// top は include が true を返す集計結果を before の順に最大 n 件返す。順位が同じ場合は検索式の順に並ぶ。
+func (r *Report) top(n int, include func(*QueryStats) bool, before func(a, b *QueryStats) bool) []QueryStats {
+	var list []*QueryStats
+	for _, st := range r.stats {
+		if include(st) {
+			list = append(list, st)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool {
+		switch {
+		case before(list[a], list[b]):
+			return true
+		case before(list[b], list[a]):
+			return false
+		default:
+			return list[a].Query < list[b].Query
+		}
+	})
+
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	out := make([]QueryStats, len(list))
+	for i, st := range list {
+		out[i] = *st
+	}
+	return out
+}
+
+func normalize(expr string) string {
+	return strings.Join(strings.Fields(strings.ToLower(expr)), " ")
+}
@@ -0,0 +1,236 @@
+// Package querylog は検索クエリとクリックされた結果を JSON Lines 形式のファイルに記録し、集計する。
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFileSize はファイルを切り替えるまでに書き込む既定のバイト数
+	DefaultMaxFileSize = 64 << 20
+
+	// DefaultMaxFiles は保持する既定のファイル数
+	DefaultMaxFiles = 10
+
+	filePrefix = "queries-"
+	fileSuffix = ".jsonl"
+
+	// fileTimeFormat はファイル名の時刻の形式。名前の順に並べると作成された順になる。
+	fileTimeFormat = "20060102T150405.000000000"
+)
+
+// 記録の種類
+const (
+	KindSearch = "search"
+	KindClick  = "click"
+)
+
+// Record はログの 1 行。検索の記録とクリックの記録は QueryID で対応付けられる。
+type Record struct {
+	Kind    string    `json:"kind"`
+	Time    time.Time `json:"time"`
+	QueryID uuid.UUID `json:"query_id"`
+
+	// 検索の記録のフィールド
+	Expression string        `json:"expression,omitempty"`
+	Type       string        `json:"type,omitempty"`
+	Offset     uint64        `json:"offset,omitempty"`
+	Cursor     bool          `json:"cursor,omitempty"`
+	TotalCount uint64        `json:"total_count"`
+	Latency    time.Duration `json:"latency_ns,omitempty"`
+	Error      string        `json:"error,omitempty"`
+
+	// クリックの記録のフィールド。Position は結果の先頭を 0 とする位置。
+	LinkID   uuid.UUID `json:"link_id"`
+	Position int       `json:"position,omitempty"`
+}
+
+// Config は Writer の設定を保持する
+type Config struct {
+	// Dir はログファイルを作成するディレクトリ
+	Dir string
+
+	// MaxFileSize はファイルを切り替えるまでに書き込むバイト数。0 の場合は DefaultMaxFileSize が使用される。
+	MaxFileSize int64
+
+	// MaxFiles は保持するファイル数。これを超えると古いファイルから削除される。0 の場合は DefaultMaxFiles が使用される。
+	MaxFiles int
+}
+
+// Writer は Record を JSON Lines 形式でファイルに追記し、ファイルが MaxFileSize に達すると新しいファイルに切り替える。
+// 複数の goroutine から同時に使用できる。
+type Writer struct {
+	cfg Config
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewWriter は cfg.Dir にログファイルを作成する Writer を作成する
+func NewWriter(cfg Config) (*Writer, error) {
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = DefaultMaxFileSize
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = DefaultMaxFiles
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, xerrors.Errorf("create query log: %w", err)
+	}
+	return &Writer{cfg: cfg}, nil
+}
+
+// Write は r を現在のファイルに 1 行として追記する
+func (w *Writer) Write(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return xerrors.Errorf("write query log: %w", err)
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil || w.size+int64(len(line)) > w.cfg.MaxFileSize && w.size > 0 {
+		if err = w.rotate(); err != nil {
+			return xerrors.Errorf("write query log: %w", err)
+		}
+	}
+
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return xerrors.Errorf("write query log: %w", err)
+	}
+	return nil
+}
+
+// Rotate は次の Write から新しいファイルに書き込むように現在のファイルを閉じる。
+// 日ごとなど時間でファイルを切り替える場合に使用する。
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+// Close は現在のファイルを閉じる
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+func (w *Writer) closeFile() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f, w.size = nil, 0
+	return err
+}
+
+// rotate は現在のファイルを閉じて新しいファイルを作成し、MaxFiles を超えた古いファイルを削除する
+func (w *Writer) rotate() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	name := filepath.Join(w.cfg.Dir, filePrefix+time.Now().UTC().Format(fileTimeFormat)+fileSuffix)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.f = f
+
+	files, err := logFiles(w.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > w.cfg.MaxFiles {
+		if err = os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// logFiles は dir のログファイルのパスを作成された順に返す
+func logFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if name := e.Name(); !e.IsDir() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadDir は dir のすべてのログファイルの Record を書き込まれた順に返す
+func ReadDir(dir string) ([]Record, error) {
+	var records []Record
+	err := WalkDir(dir, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// WalkDir は dir のすべてのログファイルの Record について、書き込まれた順に fn を呼び出す。
+// ファイルは 1 行ずつ読み込まれるため、Record をすべてメモリに保持する必要はない。
+// fn がエラーを返した場合は読み込みを中断してそのエラーを返す。
+func WalkDir(dir string, fn func(r Record) error) error {
+	files, err := logFiles(dir)
+	if err != nil {
+		return xerrors.Errorf("read query log: %w", err)
+	}
+
+	for _, path := range files {
+		if err = readFile(path, fn); err != nil {
+			return xerrors.Errorf("read query log: %w", err)
+		}
+	}
+	return nil
+}
+
+func readFile(path string, fn func(r Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return xerrors.Errorf("%s:%d: %w", filepath.Base(path), lineNum, err)
+		}
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,245 @@
+// Package ranking は検索結果を index.Ranking のスコアで並べ替える。
+// 各 index.Indexer の実装は Query.Ranking が指定された検索を Search に委譲し、
+// Indexer は Query.Ranking が指定されていない検索に既定の Ranking を適用する。
+package ranking
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/highlight"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	"math"
+	"sort"
+	"time"
+)
+
+// MaxCandidates はスコアを計算する文書の最大数。
+// 候補は Ranking を適用しない検索の順、すなわち PageRank と関連度の降順で上位から選ばれる。
+const MaxCandidates = 1000
+
+// SearchFunc は Ranking を適用せずに検索を行う関数
+type SearchFunc func(q index.Query) (index.Iterator, error)
+
+// cursor は検索を再開する位置の文書のスコアと ID、およびスコアの計算に使用した基準時刻
+type cursor struct {
+	Score float64   `json:"s"`
+	ID    uuid.UUID `json:"id"`
+	Now   int64     `json:"t"`
+}
+
+// rankedDoc はスコアを計算した文書
+type rankedDoc struct {
+	doc       *index.Document
+	relevance float64
+	score     float64
+}
+
+// after は d が c の位置より後に並ぶかを返す
+func (c cursor) after(d rankedDoc) bool {
+	if d.score != c.Score {
+		return d.score < c.Score
+	}
+	return d.doc.LinkID.String() > c.ID.String()
+}
+
+// Search は search で q に一致する上位 MaxCandidates 件の文書を取得し、q.Ranking のスコアの降順に並べたイテレータを返す。
+// 同じスコアの文書はリンク ID の順に並ぶ。q.Offset、q.Limit、q.Cursor は並べ替えた結果に適用される。
+// TotalCount は候補に含まれない文書も含めた一致件数を返す。抜粋は返される文書についてのみ作成される。
+// カーソルにはスコアの計算に使用した基準時刻が含まれるため、続きのページでも同じ鮮度で比較される。
+func Search(q index.Query, search SearchFunc) (index.Iterator, error) {
+	r := q.Ranking.WithDefaults()
+	now := time.Now()
+
+	var after *cursor
+	if q.Cursor != "" {
+		after = new(cursor)
+		if err := index.DecodeCursor(q, after); err != nil {
+			return nil, xerrors.Errorf("search: %w", err)
+		}
+		now = time.Unix(0, after.Now)
+	}
+
+	inner := q
+	inner.Offset, inner.Limit, inner.Cursor, inner.Ranking, inner.Highlight = 0, MaxCandidates, "", nil, nil
+	docs, total, err := collect(inner, search)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxRelevance, maxPageRank float64
+	for _, d := range docs {
+		maxRelevance = math.Max(maxRelevance, d.relevance)
+		maxPageRank = math.Max(maxPageRank, d.doc.PageRank)
+	}
+	for n := range docs {
+		d := &docs[n]
+		d.score = r.Score(normalize(d.relevance, maxRelevance), normalize(d.doc.PageRank, maxPageRank), d.doc.IndexedAt, now)
+	}
+	sort.Slice(docs, func(a, b int) bool {
+		if docs[a].score != docs[b].score {
+			return docs[a].score > docs[b].score
+		}
+		return docs[a].doc.LinkID.String() < docs[b].doc.LinkID.String()
+	})
+
+	start := q.Offset
+	if after != nil {
+		start = resume(docs, *after)
+	}
+	if start > uint64(len(docs)) {
+		start = uint64(len(docs))
+	}
+
+	it := &rankedIterator{docs: docs, cur: int(start), total: total, now: now.UnixNano()}
+	if q.Limit > 0 && start+q.Limit < uint64(len(docs)) {
+		it.end = int(start + q.Limit)
+	} else {
+		it.end = len(docs)
+	}
+	if q.Highlight != nil {
+		return highlight.NewIterator(it, q), nil
+	}
+	return it, nil
+}
+
+// resume はカーソル c の次の文書の位置を返す。
+// 関連度の計算誤差で同じ文書のスコアが検索ごとにわずかに変わることがあるため、
+// カーソルの文書が結果に含まれる場合はその位置から再開し、含まれない場合にのみスコアで位置を探す。
+func resume(docs []rankedDoc, c cursor) uint64 {
+	for n, d := range docs {
+		if d.doc.LinkID == c.ID {
+			return uint64(n + 1)
+		}
+	}
+	return uint64(sort.Search(len(docs), func(n int) bool { return c.after(docs[n]) }))
+}
+
+// collect は search が返す文書を関連度とともに取得し、検索に一致した文書の総数とともに返す
+func collect(q index.Query, search SearchFunc) ([]rankedDoc, uint64, error) {
+	it, err := search(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var docs []rankedDoc
+	for it.Next() {
+		d := rankedDoc{doc: it.Document()}
+		if si, ok := it.(index.ScoredIterator); ok {
+			d.relevance = si.Relevance()
+		}
+		docs = append(docs, d)
+	}
+	if err = it.Error(); err != nil {
+		_ = it.Close()
+		return nil, 0, err
+	}
+	total := it.TotalCount()
+	if err = it.Close(); err != nil {
+		return nil, 0, err
+	}
+	return docs, total, nil
+}
+
+func normalize(v, upper float64) float64 {
+	if upper <= 0 {
+		return 0
+	}
+	return v / upper
+}
+
+var (
+	_ index.CursorIterator = (*rankedIterator)(nil)
+	_ index.ScoredIterator = (*rankedIterator)(nil)
+)
+
+// rankedIterator はスコアの順に並べ替えた文書を返す index.Iterator の実装
+type rankedIterator struct {
+	docs  []rankedDoc
+	cur   int
+	end   int
+	total uint64
+	now   int64
+
+	latched *rankedDoc
+}
+
+func (it *rankedIterator) Close() error {
+	it.cur = it.end
+	return nil
+}
+
+func (it *rankedIterator) Next() bool {
+	if it.cur >= it.end {
+		return false
+	}
+	it.latched = &it.docs[it.cur]
+	it.cur++
+	return true
+}
+
+func (it *rankedIterator) Error() error {
+	return nil
+}
+
+func (it *rankedIterator) Document() *index.Document {
+	if it.latched == nil {
+		return nil
+	}
+	return it.latched.doc
+}
+
+// Relevance は内部の検索が返した現在の文書の関連度を返す
+func (it *rankedIterator) Relevance() float64 {
+	if it.latched == nil {
+		return 0
+	}
+	return it.latched.relevance
+}
+
+// Score は現在の文書の Ranking のスコアを返す
+func (it *rankedIterator) Score() float64 {
+	if it.latched == nil {
+		return 0
+	}
+	return it.latched.score
+}
+
+// Cursor は現在の文書の次から検索を再開するためのカーソルを返す
+func (it *rankedIterator) Cursor() string {
+	if it.latched == nil {
+		return ""
+	}
+	return index.EncodeCursor(cursor{Score: it.latched.score, ID: it.latched.doc.LinkID, Now: it.now})
+}
+
+func (it *rankedIterator) TotalCount() uint64 {
+	return it.total
+}
+
+var _ index.Scanner = (*Indexer)(nil)
+
+// Indexer は index.Indexer をラップし、Query.Ranking が指定されていない検索に既定の Ranking を適用する
+type Indexer struct {
+	index.Indexer
+	ranking index.Ranking
+}
+
+// NewIndexer は idx の検索結果を既定で r のスコアの順に並べる Indexer を作成する
+func NewIndexer(idx index.Indexer, r index.Ranking) *Indexer {
+	return &Indexer{Indexer: idx, ranking: r}
+}
+
+// Search は q.Ranking が nil の場合に既定の Ranking を設定してから検索を行う
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	if q.Ranking == nil {
+		r := i.ranking
+		q.Ranking = &r
+	}
+	return i.Indexer.Search(q)
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.Indexer, fn)
+}
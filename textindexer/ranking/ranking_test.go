@@ -0,0 +1,145 @@
+package ranking
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	gc "gopkg.in/check.v1"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(RankingTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type RankingTestSuite struct{}
+
+func (s *RankingTestSuite) TestDecay(c *gc.C) {
+	exp := index.ExponentialDecay(time.Hour)
+	c.Assert(exp(0), gc.Equals, 1.0)
+	c.Assert(exp(time.Hour), gc.Equals, 0.5)
+	c.Assert(exp(2*time.Hour), gc.Equals, 0.25)
+
+	lin := index.LinearDecay(time.Hour)
+	c.Assert(lin(-time.Minute), gc.Equals, 1.0)
+	c.Assert(lin(30*time.Minute), gc.Equals, 0.5)
+	c.Assert(lin(2*time.Hour), gc.Equals, 0.0)
+}
+
+func (s *RankingTestSuite) TestFreshnessBreaksTies(c *gc.C) {
+	now := time.Now()
+	stale := &index.Document{LinkID: uuid.New(), IndexedAt: now.Add(-90 * 24 * time.Hour)}
+	fresh := &index.Document{LinkID: uuid.New(), IndexedAt: now.Add(-time.Hour)}
+	placeholder := &index.Document{LinkID: uuid.New()}
+	search := stubSearch([]*index.Document{placeholder, stale, fresh}, []float64{1, 1, 1})
+
+	it, err := Search(index.Query{Ranking: &index.Ranking{}}, search)
+	c.Assert(err, gc.IsNil)
+	c.Assert(linkIDs(c, it), gc.DeepEquals, []uuid.UUID{fresh.LinkID, stale.LinkID, placeholder.LinkID})
+
+	// 鮮度の重みのみを変更すると、関連度の差が鮮度で覆らなくなる
+	search = stubSearch([]*index.Document{stale, fresh}, []float64{2, 1})
+	it, err = Search(index.Query{Ranking: &index.Ranking{RelevanceWeight: 1, FreshnessWeight: 0.1}}, search)
+	c.Assert(err, gc.IsNil)
+	c.Assert(linkIDs(c, it), gc.DeepEquals, []uuid.UUID{stale.LinkID, fresh.LinkID})
+
+	// 経過時間を変換する関数を指定できる
+	it, err = Search(index.Query{Ranking: &index.Ranking{
+		RelevanceWeight: 1,
+		FreshnessWeight: 10,
+		Freshness:       index.LinearDecay(24 * time.Hour),
+	}}, search)
+	c.Assert(err, gc.IsNil)
+	c.Assert(linkIDs(c, it), gc.DeepEquals, []uuid.UUID{fresh.LinkID, stale.LinkID})
+}
+
+func (s *RankingTestSuite) TestCandidatesAreCapped(c *gc.C) {
+	docs := []*index.Document{
+		{LinkID: uuid.New(), Content: "Ovidius poeta"},
+		{LinkID: uuid.New(), Content: "in terra pontica"},
+	}
+	var inner index.Query
+	search := func(q index.Query) (index.Iterator, error) {
+		inner = q
+		return &stubIterator{docs: docs, relevance: []float64{1, 2}, total: 5000}, nil
+	}
+
+	it, err := Search(index.Query{Expression: "poeta", Limit: 1, Ranking: &index.Ranking{RelevanceWeight: 1}, Highlight: &index.HighlightOptions{}}, search)
+	c.Assert(err, gc.IsNil)
+
+	// 候補は上位 MaxCandidates 件に限られ、抜粋は返される文書についてのみ作成される
+	c.Assert(inner.Limit, gc.Equals, uint64(MaxCandidates))
+	c.Assert(inner.Highlight, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(5000))
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Document().LinkID, gc.Equals, docs[1].LinkID)
+	c.Assert(it.(index.SnippetIterator).Snippet(), gc.Equals, "in terra pontica")
+	c.Assert(it.Next(), gc.Equals, false)
+}
+
+func (s *RankingTestSuite) TestIndexerAppliesDefaultRanking(c *gc.C) {
+	inner := &stubIndexer{}
+	idx := NewIndexer(inner, index.Ranking{PageRankWeight: 1})
+
+	_, err := idx.Search(index.Query{Expression: "foo"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(inner.lastQuery.Ranking, gc.NotNil)
+	c.Assert(inner.lastQuery.Ranking.PageRankWeight, gc.Equals, 1.0)
+
+	// クエリで指定した Ranking が優先される
+	_, err = idx.Search(index.Query{Expression: "foo", Ranking: &index.Ranking{RelevanceWeight: 2}})
+	c.Assert(err, gc.IsNil)
+	c.Assert(inner.lastQuery.Ranking.RelevanceWeight, gc.Equals, 2.0)
+	c.Assert(inner.lastQuery.Ranking.PageRankWeight, gc.Equals, 0.0)
+}
+
+func linkIDs(c *gc.C, it index.Iterator) []uuid.UUID {
+	var ids []uuid.UUID
+	for it.Next() {
+		ids = append(ids, it.Document().LinkID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	return ids
+}
+
+func stubSearch(docs []*index.Document, relevance []float64) SearchFunc {
+	return func(index.Query) (index.Iterator, error) {
+		return &stubIterator{docs: docs, relevance: relevance}, nil
+	}
+}
+
+type stubIndexer struct {
+	index.Indexer
+	lastQuery index.Query
+}
+
+func (s *stubIndexer) Search(q index.Query) (index.Iterator, error) {
+	s.lastQuery = q
+	return &stubIterator{}, nil
+}
+
+type stubIterator struct {
+	docs      []*index.Document
+	relevance []float64
+	total     uint64
+	cur       int
+}
+
+func (it *stubIterator) Close() error { return nil }
+func (it *stubIterator) Next() bool {
+	if it.cur >= len(it.docs) {
+		return false
+	}
+	it.cur++
+	return true
+}
+func (it *stubIterator) Error() error              { return nil }
+func (it *stubIterator) Document() *index.Document { return it.docs[it.cur-1] }
+func (it *stubIterator) Relevance() float64        { return it.relevance[it.cur-1] }
+func (it *stubIterator) TotalCount() uint64 {
+	if it.total != 0 {
+		return it.total
+	}
+	return uint64(len(it.docs))
+}
@@ -0,0 +1,82 @@
+package reindex
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint は再インデックスの進捗。中断したジョブは保存された Checkpoint の位置から再開する。
+type Checkpoint struct {
+	// RetrievedBefore はジョブの開始時に決めたリンクの取得日時の上限。再開したジョブも同じ上限を使用する。
+	RetrievedBefore time.Time `json:"retrieved_before"`
+
+	Partitions []PartitionState `json:"partitions"`
+}
+
+// PartitionState はパーティションごとの進捗
+type PartitionState struct {
+	// LastID は最後に処理したリンクの ID。パーティション内のリンクは ID の昇順に処理される。
+	LastID uuid.UUID `json:"last_id"`
+
+	// Done はパーティションのすべてのリンクを処理したかを表す
+	Done bool `json:"done"`
+
+	Indexed int `json:"indexed"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// CheckpointStore は Checkpoint を永続化する
+type CheckpointStore interface {
+	// Load は保存された Checkpoint を返す。保存されていない場合は nil を返す。
+	Load() (*Checkpoint, error)
+
+	// Save は cp を保存する
+	Save(cp *Checkpoint) error
+}
+
+// FileCheckpointStore は Checkpoint を JSON ファイルに保存する CheckpointStore の実装
+type FileCheckpointStore struct {
+	path string
+}
+
+// NewFileCheckpointStore は path に Checkpoint を保存する FileCheckpointStore を作成する
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func (s *FileCheckpointStore) Load() (*Checkpoint, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("load checkpoint: %w", err)
+	}
+
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, xerrors.Errorf("load checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// Save は一時ファイルに書き出してから置き換えるため、書き込み中に停止しても以前の Checkpoint が失われない
+func (s *FileCheckpointStore) Save(cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return xerrors.Errorf("save checkpoint: %w", err)
+	}
+
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return xerrors.Errorf("save checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return xerrors.Errorf("save checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+// Package reindex はリンクグラフのすべてのリンクの内容を取得し直してテキストインデックスを再構築する。
+// アナライザの変更などで既存のインデックスが使えなくなった場合に使用する。
+package reindex
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCheckpointMismatch は保存された Checkpoint のパーティション数が Config.Partitions と異なる場合に返される
+var ErrCheckpointMismatch = xerrors.New("checkpoint does not match partition count")
+
+// maxUUID は UUID の空間の上限。graph.Graph.Links の上限は排他的なため、この ID のリンクは FindLink で個別に取得する。
+var maxUUID = uuid.MustParse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+
+// Config は Job の設定を保持する
+type Config struct {
+	// Graph はリンクを列挙するリンクグラフ (必須)
+	Graph graph.Graph
+
+	// Indexer は文書を追加するインデックス (必須)
+	Indexer index.Indexer
+
+	// Source はリンクの内容を取得する (必須)
+	Source ContentSource
+
+	// Checkpoints が指定された場合、進捗はバッチごとに保存され、次の Run は保存された位置から再開する
+	Checkpoints CheckpointStore
+
+	// Partitions は UUID の空間を分割する数。既定値は 16。
+	// パーティション内のリンクは ID の順に処理するためにまとめて読み込まれるため、リンクが多い場合は増やすこと。
+	Partitions int
+
+	// Workers は並行して処理するパーティションの数。既定値は 4。
+	Workers int
+
+	// FetchWorkers はパーティションごとに並行して内容を取得するリンクの数。既定値は 4。
+	FetchWorkers int
+
+	// BatchSize は 1 回の IndexBatch で追加する文書の数。既定値は 100。
+	BatchSize int
+
+	// OnProgress が指定された場合、バッチを処理するたびに全体の進捗を渡して呼び出される
+	OnProgress func(Progress)
+
+	// OnError が指定された場合、内容の取得またはインデックスへの追加に失敗したリンクごとに呼び出される。
+	// 失敗したリンクは処理済みとして扱われ、ジョブは続行する。
+	OnError func(link *graph.Link, err error)
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Graph == nil {
+		return xerrors.New("reindex: graph not specified")
+	}
+	if cfg.Indexer == nil {
+		return xerrors.New("reindex: indexer not specified")
+	}
+	if cfg.Source == nil {
+		return xerrors.New("reindex: content source not specified")
+	}
+	if cfg.Partitions <= 0 {
+		cfg.Partitions = 16
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.FetchWorkers <= 0 {
+		cfg.FetchWorkers = 4
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	return nil
+}
+
+// Progress は再インデックスの全体の進捗。中断したジョブを再開した場合、以前の Run の処理も含まれる。
+type Progress struct {
+	Partitions     int
+	PartitionsDone int
+
+	Indexed int
+	Skipped int
+	Failed  int
+
+	// Elapsed は現在の Run の経過時間
+	Elapsed time.Duration
+}
+
+// Job はリンクグラフのリンクをパーティションごとに並行して再インデックスする
+type Job struct {
+	cfg Config
+
+	mu    sync.Mutex
+	cp    *Checkpoint
+	start time.Time
+}
+
+// New は cfg の Job を作成する
+func New(cfg Config) (*Job, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Job{cfg: cfg}, nil
+}
+
+// Run はすべてのパーティションのリンクを再インデックスする。保存された Checkpoint があれば、処理済みのリンクは読み飛ばす。
+// いずれかのパーティションの処理に失敗するか ctx がキャンセルされた場合、他のパーティションの処理を中断してエラーを返す。
+func (j *Job) Run(ctx context.Context) (Progress, error) {
+	cp, err := j.loadCheckpoint()
+	if err != nil {
+		return Progress{}, err
+	}
+
+	j.mu.Lock()
+	j.cp, j.start = cp, time.Now()
+	j.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg         sync.WaitGroup
+		partitions = make(chan int)
+		errs       = make([]error, j.cfg.Partitions)
+	)
+	for w := 0; w < j.cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range partitions {
+				if err := j.runPartition(ctx, p); err != nil {
+					errs[p] = xerrors.Errorf("partition %d: %w", p, err)
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
+	for p := 0; p < j.cfg.Partitions; p++ {
+		select {
+		case partitions <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(partitions)
+	wg.Wait()
+
+	progress := j.progress()
+	for _, err := range errs {
+		if err != nil {
+			return progress, xerrors.Errorf("reindex: %w", err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return progress, xerrors.Errorf("reindex: %w", err)
+	}
+	return progress, nil
+}
+
+// loadCheckpoint は保存された Checkpoint を読み込む。保存されていない場合は新しい Checkpoint を作成する。
+func (j *Job) loadCheckpoint() (*Checkpoint, error) {
+	if j.cfg.Checkpoints != nil {
+		cp, err := j.cfg.Checkpoints.Load()
+		if err != nil {
+			return nil, xerrors.Errorf("reindex: %w", err)
+		}
+		if cp != nil {
+			if len(cp.Partitions) != j.cfg.Partitions {
+				return nil, xerrors.Errorf("reindex: checkpoint has %d partitions: %w", len(cp.Partitions), ErrCheckpointMismatch)
+			}
+			return cp, nil
+		}
+	}
+
+	return &Checkpoint{
+		RetrievedBefore: time.Now(),
+		Partitions:      make([]PartitionState, j.cfg.Partitions),
+	}, nil
+}
+
+// runPartition はパーティション p のリンクを ID の昇順にバッチごとに再インデックスする
+func (j *Job) runPartition(ctx context.Context, p int) error {
+	j.mu.Lock()
+	state, retrievedBefore := j.cp.Partitions[p], j.cp.RetrievedBefore
+	j.mu.Unlock()
+	if state.Done {
+		return nil
+	}
+
+	from, to := partitionRange(p, j.cfg.Partitions)
+	if state.LastID != uuid.Nil {
+		from = state.LastID
+	}
+	links, err := j.links(from, to, retrievedBefore, state.LastID)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(links); start += j.cfg.BatchSize {
+		end := start + j.cfg.BatchSize
+		if end > len(links) {
+			end = len(links)
+		}
+		batch := links[start:end]
+
+		var delta PartitionState
+		docs, err := j.load(ctx, batch, &delta)
+		if err != nil {
+			return err
+		}
+		res, err := index.IndexBatch(j.cfg.Indexer, docs)
+		if err != nil {
+			return err
+		}
+		delta.Indexed = res.Processed
+		delta.Failed += len(res.Errors)
+		if j.cfg.OnError != nil {
+			for _, docErr := range res.Errors {
+				j.cfg.OnError(&graph.Link{ID: docs[docErr.Pos].LinkID, URL: docs[docErr.Pos].URL}, docErr)
+			}
+		}
+
+		delta.LastID = batch[len(batch)-1].ID
+		if err := j.advance(p, delta, false); err != nil {
+			return err
+		}
+	}
+	return j.advance(p, PartitionState{}, true)
+}
+
+// links は [from, to) の範囲のリンクを ID の昇順に返す。to が maxUUID の場合は ID が maxUUID のリンクも含める。
+// after が uuid.Nil でない場合、after 以前のリンクは除く。
+func (j *Job) links(from, to uuid.UUID, retrievedBefore time.Time, after uuid.UUID) ([]*graph.Link, error) {
+	it, err := j.cfg.Graph.Links(from, to, retrievedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []*graph.Link
+	for it.Next() {
+		link := it.Link()
+		if after != uuid.Nil && bytes.Compare(link.ID[:], after[:]) <= 0 {
+			continue
+		}
+		links = append(links, link)
+	}
+	if err := it.Error(); err != nil {
+		_ = it.Close()
+		return nil, err
+	}
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+
+	if to == maxUUID && after != maxUUID {
+		link, err := j.cfg.Graph.FindLink(maxUUID)
+		if err != nil && !xerrors.Is(err, graph.ErrNotFound) {
+			return nil, err
+		} else if link != nil && link.RetrievedAt.Before(retrievedBefore) {
+			links = append(links, link)
+		}
+	}
+
+	sort.Slice(links, func(a, b int) bool { return bytes.Compare(links[a].ID[:], links[b].ID[:]) < 0 })
+	return links, nil
+}
+
+// load は links の内容を並行して取得し、取得できたリンクの文書を links の順に返す
+func (j *Job) load(ctx context.Context, links []*graph.Link, delta *PartitionState) ([]*index.Document, error) {
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, j.cfg.FetchWorkers)
+		docs = make([]*index.Document, len(links))
+		errs = make([]error, len(links))
+	)
+	for n, link := range links {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(n int, link *graph.Link) {
+			defer func() { <-sem; wg.Done() }()
+			page, err := j.cfg.Source.Content(ctx, link)
+			if err != nil {
+				errs[n] = err
+				return
+			}
+			docs[n] = &index.Document{LinkID: link.ID, URL: link.URL, Title: page.Title, Content: page.Content}
+		}(n, link)
+	}
+	wg.Wait()
+
+	// キャンセルによる失敗はリンクの失敗として記録しない
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	loaded := docs[:0]
+	for n, doc := range docs {
+		switch err := errs[n]; {
+		case err == nil:
+			loaded = append(loaded, doc)
+		case xerrors.Is(err, ErrSkip):
+			delta.Skipped++
+		default:
+			delta.Failed++
+			if j.cfg.OnError != nil {
+				j.cfg.OnError(links[n], err)
+			}
+		}
+	}
+	return loaded, nil
+}
+
+// advance はパーティション p の進捗に delta を加えて Checkpoint を保存し、進捗を通知する
+func (j *Job) advance(p int, delta PartitionState, done bool) error {
+	j.mu.Lock()
+	state := &j.cp.Partitions[p]
+	if delta.LastID != uuid.Nil {
+		state.LastID = delta.LastID
+	}
+	state.Indexed += delta.Indexed
+	state.Skipped += delta.Skipped
+	state.Failed += delta.Failed
+	state.Done = done
+
+	var err error
+	if j.cfg.Checkpoints != nil {
+		err = j.cfg.Checkpoints.Save(j.cp)
+	}
+	progress := j.progressLocked()
+	j.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if j.cfg.OnProgress != nil {
+		j.cfg.OnProgress(progress)
+	}
+	return nil
+}
+
+func (j *Job) progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progressLocked()
+}
+
+func (j *Job) progressLocked() Progress {
+	progress := Progress{Partitions: len(j.cp.Partitions), Elapsed: time.Since(j.start)}
+	for _, state := range j.cp.Partitions {
+		if state.Done {
+			progress.PartitionsDone++
+		}
+		progress.Indexed += state.Indexed
+		progress.Skipped += state.Skipped
+		progress.Failed += state.Failed
+	}
+	return progress
+}
+
+// partitionRange は UUID の空間を numPartitions 個に等分したときの p 番目の範囲 [from, to) を返す
+func partitionRange(p, numPartitions int) (from, to uuid.UUID) {
+	space := new(big.Int).Lsh(big.NewInt(1), 128)
+	bound := func(n int) uuid.UUID {
+		if n == numPartitions {
+			return maxUUID
+		}
+		v := new(big.Int).Mul(space, big.NewInt(int64(n)))
+		v.Div(v, big.NewInt(int64(numPartitions)))
+
+		var id uuid.UUID
+		v.FillBytes(id[:])
+		return id
+	}
+	return bound(p), bound(p + 1)
+}
@@ -0,0 +1,324 @@
+package reindex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	graphmemory "github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/store/memory"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(ReindexTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+const numLinks = 50
+
+type ReindexTestSuite struct {
+	graph *graphmemory.InMemoryGraph
+	idx   *memory.InMemoryBleveIndexer
+	links []*graph.Link
+
+	mu      sync.Mutex
+	fetched map[uuid.UUID]int
+}
+
+func (s *ReindexTestSuite) SetUpTest(c *gc.C) {
+	s.graph = graphmemory.NewInMemoryGraph()
+	s.links = nil
+	for n := 0; n < numLinks; n++ {
+		link := &graph.Link{URL: fmt.Sprintf("http://example.com/%d", n), RetrievedAt: time.Now().Add(-time.Hour)}
+		c.Assert(s.graph.UpsertLink(link), gc.IsNil)
+		s.links = append(s.links, link)
+	}
+
+	idx, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	s.idx = idx
+	s.fetched = make(map[uuid.UUID]int)
+}
+
+func (s *ReindexTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.idx.Close(), gc.IsNil)
+}
+
+// source は URL の末尾の番号を内容とするページを返す。番号が 7 の倍数のリンクは読み飛ばす。
+func (s *ReindexTestSuite) source() ContentSource {
+	return ContentSourceFunc(func(_ context.Context, link *graph.Link) (*Page, error) {
+		s.mu.Lock()
+		s.fetched[link.ID]++
+		s.mu.Unlock()
+
+		var n int
+		_, _ = fmt.Sscanf(link.URL, "http://example.com/%d", &n)
+		if n%7 == 0 {
+			return nil, xerrors.Errorf("page %d: %w", n, ErrSkip)
+		}
+		return &Page{Title: "page", Content: fmt.Sprintf("reindexed page number%d", n)}, nil
+	})
+}
+
+func (s *ReindexTestSuite) TestReindexAllLinks(c *gc.C) {
+	var (
+		mu       sync.Mutex
+		progress []Progress
+	)
+	job, err := New(Config{
+		Graph:      s.graph,
+		Indexer:    s.idx,
+		Source:     s.source(),
+		Partitions: 5,
+		BatchSize:  3,
+		OnProgress: func(p Progress) {
+			mu.Lock()
+			progress = append(progress, p)
+			mu.Unlock()
+		},
+	})
+	c.Assert(err, gc.IsNil)
+
+	res, err := job.Run(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Partitions, gc.Equals, 5)
+	c.Assert(res.PartitionsDone, gc.Equals, 5)
+	c.Assert(res.Skipped, gc.Equals, 8)
+	c.Assert(res.Indexed, gc.Equals, numLinks-8)
+	c.Assert(res.Failed, gc.Equals, 0)
+	c.Assert(progress[len(progress)-1].Indexed, gc.Equals, numLinks-8)
+
+	for n, link := range s.links {
+		c.Assert(s.fetched[link.ID], gc.Equals, 1)
+		doc, err := s.idx.FindByID(link.ID)
+		if n%7 == 0 {
+			c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(doc.URL, gc.Equals, link.URL)
+		c.Assert(doc.Content, gc.Equals, fmt.Sprintf("reindexed page number%d", n))
+	}
+
+	it, err := s.idx.Search(index.Query{Expression: "reindexed"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(numLinks-8))
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+func (s *ReindexTestSuite) TestResume(c *gc.C) {
+	store := NewFileCheckpointStore(filepath.Join(c.MkDir(), "checkpoint.json"))
+
+	// 一定数のリンクを処理した後に失敗するインデクサで中断させる
+	failing := &failingIndexer{Indexer: s.idx, remaining: 4}
+	cfg := Config{
+		Graph:       s.graph,
+		Indexer:     failing,
+		Source:      s.source(),
+		Checkpoints: store,
+		Partitions:  3,
+		Workers:     1,
+		BatchSize:   2,
+	}
+	job, err := New(cfg)
+	c.Assert(err, gc.IsNil)
+	_, err = job.Run(context.Background())
+	c.Assert(xerrors.Is(err, errIndexFailed), gc.Equals, true)
+
+	cp, err := store.Load()
+	c.Assert(err, gc.IsNil)
+	c.Assert(cp.Partitions[0].LastID, gc.Not(gc.Equals), uuid.Nil)
+	c.Assert(cp.Partitions[0].Done, gc.Equals, false)
+
+	// 再開したジョブは保存された位置より後のリンクのみを処理する
+	var lastID = cp.Partitions[0].LastID
+	cfg.Indexer = s.idx
+	job, err = New(cfg)
+	c.Assert(err, gc.IsNil)
+	res, err := job.Run(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.PartitionsDone, gc.Equals, 3)
+	c.Assert(res.Indexed+res.Skipped, gc.Equals, numLinks)
+
+	from, to := partitionRange(0, 3)
+	for _, link := range s.links {
+		inFirst := bytes.Compare(link.ID[:], from[:]) >= 0 && bytes.Compare(link.ID[:], to[:]) < 0
+		if inFirst && bytes.Compare(link.ID[:], lastID[:]) <= 0 {
+			c.Assert(s.fetched[link.ID], gc.Equals, 1, gc.Commentf("link %s was fetched again", link.ID))
+		}
+	}
+
+	// 完了したジョブを再び実行しても何も処理しない
+	fetched := len(s.fetched)
+	res, err = job.Run(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.PartitionsDone, gc.Equals, 3)
+	c.Assert(len(s.fetched), gc.Equals, fetched)
+
+	// パーティション数が異なる場合は再開できない
+	cfg.Partitions = 4
+	job, err = New(cfg)
+	c.Assert(err, gc.IsNil)
+	_, err = job.Run(context.Background())
+	c.Assert(xerrors.Is(err, ErrCheckpointMismatch), gc.Equals, true)
+}
+
+func (s *ReindexTestSuite) TestFailedLinks(c *gc.C) {
+	var failed []uuid.UUID
+	job, err := New(Config{
+		Graph:   s.graph,
+		Indexer: s.idx,
+		Source: ContentSourceFunc(func(_ context.Context, link *graph.Link) (*Page, error) {
+			if link.ID == s.links[3].ID {
+				return nil, xerrors.New("connection reset")
+			}
+			return &Page{Content: "ok"}, nil
+		}),
+		Workers: 1,
+		OnError: func(link *graph.Link, err error) { failed = append(failed, link.ID) },
+	})
+	c.Assert(err, gc.IsNil)
+
+	res, err := job.Run(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Failed, gc.Equals, 1)
+	c.Assert(res.Indexed, gc.Equals, numLinks-1)
+	c.Assert(failed, gc.DeepEquals, []uuid.UUID{s.links[3].ID})
+}
+
+func (s *ReindexTestSuite) TestCancel(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job, err := New(Config{
+		Graph:   s.graph,
+		Indexer: s.idx,
+		Source: ContentSourceFunc(func(ctx context.Context, link *graph.Link) (*Page, error) {
+			cancel()
+			return nil, ctx.Err()
+		}),
+	})
+	c.Assert(err, gc.IsNil)
+
+	res, err := job.Run(ctx)
+	c.Assert(xerrors.Is(err, context.Canceled), gc.Equals, true)
+	c.Assert(res.Failed, gc.Equals, 0)
+}
+
+func (s *ReindexTestSuite) TestPartitionRanges(c *gc.C) {
+	for _, n := range []int{1, 3, 16} {
+		from, _ := partitionRange(0, n)
+		c.Assert(from, gc.Equals, uuid.Nil)
+		_, to := partitionRange(n-1, n)
+		c.Assert(to, gc.Equals, maxUUID)
+
+		for p := 1; p < n; p++ {
+			_, prevTo := partitionRange(p-1, n)
+			from, to := partitionRange(p, n)
+			c.Assert(from, gc.Equals, prevTo)
+			c.Assert(bytes.Compare(from[:], to[:]) < 0, gc.Equals, true)
+		}
+	}
+}
+
+func (s *ReindexTestSuite) TestHTTPSource(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, `<html><head><title>Tom &amp; Jerry</title><style>p { color: red }</style></head>
+<body><!-- comment --><h1>Cartoon</h1><script>var x = "<p>";</script><p>Cat  and
+mouse</p></body></html>`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src := &HTTPSource{Client: srv.Client()}
+	page, err := src.Content(context.Background(), &graph.Link{URL: srv.URL + "/page"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(page.Title, gc.Equals, "Tom & Jerry")
+	c.Assert(page.Content, gc.Equals, "Cartoon Cat and mouse")
+
+	for _, path := range []string{"/image", "/missing"} {
+		_, err = src.Content(context.Background(), &graph.Link{URL: srv.URL + path})
+		c.Assert(xerrors.Is(err, ErrSkip), gc.Equals, true, gc.Commentf("path %s", path))
+	}
+	c.Assert(strings.Contains(err.Error(), "404"), gc.Equals, true)
+}
+
+func (s *ReindexTestSuite) TestMaxUUIDLink(c *gc.C) {
+	last := &graph.Link{ID: maxUUID, URL: "http://example.com/1000", RetrievedAt: time.Now().Add(-time.Hour)}
+	store := NewFileCheckpointStore(filepath.Join(c.MkDir(), "checkpoint.json"))
+	cfg := Config{
+		Graph:       &maxIDGraph{InMemoryGraph: s.graph, link: last},
+		Indexer:     s.idx,
+		Source:      s.source(),
+		Checkpoints: store,
+		Partitions:  3,
+	}
+	job, err := New(cfg)
+	c.Assert(err, gc.IsNil)
+
+	// Links の範囲に含まれない ID のリンクは最後のパーティションで処理される
+	res, err := job.Run(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Indexed+res.Skipped, gc.Equals, numLinks+1)
+	doc, err := s.idx.FindByID(maxUUID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.Content, gc.Equals, "reindexed page number1000")
+
+	// 処理済みの最後のリンクは再開したジョブで再び処理されない
+	cp, err := store.Load()
+	c.Assert(err, gc.IsNil)
+	cp.Partitions[2].Done = false
+	c.Assert(store.Save(cp), gc.IsNil)
+	_, err = job.Run(context.Background())
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.fetched[maxUUID], gc.Equals, 1)
+}
+
+// maxIDGraph は ID が UUID の最大値のリンクを追加したリンクグラフ。InMemoryGraph はリンクの ID を無作為に割り当てる。
+type maxIDGraph struct {
+	*graphmemory.InMemoryGraph
+	link *graph.Link
+}
+
+func (g *maxIDGraph) FindLink(id uuid.UUID) (*graph.Link, error) {
+	if id == g.link.ID {
+		lCopy := *g.link
+		return &lCopy, nil
+	}
+	return g.InMemoryGraph.FindLink(id)
+}
+
+var errIndexFailed = xerrors.New("index failed")
+
+// failingIndexer は remaining 件の文書を追加した後の IndexBatch を失敗させる
+type failingIndexer struct {
+	index.Indexer
+	remaining int
+}
+
+func (i *failingIndexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	if i.remaining <= 0 {
+		return index.BatchResult{}, errIndexFailed
+	}
+	i.remaining -= len(docs)
+	return index.IndexBatch(i.Indexer, docs)
+}
+
+func (i *failingIndexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	return index.UpdateScores(i.Indexer, scores)
+}
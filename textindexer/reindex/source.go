@@ -0,0 +1,130 @@
+package reindex
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/linkgraph/graph"
+	"golang.org/x/xerrors"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// defaultMaxBodySize は HTTPSource.MaxBodySize が未設定の場合に読み込む本文の最大バイト数
+const defaultMaxBodySize = 2 << 20
+
+// ErrSkip は ContentSource がリンクの内容を取得できず、リンクをインデックスに追加せずに処理済みとする場合に返す
+var ErrSkip = xerrors.New("skip link")
+
+// Page はリンクの内容
+type Page struct {
+	Title   string
+	Content string
+}
+
+// ContentSource はリンクの内容を再取得する、または保存された内容を読み込む
+type ContentSource interface {
+	Content(ctx context.Context, link *graph.Link) (*Page, error)
+}
+
+// ContentSourceFunc は関数を ContentSource として使用するためのアダプタ
+type ContentSourceFunc func(ctx context.Context, link *graph.Link) (*Page, error)
+
+func (f ContentSourceFunc) Content(ctx context.Context, link *graph.Link) (*Page, error) {
+	return f(ctx, link)
+}
+
+// HTTPSource はリンクの URL から HTML を再取得し、タイトルとタグを除いたテキストを取り出す ContentSource の実装
+type HTTPSource struct {
+	// Client はリクエストに使用される。nil の場合は http.DefaultClient が使用される。
+	// クローラと同様に ssrf.Guard の Client を指定することを推奨する。
+	Client *http.Client
+
+	// UserAgent はリクエストの User-Agent ヘッダ
+	UserAgent string
+
+	// MaxBodySize は読み込む本文の最大バイト数。0 の場合は 2 MiB。
+	MaxBodySize int64
+}
+
+// Content は link.URL を取得する。ページが存在しない場合と HTML でない場合は ErrSkip を返す。
+func (s *HTTPSource) Content(ctx context.Context, link *graph.Link) (*Page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.URL, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("fetch %s: %w", link.URL, err)
+	}
+	if s.UserAgent != "" {
+		req.Header.Set("User-Agent", s.UserAgent)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("fetch %s: %w", link.URL, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return nil, xerrors.Errorf("fetch %s: status %d: %w", link.URL, res.StatusCode, ErrSkip)
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, xerrors.Errorf("fetch %s: unexpected status %d", link.URL, res.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, xerrors.Errorf("fetch %s: content type %q: %w", link.URL, mediaType, ErrSkip)
+	}
+
+	maxSize := s.MaxBodySize
+	if maxSize <= 0 {
+		maxSize = defaultMaxBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxSize))
+	if err != nil {
+		return nil, xerrors.Errorf("fetch %s: %w", link.URL, err)
+	}
+	return extractPage(string(body)), nil
+}
+
+var (
+	titleRegex   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	commentRegex = regexp.MustCompile(`(?s)<!--.*?-->`)
+	tagRegex     = regexp.MustCompile(`(?s)<[^>]*>`)
+
+	// hiddenRegexes は表示されない要素とその内容に一致する。先に外側の head を取り除く。
+	hiddenRegexes = []*regexp.Regexp{
+		hiddenElement("head"),
+		hiddenElement("script"),
+		hiddenElement("style"),
+		hiddenElement("noscript"),
+		hiddenElement("template"),
+	}
+)
+
+func hiddenElement(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?is)<` + name + `\b[^>]*>.*?</` + name + `\s*>`)
+}
+
+// extractPage は HTML からタイトルと表示されるテキストを取り出す
+func extractPage(doc string) *Page {
+	var p Page
+	if m := titleRegex.FindStringSubmatch(doc); m != nil {
+		p.Title = collapseSpace(html.UnescapeString(m[1]))
+	}
+
+	doc = commentRegex.ReplaceAllString(doc, " ")
+	for _, re := range hiddenRegexes {
+		doc = re.ReplaceAllString(doc, " ")
+	}
+	doc = tagRegex.ReplaceAllString(doc, " ")
+	p.Content = collapseSpace(html.UnescapeString(doc))
+	return &p
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,259 @@
+// Package shard は文書をリンク ID のハッシュで複数の index.Indexer に振り分ける。
+// 検索はすべてのシャードに並行して行われ、結果は PageRank と関連度の降順に統合される。
+package shard
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/ranking"
+	"golang.org/x/xerrors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoShards は NewIndexer にシャードが渡されなかった場合に返される
+var ErrNoShards = xerrors.New("no shards")
+
+var (
+	_ index.BatchIndexer = (*Indexer)(nil)
+	_ index.Restorer     = (*Indexer)(nil)
+	_ index.Scanner      = (*Indexer)(nil)
+)
+
+// Indexer は文書を複数のシャードに振り分ける index.Indexer の実装。
+// 文書を保持するシャードはリンク ID のみから決まるため、シャードの数を変更した場合は文書を振り分け直す必要がある。
+type Indexer struct {
+	shards []index.Indexer
+}
+
+// NewIndexer は shards に文書を振り分ける Indexer を作成する
+func NewIndexer(shards []index.Indexer) (*Indexer, error) {
+	if len(shards) == 0 {
+		return nil, xerrors.Errorf("new sharded indexer: %w", ErrNoShards)
+	}
+	return &Indexer{shards: append([]index.Indexer(nil), shards...)}, nil
+}
+
+// ShardFor は linkID の文書を保持するシャードの番号を返す
+func (i *Indexer) ShardFor(linkID uuid.UUID) int {
+	h := fnv.New32a()
+	_, _ = h.Write(linkID[:])
+	return int(h.Sum32() % uint32(len(i.shards)))
+}
+
+func (i *Indexer) shard(linkID uuid.UUID) index.Indexer {
+	return i.shards[i.ShardFor(linkID)]
+}
+
+// Index は文書をリンク ID に対応するシャードに追加する
+func (i *Indexer) Index(doc *index.Document) error {
+	if doc.LinkID == uuid.Nil {
+		return xerrors.Errorf("index: %w", index.ErrMissingLinkID)
+	}
+	return i.shard(doc.LinkID).Index(doc)
+}
+
+// FindByID はリンク ID に対応するシャードから文書を取得する
+func (i *Indexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.shard(linkID).FindByID(linkID)
+}
+
+// UpdateScore はリンク ID に対応するシャードの文書の PageRank スコアを更新する
+func (i *Indexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	return i.shard(linkID).UpdateScore(linkID, score)
+}
+
+// Delete はリンク ID に対応するシャードから文書を削除する
+func (i *Indexer) Delete(linkID uuid.UUID) error {
+	return i.shard(linkID).Delete(linkID)
+}
+
+// DeleteMatching はシャードごとに順に pred が true を返す文書を削除する。
+// index.Predicate は並行して呼び出されないことが前提のため、シャードを並行して処理しない。
+// いずれかのシャードで失敗した場合も、それまでのシャードで削除した文書の数を返す。
+func (i *Indexer) DeleteMatching(pred index.Predicate) (int, error) {
+	var total int
+	for s, shard := range i.shards {
+		n, err := shard.DeleteMatching(pred)
+		total += n
+		if err != nil {
+			return total, xerrors.Errorf("shard %d: %w", s, err)
+		}
+	}
+	return total, nil
+}
+
+// Scan はシャードごとに順にすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	for s, shard := range i.shards {
+		if err := index.Scan(ctx, shard, fn); err != nil {
+			return xerrors.Errorf("shard %d: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// IndexBatch は文書をシャードごとに分けて、各シャードに並行して追加する
+func (i *Indexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	return i.indexBatch(docs, index.IndexBatch)
+}
+
+// Restore は文書をシャードごとに分けて、各シャードに並行して IndexedAt と PageRank を保持したまま追加する
+func (i *Indexer) Restore(docs []*index.Document) (index.BatchResult, error) {
+	return i.indexBatch(docs, index.Restore)
+}
+
+// indexBatch は文書をシャードごとに分けて、各シャードに対して並行して add を呼び出す
+func (i *Indexer) indexBatch(docs []*index.Document, add func(index.Indexer, []*index.Document) (index.BatchResult, error)) (index.BatchResult, error) {
+	var (
+		res       index.BatchResult
+		start     = time.Now()
+		batches   = make([][]*index.Document, len(i.shards))
+		positions = make([][]int, len(i.shards))
+	)
+	for pos, doc := range docs {
+		if doc == nil || doc.LinkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, Err: index.ErrMissingLinkID})
+			continue
+		}
+		s := i.ShardFor(doc.LinkID)
+		batches[s] = append(batches[s], doc)
+		positions[s] = append(positions[s], pos)
+	}
+
+	results := make([]index.BatchResult, len(i.shards))
+	err := i.each(func(s int, shard index.Indexer) error {
+		if len(batches[s]) == 0 {
+			return nil
+		}
+		var err error
+		results[s], err = add(shard, batches[s])
+		return err
+	})
+	if err != nil {
+		return index.BatchResult{}, err
+	}
+
+	for s, shardRes := range results {
+		res.Processed += shardRes.Processed
+		for _, docErr := range shardRes.Errors {
+			docErr.Pos = positions[s][docErr.Pos]
+			res.Errors = append(res.Errors, docErr)
+		}
+	}
+	sort.Slice(res.Errors, func(a, b int) bool { return res.Errors[a].Pos < res.Errors[b].Pos })
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// UpdateScores は PageRank スコアをシャードごとに分けて、各シャードで並行して更新する
+func (i *Indexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	var (
+		res     index.BatchResult
+		start   = time.Now()
+		batches = make([]map[uuid.UUID]float64, len(i.shards))
+	)
+	for linkID, score := range scores {
+		s := i.ShardFor(linkID)
+		if batches[s] == nil {
+			batches[s] = make(map[uuid.UUID]float64)
+		}
+		batches[s][linkID] = score
+	}
+
+	results := make([]index.BatchResult, len(i.shards))
+	err := i.each(func(s int, shard index.Indexer) error {
+		if len(batches[s]) == 0 {
+			return nil
+		}
+		var err error
+		results[s], err = index.UpdateScores(shard, batches[s])
+		return err
+	})
+	if err != nil {
+		return index.BatchResult{}, err
+	}
+
+	for _, shardRes := range results {
+		res.Processed += shardRes.Processed
+		res.Errors = append(res.Errors, shardRes.Errors...)
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// Search はすべてのシャードで並行して検索を行い、結果を PageRank、関連度、リンク ID の順に統合するイテレータを返す。
+// TotalCount は各シャードの TotalCount の合計となる。q.Offset は統合した結果に適用されるため、
+// 各シャードからは先頭から q.Offset + q.Limit 件までの文書を取得する。
+// 返されるカーソルには各シャードで最後に返した文書のカーソルが含まれる。
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	if q.Ranking != nil {
+		return ranking.Search(q, i.Search)
+	}
+
+	shardQueries := make([]index.Query, len(i.shards))
+	for s := range shardQueries {
+		sq := q
+		sq.Offset, sq.Cursor = 0, ""
+		if q.Limit > 0 {
+			sq.Limit = q.Offset + q.Limit
+		}
+		shardQueries[s] = sq
+	}
+	if q.Cursor != "" {
+		var cursors []string
+		if err := index.DecodeCursor(q, &cursors); err != nil {
+			return nil, xerrors.Errorf("search: %w", err)
+		} else if len(cursors) != len(i.shards) {
+			return nil, xerrors.Errorf("search: decode cursor: %w", index.ErrInvalidCursor)
+		}
+		for s, c := range cursors {
+			shardQueries[s].Cursor = c
+		}
+	}
+
+	its := make([]index.Iterator, len(i.shards))
+	err := i.each(func(s int, shard index.Indexer) error {
+		var err error
+		its[s], err = shard.Search(shardQueries[s])
+		return err
+	})
+	if err != nil {
+		for _, it := range its {
+			if it != nil {
+				_ = it.Close()
+			}
+		}
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+
+	return newMergeIterator(its, q), nil
+}
+
+// each はシャードごとに fn を並行して呼び出し、最初に発生したエラーを返す
+func (i *Indexer) each(fn func(s int, shard index.Indexer) error) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(i.shards))
+	)
+	for s, shard := range i.shards {
+		wg.Add(1)
+		go func(s int, shard index.Indexer) {
+			defer wg.Done()
+			if err := fn(s, shard); err != nil {
+				errs[s] = xerrors.Errorf("shard %d: %w", s, err)
+			}
+		}(s, shard)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
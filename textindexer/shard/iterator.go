@@ -0,0 +1,192 @@
+package shard
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+)
+
+var (
+	_ index.CursorIterator  = (*mergeIterator)(nil)
+	_ index.SnippetIterator = (*mergeIterator)(nil)
+	_ index.ScoredIterator  = (*mergeIterator)(nil)
+)
+
+// head は各シャードのイテレータの次に返す文書
+type head struct {
+	it    index.Iterator
+	doc   *index.Document
+	score float64
+	done  bool
+}
+
+// mergeIterator は各シャードの結果を PageRank、関連度、リンク ID の順に統合する index.Iterator の実装
+type mergeIterator struct {
+	heads   []head
+	started bool
+	total   uint64
+
+	// skip は統合した結果のうち読み飛ばす残りの件数
+	skip uint64
+
+	// limit は返す文書の最大数、returned は返した文書の数
+	limit    uint64
+	returned uint64
+
+	// cursors は各シャードで最後に返した文書のカーソル
+	cursors []string
+
+	latched *head
+	snippet string
+	lastErr error
+}
+
+func newMergeIterator(its []index.Iterator, q index.Query) *mergeIterator {
+	it := &mergeIterator{
+		heads:   make([]head, len(its)),
+		skip:    q.Offset,
+		limit:   q.Limit,
+		cursors: make([]string, len(its)),
+	}
+	for s, shardIt := range its {
+		it.heads[s].it = shardIt
+		it.total += shardIt.TotalCount()
+	}
+	if q.Cursor != "" {
+		// 続きのページでも、文書を返していないシャードは受け取ったカーソルの位置から再開する
+		_ = index.DecodeCursor(q, &it.cursors)
+	}
+	return it
+}
+
+func (it *mergeIterator) Close() error {
+	var err error
+	for s := range it.heads {
+		h := &it.heads[s]
+		if h.it == nil {
+			continue
+		}
+		if closeErr := h.it.Close(); closeErr != nil && err == nil {
+			err = xerrors.Errorf("shard %d: %w", s, closeErr)
+		}
+		h.it, h.done = nil, true
+	}
+	return err
+}
+
+func (it *mergeIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		for s := range it.heads {
+			it.advance(s)
+		}
+	}
+
+	for it.lastErr == nil && (it.limit == 0 || it.returned < it.limit) {
+		best := -1
+		for s := range it.heads {
+			if !it.heads[s].done && (best == -1 || before(&it.heads[s], &it.heads[best])) {
+				best = s
+			}
+		}
+		if best == -1 {
+			return false
+		}
+
+		latched := it.heads[best]
+		it.latched = &latched
+		it.snippet = ""
+		if si, ok := latched.it.(index.SnippetIterator); ok {
+			it.snippet = si.Snippet()
+		}
+		if ci, ok := latched.it.(index.CursorIterator); ok {
+			it.cursors[best] = ci.Cursor()
+		}
+		it.advance(best)
+
+		if it.skip > 0 {
+			it.skip--
+			continue
+		}
+		it.returned++
+		return true
+	}
+	return false
+}
+
+// advance はシャード s のイテレータから次の文書を取得する
+func (it *mergeIterator) advance(s int) {
+	h := &it.heads[s]
+	if h.done || h.it == nil {
+		h.done = true
+		return
+	}
+	if !h.it.Next() {
+		h.done = true
+		if err := h.it.Error(); err != nil && it.lastErr == nil {
+			it.lastErr = xerrors.Errorf("shard %d: %w", s, err)
+		}
+		return
+	}
+
+	h.doc = h.it.Document()
+	h.score = 0
+	if si, ok := h.it.(index.ScoredIterator); ok {
+		h.score = si.Relevance()
+	}
+}
+
+// before は a の文書が b の文書より先に並ぶかを返す
+func before(a, b *head) bool {
+	switch {
+	case a.doc.PageRank != b.doc.PageRank:
+		return a.doc.PageRank > b.doc.PageRank
+	case a.score != b.score:
+		return a.score > b.score
+	default:
+		return a.doc.LinkID.String() < b.doc.LinkID.String()
+	}
+}
+
+func (it *mergeIterator) Error() error {
+	return it.lastErr
+}
+
+func (it *mergeIterator) Document() *index.Document {
+	if it.latched == nil {
+		return nil
+	}
+	return it.latched.doc
+}
+
+// Snippet は現在の文書を返したシャードのイテレータが作成した抜粋を返す
+func (it *mergeIterator) Snippet() string {
+	return it.snippet
+}
+
+// Relevance は現在の文書を返したシャードのイテレータが返した関連度を返す
+func (it *mergeIterator) Relevance() float64 {
+	if it.latched == nil {
+		return 0
+	}
+	return it.latched.score
+}
+
+// Cursor は各シャードで最後に返した文書の次から検索を再開するためのカーソルを返す。
+// シャードのイテレータが index.CursorIterator を実装しない場合は空文字列を返す。
+func (it *mergeIterator) Cursor() string {
+	if it.latched == nil {
+		return ""
+	}
+	for _, h := range it.heads {
+		if h.it != nil {
+			if _, ok := h.it.(index.CursorIterator); !ok {
+				return ""
+			}
+		}
+	}
+	return index.EncodeCursor(it.cursors)
+}
+
+func (it *mergeIterator) TotalCount() uint64 {
+	return it.total
+}
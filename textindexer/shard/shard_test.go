@@ -0,0 +1,125 @@
+package shard
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/indextest"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(ShardedIndexerTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+const numShards = 3
+
+type ShardedIndexerTestSuite struct {
+	indextest.SuiteBase
+	shards []*memory.InMemoryBleveIndexer
+	idx    *Indexer
+}
+
+func (s *ShardedIndexerTestSuite) SetUpTest(c *gc.C) {
+	s.shards = nil
+	var shards []index.Indexer
+	for n := 0; n < numShards; n++ {
+		shard, err := memory.NewInMemoryBleveIndexer()
+		c.Assert(err, gc.IsNil)
+		s.shards = append(s.shards, shard)
+		shards = append(shards, shard)
+	}
+
+	idx, err := NewIndexer(shards)
+	c.Assert(err, gc.IsNil)
+	s.idx = idx
+	s.SetIndexer(idx)
+}
+
+func (s *ShardedIndexerTestSuite) TearDownTest(c *gc.C) {
+	for _, shard := range s.shards {
+		c.Assert(shard.Close(), gc.IsNil)
+	}
+}
+
+func (s *ShardedIndexerTestSuite) TestDocumentsAreDistributed(c *gc.C) {
+	var ids []uuid.UUID
+	for n := 0; n < 30; n++ {
+		id := uuid.New()
+		ids = append(ids, id)
+		c.Assert(s.idx.Index(&index.Document{LinkID: id, Content: "distributed"}), gc.IsNil)
+	}
+
+	for _, id := range ids {
+		// 文書は ShardFor が返すシャードのみに格納される
+		for n, shard := range s.shards {
+			_, err := shard.FindByID(id)
+			if n == s.idx.ShardFor(id) {
+				c.Assert(err, gc.IsNil)
+			} else {
+				c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, true)
+			}
+		}
+	}
+
+	for n, shard := range s.shards {
+		it, err := shard.Search(index.Query{Expression: "distributed"})
+		c.Assert(err, gc.IsNil)
+		c.Assert(it.TotalCount() > 0, gc.Equals, true, gc.Commentf("shard %d is empty", n))
+		c.Assert(it.Close(), gc.IsNil)
+	}
+}
+
+func (s *ShardedIndexerTestSuite) TestGlobalOffset(c *gc.C) {
+	var exp []uuid.UUID
+	for n := 0; n < 12; n++ {
+		id := uuid.New()
+		exp = append(exp, id)
+		c.Assert(s.idx.Index(&index.Document{LinkID: id, Content: "offset"}), gc.IsNil)
+		c.Assert(s.idx.UpdateScore(id, float64(100-n)), gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{Expression: "offset", Offset: 5, Limit: 4})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(12))
+
+	var got []uuid.UUID
+	for it.Next() {
+		got = append(got, it.Document().LinkID)
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(got, gc.DeepEquals, exp[5:9])
+}
+
+func (s *ShardedIndexerTestSuite) TestDeleteMatchingCallsPredicateSequentially(c *gc.C) {
+	for n := 0; n < 30; n++ {
+		c.Assert(s.idx.Index(&index.Document{LinkID: uuid.New(), Content: "sequential"}), gc.IsNil)
+	}
+
+	// 排他制御のない Predicate が並行して呼び出されると inFlight が 1 を超える
+	var inFlight, maxInFlight, calls int32
+	n, err := s.idx.DeleteMatching(func(doc *index.Document) bool {
+		cur := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		if cur > atomic.LoadInt32(&maxInFlight) {
+			atomic.StoreInt32(&maxInFlight, cur)
+		}
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond)
+		return true
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 30)
+	c.Assert(calls, gc.Equals, int32(30))
+	c.Assert(maxInFlight, gc.Equals, int32(1))
+}
+
+func (s *ShardedIndexerTestSuite) TestNoShards(c *gc.C) {
+	_, err := NewIndexer(nil)
+	c.Assert(xerrors.Is(err, ErrNoShards), gc.Equals, true)
+}
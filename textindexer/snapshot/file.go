@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ExportFile は idx のすべての文書を path のアーカイブに書き出す。
+// アーカイブは同じディレクトリの一時ファイルに書き出してから置き換えるため、失敗した場合も既存のファイルは変更されない。
+func ExportFile(path string, idx index.Indexer) (Manifest, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	m, err := Export(f, idx)
+	if err != nil {
+		_ = f.Close()
+		return Manifest{}, err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+	return m, nil
+}
+
+// RestoreFile は path のアーカイブを検証してから文書を idx に追加する。
+// アーカイブが破損している場合、idx は変更されない。
+func RestoreFile(path string, idx index.Indexer) (Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Manifest{}, xerrors.Errorf("restore: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := Verify(f); err != nil {
+		return Manifest{}, xerrors.Errorf("restore: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Manifest{}, xerrors.Errorf("restore: %w", err)
+	}
+	return Restore(f, idx)
+}
@@ -0,0 +1,269 @@
+// Package snapshot はインデックスのすべての文書を gzip で圧縮した JSON Lines 形式のアーカイブに書き出し、
+// 任意の index.Indexer に復元する。
+//
+// アーカイブの 1 行目はフォーマットとバージョンを含むヘッダ、最終行は文書の数と文書の行の SHA-256 を含むトレーラで、
+// その間の各行が 1 件の文書となる。書き出しと復元はどちらも文書を逐次処理するため、使用するメモリは文書の数に依存しない。
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	"hash"
+	"io"
+	"time"
+)
+
+const (
+	// Format はアーカイブのヘッダに記録されるフォーマット名
+	Format = "textindexer-snapshot"
+
+	// Version は書き出すアーカイブのバージョン。これより新しいバージョンのアーカイブは復元できない。
+	Version = 1
+
+	// restoreBatchSize は復元時に 1 回の index.Restore で追加する文書の数
+	restoreBatchSize = 500
+)
+
+var (
+	// ErrCorrupted はアーカイブが破損している、または切り詰められている場合に返される
+	ErrCorrupted = xerrors.New("corrupted snapshot")
+
+	// ErrUnsupportedVersion はアーカイブのバージョンに対応していない場合に返される
+	ErrUnsupportedVersion = xerrors.New("unsupported snapshot version")
+)
+
+// Manifest はアーカイブのヘッダとトレーラの内容
+type Manifest struct {
+	Version   int
+	CreatedAt time.Time
+
+	// Documents はアーカイブに含まれる文書の数
+	Documents int
+
+	// Checksum は文書の行の SHA-256 の 16 進数表記
+	Checksum string
+}
+
+// line はアーカイブの 1 行。Header、Doc、End のいずれか 1 つのみが設定される。
+type line struct {
+	Header *header  `json:"header,omitempty"`
+	Doc    *record  `json:"doc,omitempty"`
+	End    *trailer `json:"end,omitempty"`
+}
+
+type header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type trailer struct {
+	Documents int    `json:"documents"`
+	Checksum  string `json:"sha256"`
+}
+
+// record はアーカイブに保存される文書。index.Document の変更がアーカイブの形式に影響しないように別に定義する。
+type record struct {
+	LinkID      uuid.UUID `json:"link_id"`
+	URL         string    `json:"url,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Content     string    `json:"content,omitempty"`
+	IndexedAt   time.Time `json:"indexed_at"`
+	PageRank    float64   `json:"page_rank"`
+	Fingerprint uint64    `json:"fingerprint,omitempty"`
+	DuplicateOf uuid.UUID `json:"duplicate_of"`
+	Language    string    `json:"language,omitempty"`
+}
+
+func makeRecord(d *index.Document) *record {
+	return &record{
+		LinkID:      d.LinkID,
+		URL:         d.URL,
+		Title:       d.Title,
+		Content:     d.Content,
+		IndexedAt:   d.IndexedAt,
+		PageRank:    d.PageRank,
+		Fingerprint: d.Fingerprint,
+		DuplicateOf: d.DuplicateOf,
+		Language:    d.Language,
+	}
+}
+
+func (r *record) document() *index.Document {
+	return &index.Document{
+		LinkID:      r.LinkID,
+		URL:         r.URL,
+		Title:       r.Title,
+		Content:     r.Content,
+		IndexedAt:   r.IndexedAt,
+		PageRank:    r.PageRank,
+		Fingerprint: r.Fingerprint,
+		DuplicateOf: r.DuplicateOf,
+		Language:    r.Language,
+	}
+}
+
+// Export は idx のすべての文書を index.Scan で走査してアーカイブとして w に書き出す
+func Export(w io.Writer, idx index.Indexer) (Manifest, error) {
+	m := Manifest{Version: Version, CreatedAt: time.Now().UTC()}
+
+	var (
+		zw  = gzip.NewWriter(w)
+		sum = sha256.New()
+	)
+	if err := writeLine(zw, nil, line{Header: &header{Format: Format, Version: Version, CreatedAt: m.CreatedAt}}); err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+
+	err := index.Scan(context.Background(), idx, func(doc *index.Document) error {
+		if err := writeLine(zw, sum, line{Doc: makeRecord(doc)}); err != nil {
+			return err
+		}
+		m.Documents++
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+
+	m.Checksum = hex.EncodeToString(sum.Sum(nil))
+	if err := writeLine(zw, nil, line{End: &trailer{Documents: m.Documents, Checksum: m.Checksum}}); err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return Manifest{}, xerrors.Errorf("export: %w", err)
+	}
+	return m, nil
+}
+
+// writeLine は l を 1 行の JSON として書き出す。sum が nil でない場合は書き出した行をハッシュに加える。
+func writeLine(w io.Writer, sum hash.Hash, l line) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if sum != nil {
+		_, _ = sum.Write(b)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Restore は r のアーカイブの文書を IndexedAt と PageRank を保持したまま idx に追加する。
+// 文書はバッチごとに追加されるため、アーカイブの破損がトレーラの検証で判明した場合も、それまでの文書は idx に追加されている。
+// 事前に破損を検出するには Verify を使用する。
+func Restore(r io.Reader, idx index.Indexer) (Manifest, error) {
+	m, err := read(r, func(docs []*index.Document) error {
+		res, err := index.Restore(idx, docs)
+		if err != nil {
+			return err
+		} else if len(res.Errors) != 0 {
+			return res.Errors[0]
+		}
+		return nil
+	})
+	if err != nil {
+		return Manifest{}, xerrors.Errorf("restore: %w", err)
+	}
+	return m, nil
+}
+
+// Verify は r のアーカイブを読み込み、フォーマット、文書の数、チェックサムを検証する
+func Verify(r io.Reader) (Manifest, error) {
+	m, err := read(r, func([]*index.Document) error { return nil })
+	if err != nil {
+		return Manifest{}, xerrors.Errorf("verify: %w", err)
+	}
+	return m, nil
+}
+
+// read は r のアーカイブを検証しながら読み込み、文書をバッチごとに fn に渡す
+func read(r io.Reader, fn func(docs []*index.Document) error) (Manifest, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return Manifest{}, xerrors.Errorf("%v: %w", err, ErrCorrupted)
+	}
+	defer func() { _ = zr.Close() }()
+
+	var (
+		br    = bufio.NewReader(zr)
+		sum   = sha256.New()
+		m     Manifest
+		batch = make([]*index.Document, 0, restoreBatchSize)
+	)
+
+	var l line
+	if _, err := readLine(br, &l); err != nil {
+		return Manifest{}, err
+	} else if l.Header == nil || l.Header.Format != Format {
+		return Manifest{}, xerrors.Errorf("missing header: %w", ErrCorrupted)
+	} else if l.Header.Version < 1 || l.Header.Version > Version {
+		return Manifest{}, xerrors.Errorf("version %d: %w", l.Header.Version, ErrUnsupportedVersion)
+	}
+	m.Version, m.CreatedAt = l.Header.Version, l.Header.CreatedAt
+
+	for {
+		l = line{}
+		b, err := readLine(br, &l)
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		if l.End != nil {
+			m.Checksum = hex.EncodeToString(sum.Sum(nil))
+			if l.End.Documents != m.Documents {
+				return Manifest{}, xerrors.Errorf("trailer reports %d documents, read %d: %w", l.End.Documents, m.Documents, ErrCorrupted)
+			} else if l.End.Checksum != m.Checksum {
+				return Manifest{}, xerrors.Errorf("checksum mismatch: %w", ErrCorrupted)
+			}
+			break
+		} else if l.Doc == nil || l.Doc.LinkID == uuid.Nil {
+			return Manifest{}, xerrors.Errorf("line %d: invalid document: %w", m.Documents+2, ErrCorrupted)
+		}
+
+		_, _ = sum.Write(b)
+		m.Documents++
+		if batch = append(batch, l.Doc.document()); len(batch) == restoreBatchSize {
+			if err := fn(batch); err != nil {
+				return Manifest{}, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	// トレーラの後にデータがなく、gzip の CRC が一致することを確認する
+	if n, err := io.Copy(io.Discard, br); err != nil {
+		return Manifest{}, xerrors.Errorf("%v: %w", err, ErrCorrupted)
+	} else if n != 0 {
+		return Manifest{}, xerrors.Errorf("unexpected data after trailer: %w", ErrCorrupted)
+	}
+
+	if len(batch) != 0 {
+		if err := fn(batch); err != nil {
+			return Manifest{}, err
+		}
+	}
+	return m, nil
+}
+
+// readLine は 1 行を読み込んで l にデコードし、改行を含む行のバイト列を返す
+func readLine(br *bufio.Reader, l *line) ([]byte, error) {
+	b, err := br.ReadBytes('\n')
+	if err == io.EOF {
+		return nil, xerrors.Errorf("unexpected end of snapshot: %w", ErrCorrupted)
+	} else if err != nil {
+		return nil, xerrors.Errorf("%v: %w", err, ErrCorrupted)
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, xerrors.Errorf("%v: %w", err, ErrCorrupted)
+	}
+	return b, nil
+}
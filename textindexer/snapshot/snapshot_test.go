@@ -0,0 +1,236 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/shard"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/inverted"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var _ = gc.Suite(new(SnapshotTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type SnapshotTestSuite struct {
+	src  *memory.InMemoryBleveIndexer
+	docs []*index.Document
+}
+
+func (s *SnapshotTestSuite) SetUpTest(c *gc.C) {
+	src, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	s.src = src
+
+	s.docs = nil
+	for n := 0; n < 20; n++ {
+		doc := &index.Document{
+			LinkID:      uuid.New(),
+			URL:         "http://example.com/" + strings.Repeat("a", n),
+			Title:       "title",
+			Content:     "snapshot content",
+			Fingerprint: uint64(n) << 60,
+		}
+		c.Assert(src.Index(doc), gc.IsNil)
+		c.Assert(src.UpdateScore(doc.LinkID, float64(n)/10), gc.IsNil)
+
+		stored, err := src.FindByID(doc.LinkID)
+		c.Assert(err, gc.IsNil)
+		s.docs = append(s.docs, stored)
+	}
+}
+
+func (s *SnapshotTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.src.Close(), gc.IsNil)
+}
+
+func (s *SnapshotTestSuite) TestRoundTrip(c *gc.C) {
+	var buf bytes.Buffer
+	m, err := Export(&buf, s.src)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Version, gc.Equals, Version)
+	c.Assert(m.Documents, gc.Equals, len(s.docs))
+
+	vm, err := Verify(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.IsNil)
+	c.Assert(vm, gc.DeepEquals, m)
+
+	// 復元に使用する時刻と区別できるように少し待つ
+	time.Sleep(10 * time.Millisecond)
+
+	dst := inverted.NewInvertedIndexer(inverted.Config{})
+	rm, err := Restore(bytes.NewReader(buf.Bytes()), dst)
+	c.Assert(err, gc.IsNil)
+	c.Assert(rm, gc.DeepEquals, m)
+	s.assertRestored(c, dst, true)
+}
+
+func (s *SnapshotTestSuite) TestRestoreIntoShards(c *gc.C) {
+	var shards []index.Indexer
+	for n := 0; n < 3; n++ {
+		shardIdx, err := memory.NewInMemoryBleveIndexer()
+		c.Assert(err, gc.IsNil)
+		defer func() { c.Assert(shardIdx.Close(), gc.IsNil) }()
+		shards = append(shards, shardIdx)
+	}
+	dst, err := shard.NewIndexer(shards)
+	c.Assert(err, gc.IsNil)
+
+	var buf bytes.Buffer
+	_, err = Export(&buf, s.src)
+	c.Assert(err, gc.IsNil)
+	_, err = Restore(&buf, dst)
+	c.Assert(err, gc.IsNil)
+	s.assertRestored(c, dst, true)
+
+	// シャードから書き出したアーカイブも同じ文書を含む
+	buf.Reset()
+	m, err := Export(&buf, dst)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Documents, gc.Equals, len(s.docs))
+}
+
+func (s *SnapshotTestSuite) TestRestoreWithoutRestorer(c *gc.C) {
+	var buf bytes.Buffer
+	_, err := Export(&buf, s.src)
+	c.Assert(err, gc.IsNil)
+
+	// index.Restorer を実装しない Indexer には PageRank のみが復元される
+	backend, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	defer func() { c.Assert(backend.Close(), gc.IsNil) }()
+	dst := struct{ index.Indexer }{backend}
+
+	_, err = Restore(&buf, dst)
+	c.Assert(err, gc.IsNil)
+	s.assertRestored(c, backend, false)
+
+	// index.Scanner を実装しない Indexer はエクスポートできない
+	_, err = Export(io.Discard, dst)
+	c.Assert(xerrors.Is(err, index.ErrScanUnsupported), gc.Equals, true)
+}
+
+func (s *SnapshotTestSuite) TestCorruption(c *gc.C) {
+	var buf bytes.Buffer
+	_, err := Export(&buf, s.src)
+	c.Assert(err, gc.IsNil)
+	archive := buf.Bytes()
+
+	specs := []struct {
+		descr   string
+		archive []byte
+		err     error
+	}{
+		{descr: "not gzip", archive: []byte("plain text"), err: ErrCorrupted},
+		{descr: "truncated", archive: archive[:len(archive)/2], err: ErrCorrupted},
+		{
+			descr:   "modified document",
+			archive: rewrite(c, archive, func(s string) string { return strings.Replace(s, "snapshot content", "tampered content", 1) }),
+			err:     ErrCorrupted,
+		},
+		{
+			descr: "missing document",
+			archive: rewrite(c, archive, func(s string) string {
+				lines := strings.SplitAfter(s, "\n")
+				return strings.Join(append(lines[:1], lines[2:]...), "")
+			}),
+			err: ErrCorrupted,
+		},
+		{
+			descr: "missing trailer",
+			archive: rewrite(c, archive, func(s string) string {
+				return s[:strings.LastIndex(s[:len(s)-1], "\n")+1]
+			}),
+			err: ErrCorrupted,
+		},
+		{
+			descr:   "newer version",
+			archive: rewrite(c, archive, func(s string) string { return strings.Replace(s, `"version":1`, `"version":2`, 1) }),
+			err:     ErrUnsupportedVersion,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.descr)
+		_, err := Verify(bytes.NewReader(spec.archive))
+		c.Assert(xerrors.Is(err, spec.err), gc.Equals, true, gc.Commentf("got error %v", err))
+	}
+}
+
+func (s *SnapshotTestSuite) TestFiles(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "index.jsonl.gz")
+	m, err := ExportFile(path, s.src)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Documents, gc.Equals, len(s.docs))
+
+	dst := inverted.NewInvertedIndexer(inverted.Config{})
+	_, err = RestoreFile(path, dst)
+	c.Assert(err, gc.IsNil)
+	s.assertRestored(c, dst, true)
+
+	// 破損したアーカイブからは 1 件も復元されない
+	archive, err := os.ReadFile(path)
+	c.Assert(err, gc.IsNil)
+	archive = rewrite(c, archive, func(s string) string { return strings.Replace(s, `"sha256":"`, `"sha256":"0`, 1) })
+	c.Assert(os.WriteFile(path, archive, 0644), gc.IsNil)
+
+	empty := inverted.NewInvertedIndexer(inverted.Config{})
+	_, err = RestoreFile(path, empty)
+	c.Assert(xerrors.Is(err, ErrCorrupted), gc.Equals, true)
+	m, err = Export(io.Discard, empty)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Documents, gc.Equals, 0)
+}
+
+func (s *SnapshotTestSuite) assertRestored(c *gc.C, dst index.Indexer, indexedAt bool) {
+	var buf bytes.Buffer
+	m, err := Export(&buf, dst)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Documents, gc.Equals, len(s.docs))
+
+	for _, exp := range s.docs {
+		got, err := dst.FindByID(exp.LinkID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got.URL, gc.Equals, exp.URL)
+		c.Assert(got.Content, gc.Equals, exp.Content)
+		c.Assert(got.PageRank, gc.Equals, exp.PageRank)
+		c.Assert(got.Fingerprint, gc.Equals, exp.Fingerprint)
+		c.Assert(got.IndexedAt.Equal(exp.IndexedAt), gc.Equals, indexedAt)
+	}
+
+	it, err := dst.Search(index.Query{Expression: "snapshot"})
+	c.Assert(err, gc.IsNil)
+	var ranks []float64
+	for it.Next() {
+		ranks = append(ranks, it.Document().PageRank)
+	}
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(ranks, gc.HasLen, len(s.docs))
+	c.Assert(sort.IsSorted(sort.Reverse(sort.Float64Slice(ranks))), gc.Equals, true)
+}
+
+// rewrite はアーカイブを展開して fn で書き換え、再び圧縮する
+func rewrite(c *gc.C, archive []byte, fn func(string) string) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	c.Assert(err, gc.IsNil)
+	data, err := io.ReadAll(zr)
+	c.Assert(err, gc.IsNil)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err = zw.Write([]byte(fn(string(data))))
+	c.Assert(err, gc.IsNil)
+	c.Assert(zw.Close(), gc.IsNil)
+	return buf.Bytes()
+}
@@ -0,0 +1,133 @@
+package spell
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+)
+
+var (
+	_ index.BatchIndexer = (*Indexer)(nil)
+	_ index.Scanner      = (*Indexer)(nil)
+)
+
+// Indexer は index.Indexer をラップし、インデックスに追加される文書の語を Suggester の語彙に登録する。
+// Search が返すイテレータは index.SuggestionIterator を実装し、綴りを修正した検索式を返す。
+type Indexer struct {
+	idx index.Indexer
+	s   *Suggester
+}
+
+// NewIndexer は idx をラップする Indexer を作成する
+func NewIndexer(idx index.Indexer, cfg Config) *Indexer {
+	return &Indexer{idx: idx, s: NewSuggester(cfg)}
+}
+
+// Suggester は語彙を保持する Suggester を返す
+func (i *Indexer) Suggester() *Suggester {
+	return i.s
+}
+
+// Index は文書をインデックスに追加し、その語を語彙に登録する
+func (i *Indexer) Index(doc *index.Document) error {
+	if err := i.idx.Index(doc); err != nil {
+		return err
+	}
+	i.s.Add(doc)
+	return nil
+}
+
+// IndexBatch は文書をまとめてインデックスに追加し、追加に成功した文書の語を語彙に登録する
+func (i *Indexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	res, err := index.IndexBatch(i.idx, docs)
+	if err != nil {
+		return res, err
+	}
+
+	failed := make(map[int]struct{}, len(res.Errors))
+	for _, docErr := range res.Errors {
+		failed[docErr.Pos] = struct{}{}
+	}
+	for pos, doc := range docs {
+		if _, ok := failed[pos]; !ok {
+			i.s.Add(doc)
+		}
+	}
+	return res, nil
+}
+
+// Track は既にインデックスに存在する文書の語を語彙に登録する。
+// プロセスの再起動後に既存の文書を読み込み直す場合に使用する。
+func (i *Indexer) Track(doc *index.Document) {
+	i.s.Add(doc)
+}
+
+func (i *Indexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.idx.FindByID(linkID)
+}
+
+// Search はラップしたインデクサで検索を行い、綴りを修正した検索式を返すイテレータを返す
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	it, err := i.idx.Search(q)
+	if err != nil {
+		return nil, err
+	}
+	return &suggestingIterator{IteratorWrapper: index.IteratorWrapper{Iterator: it}, s: i.s, q: q}, nil
+}
+
+func (i *Indexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	return i.idx.UpdateScore(linkID, score)
+}
+
+func (i *Indexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	return index.UpdateScores(i.idx, scores)
+}
+
+// Delete は文書を削除し、その語を語彙から取り除く
+func (i *Indexer) Delete(linkID uuid.UUID) error {
+	if err := i.idx.Delete(linkID); err != nil {
+		return err
+	}
+	i.s.Remove(linkID)
+	return nil
+}
+
+// DeleteMatching は pred が true を返す文書を削除し、それらの語を語彙から取り除く
+func (i *Indexer) DeleteMatching(pred index.Predicate) (int, error) {
+	matched, count, err := index.CollectMatching(i.idx, pred)
+
+	for _, id := range matched {
+		i.s.Remove(id)
+	}
+	return count, err
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.idx, fn)
+}
+
+var (
+	_ index.SuggestionIterator = (*suggestingIterator)(nil)
+	_ index.CursorIterator     = (*suggestingIterator)(nil)
+	_ index.SnippetIterator    = (*suggestingIterator)(nil)
+	_ index.ScoredIterator     = (*suggestingIterator)(nil)
+)
+
+// suggestingIterator は修正候補を返す index.Iterator。
+// 語彙との比較は結果が得られた検索の多くでは不要なため、修正候補は最初に Suggestion が呼ばれたときに作成する。
+type suggestingIterator struct {
+	index.IteratorWrapper
+	s *Suggester
+	q index.Query
+
+	suggested  bool
+	suggestion string
+}
+
+func (it *suggestingIterator) Suggestion() string {
+	if !it.suggested {
+		it.suggestion, it.suggested = it.s.Suggest(it.q), true
+	}
+	return it.suggestion
+}
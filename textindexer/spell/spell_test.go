@@ -0,0 +1,129 @@
+package spell
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/memory"
+	gc "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = gc.Suite(new(SpellTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type SpellTestSuite struct{}
+
+func (s *SpellTestSuite) TestDistance(c *gc.C) {
+	specs := []struct {
+		a, b string
+		exp  int
+	}{
+		{"golang", "golang", 0},
+		{"golang", "golnag", 1}, // 入れ替え
+		{"golang", "gollang", 1},
+		{"golang", "gopher", 4}, // 上限を超えた場合は上限 + 1
+		{"concurrency", "concurency", 1},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s -> %s", specIndex, spec.a, spec.b)
+		c.Assert(distance([]rune(spec.a), []rune(spec.b), 3), gc.Equals, spec.exp)
+	}
+	c.Assert(distance([]rune("golang"), []rune("gopher"), 2), gc.Equals, 3)
+}
+
+func (s *SpellTestSuite) TestCorrectPrefersFrequentWords(c *gc.C) {
+	sg := NewSuggester(Config{})
+	sg.Add(&index.Document{LinkID: uuid.New(), Content: "fork fork fork"})
+	sg.Add(&index.Document{LinkID: uuid.New(), Content: "form"})
+
+	got, ok := sg.Correct("forn")
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(got, gc.Equals, "fork")
+
+	// 語彙に含まれる語は修正しない
+	_, ok = sg.Correct("Form")
+	c.Assert(ok, gc.Equals, false)
+
+	// 短すぎる語や候補のない語は修正しない
+	_, ok = sg.Correct("fo")
+	c.Assert(ok, gc.Equals, false)
+	_, ok = sg.Correct("xylophone")
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *SpellTestSuite) TestCorrectAfterRemove(c *gc.C) {
+	sg := NewSuggester(Config{})
+	forks, frameworks := uuid.New(), uuid.New()
+	sg.Add(&index.Document{LinkID: forks, Content: "fork fork"})
+	sg.Add(&index.Document{LinkID: frameworks, Content: "framework frameworks"})
+
+	// 文字数の異なる語も編集距離の範囲内であれば候補になる
+	got, ok := sg.Correct("framewrk")
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(got, gc.Equals, "framework")
+
+	// 削除した文書の語は候補にならない
+	sg.Remove(forks)
+	_, ok = sg.Correct("forn")
+	c.Assert(ok, gc.Equals, false)
+	sg.Add(&index.Document{LinkID: forks, Content: "form"})
+	got, ok = sg.Correct("forn")
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(got, gc.Equals, "form")
+}
+
+func (s *SpellTestSuite) TestSuggestKeepsQuerySyntax(c *gc.C) {
+	sg := NewSuggester(Config{})
+	sg.Add(&index.Document{LinkID: uuid.New(), Title: "Concurrency in Go", Content: "goroutines and channels"})
+
+	specs := []struct {
+		q   index.Query
+		exp string
+	}{
+		{index.Query{Expression: "concurency goroutnes"}, "concurrency goroutines"},
+		{index.Query{Expression: "concurrency"}, ""},
+		{index.Query{Type: index.QueryTypePhrase, Expression: "chanels"}, "channels"},
+		{
+			index.Query{Type: index.QueryTypeAdvanced, Expression: `title:concurency AND (chanels OR gorout*) -"goroutnes" site:exampel.com`},
+			`title:concurrency AND (channels OR gorout*) -"goroutines" site:exampel.com`,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.q.Expression)
+		c.Assert(sg.Suggest(spec.q), gc.Equals, spec.exp)
+	}
+}
+
+func (s *SpellTestSuite) TestIndexerSuggestsCorrection(c *gc.C) {
+	bleveIdx, err := memory.NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	defer func() { _ = bleveIdx.Close() }()
+	idx := NewIndexer(bleveIdx, Config{})
+
+	doc := &index.Document{LinkID: uuid.New(), Title: "Fast Fourier transform", Content: "An algorithm that computes the discrete Fourier transform"}
+	c.Assert(idx.Index(doc), gc.IsNil)
+
+	it, err := idx.Search(index.Query{Expression: "fourrier"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(0))
+	si, ok := it.(index.SuggestionIterator)
+	c.Assert(ok, gc.Equals, true, gc.Commentf("iterator does not implement index.SuggestionIterator"))
+	c.Assert(si.Suggestion(), gc.Equals, "fourier")
+	c.Assert(it.Close(), gc.IsNil)
+
+	it, err = idx.Search(index.Query{Expression: si.Suggestion()})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(1))
+	c.Assert(it.(index.SuggestionIterator).Suggestion(), gc.Equals, "")
+	c.Assert(it.Close(), gc.IsNil)
+
+	// 削除した文書の語は候補にならない
+	c.Assert(idx.Delete(doc.LinkID), gc.IsNil)
+	it, err = idx.Search(index.Query{Expression: "fourrier"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.(index.SuggestionIterator).Suggestion(), gc.Equals, "")
+	c.Assert(it.Close(), gc.IsNil)
+}
@@ -0,0 +1,285 @@
+// Package spell はインデックスの語彙から検索式の綴りの誤りを修正する。
+// 語彙に含まれない語は、編集距離が最も小さく、語彙中の出現回数が最も多い語に置き換えられる。
+package spell
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// DefaultMaxDistance は修正の候補とする語の編集距離の最大値
+	DefaultMaxDistance = 2
+
+	// DefaultMinWordLength は修正の対象とする語の最小の文字数
+	DefaultMinWordLength = 3
+)
+
+// Config は Suggester の設定を保持する
+type Config struct {
+	// MaxDistance は候補とする語の編集距離の最大値。0 の場合は DefaultMaxDistance が使用される。
+	// 4 文字以下の語では 1 に制限される。
+	MaxDistance int
+
+	// MinWordLength はこれより短い語を修正しない文字数。0 の場合は DefaultMinWordLength が使用される。
+	MinWordLength int
+}
+
+// Suggester は文書に現れる語とその出現回数を保持し、語彙に含まれない語の修正候補を返す。
+// 複数の goroutine から同時に使用できる。
+type Suggester struct {
+	maxDistance   int
+	minWordLength int
+
+	mu sync.RWMutex
+	// freq は語ごとのすべての文書での出現回数
+	freq map[string]int
+	// docs は文書ごとの語の出現回数。文書を更新または削除する際に freq から差し引く。
+	docs map[uuid.UUID]map[string]int
+	// byLen は語彙の語を文字数ごとに分けたもの。Correct は編集距離の範囲内の文字数の語のみを比較する。
+	byLen map[int]map[string][]rune
+}
+
+// NewSuggester は空の語彙を持つ Suggester を作成する
+func NewSuggester(cfg Config) *Suggester {
+	if cfg.MaxDistance <= 0 {
+		cfg.MaxDistance = DefaultMaxDistance
+	}
+	if cfg.MinWordLength <= 0 {
+		cfg.MinWordLength = DefaultMinWordLength
+	}
+
+	return &Suggester{
+		maxDistance:   cfg.MaxDistance,
+		minWordLength: cfg.MinWordLength,
+		freq:          make(map[string]int),
+		docs:          make(map[uuid.UUID]map[string]int),
+		byLen:         make(map[int]map[string][]rune),
+	}
+}
+
+// Add は文書のタイトルと本文の語を語彙に追加する。同じリンク ID の文書が既にある場合は置き換える。
+func (s *Suggester) Add(doc *index.Document) {
+	counts := make(map[string]int)
+	for _, w := range words(doc.Title + "\n" + doc.Content) {
+		counts[w]++
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(doc.LinkID)
+	for w, n := range counts {
+		if s.freq[w] == 0 {
+			s.addWord(w)
+		}
+		s.freq[w] += n
+	}
+	s.docs[doc.LinkID] = counts
+}
+
+// Remove は文書の語を語彙から取り除く
+func (s *Suggester) Remove(linkID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(linkID)
+}
+
+func (s *Suggester) remove(linkID uuid.UUID) {
+	for w, n := range s.docs[linkID] {
+		if s.freq[w] -= n; s.freq[w] <= 0 {
+			delete(s.freq, w)
+			s.removeWord(w)
+		}
+	}
+	delete(s.docs, linkID)
+}
+
+func (s *Suggester) addWord(w string) {
+	runes := []rune(w)
+	bucket := s.byLen[len(runes)]
+	if bucket == nil {
+		bucket = make(map[string][]rune)
+		s.byLen[len(runes)] = bucket
+	}
+	bucket[w] = runes
+}
+
+func (s *Suggester) removeWord(w string) {
+	n := len([]rune(w))
+	if delete(s.byLen[n], w); len(s.byLen[n]) == 0 {
+		delete(s.byLen, n)
+	}
+}
+
+// Frequency は語彙中の語 word の出現回数を返す
+func (s *Suggester) Frequency(word string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.freq[strings.ToLower(word)]
+}
+
+// Correct は語彙に含まれない語 word の修正候補を返す。
+// word が語彙に含まれる場合や、候補が見つからない場合は false を返す。
+func (s *Suggester) Correct(word string) (string, bool) {
+	word = strings.ToLower(word)
+	target := []rune(word)
+	if len(target) < s.minWordLength || hasCJK(target) {
+		return "", false
+	}
+
+	maxDist := s.maxDistance
+	if len(target) <= 4 && maxDist > 1 {
+		maxDist = 1
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.freq[word] > 0 {
+		return "", false
+	}
+
+	var (
+		best     string
+		bestDist = maxDist + 1
+		bestFreq int
+	)
+	// 文字数の差が maxDist を超える語の編集距離は maxDist を超える
+	for n := len(target) - maxDist; n <= len(target)+maxDist; n++ {
+		for cand, runes := range s.byLen[n] {
+			d := distance(target, runes, maxDist)
+			freq := s.freq[cand]
+			if d < bestDist || (d == bestDist && (freq > bestFreq || (freq == bestFreq && cand < best))) {
+				best, bestDist, bestFreq = cand, d, freq
+			}
+		}
+	}
+	return best, bestDist <= maxDist
+}
+
+// Suggest は q.Expression の語彙に含まれない語を修正した検索式を返す。修正する語がない場合は空文字列を返す。
+// 語以外の文字はそのまま残し、QueryTypeAdvanced の演算子、フィールド名、前方一致の語、url: と site: の値は修正しない。
+func (s *Suggester) Suggest(q index.Query) string {
+	var (
+		expr      = []rune(q.Expression)
+		sb        strings.Builder
+		changed   bool
+		skipValue bool
+	)
+	for start := 0; start < len(expr); {
+		if !isWordRune(expr[start]) {
+			if unicode.IsSpace(expr[start]) {
+				skipValue = false
+			}
+			sb.WriteRune(expr[start])
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(expr) && isWordRune(expr[end]) {
+			end++
+		}
+		word := string(expr[start:end])
+		next := rune(0)
+		if end < len(expr) {
+			next = expr[end]
+		}
+
+		replacement := word
+		switch {
+		case q.Type == index.QueryTypeAdvanced && next == ':':
+			switch strings.ToLower(word) {
+			case "url", "site", "host":
+				skipValue = true
+			}
+		case q.Type == index.QueryTypeAdvanced && (skipValue || next == '*' || isOperator(word)):
+		default:
+			if corrected, ok := s.Correct(word); ok {
+				replacement, changed = corrected, true
+			}
+		}
+		sb.WriteString(replacement)
+		start = end
+	}
+
+	if !changed {
+		return ""
+	}
+	return sb.String()
+}
+
+func isOperator(word string) bool {
+	return word == "AND" || word == "OR" || word == "NOT"
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// words はテキストを小文字の語に分割する。CJK の文字を含む語は語彙に含めない。
+func words(text string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isWordRune(r) }) {
+		if !hasCJK([]rune(w)) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// hasCJK は語が空白で区切られない文字体系の文字を含むかを返す。そのような語は編集距離で修正できない。
+func hasCJK(word []rune) bool {
+	for _, r := range word {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
+
+// distance は a と b の制限付き Damerau-Levenshtein 距離 (隣接する文字の入れ替えを 1 回の編集とみなす) を返す。
+// 距離が max を超えることが確定した時点で max+1 を返す。
+func distance(a, b []rune, max int) int {
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func min(v int, rest ...int) int {
+	for _, r := range rest {
+		if r < v {
+			v = r
+		}
+	}
+	return v
+}
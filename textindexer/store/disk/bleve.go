@@ -0,0 +1,528 @@
+package disk
+
+import (
+	"context"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/highlight"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/lang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/ranking"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/internal/bleveiter"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/internal/blevequery"
+	"golang.org/x/xerrors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize = 10
+
+	// scanBatchSize は Scan と DeleteMatching がすべての文書を走査する際に 1 回の検索で取得する件数
+	scanBatchSize = 100
+)
+
+// 文書のフィールド名
+const (
+	fieldURL         = "URL"
+	fieldTitle       = "Title"
+	fieldContent     = "Content"
+	fieldIndexedAt   = "IndexedAt"
+	fieldPageRank    = "PageRank"
+	fieldFingerprint = "Fingerprint"
+	fieldDuplicateOf = "DuplicateOf"
+	fieldLanguage    = blevequery.FieldLanguage
+
+	// fieldSites は site: による検索のための、URL のホスト名とその上位ドメイン
+	fieldSites = blevequery.FieldSites
+)
+
+var (
+	_ index.ContextIndexer = (*DiskBleveIndexer)(nil)
+	_ index.BatchIndexer   = (*DiskBleveIndexer)(nil)
+	_ index.Restorer       = (*DiskBleveIndexer)(nil)
+	_ index.Scanner        = (*DiskBleveIndexer)(nil)
+)
+
+// DiskBleveIndexer はディスク上の bleve インデックスに index.Document のすべてのフィールドを保存する index.Indexer の実装。
+// 文書はインデックス自体から復元されるため、プロセスを再起動しても同じ内容で検索できる。
+type DiskBleveIndexer struct {
+	// mu は既存の PageRank を保持するための読み込みと書き込みの組を直列化する
+	mu  sync.Mutex
+	idx bleve.Index
+}
+
+// NewDiskBleveIndexer は path にある bleve インデックスを開く。存在しない場合は新たに作成する。
+func NewDiskBleveIndexer(path string) (*DiskBleveIndexer, error) {
+	idx, err := bleve.Open(path)
+	if xerrors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		idx, err = bleve.New(path, newIndexMapping())
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("open index %q: %w", path, err)
+	}
+
+	return &DiskBleveIndexer{idx: idx}, nil
+}
+
+// newIndexMapping は文書のすべてのフィールドを保存するマッピングを作成する。
+// タイトルと本文は文書の言語ごとのアナライザで解析される。このマッピングより前に作成されたインデックスを
+// 言語ごとに解析するには、インデックスを作成し直す必要がある。
+func newIndexMapping() mapping.IndexMapping {
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = newDocMapping()
+	blevequery.AddLanguageMappings(m, newDocMapping)
+	return m
+}
+
+func newDocMapping() *mapping.DocumentMapping {
+	// URL と DuplicateOf は完全一致で検索するため、解析せずに索引付けする
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.IncludeInAll = false
+
+	storeOnlyField := bleve.NewTextFieldMapping()
+	storeOnlyField.Index = false
+	storeOnlyField.IncludeInAll = false
+	storeOnlyField.IncludeTermVectors = false
+
+	docMapping := bleve.NewDocumentStaticMapping()
+	docMapping.AddFieldMappingsAt(fieldURL, keywordField)
+	docMapping.AddFieldMappingsAt(fieldTitle, bleve.NewTextFieldMapping())
+	docMapping.AddFieldMappingsAt(fieldContent, bleve.NewTextFieldMapping())
+	docMapping.AddFieldMappingsAt(fieldIndexedAt, bleve.NewDateTimeFieldMapping())
+	docMapping.AddFieldMappingsAt(fieldPageRank, bleve.NewNumericFieldMapping())
+	docMapping.AddFieldMappingsAt(fieldFingerprint, storeOnlyField)
+	docMapping.AddFieldMappingsAt(fieldDuplicateOf, keywordField)
+
+	sitesField := bleve.NewTextFieldMapping()
+	sitesField.Analyzer = keyword.Name
+	sitesField.Store = false
+	sitesField.IncludeInAll = false
+	docMapping.AddFieldMappingsAt(fieldSites, sitesField)
+	docMapping.AddFieldMappingsAt(fieldLanguage, blevequery.NewLanguageFieldMapping(true))
+	return docMapping
+}
+
+func (i *DiskBleveIndexer) Close() error {
+	return i.idx.Close()
+}
+
+func (i *DiskBleveIndexer) Index(doc *index.Document) error {
+	return i.IndexContext(context.Background(), doc)
+}
+
+func (i *DiskBleveIndexer) IndexContext(ctx context.Context, doc *index.Document) error {
+	if doc.LinkID == uuid.Nil {
+		return xerrors.Errorf("index: %w", index.ErrMissingLinkID)
+	}
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("index: %w", err)
+	}
+
+	doc.IndexedAt = time.Now()
+	doc.Language = lang.OfDocument(doc)
+	dcopy := *doc
+	key := dcopy.LinkID.String()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// 更新する場合、既存のPageRankスコアを保持する。
+	orig, err := i.findByID(key)
+	if err != nil && !xerrors.Is(err, index.ErrNotFound) {
+		return xerrors.Errorf("index: %w", err)
+	} else if orig != nil {
+		dcopy.PageRank = orig.PageRank
+	}
+
+	if err := i.idx.Index(key, makeBleveDoc(&dcopy)); err != nil {
+		return xerrors.Errorf("index: %w", err)
+	}
+	return nil
+}
+
+// IndexBatch は docs を 1 つの bleve のバッチでインデックスに追加する。
+// リンク ID のない文書などはエラーとして報告され、残りの文書は追加される。
+func (i *DiskBleveIndexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	return i.indexBatch(docs, false)
+}
+
+// Restore は IndexBatch と同様に docs を追加するが、文書の IndexedAt と PageRank をそのまま保存する
+func (i *DiskBleveIndexer) Restore(docs []*index.Document) (index.BatchResult, error) {
+	return i.indexBatch(docs, true)
+}
+
+func (i *DiskBleveIndexer) indexBatch(docs []*index.Document, restore bool) (index.BatchResult, error) {
+	var (
+		res   index.BatchResult
+		start = time.Now()
+	)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	batch := i.idx.NewBatch()
+	for pos, doc := range docs {
+		if doc == nil || doc.LinkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, Err: index.ErrMissingLinkID})
+			continue
+		}
+
+		if !restore {
+			doc.IndexedAt = start
+		}
+		doc.Language = lang.OfDocument(doc)
+		dcopy := *doc
+		key := dcopy.LinkID.String()
+
+		// 更新する場合、既存のPageRankスコアを保持する。
+		if !restore {
+			orig, err := i.findByID(key)
+			if err != nil && !xerrors.Is(err, index.ErrNotFound) {
+				res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, LinkID: dcopy.LinkID, Err: err})
+				continue
+			} else if orig != nil {
+				dcopy.PageRank = orig.PageRank
+			}
+		}
+
+		if err := batch.Index(key, makeBleveDoc(&dcopy)); err != nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, LinkID: dcopy.LinkID, Err: err})
+			continue
+		}
+		res.Processed++
+	}
+
+	if err := i.idx.Batch(batch); err != nil {
+		return index.BatchResult{}, xerrors.Errorf("index batch: %w", err)
+	}
+
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+func (i *DiskBleveIndexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.findByID(linkID.String())
+}
+
+func (i *DiskBleveIndexer) FindByIDContext(ctx context.Context, linkID uuid.UUID) (*index.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	}
+	return i.findByID(linkID.String())
+}
+
+// findByID は bleve インデックスに保存されたフィールドから文書を復元する
+func (i *DiskBleveIndexer) findByID(linkID string) (*index.Document, error) {
+	bdoc, err := i.idx.Document(linkID)
+	if err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	} else if bdoc == nil {
+		return nil, xerrors.Errorf("find by ID: %w", index.ErrNotFound)
+	}
+
+	doc, err := decodeBleveDoc(bdoc)
+	if err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	}
+	return doc, nil
+}
+
+// 特定のクエリのインデックスを検索し、結果のイテレータを返します
+func (i *DiskBleveIndexer) Search(q index.Query) (index.Iterator, error) {
+	return i.SearchContext(context.Background(), q)
+}
+
+// SearchContext は Search と同様に検索を行う。ctx がキャンセルされると、イテレータは次のバッチの取得を中断する。
+func (i *DiskBleveIndexer) SearchContext(ctx context.Context, q index.Query) (index.Iterator, error) {
+	if q.Ranking != nil {
+		return ranking.Search(q, func(q index.Query) (index.Iterator, error) {
+			return i.SearchContext(ctx, q)
+		})
+	}
+
+	bq, err := blevequery.NewQuery(q)
+	if err != nil {
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+
+	searchReq, err := blevequery.NewSearchRequest(bq, q, batchSize)
+	if err != nil {
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+	rs, err := i.idx.SearchInContext(ctx, searchReq)
+	if err != nil {
+		return nil, xerrors.Errorf("index: %w", err)
+	}
+
+	it := bleveiter.New(ctx, i.idx, i.findByID, searchReq, rs, q.Offset, q.Limit)
+	if q.Highlight != nil {
+		return highlight.NewIterator(it, q), nil
+	}
+	return it, nil
+}
+
+// UpdateScore は、指定されたリンク ID を持つ文書の PageRank スコアを更新する。
+// そのような文書が存在しない場合、指定されたスコアを持つプレースホルダ文書が作成される。
+func (i *DiskBleveIndexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	return i.UpdateScoreContext(context.Background(), linkID, score)
+}
+
+func (i *DiskBleveIndexer) UpdateScoreContext(ctx context.Context, linkID uuid.UUID, score float64) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("update score: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := linkID.String()
+	doc, err := i.findByID(key)
+	if xerrors.Is(err, index.ErrNotFound) {
+		doc = &index.Document{LinkID: linkID}
+	} else if err != nil {
+		return xerrors.Errorf("update score: %w", err)
+	}
+
+	doc.PageRank = score
+	if err := i.idx.Index(key, makeBleveDoc(doc)); err != nil {
+		return xerrors.Errorf("update score: %w", err)
+	}
+	return nil
+}
+
+// UpdateScores は scores の PageRank スコアを 1 つの bleve のバッチで更新する。
+// 存在しない文書にはプレースホルダ文書が作成される。
+func (i *DiskBleveIndexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	var (
+		res   index.BatchResult
+		start = time.Now()
+	)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	batch := i.idx.NewBatch()
+	for linkID, score := range scores {
+		if linkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: -1, Err: index.ErrMissingLinkID})
+			continue
+		}
+
+		key := linkID.String()
+		doc, err := i.findByID(key)
+		if xerrors.Is(err, index.ErrNotFound) {
+			doc = &index.Document{LinkID: linkID}
+		} else if err != nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: -1, LinkID: linkID, Err: err})
+			continue
+		}
+
+		doc.PageRank = score
+		if err := batch.Index(key, makeBleveDoc(doc)); err != nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: -1, LinkID: linkID, Err: err})
+			continue
+		}
+		res.Processed++
+	}
+
+	if err := i.idx.Batch(batch); err != nil {
+		return index.BatchResult{}, xerrors.Errorf("update scores: %w", err)
+	}
+
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// Delete は指定されたリンク ID を持つ文書をインデックスから削除する
+func (i *DiskBleveIndexer) Delete(linkID uuid.UUID) error {
+	return i.DeleteContext(context.Background(), linkID)
+}
+
+func (i *DiskBleveIndexer) DeleteContext(ctx context.Context, linkID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := linkID.String()
+	if _, err := i.findByID(key); err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+	if err := i.idx.Delete(key); err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// DeleteMatching はすべての文書を scanBatchSize 件ずつ走査し、pred が true を返す文書を走査した件数ごとのバッチで削除する。
+// バッチの削除に失敗した場合は、それまでに削除した文書の数とエラーを返す。
+func (i *DiskBleveIndexer) DeleteMatching(pred index.Predicate) (int, error) {
+	return i.DeleteMatchingContext(context.Background(), pred)
+}
+
+func (i *DiskBleveIndexer) DeleteMatchingContext(ctx context.Context, pred index.Predicate) (int, error) {
+	var count int
+	searchReq := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), scanBatchSize, 0, false)
+	searchReq.SortBy([]string{"_id"})
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, xerrors.Errorf("delete matching: %w", err)
+		}
+
+		n, rs, err := i.deleteMatchingPage(ctx, searchReq, pred)
+		count += n
+		if err != nil {
+			return count, xerrors.Errorf("delete matching: %w", err)
+		}
+		if rs.Hits.Len() < scanBatchSize {
+			return count, nil
+		}
+		// 削除した文書は直前のページに含まれるため、次のページの位置には影響しない
+		searchReq.SearchAfter = []string{rs.Hits[rs.Hits.Len()-1].ID}
+	}
+}
+
+// deleteMatchingPage は searchReq の 1 ページの文書のうち pred が true を返す文書を 1 つのバッチで削除する
+func (i *DiskBleveIndexer) deleteMatchingPage(ctx context.Context, searchReq *bleve.SearchRequest, pred index.Predicate) (int, *bleve.SearchResult, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	rs, err := i.idx.SearchInContext(ctx, searchReq)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var (
+		count int
+		batch = i.idx.NewBatch()
+	)
+	for _, hit := range rs.Hits {
+		doc, err := i.findByID(hit.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if pred(doc) {
+			batch.Delete(hit.ID)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, rs, nil
+	}
+	if err := i.idx.Batch(batch); err != nil {
+		return 0, nil, err
+	}
+	return count, rs, nil
+}
+
+// Scan はリンク ID の順にすべての文書を scanBatchSize 件ずつ検索し、各文書について fn を呼び出す。
+// 書き込みのロックは取得しないため、走査中も文書の追加や削除を妨げない。
+func (i *DiskBleveIndexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	searchReq := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), scanBatchSize, 0, false)
+	searchReq.SortBy([]string{"_id"})
+	for {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("scan: %w", err)
+		}
+
+		rs, err := i.idx.SearchInContext(ctx, searchReq)
+		if err != nil {
+			return xerrors.Errorf("scan: %w", err)
+		}
+		for _, hit := range rs.Hits {
+			doc, err := i.findByID(hit.ID)
+			if xerrors.Is(err, index.ErrNotFound) {
+				// 検索の後に削除された文書は渡さない
+				continue
+			} else if err != nil {
+				return xerrors.Errorf("scan: %w", err)
+			}
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+
+		if rs.Hits.Len() < scanBatchSize {
+			return nil
+		}
+		searchReq.SearchAfter = []string{rs.Hits[rs.Hits.Len()-1].ID}
+	}
+}
+
+// makeBleveDoc は文書をインデックスに保存する形式に変換する。
+// 時刻のゼロ値は bleve の日時フィールドで表現できないため、IndexedAt が未設定の場合はフィールドを省略する。
+func makeBleveDoc(d *index.Document) map[string]interface{} {
+	bdoc := map[string]interface{}{
+		fieldURL:         d.URL,
+		fieldTitle:       d.Title,
+		fieldContent:     d.Content,
+		fieldPageRank:    d.PageRank,
+		fieldFingerprint: strconv.FormatUint(d.Fingerprint, 16),
+		fieldSites:       querylang.Sites(d.URL),
+	}
+	if !d.IndexedAt.IsZero() {
+		bdoc[fieldIndexedAt] = d.IndexedAt
+	}
+	if d.DuplicateOf != uuid.Nil {
+		bdoc[fieldDuplicateOf] = d.DuplicateOf.String()
+	}
+	if d.Language != "" {
+		bdoc[fieldLanguage] = d.Language
+	}
+	return bdoc
+}
+
+// decodeBleveDoc は保存されたフィールドから index.Document を復元する
+func decodeBleveDoc(bdoc *document.Document) (*index.Document, error) {
+	linkID, err := uuid.Parse(bdoc.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &index.Document{LinkID: linkID}
+	for _, f := range bdoc.Fields {
+		switch f := f.(type) {
+		case *document.TextField:
+			switch val := string(f.Value()); f.Name() {
+			case fieldURL:
+				doc.URL = val
+			case fieldTitle:
+				doc.Title = val
+			case fieldContent:
+				doc.Content = val
+			case fieldFingerprint:
+				if doc.Fingerprint, err = strconv.ParseUint(val, 16, 64); err != nil {
+					return nil, err
+				}
+			case fieldDuplicateOf:
+				if doc.DuplicateOf, err = uuid.Parse(val); err != nil {
+					return nil, err
+				}
+			case fieldLanguage:
+				doc.Language = val
+			}
+		case *document.NumericField:
+			if f.Name() == fieldPageRank {
+				if doc.PageRank, err = f.Number(); err != nil {
+					return nil, err
+				}
+			}
+		case *document.DateTimeField:
+			if f.Name() == fieldIndexedAt {
+				if doc.IndexedAt, err = f.DateTime(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,118 @@
+package disk
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/indextest"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"path/filepath"
+	"testing"
+)
+
+var _ = gc.Suite(new(DiskBleveTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type DiskBleveTestSuite struct {
+	indextest.SuiteBase
+	idx  *DiskBleveIndexer
+	path string
+}
+
+func (s *DiskBleveTestSuite) SetUpTest(c *gc.C) {
+	s.path = filepath.Join(c.MkDir(), "index.bleve")
+	idx, err := NewDiskBleveIndexer(s.path)
+	c.Assert(err, gc.IsNil)
+	s.SetIndexer(idx)
+	s.idx = idx
+}
+
+func (s *DiskBleveTestSuite) TearDownTest(c *gc.C) {
+	if s.idx != nil {
+		c.Assert(s.idx.Close(), gc.IsNil)
+	}
+}
+
+func (s *DiskBleveTestSuite) TestDocumentsSurviveRestart(c *gc.C) {
+	doc := &index.Document{
+		LinkID:      uuid.New(),
+		URL:         "http://example.com",
+		Title:       "Illustrious examples",
+		Content:     "Ovidius poeta in terra pontica",
+		Fingerprint: 0xdeadbeefcafebabe,
+		DuplicateOf: uuid.New(),
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+	c.Assert(s.idx.UpdateScore(doc.LinkID, 0.42), gc.IsNil)
+
+	// インデックスを開き直す
+	c.Assert(s.idx.Close(), gc.IsNil)
+	s.idx = nil
+	idx, err := NewDiskBleveIndexer(s.path)
+	c.Assert(err, gc.IsNil)
+	s.idx = idx
+
+	got, err := idx.FindByID(doc.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.URL, gc.Equals, doc.URL)
+	c.Assert(got.Title, gc.Equals, doc.Title)
+	c.Assert(got.Content, gc.Equals, doc.Content)
+	c.Assert(got.IndexedAt.Equal(doc.IndexedAt), gc.Equals, true)
+	c.Assert(got.PageRank, gc.Equals, 0.42)
+	c.Assert(got.Fingerprint, gc.Equals, doc.Fingerprint)
+	c.Assert(got.DuplicateOf, gc.Equals, doc.DuplicateOf)
+	c.Assert(got.Language, gc.Equals, doc.Language)
+
+	it, err := idx.Search(index.Query{Type: index.QueryTypePhrase, Expression: "terra pontica"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.Next(), gc.Equals, true)
+	c.Assert(it.Document().LinkID, gc.Equals, doc.LinkID)
+	c.Assert(it.Next(), gc.Equals, false)
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+func (s *DiskBleveTestSuite) TestDeleteMatchingAcrossBatches(c *gc.C) {
+	var (
+		docs = make([]*index.Document, 0, 2*scanBatchSize+50)
+		odd  = make(map[uuid.UUID]bool)
+	)
+	for n := 0; n < cap(docs); n++ {
+		doc := &index.Document{LinkID: uuid.New(), Content: fmt.Sprintf("document %d", n), Fingerprint: uint64(n)}
+		docs = append(docs, doc)
+		odd[doc.LinkID] = n%2 == 1
+	}
+	_, err := s.idx.IndexBatch(docs)
+	c.Assert(err, gc.IsNil)
+
+	// 削除はページごとのバッチで行われ、すべてのページの文書が対象になる
+	count, err := s.idx.DeleteMatching(func(doc *index.Document) bool { return doc.Fingerprint%2 == 1 })
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, len(docs)/2)
+
+	for id, deleted := range odd {
+		_, err := s.idx.FindByID(id)
+		c.Assert(xerrors.Is(err, index.ErrNotFound), gc.Equals, deleted)
+	}
+}
+
+func (s *DiskBleveTestSuite) TestKeywordFieldsExcludedFromAll(c *gc.C) {
+	doc := &index.Document{
+		LinkID:      uuid.New(),
+		URL:         "http://example.com/page",
+		Content:     "Ovidius poeta in terra pontica",
+		DuplicateOf: uuid.New(),
+	}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	// URL と DuplicateOf はメモリ上のインデックスと同様に、フィールドを指定しない検索の対象にならない
+	for _, value := range []string{doc.URL, doc.DuplicateOf.String()} {
+		tq := bleve.NewTermQuery(value)
+		tq.SetField("_all")
+		rs, err := s.idx.idx.Search(bleve.NewSearchRequest(tq))
+		c.Assert(err, gc.IsNil)
+		c.Assert(rs.Total, gc.Equals, uint64(0), gc.Commentf("value: %s", value))
+	}
+}
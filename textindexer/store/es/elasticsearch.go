@@ -0,0 +1,697 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/highlight"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/lang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/ranking"
+	"golang.org/x/xerrors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultIndexName は Config.IndexName が未設定の場合に使用されるインデックス名
+	DefaultIndexName = "textindexer"
+
+	batchSize = 10
+
+	// sortKeys は検索結果のソートキー (PageRank、スコア、リンク ID) の数
+	sortKeys = 3
+
+	// scanBatchSize は Scan と DeleteMatching がすべての文書を走査する際に 1 回の取得で返される件数
+	scanBatchSize = 100
+
+	// scrollKeepAlive はスクロールコンテキストを次の取得まで保持する期間
+	scrollKeepAlive = "1m"
+)
+
+// indexMapping はインデックスの作成時に使用するマッピング。
+// Fingerprint は uint64 の範囲を long で表現できないため、16 進数の文字列として保存する。
+// Sites は site: による検索のための、URL のホスト名とその上位ドメイン。
+const indexMapping = `{
+  "mappings": {
+    "properties": {
+      "LinkID":      {"type": "keyword"},
+      "URL":         {"type": "keyword"},
+      "Title":       {"type": "text"},
+      "Content":     {"type": "text"},
+      "IndexedAt":   {"type": "date"},
+      "PageRank":    {"type": "double"},
+      "Fingerprint": {"type": "keyword", "index": false},
+      "DuplicateOf": {"type": "keyword"},
+      "Sites":       {"type": "keyword"},
+      "Language":    {"type": "keyword"}
+    }
+  }
+}`
+
+var (
+	_ index.ContextIndexer = (*ElasticSearchIndexer)(nil)
+	_ index.Restorer       = (*ElasticSearchIndexer)(nil)
+	_ index.Scanner        = (*ElasticSearchIndexer)(nil)
+)
+
+// Config は ElasticSearchIndexer の接続設定を保持する
+type Config struct {
+	// Nodes はクラスタのノードの URL (例: http://localhost:9200)。リクエストは順番に各ノードへ送られる。
+	Nodes []string
+
+	// IndexName は文書を保存するインデックス名。既定値は DefaultIndexName。
+	IndexName string
+
+	// SyncUpdates が true の場合、書き込みのたびにインデックスをリフレッシュし、直後の検索に反映させる
+	SyncUpdates bool
+
+	// Client はリクエストに使用する HTTP クライアント。nil の場合は http.DefaultClient が使用される。
+	Client *http.Client
+}
+
+// ElasticSearchIndexer は Elasticsearch または OpenSearch のクラスタを REST API 経由で使用する index.Indexer の実装
+type ElasticSearchIndexer struct {
+	client     *http.Client
+	nodes      []string
+	nextNode   uint32
+	indexName  string
+	refreshOpt string
+}
+
+// NewElasticSearchIndexer は cfg のクラスタに接続し、インデックスが存在しない場合はマッピングとともに作成する
+func NewElasticSearchIndexer(cfg Config) (*ElasticSearchIndexer, error) {
+	if len(cfg.Nodes) == 0 {
+		return nil, xerrors.New("elasticsearch: at least one node must be specified")
+	}
+	if cfg.IndexName == "" {
+		cfg.IndexName = DefaultIndexName
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	refreshOpt := "false"
+	if cfg.SyncUpdates {
+		refreshOpt = "true"
+	}
+
+	i := &ElasticSearchIndexer{
+		client:     cfg.Client,
+		nodes:      cfg.Nodes,
+		indexName:  cfg.IndexName,
+		refreshOpt: refreshOpt,
+	}
+	if err := i.ensureIndex(context.Background()); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// ensureIndex はインデックスが存在しない場合に作成する
+func (i *ElasticSearchIndexer) ensureIndex(ctx context.Context) error {
+	status, err := i.do(ctx, http.MethodHead, i.indexPath(), nil, nil)
+	if status == http.StatusOK {
+		return nil
+	} else if status != http.StatusNotFound {
+		return xerrors.Errorf("check index: %w", err)
+	}
+
+	_, err = i.do(ctx, http.MethodPut, i.indexPath(), json.RawMessage(indexMapping), nil)
+	var esErr *Error
+	if xerrors.As(err, &esErr) && esErr.Type == "resource_already_exists_exception" {
+		// 他のプロセスが同時にインデックスを作成した
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("create index: %w", err)
+	}
+	return nil
+}
+
+func (i *ElasticSearchIndexer) Index(doc *index.Document) error {
+	return i.IndexContext(context.Background(), doc)
+}
+
+// IndexContext は文書を挿入または更新する。
+// 既存の文書は PageRank 以外のフィールドのみが部分更新されるため、PageRank スコアが保持される。
+func (i *ElasticSearchIndexer) IndexContext(ctx context.Context, doc *index.Document) error {
+	if doc.LinkID == uuid.Nil {
+		return xerrors.Errorf("index: %w", index.ErrMissingLinkID)
+	}
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("index: %w", err)
+	}
+
+	doc.IndexedAt = time.Now()
+	doc.Language = lang.OfDocument(doc)
+	fields := makeEsDoc(doc)
+	update := map[string]interface{}{
+		"doc":    fields,
+		"upsert": esRecord{esDoc: fields, PageRank: doc.PageRank},
+	}
+
+	if _, err := i.do(ctx, http.MethodPost, i.updatePath(doc.LinkID), update, nil); err != nil {
+		return xerrors.Errorf("index: %w", err)
+	}
+	return nil
+}
+
+// Restore は docs を PageRank を含めて _bulk API でまとめて保存する。既存の文書は置き換えられ、IndexedAt は上書きされない。
+// 保存した文書はまとめて 1 度だけリフレッシュされる。docs の文書は変更されない。
+func (i *ElasticSearchIndexer) Restore(docs []*index.Document) (index.BatchResult, error) {
+	var (
+		res       index.BatchResult
+		start     = time.Now()
+		body      bytes.Buffer
+		enc       = json.NewEncoder(&body)
+		positions []int
+	)
+	for pos, doc := range docs {
+		if doc == nil || doc.LinkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, Err: index.ErrMissingLinkID})
+			continue
+		}
+
+		dcopy := *doc
+		dcopy.Language = lang.OfDocument(&dcopy)
+		action := map[string]interface{}{"index": map[string]string{"_id": doc.LinkID.String()}}
+		if err := enc.Encode(action); err != nil {
+			return index.BatchResult{}, xerrors.Errorf("restore: %w", err)
+		}
+		if err := enc.Encode(esRecord{esDoc: makeEsDoc(&dcopy), PageRank: dcopy.PageRank}); err != nil {
+			return index.BatchResult{}, xerrors.Errorf("restore: %w", err)
+		}
+		positions = append(positions, pos)
+	}
+
+	if len(positions) != 0 {
+		var bulkRes struct {
+			Items []map[string]struct {
+				Status int `json:"status"`
+				Error  *struct {
+					Type   string `json:"type"`
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"items"`
+		}
+		path := fmt.Sprintf("%s/_bulk?refresh=%s", i.indexPath(), i.refreshOpt)
+		if _, err := i.do(context.Background(), http.MethodPost, path, ndjson(body.Bytes()), &bulkRes); err != nil {
+			return index.BatchResult{}, xerrors.Errorf("restore: %w", err)
+		}
+		if len(bulkRes.Items) != len(positions) {
+			return index.BatchResult{}, xerrors.Errorf("restore: expected %d bulk items, got %d", len(positions), len(bulkRes.Items))
+		}
+
+		for n, item := range bulkRes.Items {
+			pos := positions[n]
+			if r := item["index"]; r.Error != nil {
+				err := &Error{Status: r.Status, Type: r.Error.Type, Reason: r.Error.Reason}
+				res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, LinkID: docs[pos].LinkID, Err: err})
+				continue
+			}
+			res.Processed++
+		}
+		sort.Slice(res.Errors, func(a, b int) bool { return res.Errors[a].Pos < res.Errors[b].Pos })
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+func (i *ElasticSearchIndexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	return i.FindByIDContext(context.Background(), linkID)
+}
+
+func (i *ElasticSearchIndexer) FindByIDContext(ctx context.Context, linkID uuid.UUID) (*index.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	}
+
+	var res struct {
+		Found  bool     `json:"found"`
+		Source esRecord `json:"_source"`
+	}
+	path := fmt.Sprintf("%s/_doc/%s", i.indexPath(), linkID)
+	status, err := i.do(ctx, http.MethodGet, path, nil, &res)
+	if status == http.StatusNotFound || (err == nil && !res.Found) {
+		return nil, xerrors.Errorf("find by ID: %w", index.ErrNotFound)
+	} else if err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	}
+
+	doc, err := mapEsDoc(&res.Source)
+	if err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	}
+	return doc, nil
+}
+
+func (i *ElasticSearchIndexer) Search(q index.Query) (index.Iterator, error) {
+	return i.SearchContext(context.Background(), q)
+}
+
+// SearchContext は PageRank とスコアの降順で文書を返すイテレータを作成する。
+// 結果はスクロール API でバッチ単位に取得され、イテレータを Close するとスクロールコンテキストが解放される。
+// q.Offset または q.Cursor が指定された場合はスクロールの代わりに search_after で続きのバッチを取得する。
+// q.Ranking が指定された場合は一致したすべての文書を取得してから Ranking のスコアで並べる。
+func (i *ElasticSearchIndexer) SearchContext(ctx context.Context, q index.Query) (index.Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+	if q.Ranking != nil {
+		return ranking.Search(q, func(q index.Query) (index.Iterator, error) {
+			return i.SearchContext(ctx, q)
+		})
+	}
+
+	var esQuery map[string]interface{}
+	switch q.Type {
+	case index.QueryTypePhrase:
+		esQuery = multiMatch("phrase", q.Expression)
+	case index.QueryTypeAdvanced:
+		n, err := querylang.Parse(q.Expression)
+		if err != nil {
+			return nil, xerrors.Errorf("search: %w", err)
+		}
+		esQuery = translateQuery(n)
+	default:
+		esQuery = multiMatch("best_fields", q.Expression)
+	}
+
+	size := batchSize
+	if q.Limit > 0 {
+		size = int(q.Limit)
+	}
+	req := map[string]interface{}{
+		"query": esQuery,
+		"sort": []interface{}{
+			map[string]interface{}{"PageRank": map[string]string{"order": "desc"}},
+			map[string]interface{}{"_score": map[string]string{"order": "desc"}},
+			map[string]interface{}{"LinkID": map[string]string{"order": "asc"}},
+		},
+		"size":             size,
+		"track_total_hits": true,
+		// フィールドでソートする場合も Relevance のために _score を計算させる
+		"track_scores": true,
+	}
+
+	it := &esIterator{ctx: ctx, idx: i, limit: q.Limit, cumIdx: q.Offset}
+	path := fmt.Sprintf("%s/_search?scroll=%s", i.indexPath(), scrollKeepAlive)
+	switch {
+	case q.Cursor != "":
+		var after []interface{}
+		if err := index.DecodeCursor(q, &after); err != nil {
+			return nil, xerrors.Errorf("search: %w", err)
+		} else if len(after) != sortKeys {
+			return nil, xerrors.Errorf("search: decode cursor: %w", index.ErrInvalidCursor)
+		}
+		req["search_after"] = after
+		it.req = req
+		path = i.indexPath() + "/_search"
+	case q.Offset > 0:
+		// スクロール API は from を指定できないため、最初のバッチを from で取得し、続きを search_after で取得する
+		req["from"] = q.Offset
+		it.req = req
+		path = i.indexPath() + "/_search"
+	}
+
+	var rs searchResult
+	if _, err := i.do(ctx, http.MethodPost, path, req, &rs); err != nil {
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+	it.rs = &rs
+	// search_after は from と同時に指定できない
+	delete(req, "from")
+
+	if q.Highlight != nil {
+		return highlight.NewIterator(it, q), nil
+	}
+	return it, nil
+}
+
+// searchAfter は after のソートキーを持つ文書の次のバッチを取得する
+func (i *ElasticSearchIndexer) searchAfter(ctx context.Context, req map[string]interface{}, after []interface{}) (*searchResult, error) {
+	req["search_after"] = after
+
+	var rs searchResult
+	if _, err := i.do(ctx, http.MethodPost, i.indexPath()+"/_search", req, &rs); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// scroll はスクロールコンテキストから次のバッチを取得する
+func (i *ElasticSearchIndexer) scroll(ctx context.Context, scrollID string) (*searchResult, error) {
+	req := map[string]string{"scroll": scrollKeepAlive, "scroll_id": scrollID}
+
+	var rs searchResult
+	if _, err := i.do(ctx, http.MethodPost, "/_search/scroll", req, &rs); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// clearScroll はスクロールコンテキストを解放する。既に期限切れの場合はエラーにしない。
+func (i *ElasticSearchIndexer) clearScroll(scrollID string) error {
+	req := map[string][]string{"scroll_id": {scrollID}}
+	status, err := i.do(context.Background(), http.MethodDelete, "/_search/scroll", req, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// existing は ids のうちインデックスに存在する文書の ID を返す。
+// 取得 API は検索と異なりリフレッシュを待たずに削除を反映する。
+func (i *ElasticSearchIndexer) existing(ctx context.Context, ids []string) (map[string]bool, error) {
+	req := map[string]interface{}{"ids": ids}
+
+	var res struct {
+		Docs []struct {
+			ID    string `json:"_id"`
+			Found bool   `json:"found"`
+		} `json:"docs"`
+	}
+	if _, err := i.do(ctx, http.MethodPost, i.indexPath()+"/_mget?_source=false", req, &res); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(res.Docs))
+	for _, doc := range res.Docs {
+		if doc.Found {
+			found[doc.ID] = true
+		}
+	}
+	return found, nil
+}
+
+func (i *ElasticSearchIndexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	return i.UpdateScoreContext(context.Background(), linkID, score)
+}
+
+// UpdateScoreContext は、指定されたリンク ID を持つ文書の PageRank スコアを更新する。
+// そのような文書が存在しない場合、指定されたスコアを持つプレースホルダ文書が作成される。
+func (i *ElasticSearchIndexer) UpdateScoreContext(ctx context.Context, linkID uuid.UUID, score float64) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("update score: %w", err)
+	}
+
+	update := map[string]interface{}{
+		"doc":    map[string]interface{}{"PageRank": score},
+		"upsert": esRecord{esDoc: esDoc{LinkID: linkID.String()}, PageRank: score},
+	}
+	if _, err := i.do(ctx, http.MethodPost, i.updatePath(linkID), update, nil); err != nil {
+		return xerrors.Errorf("update score: %w", err)
+	}
+	return nil
+}
+
+func (i *ElasticSearchIndexer) Delete(linkID uuid.UUID) error {
+	return i.DeleteContext(context.Background(), linkID)
+}
+
+// DeleteContext は指定されたリンク ID を持つ文書を削除する
+func (i *ElasticSearchIndexer) DeleteContext(ctx context.Context, linkID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+
+	path := fmt.Sprintf("%s/_doc/%s?refresh=%s", i.indexPath(), linkID, i.refreshOpt)
+	status, err := i.do(ctx, http.MethodDelete, path, nil, nil)
+	if status == http.StatusNotFound {
+		return xerrors.Errorf("delete: %w", index.ErrNotFound)
+	} else if err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+func (i *ElasticSearchIndexer) DeleteMatching(pred index.Predicate) (int, error) {
+	return i.DeleteMatchingContext(context.Background(), pred)
+}
+
+// DeleteMatchingContext はスクロール API ですべての文書を走査し、pred が true を返す文書を
+// バッチごとに delete by query API で削除する。走査と削除の間に更新された文書も削除される。
+func (i *ElasticSearchIndexer) DeleteMatchingContext(ctx context.Context, pred index.Predicate) (int, error) {
+	var (
+		ids   []string
+		count int
+	)
+	err := i.scan(ctx, func(doc *index.Document) error {
+		if pred(doc) {
+			ids = append(ids, doc.LinkID.String())
+		}
+		if len(ids) < scanBatchSize {
+			return nil
+		}
+		n, err := i.deleteByIDs(ctx, ids)
+		count += n
+		ids = ids[:0]
+		return err
+	})
+	if err != nil {
+		return count, xerrors.Errorf("delete matching: %w", err)
+	}
+
+	if len(ids) != 0 {
+		n, err := i.deleteByIDs(ctx, ids)
+		count += n
+		if err != nil {
+			return count, xerrors.Errorf("delete matching: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// Scan はスクロール API ですべての文書を走査し、各文書について fn を呼び出す
+func (i *ElasticSearchIndexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	if err := i.scan(ctx, fn); err != nil {
+		return xerrors.Errorf("scan: %w", err)
+	}
+	return nil
+}
+
+// scan はスクロール API ですべての文書を走査し、各文書について fn を呼び出す。
+// fn がエラーを返した場合はスクロールを解放してそのエラーを返す。
+func (i *ElasticSearchIndexer) scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	req := map[string]interface{}{
+		"query":            map[string]interface{}{"match_all": map[string]interface{}{}},
+		"sort":             []string{"_doc"},
+		"size":             scanBatchSize,
+		"track_total_hits": true,
+	}
+	var rs searchResult
+	path := fmt.Sprintf("%s/_search?scroll=%s", i.indexPath(), scrollKeepAlive)
+	if _, err := i.do(ctx, http.MethodPost, path, req, &rs); err != nil {
+		return err
+	}
+
+	it := &esIterator{ctx: ctx, idx: i, rs: &rs}
+	for it.Next() {
+		if err := fn(it.Document()); err != nil {
+			_ = it.Close()
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		_ = it.Close()
+		return err
+	}
+	return it.Close()
+}
+
+// deleteByIDs はリンク ID が ids に含まれる文書を削除し、削除された文書の数を返す
+func (i *ElasticSearchIndexer) deleteByIDs(ctx context.Context, ids []string) (int, error) {
+	req := map[string]interface{}{
+		"query": map[string]interface{}{"terms": map[string]interface{}{"LinkID": ids}},
+	}
+	var res struct {
+		Deleted int `json:"deleted"`
+	}
+	path := fmt.Sprintf("%s/_delete_by_query?conflicts=proceed&refresh=%s", i.indexPath(), i.refreshOpt)
+	if _, err := i.do(ctx, http.MethodPost, path, req, &res); err != nil {
+		return 0, err
+	}
+	return res.Deleted, nil
+}
+
+func (i *ElasticSearchIndexer) indexPath() string {
+	return "/" + url.PathEscape(i.indexName)
+}
+
+func (i *ElasticSearchIndexer) updatePath(linkID uuid.UUID) string {
+	return fmt.Sprintf("%s/_update/%s?refresh=%s", i.indexPath(), linkID, i.refreshOpt)
+}
+
+// ndjson は _bulk API に送信する、改行区切りの JSON のリクエストボディ
+type ndjson []byte
+
+// do は body を JSON として (ndjson の場合はそのまま) 送信し、成功した場合はレスポンスを out にデコードする。
+// 接続に失敗した場合は次のノードで再試行する。HTTP ステータスが 2xx 以外の場合は *Error を返す。
+func (i *ElasticSearchIndexer) do(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var (
+		payload     []byte
+		contentType = "application/json"
+	)
+	switch body := body.(type) {
+	case nil:
+	case ndjson:
+		payload, contentType = body, "application/x-ndjson"
+	default:
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < len(i.nodes); attempt++ {
+		node := i.nodes[atomic.AddUint32(&i.nextNode, 1)%uint32(len(i.nodes))]
+		req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(node, "/")+path, bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		res, err := i.client.Do(req)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			lastErr = err
+			continue
+		}
+		return res.StatusCode, decodeResponse(res, out)
+	}
+	return 0, lastErr
+}
+
+func decodeResponse(res *http.Response, out interface{}) error {
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return decodeError(res)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Error は Elasticsearch が返したエラーを表す
+type Error struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("elasticsearch: status %d", e.Status)
+	}
+	return fmt.Sprintf("elasticsearch: status %d: %s: %s", e.Status, e.Type, e.Reason)
+}
+
+func decodeError(res *http.Response) error {
+	var body struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	// HEAD リクエストや一部のエラーには本文がない
+	_ = json.NewDecoder(res.Body).Decode(&body)
+
+	return &Error{Status: res.StatusCode, Type: body.Error.Type, Reason: body.Error.Reason}
+}
+
+// esDoc は部分更新に使用する、PageRank 以外の文書のフィールド。
+// 時刻のゼロ値を保存しないように、IndexedAt が未設定の場合はフィールドを省略する。
+// それ以外のフィールドは、部分更新で以前の値が残らないように空の値も常に送信する。
+type esDoc struct {
+	LinkID      string     `json:"LinkID"`
+	URL         string     `json:"URL"`
+	Title       string     `json:"Title"`
+	Content     string     `json:"Content"`
+	IndexedAt   *time.Time `json:"IndexedAt,omitempty"`
+	Fingerprint string     `json:"Fingerprint"`
+	DuplicateOf string     `json:"DuplicateOf"`
+	Sites       []string   `json:"Sites"`
+	Language    string     `json:"Language,omitempty"`
+}
+
+// esRecord はインデックスに保存される文書全体
+type esRecord struct {
+	esDoc
+	PageRank float64 `json:"PageRank"`
+}
+
+func makeEsDoc(d *index.Document) esDoc {
+	doc := esDoc{
+		LinkID:   d.LinkID.String(),
+		URL:      d.URL,
+		Title:    d.Title,
+		Content:  d.Content,
+		Language: d.Language,
+	}
+	if !d.IndexedAt.IsZero() {
+		indexedAt := d.IndexedAt.UTC()
+		doc.IndexedAt = &indexedAt
+	}
+	if d.Fingerprint != 0 {
+		doc.Fingerprint = strconv.FormatUint(d.Fingerprint, 16)
+	}
+	if d.DuplicateOf != uuid.Nil {
+		doc.DuplicateOf = d.DuplicateOf.String()
+	}
+	// 部分更新で以前の値を消去するため、URL にホスト名がない場合も空の配列を送信する
+	if doc.Sites = querylang.Sites(d.URL); doc.Sites == nil {
+		doc.Sites = []string{}
+	}
+	return doc
+}
+
+func mapEsDoc(r *esRecord) (*index.Document, error) {
+	linkID, err := uuid.Parse(r.LinkID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &index.Document{
+		LinkID:   linkID,
+		URL:      r.URL,
+		Title:    r.Title,
+		Content:  r.Content,
+		PageRank: r.PageRank,
+		Language: r.Language,
+	}
+	if r.IndexedAt != nil {
+		doc.IndexedAt = *r.IndexedAt
+	}
+	if r.Fingerprint != "" {
+		if doc.Fingerprint, err = strconv.ParseUint(r.Fingerprint, 16, 64); err != nil {
+			return nil, err
+		}
+	}
+	if r.DuplicateOf != "" {
+		if doc.DuplicateOf, err = uuid.Parse(r.DuplicateOf); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,134 @@
+package es
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/indextest"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/es/estest"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"net/http"
+	"testing"
+)
+
+var _ = gc.Suite(new(ElasticSearchTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type ElasticSearchTestSuite struct {
+	indextest.SuiteBase
+	srv *estest.Server
+	idx *ElasticSearchIndexer
+}
+
+func (s *ElasticSearchTestSuite) SetUpTest(c *gc.C) {
+	s.srv = estest.NewServer()
+	idx, err := NewElasticSearchIndexer(Config{Nodes: []string{s.srv.URL}, SyncUpdates: true})
+	c.Assert(err, gc.IsNil)
+	s.SetIndexer(idx)
+	s.idx = idx
+}
+
+func (s *ElasticSearchTestSuite) TearDownTest(c *gc.C) {
+	// すべてのイテレータがスクロールコンテキストを解放していること
+	c.Assert(s.srv.OpenScrolls(), gc.Equals, 0)
+	s.srv.Close()
+}
+
+func (s *ElasticSearchTestSuite) TestExistingIndexIsReused(c *gc.C) {
+	doc := &index.Document{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica", Fingerprint: 0xdeadbeefcafebabe}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	idx, err := NewElasticSearchIndexer(Config{Nodes: []string{s.srv.URL}})
+	c.Assert(err, gc.IsNil)
+
+	got, err := idx.FindByID(doc.LinkID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.Content, gc.Equals, doc.Content)
+	c.Assert(got.Fingerprint, gc.Equals, doc.Fingerprint)
+	c.Assert(got.IndexedAt.Equal(doc.IndexedAt), gc.Equals, true)
+}
+
+func (s *ElasticSearchTestSuite) TestFailoverToNextNode(c *gc.C) {
+	// 接続できないノードを先頭に置く
+	deadNode := "http://127.0.0.1:1"
+	idx, err := NewElasticSearchIndexer(Config{Nodes: []string{deadNode, s.srv.URL, deadNode}})
+	c.Assert(err, gc.IsNil)
+
+	for n := 0; n < 3; n++ {
+		c.Assert(idx.UpdateScore(uuid.New(), 1), gc.IsNil)
+	}
+}
+
+func (s *ElasticSearchTestSuite) TestScrollContextLost(c *gc.C) {
+	idx, err := NewElasticSearchIndexer(Config{Nodes: []string{s.srv.URL}, IndexName: "other"})
+	c.Assert(err, gc.IsNil)
+
+	// 検索の途中でスクロールコンテキストが失われた場合は Elasticsearch のエラーを返す
+	for n := 0; n < 15; n++ {
+		c.Assert(idx.Index(&index.Document{LinkID: uuid.New(), Content: "poeta"}), gc.IsNil)
+	}
+	it, err := idx.Search(index.Query{Expression: "poeta"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.(*esIterator).idx.clearScroll(it.(*esIterator).rs.ScrollID), gc.IsNil)
+
+	var count int
+	for it.Next() {
+		count++
+	}
+	c.Assert(count, gc.Equals, 10)
+
+	var esErr *Error
+	c.Assert(xerrors.As(it.Error(), &esErr), gc.Equals, true)
+	c.Assert(esErr.Status, gc.Equals, http.StatusNotFound)
+	c.Assert(esErr.Type, gc.Equals, "search_context_missing_exception")
+	c.Assert(it.Close(), gc.IsNil)
+}
+
+func (s *ElasticSearchTestSuite) TestOffsetWithoutScrolling(c *gc.C) {
+	for n := 0; n < 25; n++ {
+		c.Assert(s.idx.Index(&index.Document{LinkID: uuid.New(), Content: "poeta"}), gc.IsNil)
+	}
+
+	// オフセットまでの結果はクライアントで読み飛ばさずに from で指定する
+	before := s.srv.Requests()
+	it, err := s.idx.Search(index.Query{Expression: "poeta", Offset: 20})
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.srv.OpenScrolls(), gc.Equals, 0)
+
+	var count int
+	for it.Next() {
+		count++
+	}
+	c.Assert(it.Error(), gc.IsNil)
+	c.Assert(it.Close(), gc.IsNil)
+	c.Assert(count, gc.Equals, 5)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(25))
+
+	// 検索と、削除されていないことの確認
+	c.Assert(s.srv.Requests()-before, gc.Equals, 2)
+}
+
+func (s *ElasticSearchTestSuite) TestRestoreUsesBulk(c *gc.C) {
+	docs := make([]*index.Document, 0, 5)
+	for n := 0; n < cap(docs); n++ {
+		docs = append(docs, &index.Document{LinkID: uuid.New(), Content: "Ovidius poeta in terra pontica"})
+	}
+
+	before := s.srv.Requests()
+	res, err := s.idx.Restore(docs)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Processed, gc.Equals, len(docs))
+	c.Assert(res.Errors, gc.HasLen, 0)
+	c.Assert(s.srv.Requests()-before, gc.Equals, 1)
+
+	for _, doc := range docs {
+		// 呼び出し元の文書は変更されない
+		c.Assert(doc.Language, gc.Equals, "")
+
+		got, err := s.idx.FindByID(doc.LinkID)
+		c.Assert(err, gc.IsNil)
+		c.Assert(got.Content, gc.Equals, doc.Content)
+		c.Assert(got.Language, gc.Not(gc.Equals), "")
+	}
+}
@@ -0,0 +1,244 @@
+package estest
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// eval は文書がクエリに一致するかとそのスコアを返す
+func (idx *fakeIndex) eval(q map[string]interface{}, doc map[string]interface{}) (float64, bool, error) {
+	if len(q) != 1 {
+		return 0, false, fmt.Errorf("query must contain exactly one clause")
+	}
+
+	for kind, body := range q {
+		params, ok := body.(map[string]interface{})
+		if !ok {
+			return 0, false, fmt.Errorf("[%s] query malformed", kind)
+		}
+
+		switch kind {
+		case "match_all":
+			return 1, true, nil
+		case "match_none":
+			return 0, false, nil
+		case "bool":
+			return idx.evalBool(params, doc)
+		case "multi_match":
+			query, _ := params["query"].(string)
+			matchType, _ := params["type"].(string)
+			fields, _ := params["fields"].([]interface{})
+
+			var best float64
+			for _, field := range fields {
+				name, _ := field.(string)
+				var score float64
+				if matchType == "phrase" {
+					score = matchPhrase(query, textValue(doc[name]))
+				} else {
+					score = matchTerms(query, textValue(doc[name]))
+				}
+				if score > best {
+					best = score
+				}
+			}
+			return best, best > 0, nil
+		case "terms":
+			if len(params) != 1 {
+				return 0, false, fmt.Errorf("[terms] query doesn't support multiple fields")
+			}
+			for field, values := range params {
+				for _, v := range keywordValues(values) {
+					if idx.evalField("term", field, v, doc[field]) > 0 {
+						return 1, true, nil
+					}
+				}
+			}
+			return 0, false, nil
+		case "match", "match_phrase", "prefix", "term":
+			field, value, err := fieldParams(kind, params)
+			if err != nil {
+				return 0, false, err
+			}
+			score := idx.evalField(kind, field, value, doc[field])
+			return score, score > 0, nil
+		default:
+			return 0, false, fmt.Errorf("unknown query [%s]", kind)
+		}
+	}
+	return 0, false, nil
+}
+
+func (idx *fakeIndex) evalBool(params map[string]interface{}, doc map[string]interface{}) (float64, bool, error) {
+	var score float64
+	for _, occur := range []string{"must", "filter", "must_not"} {
+		for _, clause := range clauses(params[occur]) {
+			clauseScore, matched, err := idx.eval(clause, doc)
+			if err != nil {
+				return 0, false, err
+			}
+			if matched == (occur == "must_not") {
+				return 0, false, nil
+			}
+			if occur == "must" {
+				score += clauseScore
+			}
+		}
+	}
+
+	// must と filter がない場合、should の少なくとも 1 つに一致する必要がある
+	minShould := 0
+	if params["must"] == nil && params["filter"] == nil {
+		minShould = 1
+	}
+	if v, ok := params["minimum_should_match"].(float64); ok {
+		minShould = int(v)
+	}
+
+	should := clauses(params["should"])
+	if len(should) == 0 {
+		minShould = 0
+	}
+	var matchedShould int
+	for _, clause := range should {
+		clauseScore, matched, err := idx.eval(clause, doc)
+		if err != nil {
+			return 0, false, err
+		}
+		if matched {
+			matchedShould++
+			score += clauseScore
+		}
+	}
+	return score, matchedShould >= minShould, nil
+}
+
+// evalField は単一のフィールドを対象とするクエリのスコアを返す。一致しない場合は 0 を返す。
+func (idx *fakeIndex) evalField(kind, field, value string, fieldValue interface{}) float64 {
+	if idx.keywords[field] {
+		for _, v := range keywordValues(fieldValue) {
+			if (kind == "prefix" && strings.HasPrefix(v, value)) || (kind != "prefix" && v == value) {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	text := textValue(fieldValue)
+	switch kind {
+	case "match_phrase":
+		return matchPhrase(value, text)
+	case "prefix":
+		var score float64
+		for _, word := range tokenize(text) {
+			if strings.HasPrefix(word, value) {
+				score++
+			}
+		}
+		return score
+	case "term":
+		var score float64
+		for _, word := range tokenize(text) {
+			if word == value {
+				score++
+			}
+		}
+		return score
+	default:
+		return matchTerms(value, text)
+	}
+}
+
+func fieldParams(kind string, params map[string]interface{}) (string, string, error) {
+	if len(params) != 1 {
+		return "", "", fmt.Errorf("[%s] query doesn't support multiple fields", kind)
+	}
+	for field, v := range params {
+		if value, ok := v.(string); ok {
+			return field, value, nil
+		}
+	}
+	return "", "", fmt.Errorf("[%s] query malformed", kind)
+}
+
+// clauses は bool クエリの句を返す。単一のオブジェクトと配列の両方を受け付ける。
+func clauses(v interface{}) []map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []interface{}:
+		var list []map[string]interface{}
+		for _, item := range v {
+			if clause, ok := item.(map[string]interface{}); ok {
+				list = append(list, clause)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+func keywordValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func textValue(v interface{}) string {
+	return strings.Join(keywordValues(v), " ")
+}
+
+// matchTerms はクエリのいずれかの語がテキストに現れる回数を返す
+func matchTerms(query, text string) float64 {
+	var score float64
+	words := tokenize(text)
+	for _, term := range tokenize(query) {
+		for _, word := range words {
+			if word == term {
+				score++
+			}
+		}
+	}
+	return score
+}
+
+// matchPhrase はクエリの語の並びがテキストに連続して現れる場合に 1 を返す
+func matchPhrase(query, text string) float64 {
+	terms, words := tokenize(query), tokenize(text)
+	if len(terms) == 0 {
+		return 0
+	}
+
+	for start := 0; start+len(terms) <= len(words); start++ {
+		matched := true
+		for j, term := range terms {
+			if words[start+j] != term {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return 1
+		}
+	}
+	return 0
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,472 @@
+// Package estest は ElasticSearchIndexer のテストに使用する、Elasticsearch REST API のサブセットを実装した HTTP サーバを提供する。
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultSize = 10
+
+// Server はインデックスの作成、文書の保存と部分更新、取得と一括取得、一括保存、削除、検索とスクロールをメモリ上で処理する httptest.Server。
+// 検索は bool、multi_match、match、match_phrase、prefix、term、terms、match_all、match_none の各クエリに対応する。
+// スコアは一致した語の数で、text 型のフィールドの解析は小文字化と単語への分割のみを行う。
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	indices      map[string]*fakeIndex
+	scrolls      map[string]*scroll
+	nextScrollID int
+	requests     int
+}
+
+type fakeIndex struct {
+	// keywords はマッピングで keyword 型が指定されたフィールド
+	keywords map[string]bool
+	docs     map[string]map[string]interface{}
+}
+
+type hit struct {
+	ID     string                 `json:"_id"`
+	Score  float64                `json:"_score"`
+	Source map[string]interface{} `json:"_source"`
+
+	// Sort は PageRank、スコア、ID からなるソートキー
+	Sort []interface{} `json:"sort"`
+}
+
+// after は h が search_after のソートキーより後に並ぶかを返す
+func (h *hit) after(keys []interface{}) bool {
+	pr, _ := keys[0].(float64)
+	score, _ := keys[1].(float64)
+	id, _ := keys[2].(string)
+
+	if hpr := h.Sort[0].(float64); hpr != pr {
+		return hpr < pr
+	}
+	if h.Score != score {
+		return h.Score < score
+	}
+	return h.ID > id
+}
+
+type scroll struct {
+	hits  []hit
+	size  int
+	total int
+}
+
+// NewServer は新しいサーバを起動する。使用後は Close を呼び出すこと。
+func NewServer() *Server {
+	s := &Server{
+		indices: make(map[string]*fakeIndex),
+		scrolls: make(map[string]*scroll),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// OpenScrolls は解放されていないスクロールコンテキストの数を返す
+func (s *Server) OpenScrolls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.scrolls)
+}
+
+// Requests はサーバが受け付けたリクエストの数を返す
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "_search" && parts[1] == "scroll":
+		switch r.Method {
+		case http.MethodPost:
+			s.handleScroll(w, r)
+		case http.MethodDelete:
+			s.handleClearScroll(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", "unsupported method")
+		}
+	case len(parts) == 1 && r.Method == http.MethodHead:
+		if _, exists := s.indices[parts[0]]; !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		if _, exists := s.indices[parts[0]]; exists {
+			writeError(w, http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("index [%s] already exists", parts[0]))
+			return
+		}
+		var req struct {
+			Mappings struct {
+				Properties map[string]struct {
+					Type string `json:"type"`
+				} `json:"properties"`
+			} `json:"mappings"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		}
+		idx := &fakeIndex{keywords: make(map[string]bool), docs: make(map[string]map[string]interface{})}
+		for field, prop := range req.Mappings.Properties {
+			idx.keywords[field] = prop.Type == "keyword"
+		}
+		s.indices[parts[0]] = idx
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true, "index": parts[0]})
+	case len(parts) == 3 && parts[1] == "_update" && r.Method == http.MethodPost:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handleUpdate(w, r, idx.docs, parts[2])
+		}
+	case len(parts) == 3 && parts[1] == "_doc" && r.Method == http.MethodGet:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handleGet(w, idx.docs, parts[2])
+		}
+	case len(parts) == 3 && parts[1] == "_doc" && r.Method == http.MethodPut:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handlePut(w, r, idx.docs, parts[2])
+		}
+	case len(parts) == 3 && parts[1] == "_doc" && r.Method == http.MethodDelete:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handleDelete(w, idx.docs, parts[2])
+		}
+	case len(parts) == 2 && parts[1] == "_bulk" && r.Method == http.MethodPost:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handleBulk(w, r, idx.docs)
+		}
+	case len(parts) == 2 && parts[1] == "_mget" && r.Method == http.MethodPost:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handleMultiGet(w, r, idx.docs)
+		}
+	case len(parts) == 2 && parts[1] == "_delete_by_query" && r.Method == http.MethodPost:
+		if idx := s.index(w, parts[0]); idx != nil {
+			handleDeleteByQuery(w, r, idx)
+		}
+	case len(parts) == 2 && parts[1] == "_search" && r.Method == http.MethodPost:
+		if idx := s.index(w, parts[0]); idx != nil {
+			s.handleSearch(w, r, idx)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("unsupported request %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) index(w http.ResponseWriter, name string) *fakeIndex {
+	idx, exists := s.indices[name]
+	if !exists {
+		writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+	}
+	return idx
+}
+
+func handleUpdate(w http.ResponseWriter, r *http.Request, docs map[string]map[string]interface{}, id string) {
+	var req struct {
+		Doc         map[string]interface{} `json:"doc"`
+		Upsert      map[string]interface{} `json:"upsert"`
+		DocAsUpsert bool                   `json:"doc_as_upsert"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+
+	if existing, found := docs[id]; found {
+		for k, v := range req.Doc {
+			existing[k] = v
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"_id": id, "result": "updated"})
+		return
+	}
+
+	switch {
+	case req.Upsert != nil:
+		docs[id] = req.Upsert
+	case req.DocAsUpsert:
+		docs[id] = req.Doc
+	default:
+		writeError(w, http.StatusNotFound, "document_missing_exception", fmt.Sprintf("[%s]: document missing", id))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"_id": id, "result": "created"})
+}
+
+func handlePut(w http.ResponseWriter, r *http.Request, docs map[string]map[string]interface{}, id string) {
+	var doc map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+
+	status, result := http.StatusCreated, "created"
+	if _, found := docs[id]; found {
+		status, result = http.StatusOK, "updated"
+	}
+	docs[id] = doc
+	writeJSON(w, status, map[string]interface{}{"_id": id, "result": result})
+}
+
+func handleGet(w http.ResponseWriter, docs map[string]map[string]interface{}, id string) {
+	doc, found := docs[id]
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"_id": id, "found": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"_id": id, "found": true, "_source": doc})
+}
+
+// handleBulk は index 操作のみからなる _bulk リクエストを処理する
+func handleBulk(w http.ResponseWriter, r *http.Request, docs map[string]map[string]interface{}) {
+	var (
+		dec   = json.NewDecoder(r.Body)
+		items []map[string]interface{}
+	)
+	for dec.More() {
+		var action struct {
+			Index *struct {
+				ID string `json:"_id"`
+			} `json:"index"`
+		}
+		var doc map[string]interface{}
+		if err := dec.Decode(&action); err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		} else if action.Index == nil {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", "only index actions are supported")
+			return
+		} else if err := dec.Decode(&doc); err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		}
+
+		status, result := http.StatusCreated, "created"
+		if _, exists := docs[action.Index.ID]; exists {
+			status, result = http.StatusOK, "updated"
+		}
+		docs[action.Index.ID] = doc
+		items = append(items, map[string]interface{}{
+			"index": map[string]interface{}{"_id": action.Index.ID, "status": status, "result": result},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"errors": false, "items": items})
+}
+
+// handleMultiGet は ids の各文書の有無を返す。_source は返さない。
+func handleMultiGet(w http.ResponseWriter, r *http.Request, docs map[string]map[string]interface{}) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+
+	res := make([]map[string]interface{}, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		_, found := docs[id]
+		res = append(res, map[string]interface{}{"_id": id, "found": found})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"docs": res})
+}
+
+func handleDelete(w http.ResponseWriter, docs map[string]map[string]interface{}, id string) {
+	if _, found := docs[id]; !found {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"_id": id, "result": "not_found"})
+		return
+	}
+	delete(docs, id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"_id": id, "result": "deleted"})
+}
+
+func handleDeleteByQuery(w http.ResponseWriter, r *http.Request, idx *fakeIndex) {
+	var req struct {
+		Query map[string]interface{} `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+	if req.Query == nil {
+		writeError(w, http.StatusBadRequest, "action_request_validation_exception", "query is missing")
+		return
+	}
+
+	var matched []string
+	for id, doc := range idx.docs {
+		_, ok, err := idx.eval(req.Query, doc)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		} else if ok {
+			matched = append(matched, id)
+		}
+	}
+	for _, id := range matched {
+		delete(idx.docs, id)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"total": len(matched), "deleted": len(matched)})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request, idx *fakeIndex) {
+	var req struct {
+		Query       map[string]interface{} `json:"query"`
+		From        *int                   `json:"from"`
+		Size        *int                   `json:"size"`
+		SearchAfter []interface{}          `json:"search_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+	if req.Query == nil {
+		req.Query = map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	scrolling := r.URL.Query().Get("scroll") != ""
+	if scrolling && req.From != nil {
+		writeError(w, http.StatusBadRequest, "action_request_validation_exception", "using [from] is not allowed in a scroll context")
+		return
+	}
+	if req.SearchAfter != nil && req.From != nil && *req.From > 0 {
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", "[from] parameter must be set to 0 when [search_after] is used")
+		return
+	}
+	if req.SearchAfter != nil && (scrolling || len(req.SearchAfter) != 3) {
+		writeError(w, http.StatusBadRequest, "action_request_validation_exception", "[search_after] must match the sort keys and cannot be used in a scroll context")
+		return
+	}
+
+	var hits []hit
+	for id, doc := range idx.docs {
+		score, matched, err := idx.eval(req.Query, doc)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		} else if matched {
+			pr, _ := doc["PageRank"].(float64)
+			hits = append(hits, hit{ID: id, Score: score, Source: doc, Sort: []interface{}{pr, score, id}})
+		}
+	}
+
+	// PageRank の降順、スコアの降順、ID の昇順に並べる
+	sort.Slice(hits, func(a, b int) bool {
+		return hits[b].after(hits[a].Sort)
+	})
+
+	total := len(hits)
+	if req.SearchAfter != nil {
+		var remaining []hit
+		for _, h := range hits {
+			if h.after(req.SearchAfter) {
+				remaining = append(remaining, h)
+			}
+		}
+		hits = remaining
+	}
+
+	sc := &scroll{hits: hits, size: defaultSize, total: total}
+	if req.Size != nil {
+		sc.size = *req.Size
+	}
+	if req.From != nil {
+		if *req.From >= len(sc.hits) {
+			sc.hits = nil
+		} else {
+			sc.hits = sc.hits[*req.From:]
+		}
+	}
+
+	var scrollID string
+	if scrolling {
+		s.nextScrollID++
+		scrollID = fmt.Sprintf("scroll-%d", s.nextScrollID)
+		s.scrolls[scrollID] = sc
+	}
+	writeJSON(w, http.StatusOK, sc.next(scrollID))
+}
+
+func (s *Server) handleScroll(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ScrollID string `json:"scroll_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+
+	sc, found := s.scrolls[req.ScrollID]
+	if !found {
+		writeError(w, http.StatusNotFound, "search_context_missing_exception", fmt.Sprintf("no search context found for id [%s]", req.ScrollID))
+		return
+	}
+	writeJSON(w, http.StatusOK, sc.next(req.ScrollID))
+}
+
+func (s *Server) handleClearScroll(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ScrollID []string `json:"scroll_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+
+	var freed int
+	for _, id := range req.ScrollID {
+		if _, found := s.scrolls[id]; found {
+			delete(s.scrolls, id)
+			freed++
+		}
+	}
+	status := http.StatusOK
+	if freed == 0 {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]interface{}{"succeeded": freed != 0, "num_freed": freed})
+}
+
+// next は次のバッチを取り出してレスポンスを作成する
+func (sc *scroll) next(scrollID string) map[string]interface{} {
+	n := sc.size
+	if n > len(sc.hits) {
+		n = len(sc.hits)
+	}
+	batch := sc.hits[:n]
+	sc.hits = sc.hits[n:]
+
+	res := map[string]interface{}{
+		"hits": map[string]interface{}{
+			"total": map[string]interface{}{"value": sc.total, "relation": "eq"},
+			"hits":  batch,
+		},
+	}
+	if scrollID != "" {
+		res["_scroll_id"] = scrollID
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, reason string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":  map[string]interface{}{"type": errType, "reason": reason},
+		"status": status,
+	})
+}
@@ -0,0 +1,185 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+)
+
+// searchResult は検索およびスクロール API のレスポンス
+type searchResult struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Total totalHits `json:"total"`
+		Hits  []struct {
+			ID     string        `json:"_id"`
+			Score  float64       `json:"_score"`
+			Source esRecord      `json:"_source"`
+			Sort   []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// totalHits は検索に一致した文書数。Elasticsearch 6 以前の数値形式と、7 以降のオブジェクト形式の両方を受け付ける。
+type totalHits uint64
+
+func (t *totalHits) UnmarshalJSON(data []byte) error {
+	var total struct {
+		Value uint64 `json:"value"`
+	}
+	if err := json.Unmarshal(data, &total); err == nil {
+		*t = totalHits(total.Value)
+		return nil
+	}
+
+	var value uint64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*t = totalHits(value)
+	return nil
+}
+
+var (
+	_ index.CursorIterator = (*esIterator)(nil)
+	_ index.ScoredIterator = (*esIterator)(nil)
+)
+
+// esIterator はスクロール API で検索結果をバッチ単位で取得する index.Iterator の実装
+type esIterator struct {
+	ctx context.Context
+	idx *ElasticSearchIndexer
+
+	// req は search_after で続きを取得する場合の検索リクエスト。nil の場合はスクロール API を使用する。
+	req map[string]interface{}
+
+	// limit は返す文書の最大数、returned は返した文書の数
+	limit    uint64
+	returned uint64
+
+	cumIdx uint64
+	rsIdx  int
+	rs     *searchResult
+
+	// found は現在のバッチのうち削除されていない文書の ID。バッチの文書を最初に返すときに取得する。
+	found map[string]bool
+
+	latchedDoc   *index.Document
+	latchedSort  []interface{}
+	latchedScore float64
+	lastErr      error
+}
+
+func (it *esIterator) Close() error {
+	if it.idx == nil {
+		return nil
+	}
+
+	var err error
+	if it.rs != nil && it.rs.ScrollID != "" {
+		if err = it.idx.clearScroll(it.rs.ScrollID); err != nil {
+			err = xerrors.Errorf("clear scroll: %w", err)
+		}
+		it.cumIdx = uint64(it.rs.Hits.Total)
+	}
+	it.idx = nil
+	return err
+}
+
+func (it *esIterator) Next() bool {
+	for {
+		if it.lastErr != nil || it.idx == nil || it.cumIdx >= uint64(it.rs.Hits.Total) || (it.limit != 0 && it.returned >= it.limit) {
+			return false
+		}
+
+		// 現在のバッチを使い切った場合は次のバッチを取得する
+		if it.rsIdx >= len(it.rs.Hits.Hits) {
+			if err := it.ctx.Err(); err != nil {
+				it.lastErr = xerrors.Errorf("search: %w", err)
+				return false
+			}
+
+			var (
+				rs  *searchResult
+				err error
+			)
+			if it.req == nil {
+				rs, err = it.idx.scroll(it.ctx, it.rs.ScrollID)
+			} else if n := len(it.rs.Hits.Hits); n != 0 {
+				rs, err = it.idx.searchAfter(it.ctx, it.req, it.rs.Hits.Hits[n-1].Sort)
+			} else {
+				// カーソルより後に文書がない
+				return false
+			}
+			if err != nil {
+				it.lastErr = xerrors.Errorf("search: %w", err)
+				return false
+			}
+			// TotalCount が変わらないように最初の検索時の総数を保持する
+			rs.Hits.Total = it.rs.Hits.Total
+			it.rs, it.rsIdx, it.found = rs, 0, nil
+
+			// 取得の間に文書が減った場合
+			if len(it.rs.Hits.Hits) == 0 {
+				return false
+			}
+		}
+
+		hit := &it.rs.Hits.Hits[it.rsIdx]
+		it.cumIdx++
+		it.rsIdx++
+		// 検索の後に削除された文書はバッチに含まれていても読み飛ばす
+		if it.found == nil {
+			if it.found, it.lastErr = it.idx.existing(it.ctx, it.batchIDs()); it.lastErr != nil {
+				it.lastErr = xerrors.Errorf("search: %w", it.lastErr)
+				return false
+			}
+		}
+		if !it.found[hit.ID] {
+			continue
+		}
+
+		if it.latchedDoc, it.lastErr = mapEsDoc(&hit.Source); it.lastErr != nil {
+			it.lastErr = xerrors.Errorf("search: %w", it.lastErr)
+			return false
+		}
+		it.latchedSort, it.latchedScore = hit.Sort, hit.Score
+		it.returned++
+		return true
+	}
+}
+
+// batchIDs は現在のバッチの文書の ID を返す
+func (it *esIterator) batchIDs() []string {
+	ids := make([]string, 0, len(it.rs.Hits.Hits))
+	for _, hit := range it.rs.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func (it *esIterator) Error() error {
+	return it.lastErr
+}
+
+func (it *esIterator) Document() *index.Document {
+	return it.latchedDoc
+}
+
+// Relevance は現在の文書の検索語に対する Elasticsearch のスコアを返す
+func (it *esIterator) Relevance() float64 {
+	return it.latchedScore
+}
+
+// Cursor は現在の文書の次から検索を再開するためのカーソルを返す
+func (it *esIterator) Cursor() string {
+	if len(it.latchedSort) != sortKeys {
+		return ""
+	}
+	return index.EncodeCursor(it.latchedSort)
+}
+
+func (it *esIterator) TotalCount() uint64 {
+	return uint64(it.rs.Hits.Total)
+}
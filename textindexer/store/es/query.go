@@ -0,0 +1,108 @@
+package es
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"strings"
+)
+
+// multiMatch はタイトルと本文を対象とする multi_match クエリを作成する
+func multiMatch(matchType, expr string) map[string]interface{} {
+	return map[string]interface{}{
+		"multi_match": map[string]interface{}{
+			"type":   matchType,
+			"query":  expr,
+			"fields": []string{"Title", "Content"},
+		},
+	}
+}
+
+// translateQuery は構文木を Elasticsearch の Query DSL に変換する
+func translateQuery(n querylang.Node) map[string]interface{} {
+	switch n := n.(type) {
+	case *querylang.And:
+		var must, mustNot []interface{}
+		for _, child := range n.Nodes {
+			if not, ok := child.(*querylang.Not); ok {
+				mustNot = append(mustNot, translateQuery(not.Node))
+			} else {
+				must = append(must, translateQuery(child))
+			}
+		}
+		boolQuery := map[string]interface{}{}
+		if len(must) != 0 {
+			boolQuery["must"] = must
+		}
+		if len(mustNot) != 0 {
+			boolQuery["must_not"] = mustNot
+		}
+		return map[string]interface{}{"bool": boolQuery}
+	case *querylang.Or:
+		var should []interface{}
+		for _, child := range n.Nodes {
+			should = append(should, translateQuery(child))
+		}
+		return map[string]interface{}{
+			"bool": map[string]interface{}{"should": should, "minimum_should_match": 1},
+		}
+	case *querylang.Not:
+		// must_not のみの bool クエリは他のすべての文書に一致する
+		return map[string]interface{}{
+			"bool": map[string]interface{}{"must_not": []interface{}{translateQuery(n.Node)}},
+		}
+	case *querylang.Term:
+		return translateTerm(n)
+	case *querylang.Phrase:
+		switch n.Field {
+		case querylang.FieldURL, querylang.FieldSite:
+			return translateTerm(&querylang.Term{Field: n.Field, Value: n.Value})
+		case querylang.FieldAny:
+			return multiMatch("phrase", n.Value)
+		}
+		return fieldQuery("match_phrase", textField(n.Field), n.Value)
+	default:
+		return map[string]interface{}{"match_none": map[string]interface{}{}}
+	}
+}
+
+func translateTerm(t *querylang.Term) map[string]interface{} {
+	switch {
+	case t.Field == querylang.FieldURL && t.Prefix:
+		return fieldQuery("prefix", "URL", t.Value)
+	case t.Field == querylang.FieldURL:
+		return fieldQuery("term", "URL", t.Value)
+	case t.Field == querylang.FieldSite:
+		return fieldQuery("term", "Sites", strings.ToLower(t.Value))
+	case !t.Prefix && t.Field == querylang.FieldAny:
+		return multiMatch("best_fields", t.Value)
+	case !t.Prefix:
+		return fieldQuery("match", textField(t.Field), t.Value)
+	}
+
+	// 前方一致のクエリは解析されないため、索引に合わせて小文字にする
+	prefix := strings.ToLower(t.Value)
+	if t.Field != querylang.FieldAny {
+		return fieldQuery("prefix", textField(t.Field), prefix)
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				fieldQuery("prefix", "Title", prefix),
+				fieldQuery("prefix", "Content", prefix),
+			},
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+func textField(f querylang.Field) string {
+	if f == querylang.FieldTitle {
+		return "Title"
+	}
+	return "Content"
+}
+
+func fieldQuery(queryType, field, value string) map[string]interface{} {
+	return map[string]interface{}{
+		queryType: map[string]interface{}{field: value},
+	}
+}
@@ -0,0 +1,151 @@
+// Package bleveiter は bleve の検索結果をバッチ単位で取得する index.Iterator の実装を提供する。
+// bleve を使用する各 index.Indexer の実装が共通して使用する。
+package bleveiter
+
+import (
+	"context"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/internal/blevequery"
+	"golang.org/x/xerrors"
+)
+
+// FindFunc はリンク ID の文書を返す関数。文書が存在しない場合は index.ErrNotFound をラップしたエラーを返す。
+type FindFunc func(linkID string) (*index.Document, error)
+
+var (
+	_ index.CursorIterator = (*Iterator)(nil)
+	_ index.ScoredIterator = (*Iterator)(nil)
+)
+
+// Iterator は検索結果をバッチ単位で取得する index.Iterator の実装。
+// 検索の後に削除された文書は読み飛ばされる。
+type Iterator struct {
+	ctx       context.Context
+	idx       bleve.Index
+	find      FindFunc
+	searchReq *bleve.SearchRequest
+
+	cumIdx uint64
+	rsIdx  int
+	rs     *bleve.SearchResult
+
+	// limit は返す文書の最大数、returned は返した文書の数
+	limit    uint64
+	returned uint64
+
+	latchedDoc *index.Document
+	latchedHit *search.DocumentMatch
+	lastErr    error
+}
+
+// New は searchReq の最初のバッチ rs から結果を返す Iterator を作成する。
+// 続きのバッチは idx から取得され、各文書は find で取得される。offset は rs の先頭までに読み飛ばした件数。
+func New(ctx context.Context, idx bleve.Index, find FindFunc, searchReq *bleve.SearchRequest, rs *bleve.SearchResult, offset, limit uint64) *Iterator {
+	return &Iterator{ctx: ctx, idx: idx, find: find, searchReq: searchReq, rs: rs, cumIdx: offset, limit: limit}
+}
+
+func (it *Iterator) Close() error {
+	it.idx = nil
+	it.searchReq = nil
+	if it.rs != nil {
+		it.cumIdx = it.rs.Total
+	}
+	return nil
+}
+
+func (it *Iterator) Next() bool {
+	if it.lastErr != nil || it.rs == nil || (it.limit != 0 && it.returned >= it.limit) {
+		return false
+	}
+
+	for it.cumIdx < it.rs.Total {
+		if !it.fetchBatch() {
+			return false
+		}
+
+		it.latchedHit = it.rs.Hits[it.rsIdx]
+		it.cumIdx++
+		it.rsIdx++
+
+		doc, err := it.find(it.latchedHit.ID)
+		if xerrors.Is(err, index.ErrNotFound) {
+			// 検索後に削除された文書は読み飛ばす
+			continue
+		} else if err != nil {
+			it.lastErr = err
+			return false
+		}
+
+		it.latchedDoc = doc
+		it.returned++
+		return true
+	}
+	return false
+}
+
+// fetchBatch は現在のバッチを使い切った場合に次のバッチを取得し、取得できる文書がなくなった場合は false を返す
+func (it *Iterator) fetchBatch() bool {
+	if it.rsIdx >= it.rs.Hits.Len() {
+		// カーソルより後に文書がない場合
+		if it.rs.Hits.Len() == 0 {
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.lastErr = xerrors.Errorf("search: %w", err)
+			return false
+		}
+
+		// 検索の間にスコアが変わっても結果が重複したり欠落したりしないように、直前の文書の次から取得する
+		it.searchReq.From = 0
+		it.searchReq.SearchAfter = blevequery.SearchAfter(it.rs.Hits[it.rs.Hits.Len()-1])
+		if it.rs, it.lastErr = it.idx.SearchInContext(it.ctx, it.searchReq); it.lastErr != nil {
+			it.lastErr = xerrors.Errorf("search: %w", it.lastErr)
+			return false
+		}
+		it.rsIdx = 0
+
+		// 取得の間に文書が減った場合
+		if it.rs.Hits.Len() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (it *Iterator) Error() error {
+	return it.lastErr
+}
+
+func (it *Iterator) Document() *index.Document {
+	return it.latchedDoc
+}
+
+// Hit は現在の文書の bleve の検索結果を返す
+func (it *Iterator) Hit() *search.DocumentMatch {
+	return it.latchedHit
+}
+
+// Relevance は現在の文書の検索語に対する bleve のスコアを返す
+func (it *Iterator) Relevance() float64 {
+	if it.latchedHit == nil {
+		return 0
+	}
+	return it.latchedHit.Score
+}
+
+// Cursor は現在の文書の次から検索を再開するためのカーソルを返す
+func (it *Iterator) Cursor() string {
+	if it.latchedHit == nil {
+		return ""
+	}
+	return blevequery.Cursor(it.latchedHit)
+}
+
+func (it *Iterator) TotalCount() uint64 {
+	if it.rs == nil {
+		return 0
+	}
+	return it.rs.Total
+}
@@ -0,0 +1,118 @@
+package blevequery
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/analysis/lang/de"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/analysis/lang/es"
+	"github.com/blevesearch/bleve/analysis/lang/fr"
+	"github.com/blevesearch/bleve/analysis/lang/ru"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/lang"
+)
+
+// FieldLanguage は文書の言語を格納するフィールド。文書を索引付けするマッピングの選択に使用される。
+const FieldLanguage = "Language"
+
+// analyzers は言語ごとに使用する bleve のアナライザ。
+// CJK の言語は 2 文字ずつの組 (bigram) に分割し、ラテン文字とキリル文字の言語はストップワードの除去と語幹の抽出を行う。
+var analyzers = map[string]string{
+	lang.Japanese: cjk.AnalyzerName,
+	lang.Chinese:  cjk.AnalyzerName,
+	lang.Korean:   cjk.AnalyzerName,
+	lang.English:  en.AnalyzerName,
+	lang.German:   de.AnalyzerName,
+	lang.French:   fr.AnalyzerName,
+	lang.Spanish:  es.AnalyzerName,
+	lang.Russian:  ru.AnalyzerName,
+}
+
+// fallbackAnalyzers は言語を確かに推定できない検索語の解析に使用するアナライザ。
+// 検索語はそれぞれのアナライザで解析され、いずれかに一致する文書が結果となる。
+var fallbackAnalyzers = []string{en.AnalyzerName, de.AnalyzerName, fr.AnalyzerName, es.AnalyzerName, standard.Name}
+
+// Analyzer は言語 l のテキストに使用するアナライザ名を返す。対応していない言語には standard を使用する。
+func Analyzer(l string) string {
+	if name, ok := analyzers[l]; ok {
+		return name
+	}
+	return standard.Name
+}
+
+// textAnalyzers は検索語 text の解析に使用するアナライザ名を返す。
+// analyzer が空でない場合はそれのみを使用する。空の場合は text の言語を推定し、確かに推定できない場合は fallbackAnalyzers を返す。
+func textAnalyzers(text, analyzer string) []string {
+	if analyzer != "" {
+		return []string{analyzer}
+	}
+	if l, ok := lang.DetectWithConfidence(text); ok {
+		return []string{Analyzer(l)}
+	}
+	return fallbackAnalyzers
+}
+
+// anyAnalyzer は analyzers のそれぞれについて mk で作成したクエリのいずれかに一致するクエリを返す
+func anyAnalyzer(analyzers []string, mk func(analyzer string) query.Query) query.Query {
+	if len(analyzers) == 1 {
+		return mk(analyzers[0])
+	}
+	qs := make([]query.Query, 0, len(analyzers))
+	for _, analyzer := range analyzers {
+		qs = append(qs, mk(analyzer))
+	}
+	return bleve.NewDisjunctionQuery(qs...)
+}
+
+// AddLanguageMappings は対応する各言語について newDocMapping で作成した文書マッピングを m に登録する。
+// 各マッピングは FieldLanguage の値で選択され、アナライザが指定されていないテキストフィールドをその言語のアナライザで解析する。
+// 対応していない言語の文書には m.DefaultMapping が使用される。
+func AddLanguageMappings(m *mapping.IndexMappingImpl, newDocMapping func() *mapping.DocumentMapping) {
+	m.TypeField = FieldLanguage
+	for l, analyzer := range analyzers {
+		dm := newDocMapping()
+		dm.DefaultAnalyzer = analyzer
+		m.AddDocumentMapping(l, dm)
+	}
+}
+
+// NewLanguageFieldMapping は FieldLanguage に使用する、検索対象に含まれないキーワードフィールドのマッピングを作成する
+func NewLanguageFieldMapping(store bool) *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Analyzer = keyword.Name
+	fm.Store = store
+	fm.IncludeInAll = false
+	fm.IncludeTermVectors = false
+	return fm
+}
+
+// NewQuery は q の種類に応じた bleve のクエリを作成する。語は q の言語のアナライザで解析される。
+// q.Language が指定されていない場合は検索語から言語を推定し、QueryTypeAdvanced では語ごとに推定する。
+// 言語を確かに推定できない場合は、対応する各言語のアナライザで解析した語のいずれかに一致する文書を検索する。
+func NewQuery(q index.Query) (query.Query, error) {
+	var analyzer string
+	if q.Language != "" {
+		analyzer = Analyzer(q.Language)
+	}
+
+	switch q.Type {
+	case index.QueryTypePhrase:
+		return anyAnalyzer(textAnalyzers(q.Expression, analyzer), func(analyzer string) query.Query {
+			pq := bleve.NewMatchPhraseQuery(q.Expression)
+			pq.Analyzer = analyzer
+			return pq
+		}), nil
+	case index.QueryTypeAdvanced:
+		return Parse(q.Expression, analyzer)
+	default:
+		return anyAnalyzer(textAnalyzers(q.Expression, analyzer), func(analyzer string) query.Query {
+			mq := bleve.NewMatchQuery(q.Expression)
+			mq.Analyzer = analyzer
+			return mq
+		}), nil
+	}
+}
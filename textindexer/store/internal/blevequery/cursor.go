@@ -0,0 +1,50 @@
+package blevequery
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	"strconv"
+)
+
+// SortOrder は検索結果の並び順。同じスコアの文書は ID の順に並ぶため、ページの境界が一意に定まる。
+var SortOrder = []string{"-PageRank", "-_score", "_id"}
+
+// Cursor は hit の次の文書から検索を再開するためのカーソルを返す
+func Cursor(hit *search.DocumentMatch) string {
+	return index.EncodeCursor(SearchAfter(hit))
+}
+
+// SearchAfter は hit の次の文書から検索するための bleve.SearchRequest.SearchAfter の値を返す。
+// _score のソートキーには実際のスコアが含まれないため、hit のスコアで置き換える。
+func SearchAfter(hit *search.DocumentMatch) []string {
+	return []string{hit.Sort[0], strconv.FormatFloat(hit.Score, 'g', -1, 64), hit.ID}
+}
+
+// NewSearchRequest は q の並び順と開始位置を設定した検索リクエストを作成する。
+// 1 回の検索で取得する件数は q.Limit、未指定の場合は batchSize となる。
+func NewSearchRequest(bq query.Query, q index.Query, batchSize int) (*bleve.SearchRequest, error) {
+	searchReq := bleve.NewSearchRequest(bq)
+	searchReq.SortBy(SortOrder)
+	searchReq.Size = batchSize
+	if q.Limit > 0 {
+		searchReq.Size = int(q.Limit)
+	}
+
+	if q.Cursor == "" {
+		searchReq.From = int(q.Offset)
+		return searchReq, nil
+	}
+
+	var after []string
+	if err := index.DecodeCursor(q, &after); err != nil {
+		return nil, err
+	}
+	if len(after) != len(SortOrder) {
+		return nil, xerrors.Errorf("decode cursor: %w", index.ErrInvalidCursor)
+	}
+	searchReq.SearchAfter = after
+	return searchReq, nil
+}
@@ -0,0 +1,133 @@
+// Package blevequery は index.Query と querylang の構文木を bleve のクエリに変換する。
+// 文書のマッピングには、Title と Content のテキストフィールドに加えて、
+// URL とその querylang.Sites を格納する Sites のキーワードフィールドが必要となる。
+// 語は言語に応じたアナライザで解析されるため、文書も AddLanguageMappings で登録したマッピングで索引付けする必要がある。
+package blevequery
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"strings"
+)
+
+// 変換後のクエリが参照するフィールド名
+const (
+	FieldTitle   = "Title"
+	FieldContent = "Content"
+	FieldURL     = "URL"
+	FieldSites   = "Sites"
+)
+
+// Parse は検索式を解析して bleve のクエリに変換する。
+// analyzer は語とフレーズの解析に使用するアナライザ名で、空の場合は語ごとに言語を推定して選択する。
+func Parse(expr, analyzer string) (query.Query, error) {
+	n, err := querylang.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return Translate(n, analyzer), nil
+}
+
+// Translate は構文木を bleve のクエリに変換する。analyzer の扱いは Parse と同じ。
+func Translate(n querylang.Node, analyzer string) query.Query {
+	switch n := n.(type) {
+	case *querylang.And:
+		var must, mustNot []query.Query
+		for _, child := range n.Nodes {
+			if not, ok := child.(*querylang.Not); ok {
+				mustNot = append(mustNot, Translate(not.Node, analyzer))
+			} else {
+				must = append(must, Translate(child, analyzer))
+			}
+		}
+		if len(mustNot) == 0 {
+			return bleve.NewConjunctionQuery(must...)
+		}
+		return exclude(must, mustNot)
+	case *querylang.Or:
+		var should []query.Query
+		for _, child := range n.Nodes {
+			should = append(should, Translate(child, analyzer))
+		}
+		return bleve.NewDisjunctionQuery(should...)
+	case *querylang.Not:
+		return exclude(nil, []query.Query{Translate(n.Node, analyzer)})
+	case *querylang.Term:
+		return translateTerm(n, analyzer)
+	case *querylang.Phrase:
+		return translatePhrase(n, analyzer)
+	default:
+		return bleve.NewMatchNoneQuery()
+	}
+}
+
+// exclude は must のすべてに一致し、mustNot のいずれにも一致しない文書に一致するクエリを作成する。
+// must が空の場合はすべての文書が対象となる。
+func exclude(must, mustNot []query.Query) query.Query {
+	if len(must) == 0 {
+		must = []query.Query{bleve.NewMatchAllQuery()}
+	}
+	return query.NewBooleanQuery(must, nil, mustNot)
+}
+
+func translateTerm(t *querylang.Term, analyzer string) query.Query {
+	switch t.Field {
+	case querylang.FieldURL:
+		if t.Prefix {
+			return fieldQuery(bleve.NewPrefixQuery(t.Value), FieldURL)
+		}
+		return fieldQuery(bleve.NewTermQuery(t.Value), FieldURL)
+	case querylang.FieldSite:
+		return fieldQuery(bleve.NewTermQuery(strings.ToLower(t.Value)), FieldSites)
+	}
+
+	return textFields(t.Field, func(field string) query.Query {
+		if t.Prefix {
+			// 前方一致のクエリは解析されないため、索引に合わせて小文字にする
+			return fieldQuery(bleve.NewPrefixQuery(strings.ToLower(t.Value)), field)
+		}
+		return anyAnalyzer(textAnalyzers(t.Value, analyzer), func(analyzer string) query.Query {
+			mq := bleve.NewMatchQuery(t.Value)
+			mq.Analyzer = analyzer
+			return fieldQuery(mq, field)
+		})
+	})
+}
+
+func translatePhrase(p *querylang.Phrase, analyzer string) query.Query {
+	switch p.Field {
+	case querylang.FieldURL, querylang.FieldSite:
+		return translateTerm(&querylang.Term{Field: p.Field, Value: p.Value}, analyzer)
+	}
+
+	return textFields(p.Field, func(field string) query.Query {
+		return anyAnalyzer(textAnalyzers(p.Value, analyzer), func(analyzer string) query.Query {
+			pq := bleve.NewMatchPhraseQuery(p.Value)
+			pq.Analyzer = analyzer
+			return fieldQuery(pq, field)
+		})
+	})
+}
+
+// textFields は f のテキストフィールドごとに mk で作成したクエリを返す。FieldAny の場合はいずれかに一致するクエリとなる。
+func textFields(f querylang.Field, mk func(field string) query.Query) query.Query {
+	switch f {
+	case querylang.FieldTitle:
+		return mk(FieldTitle)
+	case querylang.FieldContent:
+		return mk(FieldContent)
+	default:
+		return bleve.NewDisjunctionQuery(mk(FieldTitle), mk(FieldContent))
+	}
+}
+
+type fieldableQuery interface {
+	query.Query
+	SetField(string)
+}
+
+func fieldQuery(q fieldableQuery, field string) query.Query {
+	q.SetField(field)
+	return q
+}
@@ -0,0 +1,122 @@
+package inverted
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"strings"
+)
+
+// advancedScores は構文木 n に一致する文書とその BM25 スコアを返す。
+// url: と site: の条件および否定はスコアに寄与しない。
+func (i *InvertedIndexer) advancedScores(n querylang.Node) map[uuid.UUID]float64 {
+	switch n := n.(type) {
+	case *querylang.And:
+		var (
+			scores  map[uuid.UUID]float64
+			exclude []map[uuid.UUID]float64
+		)
+		for _, child := range n.Nodes {
+			if not, ok := child.(*querylang.Not); ok {
+				exclude = append(exclude, i.advancedScores(not.Node))
+				continue
+			}
+			childScores := i.advancedScores(child)
+			if scores == nil {
+				scores = childScores
+				continue
+			}
+			for id, score := range scores {
+				if childScore, found := childScores[id]; found {
+					scores[id] = score + childScore
+				} else {
+					delete(scores, id)
+				}
+			}
+		}
+
+		// 否定のみの場合はすべての文書が対象となる
+		if scores == nil {
+			scores = i.allDocs()
+		}
+		for _, excluded := range exclude {
+			for id := range excluded {
+				delete(scores, id)
+			}
+		}
+		return scores
+	case *querylang.Or:
+		scores := make(map[uuid.UUID]float64)
+		for _, child := range n.Nodes {
+			for id, score := range i.advancedScores(child) {
+				scores[id] += score
+			}
+		}
+		return scores
+	case *querylang.Not:
+		scores := i.allDocs()
+		for id := range i.advancedScores(n.Node) {
+			delete(scores, id)
+		}
+		return scores
+	case *querylang.Term:
+		return i.termScores(n)
+	case *querylang.Phrase:
+		switch n.Field {
+		case querylang.FieldURL, querylang.FieldSite:
+			return i.termScores(&querylang.Term{Field: n.Field, Value: n.Value})
+		}
+		return i.phraseScores(analyze(n.Value), n.Field)
+	default:
+		return nil
+	}
+}
+
+func (i *InvertedIndexer) termScores(t *querylang.Term) map[uuid.UUID]float64 {
+	switch t.Field {
+	case querylang.FieldURL:
+		return i.filterDocs(func(entry *docEntry) bool {
+			if t.Prefix {
+				return strings.HasPrefix(entry.doc.URL, t.Value)
+			}
+			return entry.doc.URL == t.Value
+		})
+	case querylang.FieldSite:
+		site := strings.ToLower(t.Value)
+		return i.filterDocs(func(entry *docEntry) bool {
+			for _, s := range querylang.Sites(entry.doc.URL) {
+				if s == site {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if !t.Prefix {
+		return i.matchScores(analyze(t.Value), t.Field)
+	}
+
+	// 前方一致する語をすべて検索する
+	prefix := strings.ToLower(t.Value)
+	var terms []token
+	for term := range i.postings {
+		if strings.HasPrefix(term, prefix) {
+			terms = append(terms, token{term: term})
+		}
+	}
+	return i.matchScores(terms, t.Field)
+}
+
+func (i *InvertedIndexer) allDocs() map[uuid.UUID]float64 {
+	return i.filterDocs(func(*docEntry) bool { return true })
+}
+
+func (i *InvertedIndexer) filterDocs(match func(*docEntry) bool) map[uuid.UUID]float64 {
+	scores := make(map[uuid.UUID]float64)
+	for id, entry := range i.docs {
+		if match(entry) {
+			scores[id] = 0
+		}
+	}
+	return scores
+}
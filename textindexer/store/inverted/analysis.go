@@ -0,0 +1,79 @@
+package inverted
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords は検索に寄与しないため索引付けしない英語の語
+var stopWords = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "are": {}, "as": {}, "at": {}, "be": {}, "but": {}, "by": {},
+	"for": {}, "if": {}, "in": {}, "into": {}, "is": {}, "it": {}, "no": {}, "not": {}, "of": {},
+	"on": {}, "or": {}, "such": {}, "that": {}, "the": {}, "their": {}, "then": {}, "there": {},
+	"these": {}, "they": {}, "this": {}, "to": {}, "was": {}, "will": {}, "with": {},
+}
+
+// token は解析された語とテキスト中の位置を表す
+type token struct {
+	term string
+	pos  int
+}
+
+// analyze はテキストを小文字の語に分割し、ストップワードを除外する。
+// 除外された語も位置を消費するため、フレーズ検索では語の間隔が保たれる。
+// 空白で区切られない CJK の文字列は、隣接する 2 文字ずつの組 (bigram) に分割する。
+func analyze(text string) []token {
+	var (
+		tokens []token
+		pos    int
+		word   strings.Builder
+		cjk    []rune
+	)
+
+	emit := func(term string) {
+		if _, stop := stopWords[term]; !stop {
+			tokens = append(tokens, token{term: term, pos: pos})
+		}
+		pos++
+	}
+	flushWord := func() {
+		if word.Len() != 0 {
+			emit(word.String())
+			word.Reset()
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			emit(string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				emit(string(cjk[i : i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,438 @@
+package inverted
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/highlight"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/lang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/ranking"
+	"golang.org/x/xerrors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BM25 の既定のパラメータ
+	defaultK1 = 1.2
+	defaultB  = 0.75
+
+	// scanBatchSize は Scan が 1 回の読み取りロックでコピーする文書の数
+	scanBatchSize = 100
+
+	// titleGap はタイトルと本文の間に空ける位置の数。フレーズがタイトルと本文にまたがって一致することを防ぐ。
+	titleGap = 100
+)
+
+var (
+	_ index.Indexer  = (*InvertedIndexer)(nil)
+	_ index.Restorer = (*InvertedIndexer)(nil)
+	_ index.Scanner  = (*InvertedIndexer)(nil)
+)
+
+// Config は InvertedIndexer のスコア計算のパラメータを保持する。ゼロ値のフィールドには既定値が使用される。
+type Config struct {
+	// K1 は語の出現頻度によるスコアの飽和の度合い
+	K1 float64
+
+	// B は文書の長さによる正規化の度合い
+	B float64
+
+	// PageRankWeight は BM25 スコアに加算される PageRank の重み。既定値は 1。
+	PageRankWeight float64
+}
+
+type docEntry struct {
+	doc    *index.Document
+	length int
+
+	// contentStart は本文の最初の語の位置。これより前の位置はタイトルに含まれる。
+	contentStart int
+
+	// terms は文書に含まれる語。再インデックス時にポスティングを削除するために使用する。
+	terms []string
+}
+
+// placeholder は UpdateScore によって作成され、まだ内容が追加されていない文書かを返す
+func (e *docEntry) placeholder() bool {
+	return e.doc.IndexedAt.IsZero() && e.length == 0
+}
+
+// InvertedIndexer は標準ライブラリのみで実装された、位置情報付きの転置インデックスと
+// BM25 スコアリングを用いる index.Indexer の実装
+type InvertedIndexer struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	docs     map[uuid.UUID]*docEntry
+	postings map[string]map[uuid.UUID][]int
+
+	// numDocs と totalLen はプレースホルダ文書を除いた文書の数と長さの合計。BM25 の計算に使用する。
+	numDocs  int
+	totalLen int
+}
+
+// NewInvertedIndexer は新しい InvertedIndexer を作成する
+func NewInvertedIndexer(cfg Config) *InvertedIndexer {
+	if cfg.K1 == 0 {
+		cfg.K1 = defaultK1
+	}
+	if cfg.B == 0 {
+		cfg.B = defaultB
+	}
+	if cfg.PageRankWeight == 0 {
+		cfg.PageRankWeight = 1
+	}
+
+	return &InvertedIndexer{
+		cfg:      cfg,
+		docs:     make(map[uuid.UUID]*docEntry),
+		postings: make(map[string]map[uuid.UUID][]int),
+	}
+}
+
+func (i *InvertedIndexer) Index(doc *index.Document) error {
+	if doc.LinkID == uuid.Nil {
+		return xerrors.Errorf("index: %w", index.ErrMissingLinkID)
+	}
+
+	doc.IndexedAt = time.Now()
+	i.index(doc, false)
+	return nil
+}
+
+// Restore は docs を追加する。Index と異なり、文書の IndexedAt と PageRank をそのまま保存する。
+func (i *InvertedIndexer) Restore(docs []*index.Document) (index.BatchResult, error) {
+	var (
+		res   index.BatchResult
+		start = time.Now()
+	)
+	for pos, doc := range docs {
+		if doc == nil || doc.LinkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, Err: index.ErrMissingLinkID})
+			continue
+		}
+		i.index(doc, true)
+		res.Processed++
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// index は文書とそのポスティングを追加する。restore が false の場合、既存の文書の PageRank スコアを保持する。
+func (i *InvertedIndexer) index(doc *index.Document, restore bool) {
+	doc.Language = lang.OfDocument(doc)
+	dcopy := copyDoc(doc)
+
+	// タイトルと本文の位置が重ならないように本文の位置をずらす
+	tokens := analyze(dcopy.Title)
+	offset := titleGap
+	if n := len(tokens); n != 0 {
+		offset += tokens[n-1].pos + 1
+	}
+	for _, tok := range analyze(dcopy.Content) {
+		tok.pos += offset
+		tokens = append(tokens, tok)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// 更新する場合、既存のPageRankスコアを保持する。
+	if orig, exists := i.docs[dcopy.LinkID]; exists {
+		if !restore {
+			dcopy.PageRank = orig.doc.PageRank
+		}
+		i.removePostings(dcopy.LinkID, orig)
+	}
+
+	entry := &docEntry{doc: dcopy, length: len(tokens), contentStart: offset}
+	for _, tok := range tokens {
+		docPostings := i.postings[tok.term]
+		if docPostings == nil {
+			docPostings = make(map[uuid.UUID][]int)
+			i.postings[tok.term] = docPostings
+		}
+		if _, seen := docPostings[dcopy.LinkID]; !seen {
+			entry.terms = append(entry.terms, tok.term)
+		}
+		docPostings[dcopy.LinkID] = append(docPostings[dcopy.LinkID], tok.pos)
+	}
+
+	i.docs[dcopy.LinkID] = entry
+	if !entry.placeholder() {
+		i.numDocs++
+		i.totalLen += entry.length
+	}
+}
+
+func (i *InvertedIndexer) removePostings(linkID uuid.UUID, entry *docEntry) {
+	for _, term := range entry.terms {
+		delete(i.postings[term], linkID)
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+	if !entry.placeholder() {
+		i.numDocs--
+		i.totalLen -= entry.length
+	}
+}
+
+func (i *InvertedIndexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if entry, found := i.docs[linkID]; found {
+		return copyDoc(entry.doc), nil
+	}
+
+	return nil, xerrors.Errorf("find by ID: %w", index.ErrNotFound)
+}
+
+// Search は BM25 スコアに PageRank を加えたスコアの降順で文書を返す
+// q.Ranking が指定された場合は PageRankWeight の代わりに Ranking のスコアで並べる。
+func (i *InvertedIndexer) Search(q index.Query) (index.Iterator, error) {
+	if q.Ranking != nil {
+		return ranking.Search(q, i.Search)
+	}
+
+	terms := analyze(q.Expression)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var scores map[uuid.UUID]float64
+	switch q.Type {
+	case index.QueryTypePhrase:
+		scores = i.phraseScores(terms, querylang.FieldAny)
+	case index.QueryTypeAdvanced:
+		n, err := querylang.Parse(q.Expression)
+		if err != nil {
+			return nil, xerrors.Errorf("search: %w", err)
+		}
+		scores = i.advancedScores(n)
+	default:
+		scores = i.matchScores(terms, querylang.FieldAny)
+	}
+
+	results := make([]scoredDoc, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, scoredDoc{
+			id:        id,
+			score:     score + i.cfg.PageRankWeight*i.docs[id].doc.PageRank,
+			relevance: score,
+		})
+	}
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].score != results[b].score {
+			return results[a].score > results[b].score
+		}
+		return results[a].id.String() < results[b].id.String()
+	})
+
+	start := q.Offset
+	if q.Cursor != "" {
+		var c cursor
+		if err := index.DecodeCursor(q, &c); err != nil {
+			return nil, xerrors.Errorf("search: %w", err)
+		}
+		start = uint64(sort.Search(len(results), func(n int) bool { return c.after(results[n]) }))
+	}
+
+	it := &resultIterator{idx: i, results: results, cur: start, total: uint64(len(results)), limit: q.Limit}
+	if q.Highlight != nil {
+		return highlight.NewIterator(it, q), nil
+	}
+	return it, nil
+}
+
+// matchScores はフィールド field にいずれかの語を含む文書の BM25 スコアを計算する
+func (i *InvertedIndexer) matchScores(terms []token, field querylang.Field) map[uuid.UUID]float64 {
+	scores := make(map[uuid.UUID]float64)
+	for _, term := range uniqueTerms(terms) {
+		for id := range i.postings[term] {
+			if tf := len(i.fieldPositions(term, id, field)); tf != 0 {
+				scores[id] += i.bm25(term, id, tf)
+			}
+		}
+	}
+	return scores
+}
+
+// phraseScores はフィールド field にすべての語を同じ間隔で含む文書の BM25 スコアを計算する
+func (i *InvertedIndexer) phraseScores(terms []token, field querylang.Field) map[uuid.UUID]float64 {
+	scores := make(map[uuid.UUID]float64)
+	if len(terms) == 0 {
+		return scores
+	}
+
+	first := terms[0]
+	for id := range i.postings[first.term] {
+		if !i.containsPhrase(id, i.fieldPositions(first.term, id, field), terms) {
+			continue
+		}
+		for _, term := range uniqueTerms(terms) {
+			scores[id] += i.bm25(term, id, len(i.fieldPositions(term, id, field)))
+		}
+	}
+	return scores
+}
+
+// fieldPositions は文書 id における語 term の位置のうち、フィールド field に含まれるものを返す
+func (i *InvertedIndexer) fieldPositions(term string, id uuid.UUID, field querylang.Field) []int {
+	positions := i.postings[term][id]
+	split := sort.SearchInts(positions, i.docs[id].contentStart)
+	switch field {
+	case querylang.FieldTitle:
+		return positions[:split]
+	case querylang.FieldContent:
+		return positions[split:]
+	default:
+		return positions
+	}
+}
+
+func (i *InvertedIndexer) containsPhrase(id uuid.UUID, firstPositions []int, terms []token) bool {
+	for _, start := range firstPositions {
+		matched := true
+		for _, term := range terms[1:] {
+			want := start + term.pos - terms[0].pos
+			positions := i.postings[term.term][id]
+			j := sort.SearchInts(positions, want)
+			if j >= len(positions) || positions[j] != want {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// bm25 は語 term が termFreq 回出現する文書 id の BM25 スコアを計算する
+func (i *InvertedIndexer) bm25(term string, id uuid.UUID, termFreq int) float64 {
+	var (
+		n      = float64(i.numDocs)
+		df     = float64(len(i.postings[term]))
+		tf     = float64(termFreq)
+		avgLen = float64(i.totalLen) / n
+		docLen = float64(i.docs[id].length)
+	)
+
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	return idf * tf * (i.cfg.K1 + 1) / (tf + i.cfg.K1*(1-i.cfg.B+i.cfg.B*docLen/avgLen))
+}
+
+// UpdateScore は、指定されたリンク ID を持つ文書の PageRank スコアを更新する。
+// そのような文書が存在しない場合、指定されたスコアを持つプレースホルダ文書が作成される。
+func (i *InvertedIndexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, found := i.docs[linkID]
+	if !found {
+		entry = &docEntry{doc: &index.Document{LinkID: linkID}}
+		i.docs[linkID] = entry
+	}
+
+	entry.doc.PageRank = score
+	return nil
+}
+
+// Delete は指定されたリンク ID を持つ文書とそのポスティングを削除する
+func (i *InvertedIndexer) Delete(linkID uuid.UUID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, found := i.docs[linkID]
+	if !found {
+		return xerrors.Errorf("delete: %w", index.ErrNotFound)
+	}
+
+	i.removePostings(linkID, entry)
+	delete(i.docs, linkID)
+	return nil
+}
+
+// DeleteMatching は pred が true を返すすべての文書とそのポスティングを削除する
+func (i *InvertedIndexer) DeleteMatching(pred index.Predicate) (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var count int
+	for id, entry := range i.docs {
+		if pred(copyDoc(entry.doc)) {
+			i.removePostings(id, entry)
+			delete(i.docs, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Scan はリンク ID の順にすべての文書について fn を呼び出す。
+// 文書は scanBatchSize 件ずつ読み取りロックを取得してコピーされ、fn はロックを解放してから呼び出される。
+func (i *InvertedIndexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	i.mu.RLock()
+	ids := make([]uuid.UUID, 0, len(i.docs))
+	for id := range i.docs {
+		ids = append(ids, id)
+	}
+	i.mu.RUnlock()
+	sort.Slice(ids, func(a, b int) bool { return bytes.Compare(ids[a][:], ids[b][:]) < 0 })
+
+	docs := make([]*index.Document, 0, scanBatchSize)
+	for start := 0; start < len(ids); start += scanBatchSize {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("scan: %w", err)
+		}
+
+		end := start + scanBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		docs = docs[:0]
+		i.mu.RLock()
+		for _, id := range ids[start:end] {
+			// 走査の開始後に削除された文書は渡さない
+			if entry, found := i.docs[id]; found {
+				docs = append(docs, copyDoc(entry.doc))
+			}
+		}
+		i.mu.RUnlock()
+
+		for _, doc := range docs {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func uniqueTerms(tokens []token) []string {
+	var (
+		terms []string
+		seen  = make(map[string]struct{}, len(tokens))
+	)
+	for _, tok := range tokens {
+		if _, dup := seen[tok.term]; !dup {
+			seen[tok.term] = struct{}{}
+			terms = append(terms, tok.term)
+		}
+	}
+	return terms
+}
+
+func copyDoc(d *index.Document) *index.Document {
+	dcopy := new(index.Document)
+	*dcopy = *d
+	return dcopy
+}
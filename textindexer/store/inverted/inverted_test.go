@@ -0,0 +1,140 @@
+package inverted
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/indextest"
+	gc "gopkg.in/check.v1"
+	"testing"
+)
+
+var _ = gc.Suite(new(InvertedIndexerTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type InvertedIndexerTestSuite struct {
+	indextest.SuiteBase
+	idx *InvertedIndexer
+}
+
+func (s *InvertedIndexerTestSuite) SetUpTest(c *gc.C) {
+	s.idx = NewInvertedIndexer(Config{})
+	s.SetIndexer(s.idx)
+}
+
+func (s *InvertedIndexerTestSuite) TestBM25Ranking(c *gc.C) {
+	rare := &index.Document{LinkID: uuid.New(), Content: "gopher mentioned once among many other different words here"}
+	frequent := &index.Document{LinkID: uuid.New(), Content: "gopher gopher gopher"}
+	unrelated := &index.Document{LinkID: uuid.New(), Content: "nothing to see"}
+	for _, doc := range []*index.Document{rare, frequent, unrelated} {
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+	}
+
+	it, err := s.idx.Search(index.Query{Expression: "Gopher"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(2))
+	c.Assert(collectIDs(it), gc.DeepEquals, []uuid.UUID{frequent.LinkID, rare.LinkID})
+
+	// PageRank は BM25 スコアに加算される
+	c.Assert(s.idx.UpdateScore(rare.LinkID, 10), gc.IsNil)
+	it, err = s.idx.Search(index.Query{Expression: "gopher"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(collectIDs(it), gc.DeepEquals, []uuid.UUID{rare.LinkID, frequent.LinkID})
+}
+
+func (s *InvertedIndexerTestSuite) TestBM25IgnoresPlaceholders(c *gc.C) {
+	doc := &index.Document{LinkID: uuid.New(), Content: "gopher conference"}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+	c.Assert(s.idx.Index(&index.Document{LinkID: uuid.New(), Content: "rust conference talks"}), gc.IsNil)
+	before := s.relevance(c, "gopher")
+
+	// UpdateScore が作成するプレースホルダ文書は文書の数と平均の長さに含めない
+	placeholders := make([]uuid.UUID, 50)
+	for n := range placeholders {
+		placeholders[n] = uuid.New()
+		c.Assert(s.idx.UpdateScore(placeholders[n], 0.1), gc.IsNil)
+	}
+	c.Assert(s.relevance(c, "gopher"), gc.Equals, before)
+	c.Assert(s.idx.numDocs, gc.Equals, 2)
+
+	// 内容が追加されたプレースホルダ文書は数に含め、削除した文書は数から除く
+	c.Assert(s.idx.Index(&index.Document{LinkID: placeholders[0], Content: "gopher"}), gc.IsNil)
+	c.Assert(s.idx.Delete(placeholders[1]), gc.IsNil)
+	_, err := s.idx.DeleteMatching(func(d *index.Document) bool { return d.LinkID == doc.LinkID })
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.idx.numDocs, gc.Equals, 2)
+	c.Assert(s.idx.totalLen, gc.Equals, 4)
+}
+
+// relevance は expr で検索した最初の文書の関連度を返す
+func (s *InvertedIndexerTestSuite) relevance(c *gc.C, expr string) float64 {
+	it, err := s.idx.Search(index.Query{Expression: expr})
+	c.Assert(err, gc.IsNil)
+	defer func() { c.Assert(it.Close(), gc.IsNil) }()
+	c.Assert(it.Next(), gc.Equals, true)
+	return it.(index.ScoredIterator).Relevance()
+}
+
+func (s *InvertedIndexerTestSuite) TestPhraseWithStopWords(c *gc.C) {
+	doc := &index.Document{LinkID: uuid.New(), Title: "Lord", Content: "The Lord of the Rings"}
+	other := &index.Document{LinkID: uuid.New(), Content: "rings of the lord"}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+	c.Assert(s.idx.Index(other), gc.IsNil)
+
+	it, err := s.idx.Search(index.Query{Type: index.QueryTypePhrase, Expression: "lord of the rings"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(collectIDs(it), gc.DeepEquals, []uuid.UUID{doc.LinkID})
+
+	// 除外された語の位置がずれているフレーズには一致しない
+	it, err = s.idx.Search(index.Query{Type: index.QueryTypePhrase, Expression: "lord rings"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(0))
+}
+
+func (s *InvertedIndexerTestSuite) TestReindexReplacesPostings(c *gc.C) {
+	doc := &index.Document{LinkID: uuid.New(), Content: "old content"}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+	c.Assert(s.idx.Index(&index.Document{LinkID: doc.LinkID, Content: "new content"}), gc.IsNil)
+
+	it, err := s.idx.Search(index.Query{Expression: "old"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(it.TotalCount(), gc.Equals, uint64(0))
+
+	it, err = s.idx.Search(index.Query{Expression: "new"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(collectIDs(it), gc.DeepEquals, []uuid.UUID{doc.LinkID})
+}
+
+func (s *InvertedIndexerTestSuite) TestCJKBigrams(c *gc.C) {
+	doc := &index.Document{LinkID: uuid.New(), Content: "東京都の天気予報"}
+	c.Assert(s.idx.Index(doc), gc.IsNil)
+
+	it, err := s.idx.Search(index.Query{Type: index.QueryTypePhrase, Expression: "天気予報"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(collectIDs(it), gc.DeepEquals, []uuid.UUID{doc.LinkID})
+
+	it, err = s.idx.Search(index.Query{Type: index.QueryTypePhrase, Expression: "京都"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(collectIDs(it), gc.DeepEquals, []uuid.UUID{doc.LinkID})
+}
+
+func (s *InvertedIndexerTestSuite) TestAnalyze(c *gc.C) {
+	c.Assert(analyze("The Quick, brown FOX!"), gc.DeepEquals, []token{
+		{term: "quick", pos: 1},
+		{term: "brown", pos: 2},
+		{term: "fox", pos: 3},
+	})
+	c.Assert(analyze("Go言語"), gc.DeepEquals, []token{
+		{term: "go", pos: 0},
+		{term: "言語", pos: 1},
+	})
+}
+
+func collectIDs(it index.Iterator) []uuid.UUID {
+	var ids []uuid.UUID
+	for it.Next() {
+		ids = append(ids, it.Document().LinkID)
+	}
+	_ = it.Close()
+	return ids
+}
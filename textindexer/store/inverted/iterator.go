@@ -0,0 +1,96 @@
+package inverted
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+)
+
+var (
+	_ index.CursorIterator = (*resultIterator)(nil)
+	_ index.ScoredIterator = (*resultIterator)(nil)
+)
+
+// scoredDoc は検索に一致した文書のスコア。relevance は PageRank を含まない BM25 スコア。
+type scoredDoc struct {
+	id        uuid.UUID
+	score     float64
+	relevance float64
+}
+
+// cursor は検索を再開する位置の文書のスコアと ID
+type cursor struct {
+	Score float64   `json:"s"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// after は d が c の位置より後に並ぶかを返す
+func (c cursor) after(d scoredDoc) bool {
+	if d.score != c.Score {
+		return d.score < c.Score
+	}
+	return d.id.String() > c.ID.String()
+}
+
+// resultIterator は検索時点で確定したスコア順の結果を返す index.Iterator の実装
+type resultIterator struct {
+	idx     *InvertedIndexer
+	results []scoredDoc
+	cur     uint64
+	total   uint64
+
+	// limit は返す文書の最大数、returned は返した文書の数
+	limit    uint64
+	returned uint64
+
+	latchedDoc    *index.Document
+	latchedResult scoredDoc
+}
+
+func (it *resultIterator) Close() error {
+	it.idx = nil
+	it.results = nil
+	return nil
+}
+
+func (it *resultIterator) Next() bool {
+	for it.idx != nil && it.cur < uint64(len(it.results)) && (it.limit == 0 || it.returned < it.limit) {
+		result := it.results[it.cur]
+		it.cur++
+
+		// 検索後に削除された文書は読み飛ばす
+		if doc, err := it.idx.FindByID(result.id); err == nil {
+			it.latchedDoc, it.latchedResult = doc, result
+			it.returned++
+			return true
+		}
+	}
+	return false
+}
+
+func (it *resultIterator) Error() error {
+	return nil
+}
+
+func (it *resultIterator) Document() *index.Document {
+	return it.latchedDoc
+}
+
+// Relevance は現在の文書の BM25 スコアを返す
+func (it *resultIterator) Relevance() float64 {
+	if it.latchedDoc == nil {
+		return 0
+	}
+	return it.latchedResult.relevance
+}
+
+// Cursor は現在の文書の次から検索を再開するためのカーソルを返す
+func (it *resultIterator) Cursor() string {
+	if it.latchedDoc == nil {
+		return ""
+	}
+	return index.EncodeCursor(cursor{Score: it.latchedResult.score, ID: it.latchedResult.id})
+}
+
+func (it *resultIterator) TotalCount() uint64 {
+	return it.total
+}
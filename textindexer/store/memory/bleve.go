@@ -1,34 +1,68 @@
 package memory
 
 import (
+	"context"
 	"github.com/blevesearch/bleve"
-	"github.com/blevesearch/bleve/search/query"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/highlight/fragmenter/simple"
+	simplehl "github.com/blevesearch/bleve/search/highlight/highlighter/simple"
 	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/highlight"
 	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/lang"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/ranking"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/internal/bleveiter"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/internal/blevequery"
 	"golang.org/x/xerrors"
+	"sort"
 	"sync"
 	"time"
 )
 
-const batchSize = 10
+const (
+	batchSize = 10
 
-var _ index.Indexer = (*InMemoryBleveIndexer)(nil)
+	// scanBatchSize は Scan が 1 回の読み取りロックでコピーする文書の数
+	scanBatchSize = 100
+)
+
+var (
+	_ index.ContextIndexer = (*InMemoryBleveIndexer)(nil)
+	_ index.BatchIndexer   = (*InMemoryBleveIndexer)(nil)
+	_ index.Restorer       = (*InMemoryBleveIndexer)(nil)
+	_ index.Scanner        = (*InMemoryBleveIndexer)(nil)
+)
 
 type bleveDoc struct {
 	Title    string
 	Content  string
 	PageRank float64
+
+	// URL と Sites は QueryTypeAdvanced の url: と site: による検索に使用される
+	URL   string
+	Sites []string
+
+	// Language は文書の索引付けに使用するアナライザを選択する
+	Language string
 }
 
 type InMemoryBleveIndexer struct {
 	mu   sync.RWMutex
 	docs map[string]*index.Document
 	idx  bleve.Index
+
+	// rewriter は検索の前にクエリを書き換える。nil の場合はクエリをそのまま使用する。
+	rewriter index.QueryRewriter
 }
 
+// NewInMemoryBleveIndexer は文書の言語ごとのアナライザでタイトルと本文を索引付けするインデクサを作成する
 func NewInMemoryBleveIndexer() (*InMemoryBleveIndexer, error) {
-	mapping := bleve.NewIndexMapping()
-	idx, err := bleve.NewMemOnly(mapping)
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = newDocMapping()
+	blevequery.AddLanguageMappings(m, newDocMapping)
+	idx, err := bleve.NewMemOnly(m)
 	if err != nil {
 		return nil, err
 	}
@@ -39,16 +73,45 @@ func NewInMemoryBleveIndexer() (*InMemoryBleveIndexer, error) {
 	}, nil
 }
 
+func newDocMapping() *mapping.DocumentMapping {
+	// URL と Sites は完全一致で検索するため、解析せずに索引付けする
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.IncludeInAll = false
+
+	docMapping := bleve.NewDocumentMapping()
+	docMapping.AddFieldMappingsAt(blevequery.FieldURL, keywordField)
+	docMapping.AddFieldMappingsAt(blevequery.FieldSites, keywordField)
+	docMapping.AddFieldMappingsAt(blevequery.FieldLanguage, blevequery.NewLanguageFieldMapping(false))
+	return docMapping
+}
+
+// SetQueryRewriter は以降の検索でクエリを書き換える rw を設定する。nil を指定すると書き換えを行わない。
+// 書き換えは検索時に行われるため、同義語などの規則を変更してもインデックスを作り直す必要はない。
+func (i *InMemoryBleveIndexer) SetQueryRewriter(rw index.QueryRewriter) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rewriter = rw
+}
+
 func (i *InMemoryBleveIndexer) Close() error {
 	return i.idx.Close()
 }
 
 func (i *InMemoryBleveIndexer) Index(doc *index.Document) error {
+	return i.IndexContext(context.Background(), doc)
+}
+
+func (i *InMemoryBleveIndexer) IndexContext(ctx context.Context, doc *index.Document) error {
 	if doc.LinkID == uuid.Nil {
 		return xerrors.Errorf("index: %w", index.ErrMissingLinkID)
 	}
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("index: %w", err)
+	}
 
 	doc.IndexedAt = time.Now()
+	doc.Language = lang.OfDocument(doc)
 	dcopy := copyDoc(doc)
 	key := dcopy.LinkID.String()
 
@@ -65,14 +128,79 @@ func (i *InMemoryBleveIndexer) Index(doc *index.Document) error {
 	}
 
 	i.docs[key] = dcopy
-	i.mu.Unlock()
 	return nil
 }
 
+// IndexBatch は docs を 1 つの bleve のバッチでインデックスに追加する。
+// リンク ID のない文書などはエラーとして報告され、残りの文書は追加される。
+func (i *InMemoryBleveIndexer) IndexBatch(docs []*index.Document) (index.BatchResult, error) {
+	return i.indexBatch(docs, false)
+}
+
+// Restore は IndexBatch と同様に docs を追加するが、文書の IndexedAt と PageRank をそのまま保存する
+func (i *InMemoryBleveIndexer) Restore(docs []*index.Document) (index.BatchResult, error) {
+	return i.indexBatch(docs, true)
+}
+
+func (i *InMemoryBleveIndexer) indexBatch(docs []*index.Document, restore bool) (index.BatchResult, error) {
+	var (
+		res     index.BatchResult
+		start   = time.Now()
+		pending = make(map[string]*index.Document, len(docs))
+	)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	batch := i.idx.NewBatch()
+	for pos, doc := range docs {
+		if doc == nil || doc.LinkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, Err: index.ErrMissingLinkID})
+			continue
+		}
+
+		if !restore {
+			doc.IndexedAt = start
+		}
+		doc.Language = lang.OfDocument(doc)
+		dcopy := copyDoc(doc)
+		key := dcopy.LinkID.String()
+
+		// 更新する場合、既存のPageRankスコアを保持する。
+		if orig, exists := i.docs[key]; exists && !restore {
+			dcopy.PageRank = orig.PageRank
+		}
+
+		if err := batch.Index(key, makeBleveDoc(dcopy)); err != nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: pos, LinkID: dcopy.LinkID, Err: err})
+			continue
+		}
+		pending[key] = dcopy
+		res.Processed++
+	}
+
+	if err := i.idx.Batch(batch); err != nil {
+		return index.BatchResult{}, xerrors.Errorf("index batch: %w", err)
+	}
+	for key, doc := range pending {
+		i.docs[key] = doc
+	}
+
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
 func (i *InMemoryBleveIndexer) FindByID(linkID uuid.UUID) (*index.Document, error) {
 	return i.findByID(linkID.String())
 }
 
+func (i *InMemoryBleveIndexer) FindByIDContext(ctx context.Context, linkID uuid.UUID) (*index.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, xerrors.Errorf("find by ID: %w", err)
+	}
+	return i.findByID(linkID.String())
+}
+
 func (i *InMemoryBleveIndexer) findByID(linkID string) (*index.Document, error) {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
@@ -86,31 +214,74 @@ func (i *InMemoryBleveIndexer) findByID(linkID string) (*index.Document, error)
 
 // 特定のクエリのインデックスを検索し、結果のイテレータを返します
 func (i *InMemoryBleveIndexer) Search(q index.Query) (index.Iterator, error) {
-	var bq query.Query
-	switch q.Type {
-	case index.QueryTypePhrase:
-		bq = bleve.NewMatchPhraseQuery(q.Expression)
-	default:
-		bq = bleve.NewMatchQuery(q.Expression)
-	}
-
-	searchReq := bleve.NewSearchRequest(bq)
-	searchReq.SortBy([]string{"-PageRank", "-_score"})
-	searchReq.Size = batchSize
-	searchReq.From = int(q.Offset)
-	rs, err := i.idx.Search(searchReq)
+	return i.SearchContext(context.Background(), q)
+}
+
+// SearchContext は Search と同様に検索を行う。ctx がキャンセルされると、イテレータは次のバッチの取得を中断する。
+// SetQueryRewriter で設定された QueryRewriter がある場合は、書き換えたクエリで検索する。
+func (i *InMemoryBleveIndexer) SearchContext(ctx context.Context, q index.Query) (index.Iterator, error) {
+	i.mu.RLock()
+	rw := i.rewriter
+	i.mu.RUnlock()
+	if rw != nil {
+		q = rw.Rewrite(q)
+	}
+	return i.search(ctx, q)
+}
+
+func (i *InMemoryBleveIndexer) search(ctx context.Context, q index.Query) (index.Iterator, error) {
+	if q.Ranking != nil {
+		return ranking.Search(q, func(q index.Query) (index.Iterator, error) {
+			return i.search(ctx, q)
+		})
+	}
+
+	bq, err := blevequery.NewQuery(q)
+	if err != nil {
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+
+	searchReq, err := blevequery.NewSearchRequest(bq, q, batchSize)
+	if err != nil {
+		return nil, xerrors.Errorf("search: %w", err)
+	}
+	if q.Highlight != nil {
+		searchReq.IncludeLocations = true
+	}
+	rs, err := i.idx.SearchInContext(ctx, searchReq)
 	if err != nil {
 		return nil, xerrors.Errorf("index: %w", err)
 	}
 
-	return &bleveIterator{
-		idx: i, searchReq: searchReq, rs: rs, cumIdx: q.Offset}, nil
+	it := &bleveIterator{Iterator: bleveiter.New(ctx, i.idx, i.findByID, searchReq, rs, q.Offset, q.Limit), idx: i}
+	if q.Highlight != nil {
+		it.highlighter = newHighlighter(q.Highlight)
+		it.fallback = highlight.New(q)
 	}
+	return it, nil
+}
+
+// newHighlighter は opts に従って本文の抜粋を作成する bleve の highlighter を作成する
+func newHighlighter(opts *index.HighlightOptions) *simplehl.Highlighter {
+	o := opts.WithDefaults()
+	return simplehl.NewHighlighter(
+		simple.NewFragmenter(o.FragmentSize),
+		&escapingFormatter{before: o.PreTag, after: o.PostTag},
+		"…",
+	)
 }
 
 // UpdateScore は、指定されたリンク ID を持つ文書の PageRank スコアを更新する。
 // そのような文書が存在しない場合、指定されたスコアを持つプレースホルダ文書が作成される。
 func (i *InMemoryBleveIndexer) UpdateScore(linkID uuid.UUID, score float64) error {
+	return i.UpdateScoreContext(context.Background(), linkID, score)
+}
+
+func (i *InMemoryBleveIndexer) UpdateScoreContext(ctx context.Context, linkID uuid.UUID, score float64) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("update score: %w", err)
+	}
+
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
@@ -129,6 +300,150 @@ func (i *InMemoryBleveIndexer) UpdateScore(linkID uuid.UUID, score float64) erro
 	return nil
 }
 
+// UpdateScores は scores の PageRank スコアを 1 つの bleve のバッチで更新する。
+// 存在しない文書にはプレースホルダ文書が作成される。
+func (i *InMemoryBleveIndexer) UpdateScores(scores map[uuid.UUID]float64) (index.BatchResult, error) {
+	var (
+		res     index.BatchResult
+		start   = time.Now()
+		pending = make(map[string]*index.Document, len(scores))
+	)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	batch := i.idx.NewBatch()
+	for linkID, score := range scores {
+		if linkID == uuid.Nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: -1, Err: index.ErrMissingLinkID})
+			continue
+		}
+
+		key := linkID.String()
+		doc := &index.Document{LinkID: linkID}
+		if orig, found := i.docs[key]; found {
+			doc = copyDoc(orig)
+		}
+		doc.PageRank = score
+
+		if err := batch.Index(key, makeBleveDoc(doc)); err != nil {
+			res.Errors = append(res.Errors, &index.DocumentError{Pos: -1, LinkID: linkID, Err: err})
+			continue
+		}
+		pending[key] = doc
+		res.Processed++
+	}
+
+	if err := i.idx.Batch(batch); err != nil {
+		return index.BatchResult{}, xerrors.Errorf("update scores: %w", err)
+	}
+	for key, doc := range pending {
+		i.docs[key] = doc
+	}
+
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// Delete は指定されたリンク ID を持つ文書をインデックスから削除する
+func (i *InMemoryBleveIndexer) Delete(linkID uuid.UUID) error {
+	return i.DeleteContext(context.Background(), linkID)
+}
+
+func (i *InMemoryBleveIndexer) DeleteContext(ctx context.Context, linkID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := linkID.String()
+	if _, found := i.docs[key]; !found {
+		return xerrors.Errorf("delete: %w", index.ErrNotFound)
+	}
+
+	if err := i.idx.Delete(key); err != nil {
+		return xerrors.Errorf("delete: %w", err)
+	}
+	delete(i.docs, key)
+	return nil
+}
+
+// DeleteMatching は pred が true を返すすべての文書を 1 つのバッチで削除する
+func (i *InMemoryBleveIndexer) DeleteMatching(pred index.Predicate) (int, error) {
+	return i.DeleteMatchingContext(context.Background(), pred)
+}
+
+func (i *InMemoryBleveIndexer) DeleteMatchingContext(ctx context.Context, pred index.Predicate) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, xerrors.Errorf("delete matching: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var keys []string
+	batch := i.idx.NewBatch()
+	for key, doc := range i.docs {
+		if pred(copyDoc(doc)) {
+			keys = append(keys, key)
+			batch.Delete(key)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	if err := i.idx.Batch(batch); err != nil {
+		return 0, xerrors.Errorf("delete matching: %w", err)
+	}
+	for _, key := range keys {
+		delete(i.docs, key)
+	}
+	return len(keys), nil
+}
+
+// Scan はリンク ID の順にすべての文書について fn を呼び出す。
+// 文書は scanBatchSize 件ずつ読み取りロックを取得してコピーされ、fn はロックを解放してから呼び出される。
+func (i *InMemoryBleveIndexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	i.mu.RLock()
+	keys := make([]string, 0, len(i.docs))
+	for key := range i.docs {
+		keys = append(keys, key)
+	}
+	i.mu.RUnlock()
+	sort.Strings(keys)
+
+	docs := make([]*index.Document, 0, scanBatchSize)
+	for start := 0; start < len(keys); start += scanBatchSize {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("scan: %w", err)
+		}
+
+		end := start + scanBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		docs = docs[:0]
+		i.mu.RLock()
+		for _, key := range keys[start:end] {
+			// 走査の開始後に削除された文書は渡さない
+			if d, found := i.docs[key]; found {
+				docs = append(docs, copyDoc(d))
+			}
+		}
+		i.mu.RUnlock()
+
+		for _, doc := range docs {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // copyDoc ヘルパーは、内部ドキュメントマップに安全に格納できるオリジナルドキュメントのコピーを作成する
 func copyDoc(d *index.Document) *index.Document {
 	dcopy := new(index.Document)
@@ -142,5 +457,8 @@ func makeBleveDoc(d *index.Document) bleveDoc {
 		Title:    d.Title,
 		Content:  d.Content,
 		PageRank: d.PageRank,
+		URL:      d.URL,
+		Sites:    querylang.Sites(d.URL),
+		Language: d.Language,
 	}
 }
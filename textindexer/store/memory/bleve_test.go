@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"github.com/google/uuid"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/indextest"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/synonym"
+	gc "gopkg.in/check.v1"
+	"strings"
+	"testing"
+)
+
+var _ = gc.Suite(new(InMemoryBleveTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type InMemoryBleveTestSuite struct {
+	indextest.SuiteBase
+	idx *InMemoryBleveIndexer
+}
+
+func (s *InMemoryBleveTestSuite) SetUpTest(c *gc.C) {
+	idx, err := NewInMemoryBleveIndexer()
+	c.Assert(err, gc.IsNil)
+	s.SetIndexer(idx)
+	s.idx = idx
+}
+
+func (s *InMemoryBleveTestSuite) TearDownTest(c *gc.C) {
+	c.Assert(s.idx.Close(), gc.IsNil)
+}
+
+func (s *InMemoryBleveTestSuite) TestMultilingualSearch(c *gc.C) {
+	var (
+		tower   = &index.Document{LinkID: uuid.New(), Title: "東京タワー", Content: "東京タワーは東京都港区にある電波塔です。"}
+		skytree = &index.Document{LinkID: uuid.New(), Title: "東京スカイツリー", Content: "東京スカイツリーは墨田区にある電波塔です。"}
+		runners = &index.Document{LinkID: uuid.New(), Title: "Runners", Content: "The runners were running through the parks"}
+		german  = &index.Document{LinkID: uuid.New(), Title: "Läufer", Content: "Die Läufer laufen durch den Park und sind nicht müde"}
+	)
+	for _, doc := range []*index.Document{tower, skytree, runners, german} {
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+	}
+
+	specs := []struct {
+		q   index.Query
+		exp []uuid.UUID
+	}{
+		// 空白で区切られない日本語の語も一致する
+		{index.Query{Expression: "タワー"}, []uuid.UUID{tower.LinkID}},
+		{index.Query{Expression: "電波塔"}, []uuid.UUID{tower.LinkID, skytree.LinkID}},
+		{index.Query{Type: index.QueryTypePhrase, Expression: "東京タワー"}, []uuid.UUID{tower.LinkID}},
+		{index.Query{Type: index.QueryTypePhrase, Expression: "タワー東京"}, nil},
+		{index.Query{Type: index.QueryTypeAdvanced, Expression: "title:スカイツリー"}, []uuid.UUID{skytree.LinkID}},
+		// 英語の語は語幹で比較され、ストップワードは無視される
+		{index.Query{Expression: "run"}, []uuid.UUID{runners.LinkID}},
+		{index.Query{Type: index.QueryTypePhrase, Expression: "running through the park"}, []uuid.UUID{runners.LinkID}},
+		// 言語を推定できない検索語は各言語のアナライザで解析され、英語以外の語幹でも一致する
+		{index.Query{Expression: "Läufer"}, []uuid.UUID{german.LinkID}},
+		{index.Query{Expression: "laufen"}, []uuid.UUID{german.LinkID}},
+		{index.Query{Type: index.QueryTypeAdvanced, Expression: "content:Läufer"}, []uuid.UUID{german.LinkID}},
+		{index.Query{Expression: "Läufer", Language: "de"}, []uuid.UUID{german.LinkID}},
+		{index.Query{Expression: "nicht"}, nil},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %q", specIndex, spec.q.Expression)
+		it, err := s.idx.Search(spec.q)
+		c.Assert(err, gc.IsNil)
+
+		var got []uuid.UUID
+		for it.Next() {
+			got = append(got, it.Document().LinkID)
+		}
+		c.Assert(it.Close(), gc.IsNil)
+		c.Assert(idSet(got), gc.DeepEquals, idSet(spec.exp))
+	}
+}
+
+func (s *InMemoryBleveTestSuite) TestQueryRewriter(c *gc.C) {
+	var (
+		golang  = &index.Document{LinkID: uuid.New(), Title: "Golang tips", Content: "Short tips for everyday programming"}
+		goLang  = &index.Document{LinkID: uuid.New(), Title: "The Go language", Content: "A tour of the Go language"}
+		website = &index.Document{LinkID: uuid.New(), Title: "Website builder", Content: "Build a website in minutes"}
+	)
+	for _, doc := range []*index.Document{golang, goLang, website} {
+		c.Assert(s.idx.Index(doc), gc.IsNil)
+	}
+
+	search := func(q index.Query) map[uuid.UUID]bool {
+		it, err := s.idx.Search(q)
+		c.Assert(err, gc.IsNil)
+		var got []uuid.UUID
+		for it.Next() {
+			got = append(got, it.Document().LinkID)
+		}
+		c.Assert(it.Close(), gc.IsNil)
+		return idSet(got)
+	}
+	c.Assert(search(index.Query{Expression: "golang"}), gc.DeepEquals, idSet([]uuid.UUID{golang.LinkID}))
+
+	rules, err := synonym.NewRules(strings.NewReader("golang, go language"), strings.NewReader("website"))
+	c.Assert(err, gc.IsNil)
+	s.idx.SetQueryRewriter(rules)
+
+	// 同義語はインデックスを作り直さずに検索に適用される
+	c.Assert(search(index.Query{Expression: "golang"}), gc.DeepEquals, idSet([]uuid.UUID{golang.LinkID, goLang.LinkID}))
+	c.Assert(search(index.Query{Type: index.QueryTypePhrase, Expression: "go language"}), gc.DeepEquals, idSet([]uuid.UUID{golang.LinkID, goLang.LinkID}))
+	// ストップワードは検索語から取り除かれる
+	c.Assert(search(index.Query{Expression: "website tips"}), gc.DeepEquals, idSet([]uuid.UUID{golang.LinkID}))
+
+	s.idx.SetQueryRewriter(nil)
+	c.Assert(search(index.Query{Expression: "website tips"}), gc.DeepEquals, idSet([]uuid.UUID{golang.LinkID, website.LinkID}))
+}
+
+func idSet(ids []uuid.UUID) map[uuid.UUID]bool {
+	set := make(map[uuid.UUID]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
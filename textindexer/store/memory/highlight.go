@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"github.com/blevesearch/bleve/search/highlight"
+	"html"
+	"strings"
+)
+
+// escapingFormatter は一致した語をマーカーで囲む highlight.FragmentFormatter の実装。
+// bleve の html フォーマッタと異なり、一致した語自体も HTML エスケープする。
+type escapingFormatter struct {
+	before string
+	after  string
+}
+
+func (f *escapingFormatter) Format(frag *highlight.Fragment, orderedTermLocations highlight.TermLocations) string {
+	var (
+		sb   strings.Builder
+		curr = frag.Start
+	)
+	for _, loc := range orderedTermLocations {
+		if loc == nil || !loc.ArrayPositions.Equals(frag.ArrayPositions) || loc.Start < curr {
+			continue
+		}
+		if loc.End > frag.End {
+			break
+		}
+		sb.WriteString(html.EscapeString(string(frag.Orig[curr:loc.Start])))
+		sb.WriteString(f.before)
+		sb.WriteString(html.EscapeString(string(frag.Orig[loc.Start:loc.End])))
+		sb.WriteString(f.after)
+		curr = loc.End
+	}
+	sb.WriteString(html.EscapeString(string(frag.Orig[curr:frag.End])))
+	return sb.String()
+}
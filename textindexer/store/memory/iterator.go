@@ -0,0 +1,45 @@
+package memory
+
+import (
+	simplehl "github.com/blevesearch/bleve/search/highlight/highlighter/simple"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/highlight"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/store/internal/bleveiter"
+)
+
+var (
+	_ index.SnippetIterator = (*bleveIterator)(nil)
+	_ index.CursorIterator  = (*bleveIterator)(nil)
+	_ index.ScoredIterator  = (*bleveIterator)(nil)
+)
+
+// bleveIterator は bleve の一致位置を使用して抜粋を作成する index.Iterator の実装
+type bleveIterator struct {
+	*bleveiter.Iterator
+	idx *InMemoryBleveIndexer
+
+	// highlighter は Query.Highlight が指定された場合に抜粋を作成する。
+	// 本文に一致する語がない場合は fallback が本文の先頭を返す。
+	highlighter *simplehl.Highlighter
+	fallback    *highlight.Highlighter
+}
+
+func (it *bleveIterator) Close() error {
+	it.idx = nil
+	return it.Iterator.Close()
+}
+
+// Snippet は現在の文書の本文の抜粋を返す。Query.Highlight が指定されていない場合は空文字列を返す。
+func (it *bleveIterator) Snippet() string {
+	hit := it.Hit()
+	if it.highlighter == nil || hit == nil || it.idx == nil {
+		return ""
+	}
+
+	if bdoc, err := it.idx.idx.Document(hit.ID); err == nil && bdoc != nil {
+		if frag := it.highlighter.BestFragmentInField(hit, bdoc, "Content"); frag != "" {
+			return frag
+		}
+	}
+	return it.fallback.Snippet(it.Document().Content)
+}
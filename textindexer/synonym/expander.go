@@ -0,0 +1,79 @@
+package synonym
+
+import (
+	"context"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"sync"
+)
+
+var (
+	_ index.QueryRewriter = (*Expander)(nil)
+	_ index.Scanner       = (*Indexer)(nil)
+)
+
+// Expander はファイルから読み込んだ Rules でクエリを書き換える index.QueryRewriter。
+// Reload でファイルを読み込み直すと、インデックスを作り直さずに以降の検索に新しい規則が適用される。
+type Expander struct {
+	synonymsPath  string
+	stopwordsPath string
+
+	mu    sync.RWMutex
+	rules *Rules
+}
+
+// NewExpander は synonymsPath と stopwordsPath のファイルから規則を読み込む Expander を作成する。
+// パスが空の場合はその規則を使用しない。
+func NewExpander(synonymsPath, stopwordsPath string) (*Expander, error) {
+	e := &Expander{synonymsPath: synonymsPath, stopwordsPath: stopwordsPath}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload はファイルから規則を読み込み直す。読み込みに失敗した場合は以前の規則を使用し続ける。
+func (e *Expander) Reload() error {
+	rules, err := LoadRules(e.synonymsPath, e.stopwordsPath)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+// Rules は現在の規則を返す
+func (e *Expander) Rules() *Rules {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules
+}
+
+// Rewrite は現在の規則で q を書き換える
+func (e *Expander) Rewrite(q index.Query) index.Query {
+	return e.Rules().Rewrite(q)
+}
+
+// Indexer は index.Indexer をラップし、検索の前に QueryRewriter でクエリを書き換える。
+// 書き換えの仕組みを持たない Indexer の実装に同義語とストップワードを適用する場合に使用する。
+type Indexer struct {
+	index.Indexer
+	rw index.QueryRewriter
+}
+
+// NewIndexer は idx の検索クエリを rw で書き換える Indexer を作成する
+func NewIndexer(idx index.Indexer, rw index.QueryRewriter) *Indexer {
+	return &Indexer{Indexer: idx, rw: rw}
+}
+
+// Search は q を書き換えてから検索を行う
+func (i *Indexer) Search(q index.Query) (index.Iterator, error) {
+	return i.Indexer.Search(i.rw.Rewrite(q))
+}
+
+// Scan はラップしたインデクサのすべての文書について fn を呼び出す
+func (i *Indexer) Scan(ctx context.Context, fn func(doc *index.Document) error) error {
+	return index.Scan(ctx, i.Indexer, fn)
+}
@@ -0,0 +1,375 @@
+// Package synonym は検索時にクエリの同義語を展開し、ストップワードを取り除く。
+//
+// 同義語のファイルは 1 行に 1 つの規則を記述する。# 以降はコメントとして無視される。
+//
+//	# 同等の同義語: いずれの語で検索しても他の語に一致する
+//	golang, go language
+//	# 一方向の同義語: => の左辺の語で検索すると右辺の語にも一致する
+//	k8s => kubernetes
+//
+// ストップワードのファイルには取り除く語を空白または改行で区切って記述する。
+package synonym
+
+import (
+	"bufio"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index/querylang"
+	"golang.org/x/xerrors"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidRule は同義語のファイルに解析できない行が含まれる場合に返される
+var ErrInvalidRule = xerrors.New("invalid synonym rule")
+
+var _ index.QueryRewriter = (*Rules)(nil)
+
+// Rules は同義語とストップワードの規則。作成後は変更されないため、複数の goroutine から同時に使用できる。
+type Rules struct {
+	// synonyms は小文字の語を空白で区切ったキーから、その語の代わりに一致させる語の並びへの対応
+	synonyms    map[string][][]string
+	maxKeyWords int
+	stopwords   map[string]struct{}
+}
+
+// NewRules は同義語とストップワードの規則を読み込む。いずれかが nil の場合はその規則を使用しない。
+func NewRules(synonyms, stopwords io.Reader) (*Rules, error) {
+	r := &Rules{
+		synonyms:  make(map[string][][]string),
+		stopwords: make(map[string]struct{}),
+	}
+	if synonyms != nil {
+		if err := r.parseSynonyms(synonyms); err != nil {
+			return nil, xerrors.Errorf("parse synonyms: %w", err)
+		}
+	}
+	if stopwords != nil {
+		if err := r.parseStopwords(stopwords); err != nil {
+			return nil, xerrors.Errorf("parse stopwords: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// LoadRules はファイルから同義語とストップワードの規則を読み込む。パスが空の場合はその規則を使用しない。
+func LoadRules(synonymsPath, stopwordsPath string) (*Rules, error) {
+	var readers [2]io.Reader
+	for i, path := range []string{synonymsPath, stopwordsPath} {
+		if path == "" {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, xerrors.Errorf("load rules: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		readers[i] = f
+	}
+
+	r, err := NewRules(readers[0], readers[1])
+	if err != nil {
+		return nil, xerrors.Errorf("load rules: %w", err)
+	}
+	return r, nil
+}
+
+func (r *Rules) parseSynonyms(src io.Reader) error {
+	scanner := bufio.NewScanner(src)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if lhs, rhs, oneWay := strings.Cut(line, "=>"); oneWay {
+			from, to := splitTerms(lhs), splitTerms(rhs)
+			if from == nil || to == nil {
+				return xerrors.Errorf("line %d: %w", lineNum, ErrInvalidRule)
+			}
+			for _, f := range from {
+				r.add(f, to)
+			}
+			continue
+		}
+
+		terms := splitTerms(line)
+		if len(terms) < 2 {
+			return xerrors.Errorf("line %d: %w", lineNum, ErrInvalidRule)
+		}
+		for _, t := range terms {
+			r.add(t, terms)
+		}
+	}
+	return scanner.Err()
+}
+
+// add は from の語の並びで検索した場合に to のそれぞれにも一致するように登録する
+func (r *Rules) add(from []string, to [][]string) {
+	key := strings.Join(from, " ")
+	for _, t := range to {
+		if alt := strings.Join(t, " "); alt != key && !r.has(key, alt) {
+			r.synonyms[key] = append(r.synonyms[key], t)
+		}
+	}
+	if len(from) > r.maxKeyWords {
+		r.maxKeyWords = len(from)
+	}
+}
+
+func (r *Rules) has(key, alt string) bool {
+	for _, t := range r.synonyms[key] {
+		if strings.Join(t, " ") == alt {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rules) parseStopwords(src io.Reader) error {
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		for _, w := range words(stripComment(scanner.Text())) {
+			r.stopwords[w] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+func stripComment(line string) string {
+	if idx := strings.IndexByte(line, '#'); idx != -1 {
+		return line[:idx]
+	}
+	return line
+}
+
+// splitTerms はカンマで区切られた語の並びを分割する。空の語が含まれる場合は nil を返す。
+func splitTerms(s string) [][]string {
+	var terms [][]string
+	for _, part := range strings.Split(s, ",") {
+		ws := words(part)
+		if len(ws) == 0 {
+			return nil
+		}
+		terms = append(terms, ws)
+	}
+	return terms
+}
+
+// IsStopword は word が取り除く語かを返す
+func (r *Rules) IsStopword(word string) bool {
+	_, found := r.stopwords[strings.ToLower(word)]
+	return found
+}
+
+// Synonyms は語または空白で区切られたフレーズ term で検索した場合に一致させる同義語を返す
+func (r *Rules) Synonyms(term string) []string {
+	var out []string
+	for _, t := range r.synonyms[strings.Join(words(term), " ")] {
+		out = append(out, strings.Join(t, " "))
+	}
+	return out
+}
+
+// Rewrite は q の語の同義語を OR で結合し、ストップワードを取り除いたクエリを返す。
+// 書き換えたクエリは QueryTypeAdvanced となり、書き換える語がない場合は q をそのまま返す。
+// フレーズ内のストップワードは語の並びを保つために取り除かない。
+func (r *Rules) Rewrite(q index.Query) index.Query {
+	var n querylang.Node
+	switch q.Type {
+	case index.QueryTypeAdvanced:
+		parsed, err := querylang.Parse(q.Expression)
+		if err != nil {
+			// 構文の誤りは検索時に報告させる
+			return q
+		}
+		n = r.rewriteNode(parsed)
+		if n == parsed {
+			return q
+		}
+	case index.QueryTypePhrase:
+		n = r.rewritePhrase(querylang.FieldAny, q.Expression)
+	default:
+		n = r.rewriteMatch(q.Expression)
+	}
+	if n == nil {
+		return q
+	}
+
+	q.Type = index.QueryTypeAdvanced
+	q.Expression = querylang.Format(n)
+	return q
+}
+
+// rewriteMatch はいずれかの語に一致する検索式を OR で結合した構文木に変換する。書き換える語がない場合は nil を返す。
+func (r *Rules) rewriteMatch(expr string) querylang.Node {
+	var (
+		ws      = words(expr)
+		nodes   []querylang.Node
+		changed bool
+	)
+	for i := 0; i < len(ws); {
+		if alts, n := r.lookup(ws[i:]); n > 0 {
+			nodes = append(nodes, alternatives(querylang.FieldAny, ws[i:i+n], alts))
+			i, changed = i+n, true
+			continue
+		}
+		if _, stop := r.stopwords[ws[i]]; stop {
+			i, changed = i+1, true
+			continue
+		}
+		nodes = append(nodes, &querylang.Term{Value: ws[i]})
+		i++
+	}
+
+	// すべての語がストップワードの場合は書き換えない
+	if !changed || len(nodes) == 0 {
+		return nil
+	}
+	return or(nodes)
+}
+
+// rewritePhrase は語の並びのうち同義語を持つ部分を置き換えたフレーズを OR で結合する。書き換える語がない場合は nil を返す。
+func (r *Rules) rewritePhrase(field querylang.Field, value string) querylang.Node {
+	ws := words(value)
+	nodes := []querylang.Node{&querylang.Phrase{Field: field, Value: value}}
+	for i := 0; i < len(ws); {
+		alts, n := r.lookup(ws[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		for _, alt := range alts {
+			variant := append(append(append([]string{}, ws[:i]...), alt...), ws[i+n:]...)
+			nodes = append(nodes, phraseOrTerm(field, variant))
+		}
+		i += n
+	}
+
+	if len(nodes) == 1 {
+		return nil
+	}
+	return or(nodes)
+}
+
+// rewriteNode は構文木の語とフレーズを書き換える。書き換える語がない場合は n をそのまま返し、
+// n がストップワードのみからなる場合は nil を返す。
+func (r *Rules) rewriteNode(n querylang.Node) querylang.Node {
+	switch n := n.(type) {
+	case *querylang.And:
+		if nodes, changed := r.rewriteChildren(n.Nodes); changed {
+			return and(nodes)
+		}
+	case *querylang.Or:
+		if nodes, changed := r.rewriteChildren(n.Nodes); changed {
+			return or(nodes)
+		}
+	case *querylang.Not:
+		// 否定された語はストップワードでも取り除かない
+		if child := r.rewriteNode(n.Node); child != nil && child != n.Node {
+			return &querylang.Not{Node: child}
+		}
+	case *querylang.Term:
+		if n.Prefix || !isText(n.Field) {
+			return n
+		}
+		ws := words(n.Value)
+		if alts, m := r.lookup(ws); m > 0 && m == len(ws) {
+			return alternatives(n.Field, ws, alts)
+		}
+		if len(ws) == 1 {
+			if _, stop := r.stopwords[ws[0]]; stop {
+				return nil
+			}
+		}
+	case *querylang.Phrase:
+		if !isText(n.Field) {
+			return n
+		}
+		if rewritten := r.rewritePhrase(n.Field, n.Value); rewritten != nil {
+			return rewritten
+		}
+	}
+	return n
+}
+
+// rewriteChildren は子ノードを書き換え、ストップワードのみからなる子を取り除く。
+// すべての子が取り除かれる場合は元の子ノードを返す。
+func (r *Rules) rewriteChildren(children []querylang.Node) ([]querylang.Node, bool) {
+	var (
+		nodes   []querylang.Node
+		changed bool
+	)
+	for _, child := range children {
+		rewritten := r.rewriteNode(child)
+		if rewritten != child {
+			changed = true
+		}
+		if rewritten != nil {
+			nodes = append(nodes, rewritten)
+		}
+	}
+	if len(nodes) == 0 {
+		return children, false
+	}
+	return nodes, changed
+}
+
+// lookup は ws の先頭の最も長い同義語のキーを探し、その同義語と語数を返す。見つからない場合は 0 を返す。
+func (r *Rules) lookup(ws []string) ([][]string, int) {
+	for n := min(r.maxKeyWords, len(ws)); n > 0; n-- {
+		if alts, found := r.synonyms[strings.Join(ws[:n], " ")]; found {
+			return alts, n
+		}
+	}
+	return nil, 0
+}
+
+// alternatives は元の語の並び orig とその同義語のいずれかに一致する構文木を作成する
+func alternatives(field querylang.Field, orig []string, alts [][]string) querylang.Node {
+	nodes := []querylang.Node{phraseOrTerm(field, orig)}
+	for _, alt := range alts {
+		nodes = append(nodes, phraseOrTerm(field, alt))
+	}
+	return or(nodes)
+}
+
+func phraseOrTerm(field querylang.Field, ws []string) querylang.Node {
+	if len(ws) == 1 {
+		return &querylang.Term{Field: field, Value: ws[0]}
+	}
+	return &querylang.Phrase{Field: field, Value: strings.Join(ws, " ")}
+}
+
+func and(nodes []querylang.Node) querylang.Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &querylang.And{Nodes: nodes}
+}
+
+func or(nodes []querylang.Node) querylang.Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &querylang.Or{Nodes: nodes}
+}
+
+func isText(f querylang.Field) bool {
+	return f == querylang.FieldAny || f == querylang.FieldTitle || f == querylang.FieldContent
+}
+
+// words はテキストを小文字の語に分割する
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
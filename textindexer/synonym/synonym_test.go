@@ -0,0 +1,94 @@
+package synonym
+
+import (
+	"github.com/shshimamo/Hands-On-Software-Engineering-with-Golang/textindexer/index"
+	"golang.org/x/xerrors"
+	gc "gopkg.in/check.v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var _ = gc.Suite(new(SynonymTestSuite))
+
+func Test(t *testing.T) { gc.TestingT(t) }
+
+type SynonymTestSuite struct{}
+
+const testSynonyms = `
+# 同等の同義語
+golang, go language
+# 一方向の同義語
+k8s => kubernetes, kube
+`
+
+func (s *SynonymTestSuite) TestParseRules(c *gc.C) {
+	r, err := NewRules(strings.NewReader(testSynonyms), strings.NewReader("the a # comment\nwebsite"))
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(r.Synonyms("Golang"), gc.DeepEquals, []string{"go language"})
+	c.Assert(r.Synonyms("go  language"), gc.DeepEquals, []string{"golang"})
+	c.Assert(r.Synonyms("k8s"), gc.DeepEquals, []string{"kubernetes", "kube"})
+	c.Assert(r.Synonyms("kubernetes"), gc.HasLen, 0)
+	c.Assert(r.IsStopword("Website"), gc.Equals, true)
+	c.Assert(r.IsStopword("comment"), gc.Equals, false)
+
+	for _, invalid := range []string{"golang", "k8s =>", "a, , b"} {
+		_, err = NewRules(strings.NewReader(invalid), nil)
+		c.Assert(xerrors.Is(err, ErrInvalidRule), gc.Equals, true, gc.Commentf("rule %q: %v", invalid, err))
+	}
+}
+
+func (s *SynonymTestSuite) TestRewrite(c *gc.C) {
+	r, err := NewRules(strings.NewReader(testSynonyms), strings.NewReader("the tutorial"))
+	c.Assert(err, gc.IsNil)
+
+	specs := []struct {
+		q       index.Query
+		expType index.QueryType
+		exp     string
+	}{
+		{index.Query{Expression: "concurrency"}, index.QueryTypeMatch, "concurrency"},
+		{index.Query{Expression: "Golang concurrency"}, index.QueryTypeAdvanced, `((golang OR "go language") OR concurrency)`},
+		{index.Query{Expression: "the go language tutorial"}, index.QueryTypeAdvanced, `("go language" OR golang)`},
+		// すべての語がストップワードの場合は書き換えない
+		{index.Query{Expression: "the tutorial"}, index.QueryTypeMatch, "the tutorial"},
+		{index.Query{Type: index.QueryTypePhrase, Expression: "golang tutorial"}, index.QueryTypeAdvanced, `("golang tutorial" OR "go language tutorial")`},
+		{
+			index.Query{Type: index.QueryTypeAdvanced, Expression: `title:k8s the -tutorial url:https://k8s.io/*`},
+			index.QueryTypeAdvanced,
+			`((title:k8s OR title:kubernetes OR title:kube) AND -tutorial AND url:https://k8s.io/*)`,
+		},
+		{index.Query{Type: index.QueryTypeAdvanced, Expression: "kube*"}, index.QueryTypeAdvanced, "kube*"},
+	}
+
+	for specIndex, spec := range specs {
+		c.Logf("[spec %d] %s", specIndex, spec.q.Expression)
+		got := r.Rewrite(spec.q)
+		c.Assert(got.Type, gc.Equals, spec.expType)
+		c.Assert(got.Expression, gc.Equals, spec.exp)
+	}
+}
+
+func (s *SynonymTestSuite) TestExpanderReload(c *gc.C) {
+	dir := c.MkDir()
+	synPath := filepath.Join(dir, "synonyms.txt")
+	c.Assert(os.WriteFile(synPath, []byte("golang, go language"), 0644), gc.IsNil)
+
+	e, err := NewExpander(synPath, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(e.Rewrite(index.Query{Expression: "k8s"}).Expression, gc.Equals, "k8s")
+
+	c.Assert(os.WriteFile(synPath, []byte("k8s => kubernetes"), 0644), gc.IsNil)
+	c.Assert(e.Reload(), gc.IsNil)
+	c.Assert(e.Rewrite(index.Query{Expression: "k8s"}).Expression, gc.Equals, "(k8s OR kubernetes)")
+
+	// 読み込みに失敗した場合は以前の規則を使用し続ける
+	c.Assert(os.WriteFile(synPath, []byte("k8s =>"), 0644), gc.IsNil)
+	c.Assert(e.Reload(), gc.NotNil)
+	c.Assert(e.Rewrite(index.Query{Expression: "k8s"}).Expression, gc.Equals, "(k8s OR kubernetes)")
+
+	_, err = NewExpander(filepath.Join(dir, "missing.txt"), "")
+	c.Assert(err, gc.NotNil)
+}